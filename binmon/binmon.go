@@ -0,0 +1,459 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package binmon implements the binary remote monitor protocol of the VICE emulator,
+// so that IDEs and debuggers written for VICE can use this emulator as a target.
+// Requests and responses are framed by a small header and all numbers are little
+// endian. Only the main CPU memory space is supported, since there are no drives.
+package binmon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/prydin/emu6502/core"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"sort"
+	"sync"
+)
+
+const (
+	stx        = 0x02
+	apiVersion = 0x02
+	eventID    = 0xffffffff // Request ID of responses that weren't asked for
+
+	requestHeaderSize  = 11
+	responseHeaderSize = 12
+	maxRequestSize     = 0x20000
+
+	// Number of instructions to run between checks for new requests when a command
+	// runs the machine
+	interruptCheckInterval = 1000
+)
+
+// Command and response types
+const (
+	cmdMemoryGet         = 0x01
+	cmdMemorySet         = 0x02
+	cmdCheckpointGet     = 0x11
+	cmdCheckpointSet     = 0x12
+	cmdCheckpointDelete  = 0x13
+	cmdCheckpointList    = 0x14
+	cmdCheckpointToggle  = 0x15
+	cmdConditionSet      = 0x22
+	cmdRegistersGet      = 0x31
+	cmdRegistersSet      = 0x32
+	cmdDump              = 0x41
+	cmdUndump            = 0x42
+	cmdAdvance           = 0x71
+	cmdExecuteUntilRet   = 0x73
+	cmdPing              = 0x81
+	cmdBanksAvailable    = 0x82
+	cmdRegistersAvail    = 0x83
+	cmdDisplayGet        = 0x84
+	cmdInfo              = 0x85
+	cmdPaletteGet        = 0x91
+	cmdExit              = 0xaa
+	cmdQuit              = 0xbb
+	cmdReset             = 0xcc
+	cmdAutostart         = 0xdd
+	respCheckpointInfo   = 0x11
+	respStopped          = 0x62
+	respResumed          = 0x63
+	memspaceMain         = 0x00
+	displayFormatIndexed = 0x00
+)
+
+// Error codes
+const (
+	errOK              = 0x00
+	errObjectMissing   = 0x01
+	errInvalidMemspace = 0x02
+	errBadLength       = 0x80
+	errInvalidParam    = 0x81
+	errAPIVersion      = 0x82
+	errInvalidCommand  = 0x83
+	errFailed          = 0x8f
+)
+
+// CPU operations that trigger a checkpoint
+const (
+	opLoad  = 0x01
+	opStore = 0x02
+	opExec  = 0x04
+)
+
+// Register IDs and names. The IDs are the ones VICE uses for the C64 CPU.
+var registerNames = []string{"A", "X", "Y", "PC", "SP", "FL"}
+
+const (
+	regA = iota
+	regX
+	regY
+	regPC
+	regSP
+	regFL
+)
+
+// The version of VICE we claim to be. Clients enable features based on it.
+var viceVersion = []byte{3, 7, 0, 0}
+
+// Machine is the part of the computer the server needs in addition to the CPU. It's
+// implemented by computer.Commodore64.
+type Machine interface {
+	// Clock advances the entire machine by half a cycle
+	Clock()
+	MemoryBanks() map[string]core.AddressSpace
+	Reset(hard bool)
+	Autostart(prg []byte, run bool) error
+	SaveSnapshot(w io.Writer) error
+	LoadSnapshot(r io.Reader) error
+	// Display returns the last frame and the area inside the borders. The frame may be
+	// nil if the machine doesn't keep it.
+	Display() (*image.RGBA, image.Rectangle)
+	Palette() []color.RGBA
+}
+
+type request struct {
+	id      uint32
+	command uint8
+	body    []byte
+}
+
+type bank struct {
+	name string
+	mem  core.AddressSpace
+}
+
+// Options for checkpoints that the debugger doesn't know about
+type checkpointFlags struct {
+	stop      bool // Stop when hit rather than just reporting the hit
+	temporary bool // Delete after the first hit
+}
+
+// Server serves one client at a time. Like gdbstub.Stub, it never runs the machine on
+// its own. The emulation loop must call Poll regularly and Enter when the debugger
+// has stopped the machine. Both block for as long as the client keeps the machine
+// halted. As in VICE, any request from the client halts the machine until the client
+// sends an exit command.
+type Server struct {
+	cpu         *core.CPU
+	debugger    *core.Debugger
+	machine     Machine
+	banks       []bank // Indexed by bank ID
+	listener    net.Listener
+	accepted    chan net.Conn
+	conn        net.Conn
+	requests    chan *request
+	writeLock   sync.Mutex
+	pending     *request // Request received while a command was running the machine
+	checkpoints map[int]checkpointFlags
+	quit        bool
+}
+
+// Listen starts accepting connections on a TCP address such as "localhost:6502".
+func Listen(address string, cpu *core.CPU, debugger *core.Debugger, machine Machine) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cpu:         cpu,
+		debugger:    debugger,
+		machine:     machine,
+		listener:    listener,
+		accepted:    make(chan net.Conn),
+		checkpoints: make(map[int]checkpointFlags),
+	}
+
+	// Bank 0 is the default view of memory, followed by all the views by name
+	memBanks := machine.MemoryBanks()
+	s.banks = append(s.banks, bank{"default", memBanks[core.BankCPU]})
+	var names []string
+	for name := range memBanks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.banks = append(s.banks, bank{name, memBanks[name]})
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Close() error {
+	if s.conn != nil {
+		s.detach()
+	}
+	return s.listener.Close()
+}
+
+// Attached returns true while a client is connected.
+func (s *Server) Attached() bool {
+	return s.conn != nil
+}
+
+// QuitRequested returns true if a client has asked the emulator to quit.
+func (s *Server) QuitRequested() bool {
+	return s.quit
+}
+
+// Poll checks for new connections and requests without blocking. If there's a
+// request, the machine is halted until the client resumes it.
+func (s *Server) Poll() {
+	select {
+	case conn := <-s.accepted:
+		if s.conn != nil {
+			conn.Close() // One client at a time
+			break
+		}
+		s.conn = conn
+		s.requests = make(chan *request, 16)
+		go s.readRequests(conn, s.requests)
+	default:
+	}
+	if s.conn == nil {
+		return
+	}
+	select {
+	case req, ok := <-s.requests:
+		if !ok {
+			s.detach()
+			return
+		}
+		s.pending = req
+		core.FinishInstruction(s.cpu, s.machine.Clock)
+		s.sendStopped()
+		s.serve()
+	default:
+	}
+}
+
+// Enter reports a checkpoint hit to the client and serves it until it resumes the
+// machine. Checkpoints that shouldn't stop the machine are only reported.
+func (s *Server) Enter() {
+	core.FinishInstruction(s.cpu, s.machine.Clock)
+	if !s.reportHit() {
+		return
+	}
+	s.sendStopped()
+	s.serve()
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.accepted <- conn
+	}
+}
+
+// Checkpoints set by the client go away with it, so that the machine doesn't stop
+// with nobody there to resume it.
+func (s *Server) detach() {
+	for id := range s.checkpoints {
+		s.debugger.Remove(id)
+		delete(s.checkpoints, id)
+	}
+	s.conn.Close()
+	s.conn = nil
+	s.pending = nil
+	s.debugger.Resume()
+}
+
+func (s *Server) next() (*request, bool) {
+	if s.pending != nil {
+		req := s.pending
+		s.pending = nil
+		return req, true
+	}
+	req, ok := <-s.requests
+	return req, ok
+}
+
+// Serve requests until the client resumes the machine or goes away.
+func (s *Server) serve() {
+	for {
+		req, ok := s.next()
+		if !ok {
+			s.detach()
+			return
+		}
+		if s.handle(req) {
+			s.sendEvent(respResumed, u16(s.cpu.Registers().PC))
+			return
+		}
+	}
+}
+
+// Run the machine until done returns true between two instructions, a checkpoint
+// stops it or the client sends another request.
+func (s *Server) runUntil(done func() bool) {
+	s.sendEvent(respResumed, u16(s.cpu.Registers().PC))
+	for i := 1; ; i++ {
+		core.StepInstruction(s.cpu, s.machine.Clock)
+		if s.debugger.Stopped() && s.reportHit() || done() {
+			break
+		}
+		if i%interruptCheckInterval == 0 {
+			select {
+			case req, ok := <-s.requests:
+				if ok {
+					s.pending = req
+				}
+				// A closed channel is picked up by the next read
+			default:
+				continue
+			}
+			break
+		}
+	}
+	s.sendStopped()
+}
+
+// Report the checkpoint that stopped the debugger, if any. Returns true if the
+// machine should stop.
+func (s *Server) reportHit() bool {
+	stop := s.debugger.StopEvent()
+	s.debugger.Resume()
+	if stop == nil {
+		return true
+	}
+	bp := stop.Breakpoint
+	flags := s.flags(bp)
+	s.sendEvent(respCheckpointInfo, s.checkpointInfo(bp, true))
+	if flags.temporary {
+		s.debugger.Remove(bp.ID)
+		delete(s.checkpoints, bp.ID)
+	}
+	return flags.stop
+}
+
+func (s *Server) flags(bp *core.Breakpoint) checkpointFlags {
+	if flags, ok := s.checkpoints[bp.ID]; ok {
+		return flags
+	}
+	return checkpointFlags{stop: true} // Set by someone else, e.g. the monitor
+}
+
+func (s *Server) sendStopped() {
+	s.sendEvent(respStopped, u16(s.cpu.Registers().PC))
+}
+
+func (s *Server) sendEvent(responseType uint8, body []byte) {
+	s.send(eventID, responseType, errOK, body)
+}
+
+func (s *Server) send(id uint32, responseType uint8, errorCode uint8, body []byte) {
+	s.write(s.conn, id, responseType, errorCode, body)
+}
+
+func (s *Server) write(conn net.Conn, id uint32, responseType uint8, errorCode uint8, body []byte) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	header := make([]byte, responseHeaderSize, responseHeaderSize+len(body))
+	header[0] = stx
+	header[1] = apiVersion
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(body)))
+	header[6] = responseType
+	header[7] = errorCode
+	binary.LittleEndian.PutUint32(header[8:], id)
+	conn.Write(append(header, body...))
+}
+
+// Read requests and pass them on to the emulation loop. The channel is closed when
+// the connection is.
+func (s *Server) readRequests(conn net.Conn, requests chan<- *request) {
+	defer close(requests)
+	header := make([]byte, requestHeaderSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.LittleEndian.Uint32(header[2:])
+		if header[0] != stx || length > maxRequestSize {
+			return // Out of sync. There's no way to recover from that.
+		}
+		req := &request{
+			id:      binary.LittleEndian.Uint32(header[6:]),
+			command: header[10],
+			body:    make([]byte, length),
+		}
+		if _, err := io.ReadFull(conn, req.body); err != nil {
+			return
+		}
+		if header[1] != 1 && header[1] != apiVersion {
+			s.write(conn, req.id, req.command, errAPIVersion, nil)
+			continue
+		}
+		requests <- req
+	}
+}
+
+func u16(v uint16) []byte {
+	return []byte{uint8(v), uint8(v >> 8)}
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+var errShortRequest = errors.New("request too short")
+
+// Reads little endian fields from a request body.
+type reader struct {
+	*bytes.Reader
+}
+
+func (r reader) u8() uint8 {
+	b, _ := r.ReadByte()
+	return b
+}
+
+func (r reader) u16() uint16 {
+	return uint16(r.u8()) | uint16(r.u8())<<8
+}
+
+func (r reader) u32() uint32 {
+	return uint32(r.u16()) | uint32(r.u16())<<16
+}
+
+// A string prefixed by its length as a byte
+func (r reader) str() (string, error) {
+	n := int(r.u8())
+	if r.Len() < n {
+		return "", errShortRequest
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b), nil
+}
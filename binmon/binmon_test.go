@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package binmon
+
+import (
+	"encoding/binary"
+	"github.com/prydin/emu6502/core"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPalette = []color.RGBA{{0, 0, 0, 255}, {0xff, 0xff, 0xff, 255}}
+
+type testMachine struct {
+	bus        *core.Bus
+	cpu        *core.CPU
+	ram        *core.RAM
+	hardResets int
+	autostart  []byte
+}
+
+func (m *testMachine) Clock() {
+	m.bus.ClockPh1()
+}
+
+func (m *testMachine) MemoryBanks() map[string]core.AddressSpace {
+	return map[string]core.AddressSpace{"cpu": m.bus, "ram": m.ram}
+}
+
+func (m *testMachine) Reset(hard bool) {
+	if hard {
+		m.hardResets++
+	}
+}
+
+func (m *testMachine) Autostart(prg []byte, run bool) error {
+	m.autostart = prg
+	return nil
+}
+
+func (m *testMachine) SaveSnapshot(w io.Writer) error {
+	_, err := w.Write(m.ram.Bytes)
+	return err
+}
+
+func (m *testMachine) LoadSnapshot(r io.Reader) error {
+	_, err := io.ReadFull(r, m.ram.Bytes)
+	return err
+}
+
+func (m *testMachine) Display() (*image.RGBA, image.Rectangle) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	img.SetRGBA(1, 0, color.RGBA{0xf0, 0xf0, 0xf0, 255})
+	return img, image.Rect(1, 0, 3, 2)
+}
+
+func (m *testMachine) Palette() []color.RGBA {
+	return testPalette
+}
+
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	nextID uint32
+}
+
+func (c *client) send(command uint8, body ...byte) uint32 {
+	c.nextID++
+	header := make([]byte, requestHeaderSize)
+	header[0] = stx
+	header[1] = apiVersion
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(body)))
+	binary.LittleEndian.PutUint32(header[6:], c.nextID)
+	header[10] = command
+	_, err := c.conn.Write(append(header, body...))
+	require.NoError(c.t, err)
+	return c.nextID
+}
+
+// Receive a response and check its type, error code and request ID.
+func (c *client) receive(responseType, code uint8, id uint32) []byte {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, responseHeaderSize)
+	_, err := io.ReadFull(c.conn, header)
+	require.NoError(c.t, err)
+	require.Equal(c.t, []byte{stx, apiVersion}, header[:2])
+	require.Equal(c.t, responseType, header[6], "Response type")
+	require.Equal(c.t, code, header[7], "Error code")
+	require.Equal(c.t, id, binary.LittleEndian.Uint32(header[8:]), "Request ID")
+	body := make([]byte, binary.LittleEndian.Uint32(header[2:]))
+	_, err = io.ReadFull(c.conn, body)
+	require.NoError(c.t, err)
+	return body
+}
+
+func (c *client) exchange(command uint8, body ...byte) []byte {
+	return c.receive(command, errOK, c.send(command, body...))
+}
+
+func (c *client) event(responseType uint8) []byte {
+	return c.receive(responseType, errOK, eventID)
+}
+
+func str(s string) []byte {
+	return append([]byte{uint8(len(s))}, s...)
+}
+
+func registers(body []byte) map[uint8]uint16 {
+	regs := map[uint8]uint16{}
+	for i := 2; i < len(body); i += 4 {
+		regs[body[i+1]] = binary.LittleEndian.Uint16(body[i+2:])
+	}
+	return regs
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binmon")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// LDX #$00, INX, STX $2000, JMP $1002 and a subroutine doing INX, RTS
+	ram := core.MakeRAM(0xffff)
+	copy(ram.Bytes[0x1000:], []byte{0xa2, 0x00, 0xe8, 0x8e, 0x00, 0x20, 0x4c, 0x02, 0x10})
+	copy(ram.Bytes[0x1010:], []byte{0x20, 0x00, 0x11})
+	copy(ram.Bytes[0x1100:], []byte{0xe8, 0x60})
+	bus := &core.Bus{}
+	bus.Connect(ram, 0x0000, 0xffff)
+	cpu := &core.CPU{}
+	cpu.Init(bus)
+	bus.ConnectClockablePh1(cpu)
+	cpu.SetRegisters(core.Registers{PC: 0x1000, SP: 0xff})
+	debugger := core.NewDebugger(cpu, bus)
+	machine := &testMachine{bus: bus, cpu: cpu, ram: ram}
+	server, err := Listen("localhost:0", cpu, debugger, machine)
+	require.NoError(t, err)
+
+	// Emulation loop
+	var done int32
+	finished := make(chan bool)
+	go func() {
+		for n := 0; atomic.LoadInt32(&done) == 0; n++ {
+			machine.Clock()
+			if debugger.Stopped() {
+				server.Enter()
+			}
+			if n%100 == 0 {
+				server.Poll()
+			}
+		}
+		server.Close()
+		finished <- true
+	}()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	c := &client{t: t, conn: conn}
+
+	// The first request stops the machine
+	id := c.send(cmdPing)
+	c.event(respStopped)
+	c.receive(cmdPing, errOK, id)
+	require.Equal(t, []byte{4, 3, 7, 0, 0, 4, 0, 0, 0, 0}, c.exchange(cmdInfo))
+	require.Equal(t, []byte{3, 0, 10, 0, 0, 7, 'd', 'e', 'f', 'a', 'u', 'l', 't', 6, 1, 0, 3, 'c', 'p', 'u', 6, 2, 0, 3, 'r', 'a', 'm'},
+		c.exchange(cmdBanksAvailable))
+
+	// Registers and memory
+	id = c.send(cmdRegistersSet, memspaceMain, 2, 0, 3, regA, 0x42, 0, 3, regPC, 0x00, 0x10)
+	regs := registers(c.receive(cmdRegistersGet, errOK, id))
+	require.Equal(t, uint16(0x42), regs[regA])
+	require.Equal(t, uint16(0x1000), regs[regPC])
+	require.Equal(t, []byte{3, 0, 0xa2, 0x00, 0xe8}, c.exchange(cmdMemoryGet, 0, 0x00, 0x10, 0x02, 0x10, memspaceMain, 0, 0))
+	c.exchange(cmdMemorySet, 0, 0x00, 0x30, 0x01, 0x30, memspaceMain, 0, 0, 0xab, 0xcd)
+	require.Equal(t, []byte{2, 0, 0xab, 0xcd}, c.exchange(cmdMemoryGet, 0, 0x00, 0x30, 0x01, 0x30, memspaceMain, 2, 0))
+	c.receive(cmdMemoryGet, errInvalidMemspace, c.send(cmdMemoryGet, 0, 0x00, 0x30, 0x01, 0x30, 1, 0, 0))
+	c.receive(cmdMemoryGet, errBadLength, c.send(cmdMemoryGet, 0))
+	c.receive(0x72, errInvalidCommand, c.send(0x72))
+
+	// Checkpoints
+	id = c.send(cmdCheckpointSet, 0x06, 0x10, 0x06, 0x10, 1, 1, opExec, 0)
+	require.Equal(t, []byte{1, 0, 0, 0, 0, 0x06, 0x10, 0x06, 0x10, 1, 1, opExec, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, memspaceMain},
+		c.receive(respCheckpointInfo, errOK, id))
+	c.exchange(cmdExit)
+	c.event(respResumed)
+	require.Equal(t, byte(1), c.event(respCheckpointInfo)[4], "Checkpoint should be hit")
+	require.Equal(t, []byte{0x06, 0x10}, c.event(respStopped))
+	require.Equal(t, uint16(1), registers(c.exchange(cmdRegistersGet, memspaceMain))[regX])
+	c.exchange(cmdCheckpointDelete, 1, 0, 0, 0)
+	c.receive(cmdCheckpointDelete, errObjectMissing, c.send(cmdCheckpointDelete, 1, 0, 0, 0))
+
+	// Temporary watchpoint with a condition
+	c.receive(respCheckpointInfo, errOK, c.send(cmdCheckpointSet, 0x00, 0x20, 0x00, 0x20, 1, 1, opStore, 1))
+	c.exchange(cmdConditionSet, append([]byte{2, 0, 0, 0}, str("x == 3")...)...)
+	c.exchange(cmdExit)
+	c.event(respResumed)
+	c.event(respCheckpointInfo)
+	c.event(respStopped)
+	require.Equal(t, uint16(3), registers(c.exchange(cmdRegistersGet, memspaceMain))[regX])
+	require.Equal(t, []byte{0, 0, 0, 0}, c.exchange(cmdCheckpointList), "Temporary checkpoint should be gone")
+
+	// Stepping over a subroutine
+	c.receive(cmdRegistersGet, errOK, c.send(cmdRegistersSet, memspaceMain, 1, 0, 3, regPC, 0x10, 0x10))
+	c.exchange(cmdAdvance, 1, 1, 0)
+	c.event(respResumed)
+	require.Equal(t, []byte{0x13, 0x10}, c.event(respStopped))
+	require.Equal(t, uint16(4), registers(c.exchange(cmdRegistersGet, memspaceMain))[regX])
+
+	// Snapshots
+	file := filepath.Join(dir, "snapshot")
+	c.exchange(cmdDump, append([]byte{0, 0}, str(file)...)...)
+	c.exchange(cmdMemorySet, 0, 0x00, 0x30, 0x00, 0x30, memspaceMain, 0, 0, 0x00)
+	require.Equal(t, []byte{0x13, 0x10}, c.exchange(cmdUndump, str(file)...))
+	require.Equal(t, uint8(0xab), ram.Bytes[0x3000])
+
+	// Display
+	display := c.exchange(cmdDisplayGet, 1, displayFormatIndexed)
+	require.Equal(t, []byte{13, 0, 0, 0, 4, 0, 2, 0, 1, 0, 0, 0, 2, 0, 2, 0, 8, 8, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}, display)
+	require.Equal(t, []byte{2, 0, 3, 0, 0, 0, 3, 0xff, 0xff, 0xff}, c.exchange(cmdPaletteGet, 1))
+
+	// Reset and autostart
+	c.exchange(cmdReset, 1)
+	require.Equal(t, 1, machine.hardResets)
+	c.receive(cmdReset, errInvalidParam, c.send(cmdReset, 8))
+	prg := filepath.Join(dir, "test.prg")
+	require.NoError(t, ioutil.WriteFile(prg, []byte{0x01, 0x08, 0x00}, 0644))
+	c.exchange(cmdAutostart, append([]byte{1, 0, 0}, str(prg)...)...)
+	c.event(respResumed)
+
+	// Quitting
+	id = c.send(cmdQuit)
+	c.event(respStopped)
+	c.receive(cmdQuit, errOK, id)
+	c.event(respResumed)
+	conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	atomic.StoreInt32(&done, 1)
+	<-finished
+	require.True(t, server.QuitRequested())
+	require.Equal(t, []byte{0x01, 0x08, 0x00}, machine.autostart)
+	require.Empty(t, debugger.Breakpoints())
+}
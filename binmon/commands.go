@@ -0,0 +1,561 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package binmon
+
+import (
+	"bytes"
+	"github.com/prydin/emu6502/core"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Handle a request. Returns true if the machine should resume.
+func (s *Server) handle(req *request) bool {
+	r := reader{bytes.NewReader(req.body)}
+	responseType := req.command
+	var body []byte
+	code := uint8(errOK)
+	resume := false
+	if len(req.body) < minLengths[req.command] {
+		s.send(req.id, responseType, errBadLength, nil)
+		return false
+	}
+	switch req.command {
+	case cmdPing:
+	case cmdMemoryGet:
+		body, code = s.memoryGet(r)
+	case cmdMemorySet:
+		code = s.memorySet(r)
+	case cmdCheckpointGet:
+		body, code = s.checkpointGet(r)
+	case cmdCheckpointSet:
+		body, code = s.checkpointSet(r)
+		responseType = respCheckpointInfo
+	case cmdCheckpointDelete:
+		code = s.checkpointDelete(r)
+	case cmdCheckpointList:
+		s.checkpointList(req.id)
+		body = u32(uint32(len(s.debugger.Breakpoints())))
+	case cmdCheckpointToggle:
+		code = s.checkpointToggle(r)
+	case cmdConditionSet:
+		code = s.conditionSet(r)
+	case cmdRegistersGet:
+		body, code = s.registersGet(r)
+	case cmdRegistersSet:
+		body, code = s.registersSet(r)
+		responseType = cmdRegistersGet
+	case cmdDump:
+		code = s.dump(r)
+	case cmdUndump:
+		body, code = s.undump(r)
+	case cmdAdvance:
+		s.send(req.id, responseType, errOK, nil)
+		s.advance(r)
+		return false
+	case cmdExecuteUntilRet:
+		s.send(req.id, responseType, errOK, nil)
+		s.executeUntilReturn()
+		return false
+	case cmdBanksAvailable:
+		body = s.banksAvailable()
+	case cmdRegistersAvail:
+		body, code = s.registersAvailable(r)
+	case cmdDisplayGet:
+		body, code = s.displayGet(r)
+	case cmdInfo:
+		body = append(append([]byte{uint8(len(viceVersion))}, viceVersion...), 4, 0, 0, 0, 0)
+	case cmdPaletteGet:
+		body = s.paletteGet()
+	case cmdExit:
+		resume = true
+	case cmdQuit:
+		s.quit = true
+		resume = true
+	case cmdReset:
+		code = s.reset(r)
+	case cmdAutostart:
+		code = s.autostart(r)
+		resume = code == errOK && req.body[0] != 0
+	default:
+		code = errInvalidCommand
+	}
+	if code != errOK {
+		body = nil
+	}
+	s.send(req.id, responseType, code, body)
+	return resume
+}
+
+// Minimum body length of each command. Variable length fields are checked by the
+// commands themselves.
+var minLengths = map[uint8]int{
+	cmdMemoryGet:        8,
+	cmdMemorySet:        8,
+	cmdCheckpointGet:    4,
+	cmdCheckpointSet:    8,
+	cmdCheckpointDelete: 4,
+	cmdCheckpointToggle: 5,
+	cmdConditionSet:     5,
+	cmdRegistersGet:     1,
+	cmdRegistersSet:     3,
+	cmdDump:             3,
+	cmdUndump:           1,
+	cmdAdvance:          3,
+	cmdRegistersAvail:   1,
+	cmdDisplayGet:       2,
+	cmdPaletteGet:       1,
+	cmdReset:            1,
+	cmdAutostart:        4,
+}
+
+// Parse the start of a memory request: side effects, start and end address, memory
+// space and bank ID. Without side effects, watchpoints aren't triggered.
+func (s *Server) memoryRange(r reader) (core.AddressSpace, uint16, int, bool, uint8) {
+	sideEffects := r.u8() != 0
+	start := r.u16()
+	end := r.u16()
+	memspace := r.u8()
+	bankID := int(r.u16())
+	switch {
+	case memspace != memspaceMain:
+		return nil, 0, 0, false, errInvalidMemspace
+	case end < start || bankID >= len(s.banks) || s.banks[bankID].mem == nil:
+		return nil, 0, 0, false, errInvalidParam
+	}
+	return s.banks[bankID].mem, start, int(end) - int(start) + 1, sideEffects, errOK
+}
+
+func (s *Server) memoryGet(r reader) ([]byte, uint8) {
+	mem, start, length, sideEffects, code := s.memoryRange(r)
+	if code != errOK {
+		return nil, code
+	}
+	if !sideEffects {
+		s.debugger.Mute()
+		defer s.debugger.Unmute()
+		mem = core.PeekSpace{AddressSpace: mem}
+	}
+	body := u16(uint16(length)) // 0 means 64k
+	for i := 0; i < length; i++ {
+		body = append(body, mem.ReadByte(start+uint16(i)))
+	}
+	return body, errOK
+}
+
+func (s *Server) memorySet(r reader) uint8 {
+	mem, start, length, sideEffects, code := s.memoryRange(r)
+	if code != errOK {
+		return code
+	}
+	if r.Len() != length {
+		return errBadLength
+	}
+	if !sideEffects {
+		s.debugger.Mute()
+		defer s.debugger.Unmute()
+	}
+	for i := 0; i < length; i++ {
+		mem.WriteByte(start+uint16(i), r.u8())
+	}
+	return errOK
+}
+
+func kindToOp(kind core.BreakKind) uint8 {
+	op := uint8(0)
+	if kind&core.BreakRead != 0 {
+		op |= opLoad
+	}
+	if kind&core.BreakWrite != 0 {
+		op |= opStore
+	}
+	if kind&core.BreakExec != 0 {
+		op |= opExec
+	}
+	return op
+}
+
+func opToKind(op uint8) core.BreakKind {
+	kind := core.BreakKind(0)
+	if op&opLoad != 0 {
+		kind |= core.BreakRead
+	}
+	if op&opStore != 0 {
+		kind |= core.BreakWrite
+	}
+	if op&opExec != 0 {
+		kind |= core.BreakExec
+	}
+	return kind
+}
+
+func flag(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) checkpointInfo(bp *core.Breakpoint, hit bool) []byte {
+	flags := s.flags(bp)
+	body := u32(uint32(bp.ID))
+	body = append(body, flag(hit))
+	body = append(body, u16(bp.Start)...)
+	body = append(body, u16(bp.End)...)
+	body = append(body, flag(flags.stop), flag(!bp.Disabled), kindToOp(bp.Kind), flag(flags.temporary))
+	body = append(body, u32(uint32(bp.Hits))...)
+	body = append(body, u32(uint32(bp.IgnoreCount))...)
+	return append(body, flag(bp.Condition != nil), memspaceMain)
+}
+
+func (s *Server) checkpoint(r reader) (*core.Breakpoint, uint8) {
+	bp := s.debugger.Get(int(r.u32()))
+	if bp == nil {
+		return nil, errObjectMissing
+	}
+	return bp, errOK
+}
+
+func (s *Server) checkpointGet(r reader) ([]byte, uint8) {
+	bp, code := s.checkpoint(r)
+	if code != errOK {
+		return nil, code
+	}
+	return s.checkpointInfo(bp, false), errOK
+}
+
+func (s *Server) checkpointSet(r reader) ([]byte, uint8) {
+	start := r.u16()
+	end := r.u16()
+	flags := checkpointFlags{stop: r.u8() != 0}
+	enabled := r.u8() != 0
+	kind := opToKind(r.u8())
+	flags.temporary = r.u8() != 0
+	if r.Len() > 0 && r.u8() != memspaceMain {
+		return nil, errInvalidMemspace
+	}
+	if end < start || kind == 0 {
+		return nil, errInvalidParam
+	}
+	bp := s.debugger.Add(kind, start, end, nil)
+	s.checkpoints[bp.ID] = flags
+	if !enabled {
+		s.debugger.Enable(bp.ID, false)
+	}
+	return s.checkpointInfo(bp, false), errOK
+}
+
+func (s *Server) checkpointDelete(r reader) uint8 {
+	bp, code := s.checkpoint(r)
+	if code != errOK {
+		return code
+	}
+	s.debugger.Remove(bp.ID)
+	delete(s.checkpoints, bp.ID)
+	return errOK
+}
+
+// Send the info of all checkpoints as separate responses to the list request.
+func (s *Server) checkpointList(id uint32) {
+	for _, bp := range s.debugger.Breakpoints() {
+		s.send(id, respCheckpointInfo, errOK, s.checkpointInfo(bp, false))
+	}
+}
+
+func (s *Server) checkpointToggle(r reader) uint8 {
+	bp, code := s.checkpoint(r)
+	if code != errOK {
+		return code
+	}
+	s.debugger.Enable(bp.ID, r.u8() != 0)
+	return errOK
+}
+
+func (s *Server) conditionSet(r reader) uint8 {
+	bp, code := s.checkpoint(r)
+	if code != errOK {
+		return code
+	}
+	text, err := r.str()
+	if err != nil {
+		return errBadLength
+	}
+	condition, err := core.ParseCondition(text)
+	if err != nil {
+		return errInvalidParam
+	}
+	bp.Condition = condition
+	return errOK
+}
+
+func (s *Server) registersGet(r reader) ([]byte, uint8) {
+	if r.u8() != memspaceMain {
+		return nil, errInvalidMemspace
+	}
+	regs := s.cpu.Registers()
+	values := []uint16{uint16(regs.A), uint16(regs.X), uint16(regs.Y), regs.PC, uint16(regs.SP), uint16(regs.Flags)}
+	body := u16(uint16(len(values)))
+	for id, v := range values {
+		body = append(body, 3, uint8(id))
+		body = append(body, u16(v)...)
+	}
+	return body, errOK
+}
+
+func (s *Server) registersSet(r reader) ([]byte, uint8) {
+	if r.u8() != memspaceMain {
+		return nil, errInvalidMemspace
+	}
+	regs := s.cpu.Registers()
+	for n := r.u16(); n > 0; n-- {
+		size := int(r.u8())
+		if size < 3 || r.Len() < size {
+			return nil, errBadLength
+		}
+		id := r.u8()
+		v := r.u16()
+		r.Seek(int64(size-3), io.SeekCurrent) // Skip anything we don't understand
+		switch id {
+		case regA:
+			regs.A = uint8(v)
+		case regX:
+			regs.X = uint8(v)
+		case regY:
+			regs.Y = uint8(v)
+		case regPC:
+			regs.PC = v
+		case regSP:
+			regs.SP = uint8(v)
+		case regFL:
+			regs.Flags = uint8(v)
+		default:
+			return nil, errObjectMissing
+		}
+	}
+	s.cpu.SetRegisters(regs)
+	return s.registersGet(reader{bytes.NewReader([]byte{memspaceMain})})
+}
+
+func (s *Server) registersAvailable(r reader) ([]byte, uint8) {
+	if r.u8() != memspaceMain {
+		return nil, errInvalidMemspace
+	}
+	body := u16(uint16(len(registerNames)))
+	for id, name := range registerNames {
+		bits := uint8(8)
+		if id == regPC {
+			bits = 16
+		}
+		body = append(body, uint8(3+len(name)), uint8(id), bits, uint8(len(name)))
+		body = append(body, name...)
+	}
+	return body, errOK
+}
+
+func (s *Server) banksAvailable() []byte {
+	body := u16(uint16(len(s.banks)))
+	for id, b := range s.banks {
+		body = append(body, uint8(3+len(b.name)))
+		body = append(body, u16(uint16(id))...)
+		body = append(body, uint8(len(b.name)))
+		body = append(body, b.name...)
+	}
+	return body
+}
+
+// Dump a snapshot. ROMs and disks are never saved.
+func (s *Server) dump(r reader) uint8 {
+	r.u8() // Save ROMs
+	r.u8() // Save disks
+	name, err := r.str()
+	if err != nil {
+		return errBadLength
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return errFailed
+	}
+	err = s.machine.SaveSnapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errFailed
+	}
+	return errOK
+}
+
+func (s *Server) undump(r reader) ([]byte, uint8) {
+	name, err := r.str()
+	if err != nil {
+		return nil, errBadLength
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errFailed
+	}
+	defer f.Close()
+	if err := s.machine.LoadSnapshot(f); err != nil {
+		return nil, errFailed
+	}
+	return u16(s.cpu.Registers().PC), errOK
+}
+
+// Read a byte without side effects.
+func (s *Server) peek(addr uint16) uint8 {
+	return core.Peek(s.banks[0].mem, addr)
+}
+
+// Run a number of instructions, optionally treating subroutine calls as a single
+// instruction.
+func (s *Server) advance(r reader) {
+	stepOver := r.u8() != 0
+	count := int(r.u16())
+	ret := -1 // Return address while stepping over a subroutine
+	var sp uint8
+	checkJSR := func() {
+		regs := s.cpu.Registers()
+		if stepOver && s.peek(regs.PC) == core.JSR_A {
+			ret = int(regs.PC + 3)
+			sp = regs.SP
+		}
+	}
+	checkJSR()
+	s.runUntil(func() bool {
+		if ret >= 0 {
+			regs := s.cpu.Registers()
+			if int(regs.PC) != ret || regs.SP < sp {
+				return false
+			}
+			ret = -1
+		}
+		count--
+		if count <= 0 {
+			return true
+		}
+		checkJSR()
+		return false
+	})
+}
+
+// Run until the current subroutine or interrupt handler returns.
+func (s *Server) executeUntilReturn() {
+	sp := s.cpu.Registers().SP
+	op := s.peek(s.cpu.Registers().PC)
+	s.runUntil(func() bool {
+		regs := s.cpu.Registers()
+		if (op == core.RTS || op == core.RTI) && regs.SP > sp {
+			return true
+		}
+		op = s.peek(regs.PC)
+		return false
+	})
+}
+
+func (s *Server) displayGet(r reader) ([]byte, uint8) {
+	r.u8() // Use the VIC-II. There's no VDC.
+	if r.u8() != displayFormatIndexed {
+		return nil, errInvalidParam
+	}
+	img, inner := s.machine.Display()
+	if img == nil {
+		return nil, errFailed
+	}
+	size := img.Bounds().Size()
+	body := u32(13) // Length of the fields before the buffer
+	for _, v := range []int{size.X, size.Y, inner.Min.X, inner.Min.Y, inner.Dx(), inner.Dy()} {
+		body = append(body, u16(uint16(v))...)
+	}
+	body = append(body, 8) // Bits per pixel
+	body = append(body, u32(uint32(size.X*size.Y))...)
+
+	// The screen is drawn in RGB, so map the colors back to the palette
+	indexes := map[color.RGBA]uint8{}
+	palette := s.machine.Palette()
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			index, ok := indexes[c]
+			if !ok {
+				index = nearestColor(palette, c)
+				indexes[c] = index
+			}
+			body = append(body, index)
+		}
+	}
+	return body, errOK
+}
+
+func nearestColor(palette []color.RGBA, c color.RGBA) uint8 {
+	best, bestDist := 0, -1
+	for i, p := range palette {
+		dr, dg, db := int(p.R)-int(c.R), int(p.G)-int(c.G), int(p.B)-int(c.B)
+		dist := dr*dr + dg*dg + db*db
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return uint8(best)
+}
+
+func (s *Server) paletteGet() []byte {
+	palette := s.machine.Palette()
+	body := u16(uint16(len(palette)))
+	for _, c := range palette {
+		body = append(body, 3, c.R, c.G, c.B)
+	}
+	return body
+}
+
+// Reset types 0 and 1 are soft and hard resets of the computer. The others reset
+// drives, which we don't have.
+func (s *Server) reset(r reader) uint8 {
+	switch r.u8() {
+	case 0:
+		s.machine.Reset(false)
+	case 1:
+		s.machine.Reset(true)
+	default:
+		return errInvalidParam
+	}
+	return errOK
+}
+
+// Autostart a PRG file. Disk images aren't supported, so the file index is ignored.
+func (s *Server) autostart(r reader) uint8 {
+	run := r.u8() != 0
+	r.u16() // File index
+	name, err := r.str()
+	if err != nil {
+		return errBadLength
+	}
+	prg, err := ioutil.ReadFile(name)
+	if err != nil {
+		return errFailed
+	}
+	if err := s.machine.Autostart(prg, run); err != nil {
+		return errFailed
+	}
+	return errOK
+}
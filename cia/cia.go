@@ -58,13 +58,15 @@ type CIA struct {
 	PortB     Port
 	TimerA    Timer
 	TimerB    Timer
+	TOD       TOD
 	irqActive bool
 }
 
 type Port struct {
-	data    uint8 // Input and output bits
-	ddr     uint8 // Corresponding it is 0 for input, 1 for output
-	PullUps uint8 // Corresponding bit set 1 simulates pullup-resistor
+	data      uint8 // Input and output bits
+	ddr       uint8 // Corresponding it is 0 for input, 1 for output
+	pulledLow uint8 // Lines pulled low from the outside. Wins over a high output.
+	PullUps   uint8 // Corresponding bit set 1 simulates pullup-resistor
 }
 
 type Timer struct {
@@ -105,6 +107,8 @@ func (c *CIA) WriteByte(addr uint16, data uint8) {
 		c.TimerB.latch = c.TimerB.latch&0xff00 | uint16(data)
 	case TBHI:
 		c.TimerB.latch = c.TimerB.latch&0x00ff | uint16(data)<<8
+	case TOD10TH, TODSEC, TODMIN, TODHR:
+		c.TOD.write(int(addr-TOD10TH), data)
 	case ICR:
 		if data&0x80 != 0 {
 			// Set bits
@@ -114,6 +118,9 @@ func (c *CIA) WriteByte(addr uint16, data uint8) {
 			if data&0x02 != 0 {
 				c.TimerB.irqEnabled = true
 			}
+			if data&0x04 != 0 {
+				c.TOD.irqEnabled = true
+			}
 			// TODO: More flags
 		} else {
 			// Clear bits
@@ -123,12 +130,17 @@ func (c *CIA) WriteByte(addr uint16, data uint8) {
 			if data&0x02 != 0 {
 				c.TimerB.irqEnabled = false
 			}
+			if data&0x04 != 0 {
+				c.TOD.irqEnabled = false
+			}
 			// TODO: More flags
 		}
 	case CRA:
 		c.TimerA.setControlFlags(data)
+		c.TOD.hz50 = data&0x80 != 0
 	case CRB:
 		c.TimerB.setControlFlags(data)
+		c.TOD.writeAlarm = data&0x80 != 0
 	}
 }
 
@@ -151,6 +163,8 @@ func (c *CIA) ReadByte(addr uint16) uint8 {
 		return uint8(c.TimerB.counter & 0xff)
 	case TBHI:
 		return uint8(c.TimerB.counter >> 8)
+	case TOD10TH, TODSEC, TODMIN, TODHR:
+		return c.TOD.read(int(addr - TOD10TH))
 	case ICR:
 		irqFlags := uint8(0)
 		if c.TimerA.irqOccurred {
@@ -161,6 +175,10 @@ func (c *CIA) ReadByte(addr uint16) uint8 {
 			irqFlags |= 0x01
 			c.TimerB.irqOccurred = false
 		}
+		if c.TOD.irqOccurred {
+			irqFlags |= 0x04
+			c.TOD.irqOccurred = false
+		}
 		if irqFlags != 0 {
 			irqFlags |= 0x80
 		}
@@ -173,12 +191,38 @@ func (c *CIA) ReadByte(addr uint16) uint8 {
 	return 0xff // TODO: Is this correct?
 }
 
+// Peek returns what ReadByte would, without acknowledging interrupts or latching the
+// time of day clock.
+func (c *CIA) Peek(addr uint16) uint8 {
+	addr &= 0x0f
+	switch addr {
+	case TOD10TH, TODSEC, TODMIN, TODHR:
+		return c.TOD.peek(int(addr - TOD10TH))
+	case ICR:
+		irqFlags := uint8(0)
+		if c.TimerA.irqOccurred || c.TimerB.irqOccurred {
+			irqFlags |= 0x01
+		}
+		if c.TOD.irqOccurred {
+			irqFlags |= 0x04
+		}
+		if irqFlags != 0 {
+			irqFlags |= 0x80
+		}
+		return irqFlags
+	}
+	return c.ReadByte(addr)
+}
+
 func (c *CIA) Clock() {
 	irqA := c.TimerA.irqOccurred
 	irqB := c.TimerB.irqOccurred
+	irqTOD := c.TOD.irqOccurred
 	c.TimerA.Clock() // Preserve the order A -> Bus since Bus can tick A if it reaches zero
 	c.TimerB.Clock()
-	doIrq := (c.TimerA.irqOccurred && !irqA) || (c.TimerB.irqOccurred && !irqB) // Trigger on positive edge
+	c.TOD.clock()
+	doIrq := (c.TimerA.irqOccurred && !irqA) || (c.TimerB.irqOccurred && !irqB) ||
+		(c.TOD.irqOccurred && !irqTOD) // Trigger on positive edge
 
 	// TODO: Other stuff that might cause an interrupt
 
@@ -188,20 +232,107 @@ func (c *CIA) Clock() {
 	}
 }
 
+// State is the part of the CIA state that is saved in snapshots.
+type State struct {
+	PortA     PortState
+	PortB     PortState
+	TimerA    TimerState
+	TimerB    TimerState
+	TOD       TODState
+	IRQActive bool
+}
+
+type PortState struct {
+	Data uint8
+	DDR  uint8
+}
+
+type TimerState struct {
+	Counter     uint16
+	Latch       uint16
+	Running     bool
+	Source      int
+	Continuous  bool
+	IRQEnabled  bool
+	IRQOccurred bool
+}
+
+func (c *CIA) State() State {
+	return State{
+		PortA:     PortState{Data: c.PortA.data, DDR: c.PortA.ddr},
+		PortB:     PortState{Data: c.PortB.data, DDR: c.PortB.ddr},
+		TimerA:    c.TimerA.state(),
+		TimerB:    c.TimerB.state(),
+		TOD:       c.TOD.state(),
+		IRQActive: c.irqActive,
+	}
+}
+
+// SetState restores the CIA from a snapshot. Pending external timer ticks are dropped.
+func (c *CIA) SetState(s State) {
+	c.PortA.data, c.PortA.ddr = s.PortA.Data, s.PortA.DDR
+	c.PortB.data, c.PortB.ddr = s.PortB.Data, s.PortB.DDR
+	c.TimerA.setState(s.TimerA)
+	c.TimerB.setState(s.TimerB)
+	c.TOD.setState(s.TOD)
+	c.irqActive = s.IRQActive
+}
+
+func (t *Timer) state() TimerState {
+	return TimerState{
+		Counter:     t.counter,
+		Latch:       t.latch,
+		Running:     t.running,
+		Source:      t.source,
+		Continuous:  t.continuous,
+		IRQEnabled:  t.irqEnabled,
+		IRQOccurred: t.irqOccurred,
+	}
+}
+
+func (t *Timer) setState(s TimerState) {
+	atomic.StoreInt64(&t.pendingTicks, 0)
+	t.counter = s.Counter
+	t.latch = s.Latch
+	t.running = s.Running
+	t.source = s.Source
+	t.continuous = s.Continuous
+	t.irqEnabled = s.IRQEnabled
+	t.irqOccurred = s.IRQOccurred
+}
+
+// internalRead returns the level of the pins. The outputs are open collector, so a line
+// set high can still be pulled low by a device, like a joystick on port A.
 func (p *Port) internalRead() uint8 {
-	return p.data & ^p.ddr | p.PullUps&p.ddr
+	return p.data & ^p.ddr | p.data&p.ddr&^p.pulledLow
 }
 
 func (p *Port) internalWrite(data uint8) {
 	p.data = p.data & ^p.ddr | data&p.ddr
 }
 
+// ReadPins returns the level of the lines, which is what reading the data register gives.
+func (p *Port) ReadPins() uint8 {
+	return p.internalRead()
+}
+
 func (p *Port) ReadOutputs() uint8 {
 	return p.data&p.ddr | p.PullUps & ^p.ddr
 }
 
+// SetInputs sets the level of all lines from the outside. Cleared bits also pull output
+// lines low.
 func (p *Port) SetInputs(data uint8) {
 	p.data = p.data&p.ddr | data & ^p.ddr
+	p.pulledLow = ^data
+}
+
+// PullInputsLow pulls lines low wherever data has a cleared bit, whether they are inputs
+// or outputs. Used when several devices drive the same port, like the keyboard matrix
+// and a joystick do.
+func (p *Port) PullInputsLow(data uint8) {
+	p.data &= data | p.ddr
+	p.pulledLow |= ^data
 }
 
 func (t *Timer) PulseCNT() {
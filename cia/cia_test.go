@@ -22,6 +22,7 @@
 package cia
 
 import (
+	"github.com/prydin/emu6502/core"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
@@ -60,9 +61,10 @@ func TestPort_SetInputs(t *testing.T) {
 		for ddr := uint8(0);; {
 			p.ddr = ddr
 			for data := uint8(0);; {
+				p.internalWrite(pullup) // Output latch
 				p.SetInputs(data)
 				d := p.internalRead()
-				require.Equal(t, pullup & ddr | data & ^ddr, d, "Data mismatch. pu=%02x, ddr=%02x, data=%02x", pullup, ddr, data)
+				require.Equal(t, pullup & data & ddr | data & ^ddr, d, "Data mismatch. pu=%02x, ddr=%02x, data=%02x", pullup, ddr, data)
 				if data == 255 {
 					break
 				}
@@ -79,3 +81,103 @@ func TestPort_SetInputs(t *testing.T) {
 		pullup++
 	}
 }
+
+func TestPort_PullInputsLow(t *testing.T) {
+	c := CIA{}
+	c.Init(nil)
+	c.WriteByte(DDRA, 0xff)
+	c.WriteByte(PRA, 0x7f)
+	c.PortA.SetInputs(0xff)
+	require.Equal(t, uint8(0x7f), c.ReadByte(PRA))
+	c.PortA.PullInputsLow(0xef) // Joystick fire
+	require.Equal(t, uint8(0x6f), c.ReadByte(PRA), "Open collector outputs can be pulled low")
+	require.Equal(t, uint8(0x7f), c.PortA.ReadOutputs(), "The output latch is unaffected")
+	require.Equal(t, uint8(0x6f), c.PortA.ReadPins())
+	c.PortA.SetInputs(0xff)
+	require.Equal(t, uint8(0x7f), c.ReadByte(PRA))
+}
+
+func TestCIA_Peek(t *testing.T) {
+	bus := &core.Bus{}
+	c := CIA{}
+	c.Init(bus)
+	c.TimerA.irqOccurred = true
+	c.irqActive = true
+	bus.NotIRQ.PullDown()
+	require.Equal(t, uint8(0x81), c.Peek(ICR))
+	require.Equal(t, uint8(0x81), c.Peek(ICR))
+	require.False(t, bus.NotIRQ.Get(), "Peeking doesn't acknowledge the interrupt")
+	require.Equal(t, uint8(0x81), c.ReadByte(ICR))
+	require.Equal(t, uint8(0x00), c.Peek(ICR))
+
+	c.Peek(TODHR)
+	require.False(t, c.TOD.latched, "Peeking doesn't latch the time")
+	require.Equal(t, c.ReadByte(TALO), c.Peek(TALO))
+}
+
+func TestCIA_State(t *testing.T) {
+	c := CIA{}
+	c.Init(nil)
+	c.WriteByte(DDRA, 0xf0)
+	c.WriteByte(PRA, 0xa5)
+	c.WriteByte(TALO, 0x34)
+	c.WriteByte(TAHI, 0x12)
+	c.WriteByte(CRA, 0x11)
+	c.Clock()
+	state := c.State()
+
+	restored := CIA{}
+	restored.Init(nil)
+	restored.SetState(state)
+	require.Equal(t, state, restored.State())
+	require.Equal(t, uint8(0x33), restored.ReadByte(TALO))
+	require.Equal(t, uint8(0xf0), restored.ReadByte(DDRA))
+}
+
+func TestCIA_TOD(t *testing.T) {
+	bus := &core.Bus{}
+	c := CIA{}
+	c.Init(bus)
+	c.SetTODInput(600, 60) // A pulse every ten cycles
+	c.WriteByte(TODHR, 0x91)
+	c.WriteByte(TODMIN, 0x59)
+	c.WriteByte(TODSEC, 0x59)
+	for i := 0; i < 100; i++ {
+		c.Clock()
+	}
+	require.Equal(t, uint8(0x59), c.ReadByte(TODSEC), "Stopped until the tenths are written")
+	c.WriteByte(TOD10TH, 0x09)
+
+	// Alarm at midnight
+	c.WriteByte(CRB, 0x80)
+	c.WriteByte(TODHR, 0x12)
+	c.WriteByte(TODMIN, 0)
+	c.WriteByte(TODSEC, 0)
+	c.WriteByte(TOD10TH, 0)
+	c.WriteByte(CRB, 0x00)
+	c.WriteByte(ICR, 0x84)
+
+	for i := 0; i < 59; i++ {
+		c.Clock()
+	}
+	require.True(t, bus.NotIRQ.Get())
+	c.Clock()
+	require.False(t, bus.NotIRQ.Get())
+	require.Equal(t, uint8(0x84), c.ReadByte(ICR))
+
+	// Reading the hours latches the time until the tenths are read
+	require.Equal(t, uint8(0x12), c.ReadByte(TODHR), "11 PM wraps to 12 AM")
+	for i := 0; i < 60; i++ {
+		c.Clock()
+	}
+	require.Equal(t, uint8(0x00), c.ReadByte(TODSEC))
+	require.Equal(t, uint8(0x00), c.ReadByte(TOD10TH))
+	require.Equal(t, uint8(0x01), c.ReadByte(TOD10TH))
+
+	// A 60 Hz input makes the clock run fast when set up for 50 Hz
+	c.WriteByte(CRA, 0x80)
+	for i := 0; i < 50; i++ {
+		c.Clock()
+	}
+	require.Equal(t, uint8(0x02), c.ReadByte(TOD10TH))
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package cia
+
+// TOD is the time of day clock. It counts tenths of seconds, seconds, minutes and
+// hours in BCD, driven by the mains frequency on the TOD pin. The CIA must be told
+// whether that's 50 or 60 Hz through bit 7 of CRA, or the clock runs fast or slow.
+type TOD struct {
+	time        [4]uint8 // Tenths, seconds, minutes and hours with the PM flag, like the registers
+	alarm       [4]uint8
+	latch       [4]uint8
+	latched     bool // Reads come from the latch until the tenths are read
+	halted      bool // Writing the hours stops the clock until the tenths are written
+	hz50        bool // CRA bit 7
+	writeAlarm  bool // CRB bit 7
+	ticks       uint8
+	irqEnabled  bool
+	irqOccurred bool
+
+	// Pulses on the TOD pin are derived from the system clock
+	clockFrequency uint32
+	powerFrequency uint32
+	phase          uint32
+}
+
+// TODState is the part of the TOD state that is saved in snapshots.
+type TODState struct {
+	Time        [4]uint8
+	Alarm       [4]uint8
+	Latch       [4]uint8
+	Latched     bool
+	Halted      bool
+	Hz50        bool
+	WriteAlarm  bool
+	Ticks       uint8
+	IRQEnabled  bool
+	IRQOccurred bool
+}
+
+// SetTODInput sets the mains frequency fed to the TOD pin and the frequency the CIA
+// is clocked at, both in Hz. The clock doesn't run until this is called.
+func (c *CIA) SetTODInput(clockFrequency, powerFrequency uint32) {
+	c.TOD.clockFrequency = clockFrequency
+	c.TOD.powerFrequency = powerFrequency
+	c.TOD.phase = 0
+}
+
+// Called every cycle.
+func (t *TOD) clock() {
+	if t.clockFrequency == 0 {
+		return
+	}
+	t.phase += t.powerFrequency
+	if t.phase < t.clockFrequency {
+		return
+	}
+	t.phase -= t.clockFrequency
+	t.ticks++
+	divider := uint8(6)
+	if t.hz50 {
+		divider = 5
+	}
+	if t.ticks < divider {
+		return
+	}
+	t.ticks = 0
+	if t.halted {
+		return
+	}
+	t.advance()
+	if t.time == t.alarm && t.irqEnabled {
+		t.irqOccurred = true
+	}
+}
+
+// Advance the time by a tenth of a second. The hours go from 12 to 1, and the PM flag
+// flips when going from 11 to 12.
+func (t *TOD) advance() {
+	if t.time[0] = (t.time[0] + 1) & 0x0f; t.time[0] < 10 {
+		return
+	}
+	t.time[0] = 0
+	if t.time[1] = bcdIncrement(t.time[1] & 0x7f); t.time[1] < 0x60 {
+		return
+	}
+	t.time[1] = 0
+	if t.time[2] = bcdIncrement(t.time[2] & 0x7f); t.time[2] < 0x60 {
+		return
+	}
+	t.time[2] = 0
+	pm := t.time[3] & 0x80
+	hours := t.time[3] & 0x1f
+	switch hours {
+	case 0x11:
+		pm ^= 0x80
+		hours = 0x12
+	case 0x12:
+		hours = 0x01
+	default:
+		hours = bcdIncrement(hours)
+	}
+	t.time[3] = pm | hours
+}
+
+func bcdIncrement(v uint8) uint8 {
+	v++
+	if v&0x0f > 9 {
+		v += 6
+	}
+	return v
+}
+
+// Reading the hours freezes what's read until the tenths have been read, so that the
+// time can be read without the clock moving on in between.
+func (t *TOD) read(reg int) uint8 {
+	if reg == 3 && !t.latched {
+		t.latch = t.time
+		t.latched = true
+	}
+	value := t.time[reg]
+	if t.latched {
+		value = t.latch[reg]
+	}
+	if reg == 0 {
+		t.latched = false
+	}
+	return value
+}
+
+// peek returns what read would, without latching or unlatching the time.
+func (t *TOD) peek(reg int) uint8 {
+	if t.latched {
+		return t.latch[reg]
+	}
+	return t.time[reg]
+}
+
+// Writing the hours stops the clock until the tenths have been written, so that the
+// time can be set without the clock moving on in between.
+func (t *TOD) write(reg int, data uint8) {
+	masks := [4]uint8{0x0f, 0x7f, 0x7f, 0x9f}
+	data &= masks[reg]
+	if t.writeAlarm {
+		t.alarm[reg] = data
+		return
+	}
+	switch reg {
+	case 0:
+		t.halted = false
+		t.ticks = 0
+	case 3:
+		t.halted = true
+	}
+	t.time[reg] = data
+}
+
+func (t *TOD) state() TODState {
+	return TODState{
+		Time:        t.time,
+		Alarm:       t.alarm,
+		Latch:       t.latch,
+		Latched:     t.latched,
+		Halted:      t.halted,
+		Hz50:        t.hz50,
+		WriteAlarm:  t.writeAlarm,
+		Ticks:       t.ticks,
+		IRQEnabled:  t.irqEnabled,
+		IRQOccurred: t.irqOccurred,
+	}
+}
+
+func (t *TOD) setState(s TODState) {
+	t.time = s.Time
+	t.alarm = s.Alarm
+	t.latch = s.Latch
+	t.latched = s.Latched
+	t.halted = s.Halted
+	t.hz50 = s.Hz50
+	t.writeAlarm = s.WriteAlarm
+	t.ticks = s.Ticks
+	t.irqEnabled = s.IRQEnabled
+	t.irqOccurred = s.IRQOccurred
+	t.phase = 0
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// disasm disassembles a PRG file or a raw binary into source that can be assembled
+// again with go6502's assembler or ca65.
+package main
+
+import (
+	"flag"
+	"github.com/prydin/emu6502/disasm"
+	"github.com/prydin/emu6502/symbols"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var in = flag.String("in", "", "PRG file, or raw binary if -origin is given")
+var out = flag.String("out", "", "source file to write. Defaults to stdout")
+var origin = flag.String("origin", "", "load address of a raw binary in hex, e.g. c000")
+var entries = flag.String("entry", "", "additional entry points in hex, separated by commas. The SYS address or load address is always one")
+var syntax = flag.String("syntax", "go6502", "assembler syntax (go6502, ca65)")
+var labels = flag.String("labels", "", "VICE label or ca65 debug info file with names to use")
+
+func main() {
+	flag.Parse()
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var d *disasm.Disassembler
+	if *origin != "" {
+		addr, err := strconv.ParseUint(*origin, 16, 16)
+		if err != nil {
+			log.Fatalf("Invalid origin: %s", *origin)
+		}
+		d = disasm.New(uint16(addr), data)
+	} else if d, err = disasm.NewFromPRG(data); err != nil {
+		log.Fatal(err)
+	}
+	if *entries != "" {
+		for _, e := range strings.Split(*entries, ",") {
+			addr, err := strconv.ParseUint(e, 16, 16)
+			if err != nil {
+				log.Fatalf("Invalid entry point: %s", e)
+			}
+			d.AddEntry(uint16(addr))
+		}
+	}
+	switch *syntax {
+	case "go6502":
+		d.Syntax = disasm.Go6502
+	case "ca65":
+		d.Syntax = disasm.CA65
+	default:
+		log.Fatalf("Unknown syntax: %s", *syntax)
+	}
+	if *labels != "" {
+		d.Symbols = symbols.New()
+		if err := d.Symbols.Load(*labels); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := d.Write(w); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
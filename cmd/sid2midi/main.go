@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// sid2midi converts a SID register write log (as produced by the -sidlog option of
+// the emulator) to a standard MIDI file, or dumps it as text.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/prydin/emu6502/sid"
+	"log"
+	"os"
+)
+
+var in = flag.String("in", "", "SID log to read")
+var out = flag.String("out", "", "MIDI file to write")
+var dump = flag.Bool("dump", false, "print the register writes as text")
+
+func main() {
+	flag.Parse()
+	if *in == "" || *out == "" && !*dump {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	header, entries, err := sid.ReadLog(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dump {
+		w := bufio.NewWriter(os.Stdout)
+		fmt.Fprintf(w, "; model %d, clock %d Hz, %d writes\n", header.Model, header.ClockFreq, len(entries))
+		for _, e := range entries {
+			fmt.Fprintf(w, "%12d $%02X $%02X\n", e.Cycle, e.Reg, e.Data)
+		}
+		w.Flush()
+	}
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := sid.ConvertToMIDI(header, entries, f); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
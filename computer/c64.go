@@ -22,21 +22,68 @@
 package computer
 
 import (
+	"fmt"
 	"github.com/prydin/emu6502/charset"
 	"github.com/prydin/emu6502/cia"
+	"github.com/prydin/emu6502/controlport"
 	"github.com/prydin/emu6502/core"
 	"github.com/prydin/emu6502/keyboard"
+	"github.com/prydin/emu6502/sid"
 	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"strconv"
+	"strings"
 )
 
+const DefaultSampleRate = 44100
+
+// SIDSlot describes a SID chip attached to the I/O area. The first chip is mirrored
+// across $D400-$D7FF. Additional chips are decoded at exactly 32 bytes, either within
+// the mirror range (e.g. $D420 or $D500) or in the I/O expansion areas at $DE00-$DFFF.
+type SIDSlot struct {
+	Address   uint16
+	Model     int     // sid.MOS6581 or sid.MOS8580
+	Pan       float64 // -1.0 is hard left, 0.0 is center and 1.0 is hard right
+	DigiBoost bool    // Make volume register digis audible on the 8580
+}
+
+var DefaultSIDSlots = []SIDSlot{{Address: 0xd400, Model: sid.MOS6581}}
+
 type Commodore64 struct {
 	Cpu      core.CPU
 	Vic      vic_ii.VicII
 	Bus      core.Bus
+	SIDs     []*sid.SID
+	Debugger *core.Debugger
 	ram      core.RAM
 	Keyboard *keyboard.Keyboard
+	vicBus   *core.Bus // Memory as seen by the VIC-II
+	ramBus   *core.Bus // All of the RAM without ROM or I/O overlays
+	colorRam *core.RAM
+	cias     [2]*cia.CIA
+	frames   *vic_ii.FrameGrabber
+	dims     vic_ii.ScreenDimensions
+
+	// Devices in the control ports. Plug devices in after calling Init.
+	ControlPorts *controlport.ControlPorts
+
+	// Sound configuration. Must be set before calling Init.
+	SIDSlots   []SIDSlot     // SIDs to attach. Uses DefaultSIDSlots if empty.
+	Audio      sid.AudioSink // Receives the stereo mix. No samples are generated if nil.
+	SampleRate int           // Uses DefaultSampleRate if zero.
+
+	// Keep a copy of the last complete frame for Display, e.g. for the binary monitor.
+	// It costs some time for every pixel, so it's off by default. Must be set before
+	// calling Init.
+	GrabFrames bool
+
+	// Post-processing for the frames returned by Display, usually the filter of the
+	// screen. Only the settings are used, so the screen can keep using it. Must be set
+	// before calling Init.
+	Filter *vic_ii.CRTFilter
 }
 
+// Clock advances the machine by half a cycle. The VIC-II drives the rest of the
+// machine, like the clock generator of a real C64 does.
 func (c *Commodore64) Clock() {
 	c.Vic.Clock()
 }
@@ -46,11 +93,23 @@ func (c *Commodore64) Init(screen vic_ii.Raster, dimensions vic_ii.ScreenDimensi
 	c.Cpu = core.CPU{}
 	c.Vic = vic_ii.VicII{}
 	c.Bus = core.Bus{}
-	vbus := core.Bus{}
+	vbus := &core.Bus{}
+	c.vicBus = vbus
 	c.Bus.ConnectClockablePh1(&c.Cpu)
 	colorRam := core.MakeRAM(1024)
+	c.colorRam = colorRam
+	c.dims = dimensions
 	c.Cpu.Init(&c.Bus)
-	c.Vic.Init(&vbus, &c.Bus, colorRam, screen, dimensions)
+	c.Debugger = core.NewDebugger(&c.Cpu, &c.Bus)
+	c.frames = nil
+	if c.GrabFrames {
+		c.frames = vic_ii.NewFrameGrabber(screen, int(dimensions.VisibleWidth), int(dimensions.VisibleHeight))
+		if c.Filter != nil {
+			c.frames.Filter = c.Filter.Clone() // The screen may use the same filter in its own goroutine
+		}
+		screen = c.frames
+	}
+	c.Vic.Init(vbus, &c.Bus, colorRam, screen, dimensions)
 	c.ram = core.RAM{Bytes: make([]uint8, 10000)} // TODO: Size
 
 	// Load ROMs
@@ -65,8 +124,8 @@ func (c *Commodore64) Init(screen vic_ii.Raster, dimensions vic_ii.ScreenDimensi
 	}
 
 	ram0 := core.MakeRAM(40960) // Main RAM
-	ram1 := core.MakeRAM(8192)  // High RAM
-	ram2 := core.MakeRAM(4096)  // Banked RAM
+	ram1 := core.MakeRAM(4096)  // High RAM
+	ram2 := core.MakeRAM(8192)  // Banked RAM
 	ram3 := core.MakeRAM(4096)  // Banked RAM
 	ram4 := core.MakeRAM(8192)  // Banked RAM
 
@@ -76,23 +135,20 @@ func (c *Commodore64) Init(screen vic_ii.Raster, dimensions vic_ii.ScreenDimensi
 	cia2 := cia.CIA{}
 	cia2.Init(&c.Bus)
 	c.Bus.ConnectClockablePh1(&cia2)
+	cia1.SetTODInput(dimensions.ClockFrequency, dimensions.PowerFrequency)
+	cia2.SetTODInput(dimensions.ClockFrequency, dimensions.PowerFrequency)
+	c.cias = [2]*cia.CIA{&cia1, &cia2}
 
-	io := core.NewPagedSpace([]core.AddressSpace{
-		&c.Vic,            // D000
-		&c.Vic,            // D100
-		&c.Vic,            // D200
-		&c.Vic,            // D300
-		core.MakeRAM(256), // D400 TODO: Placeholder for SID
-		core.MakeRAM(256), // D500 TODO: Placeholder for SID
-		core.MakeRAM(256), // D600 TODO: Placeholder for SID
-		core.MakeRAM(256), // D700 TODO: Placeholder for SID
-		colorRam.Page(0),  // D800
-		colorRam.Page(1),  // D900
-		colorRam.Page(2),  // DA00
-		colorRam.Page(3),  // DB00
-		&cia1,             // DC00
-		&cia2,             // DD00
-	})
+	// The I/O area is decoded at 32 byte granularity, which is the size of the SID
+	// register file. This allows us to put additional SIDs e.g. at $D420.
+	io := core.NewDecodedSpace(0x1000, 0x20)
+	io.Map(&c.Vic, 0x0000, 0x03ff)   // D000-D3FF
+	io.Map(colorRam, 0x0800, 0x0bff) // D800-DBFF
+	io.Map(&cia1, 0x0c00, 0x0cff)    // DC00-DCFF
+	io.Map(&cia2, 0x0d00, 0x0dff)    // DD00-DDFF
+	if err := c.attachSIDs(io); err != nil {
+		return err
+	}
 
 	// Set up the main system Bus
 	switcher := core.NewBankSwitcher([][]core.AddressSpace{
@@ -108,12 +164,16 @@ func (c *Commodore64) Init(screen vic_ii.Raster, dimensions vic_ii.ScreenDimensi
 	c.Bus.Connect(switcher.GetBank(0), 0xa000, 0xbfff)
 	c.Bus.Connect(switcher.GetBank(1), 0xd000, 0xdfff)
 	c.Bus.Connect(switcher.GetBank(2), 0xe000, 0xffff)
-	c.Bus.Connect(colorRam, 0xd800, 0xdbff) // Color RAM
 
 	// Connect peripherals
 	c.Keyboard = &keyboard.Keyboard{}
 	c.Keyboard.Init(&cia1)
 	c.Bus.ConnectClockablePh1(c.Keyboard)
+	c.ControlPorts = &controlport.ControlPorts{}
+	c.ControlPorts.Init(&cia1)
+	c.ControlPorts.LightPen = &c.Vic
+	c.Bus.ConnectClockablePh1(c.ControlPorts) // Must come after the keyboard
+	c.SIDs[0].SetPotSource(c.ControlPorts)
 
 	// Set up the Vic-II Bus
 	vbus.Connect(ram0, 0x0000, 0x9fff)
@@ -122,8 +182,104 @@ func (c *Commodore64) Init(screen vic_ii.Raster, dimensions vic_ii.ScreenDimensi
 	vbus.Connect(ram3, 0xd000, 0xdfff)
 	vbus.Connect(ram4, 0xe000, 0xffff)
 
+	// Plain RAM for debuggers
+	c.ramBus = &core.Bus{}
+	c.ramBus.Connect(ram0, 0x0000, 0x9fff)
+	c.ramBus.Connect(ram1, 0xc000, 0xcfff)
+	c.ramBus.Connect(ram2, 0xa000, 0xbfff)
+	c.ramBus.Connect(ram3, 0xd000, 0xdfff)
+	c.ramBus.Connect(ram4, 0xe000, 0xffff)
+
 	// Overlay character ROM on top of RAM
 	vbus.Connect(&charset.CharacterROM, 0x1000, 0x1fff)
 	vbus.Connect(&charset.CharacterROM, 0x9000, 0x9fff)
 	return nil
 }
+
+// MemoryBanks returns the views of memory available to debuggers, keyed by the bank
+// names in core.
+func (c *Commodore64) MemoryBanks() map[string]core.AddressSpace {
+	return map[string]core.AddressSpace{
+		core.BankCPU: &c.Bus,
+		core.BankRAM: c.ramBus,
+		core.BankVIC: c.vicBus,
+	}
+}
+
+func (c *Commodore64) attachSIDs(io *core.DecodedSpace) error {
+	slots := c.SIDSlots
+	if len(slots) == 0 {
+		slots = DefaultSIDSlots
+	}
+	if len(slots) > 3 {
+		return fmt.Errorf("at most three SIDs are supported, got %d", len(slots))
+	}
+	sampleRate := c.SampleRate
+	if sampleRate == 0 {
+		sampleRate = DefaultSampleRate
+	}
+	mixer := sid.NewMixer(int(c.dims.ClockFrequency), sampleRate, c.Audio)
+	if slots[0].Address != 0xd400 {
+		return fmt.Errorf("the first SID must be located at $D400, got $%04x", slots[0].Address)
+	}
+	c.SIDs = nil
+	used := map[uint16]bool{}
+	for i, slot := range slots {
+		if slot.Address&0x1f != 0 ||
+			!(slot.Address >= 0xd400 && slot.Address <= 0xd7e0 || slot.Address >= 0xde00 && slot.Address <= 0xdfe0) {
+			return fmt.Errorf("invalid SID address: $%04x", slot.Address)
+		}
+		if used[slot.Address] {
+			return fmt.Errorf("more than one SID at $%04x", slot.Address)
+		}
+		used[slot.Address] = true
+		chip := sid.NewSID(slot.Model)
+		chip.SetDigiBoost(slot.DigiBoost)
+		if i == 0 {
+			// The first SID is mirrored across the entire SID area. Others are mapped on top of it.
+			io.Map(chip, 0x0400, 0x07ff)
+		} else {
+			io.Map(chip, slot.Address-0xd000, slot.Address-0xd000+0x1f)
+		}
+		mixer.Attach(chip, slot.Pan)
+		c.SIDs = append(c.SIDs, chip)
+	}
+	c.Bus.ConnectClockablePh2(mixer)
+	return nil
+}
+
+// ParseSIDSlots parses a comma separated list of SID specifications on the form
+// address[:model[:pan]], e.g. "d400:6581:-0.5,d420:8580:0.5". The address is given
+// in hex and the model defaults to 6581. The pan defaults to center.
+func ParseSIDSlots(spec string) ([]SIDSlot, error) {
+	var slots []SIDSlot
+	for _, s := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(s), ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("malformed SID specification: %s", s)
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(parts[0], "$"), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("malformed SID address: %s", parts[0])
+		}
+		slot := SIDSlot{Address: uint16(addr), Model: sid.MOS6581}
+		if len(parts) > 1 {
+			switch parts[1] {
+			case "6581":
+				slot.Model = sid.MOS6581
+			case "8580":
+				slot.Model = sid.MOS8580
+			default:
+				return nil, fmt.Errorf("unknown SID model: %s", parts[1])
+			}
+		}
+		if len(parts) > 2 {
+			slot.Pan, err = strconv.ParseFloat(parts[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed SID pan: %s", parts[2])
+			}
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
@@ -22,7 +22,11 @@
 package computer
 
 import (
+	"bytes"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/sid"
 	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"github.com/stretchr/testify/require"
 	"image"
 	"image/png"
 	"os"
@@ -43,3 +47,61 @@ func TestCommodore64_Boot(t *testing.T) {
 	f, _ := os.Create("basic.png")
 	png.Encode(f, img)
 }
+
+func TestParseSIDSlots(t *testing.T) {
+	slots, err := ParseSIDSlots("d400,d420:8580:1,$de00:6581:-0.5")
+	require.NoError(t, err)
+	require.Equal(t, []SIDSlot{
+		{Address: 0xd400, Model: sid.MOS6581},
+		{Address: 0xd420, Model: sid.MOS8580, Pan: 1},
+		{Address: 0xde00, Model: sid.MOS6581, Pan: -0.5},
+	}, slots)
+	_, err = ParseSIDSlots("d400:6582")
+	require.Error(t, err, "Unknown model should be rejected")
+}
+
+func TestCommodore64_StereoSID(t *testing.T) {
+	c64 := Commodore64{SIDSlots: []SIDSlot{{Address: 0xd400}, {Address: 0xd420, Model: sid.MOS8580}}}
+	img := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{403, 312}})
+	require.NoError(t, c64.Init(&vic_ii.ImageRaster{img}, vic_ii.PALDimensions))
+	require.Len(t, c64.SIDs, 2)
+	require.Equal(t, sid.MOS8580, c64.SIDs[1].Model())
+
+	c64.SIDSlots = []SIDSlot{{Address: 0xd400}, {Address: 0xd410}}
+	require.Error(t, c64.Init(&vic_ii.ImageRaster{img}, vic_ii.PALDimensions), "Misaligned SID should be rejected")
+}
+
+func TestCommodore64_DisplayFilter(t *testing.T) {
+	c64 := Commodore64{GrabFrames: true, Filter: &vic_ii.CRTFilter{Scanlines: 0.5}}
+	img := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{403, 312}})
+	require.NoError(t, c64.Init(&vic_ii.ImageRaster{img}, vic_ii.PALDimensions))
+	c64.Cpu.Reset()
+	for i := 0; i < 3*2*vic_ii.PalCycles; i++ {
+		c64.Clock()
+	}
+	frame, inner := c64.Display()
+	require.Equal(t, 2*vic_ii.PalVisibleHeight, frame.Rect.Dy(), "Scanlines double the height")
+	require.Equal(t, 2*(vic_ii.PalContentTop25Lines-vic_ii.PalFirstVisibleLine), inner.Min.Y)
+	require.Equal(t, 2*(vic_ii.PalContentBottom25Lines-vic_ii.PalFirstVisibleLine), inner.Max.Y)
+	border := frame.RGBAAt(0, inner.Min.Y-2)
+	dark := frame.RGBAAt(0, inner.Min.Y-1)
+	require.InDelta(t, float64(border.G)/2, float64(dark.G), 1, "Darkened scanline")
+}
+
+func TestCommodore64_SnapshotBankedRAM(t *testing.T) {
+	c64 := Commodore64{}
+	img := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{403, 312}})
+	require.NoError(t, c64.Init(&vic_ii.ImageRaster{img}, vic_ii.PALDimensions))
+	ram := c64.MemoryBanks()[core.BankRAM]
+	for _, addr := range []uint16{0xa000, 0xb000, 0xbfff, 0xc000, 0xcfff} {
+		ram.WriteByte(addr, uint8(addr>>8))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, c64.SaveSnapshot(&buf))
+	c64.Reset(true)
+	require.Equal(t, uint8(0), ram.ReadByte(0xb000))
+	require.NoError(t, c64.LoadSnapshot(&buf))
+	for _, addr := range []uint16{0xa000, 0xb000, 0xbfff, 0xc000, 0xcfff} {
+		require.Equalf(t, uint8(addr>>8), ram.ReadByte(addr), "RAM at $%04x", addr)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package computer
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/prydin/emu6502/cia"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/sid"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"image"
+	"image/color"
+	"io"
+)
+
+// SnapshotVersion is bumped whenever the snapshot format changes incompatibly.
+const SnapshotVersion = 1
+
+const (
+	kernalWaitKey    = 0xe5cd  // The kernal loops here while waiting for keyboard input
+	autostartTimeout = 5000000 // Cycles to wait for BASIC to become ready
+	keyboardBuffer   = 0x0277
+	keyboardCount    = 0x00c6
+	basicStart       = 0x0801
+)
+
+// BASIC pointers set after loading a program
+const (
+	basicVarStart   = 0x002d
+	basicArrayStart = 0x002f
+	basicArrayEnd   = 0x0031
+	loadEnd         = 0x00ae
+)
+
+// Snapshots are gob encoded. They are taken between instructions, so the CPU
+// doesn't need to be stopped in the middle of a microprogram.
+type snapshot struct {
+	Version  int
+	Model    string // VIC-II revision, which decides the timing
+	CPU      core.CPUState
+	RAM      []uint8
+	ColorRAM []uint8
+	VIC      vic_ii.State
+	CIAs     [2]cia.State
+	SIDs     []sid.State
+}
+
+// Reset the machine. A soft reset only resets the CPU, like the reset button on some
+// cartridges. A hard reset also clears RAM and the CIAs, like switching the power off
+// and on again.
+func (c *Commodore64) Reset(hard bool) {
+	if hard {
+		c.Debugger.Mute()
+		for addr := 0; addr <= 0xffff; addr++ {
+			c.ramBus.WriteByte(uint16(addr), 0)
+		}
+		c.Bus.WriteByte(0x0001, 0x07) // Default memory configuration
+		c.Debugger.Unmute()
+		for _, chip := range c.cias {
+			chip.SetState(cia.State{})
+		}
+		for _, chip := range c.SIDs {
+			chip.Reset()
+		}
+	}
+	c.Cpu.Reset()
+}
+
+// Autostart resets the machine, loads a PRG file once BASIC is ready and optionally
+// starts it. Programs loaded at the start of BASIC are started with RUN and others
+// with SYS to their load address.
+func (c *Commodore64) Autostart(prg []byte, run bool) error {
+	if len(prg) < 3 {
+		return errors.New("program file is too short")
+	}
+	load := int(prg[0]) | int(prg[1])<<8
+	end := load + len(prg) - 2
+	if end > 0x10000 {
+		return fmt.Errorf("program doesn't fit in memory: $%04x-$%04x", load, end)
+	}
+	c.Reset(true)
+	for i := 0; !(c.Cpu.AtInstructionBoundary() && c.Cpu.Registers().PC == kernalWaitKey); i++ {
+		if i > 2*autostartTimeout { // Clock runs half a cycle
+			return errors.New("timed out waiting for BASIC to start")
+		}
+		c.Clock()
+	}
+	for i, b := range prg[2:] {
+		c.ramBus.WriteByte(uint16(load+i), b)
+	}
+	command := fmt.Sprintf("SYS%d\r", load)
+	if load == basicStart {
+		for _, ptr := range []uint16{basicVarStart, basicArrayStart, basicArrayEnd, loadEnd} {
+			c.ramBus.WriteByte(ptr, uint8(end))
+			c.ramBus.WriteByte(ptr+1, uint8(end>>8))
+		}
+		command = "RUN\r"
+	}
+	if run {
+		for i := 0; i < len(command); i++ {
+			c.ramBus.WriteByte(uint16(keyboardBuffer+i), command[i])
+		}
+		c.ramBus.WriteByte(keyboardCount, uint8(len(command)))
+	}
+	return nil
+}
+
+// SaveSnapshot writes the state of the machine. It must be called between instructions.
+// Drives and cartridges aren't included.
+func (c *Commodore64) SaveSnapshot(w io.Writer) error {
+	c.Debugger.Mute()
+	defer c.Debugger.Unmute()
+	snap := snapshot{
+		Version:  SnapshotVersion,
+		Model:    c.dims.Model,
+		CPU:      c.Cpu.State(),
+		RAM:      make([]uint8, 0x10000),
+		ColorRAM: append([]uint8(nil), c.colorRam.Bytes...),
+		VIC:      c.Vic.State(),
+	}
+	for addr := range snap.RAM {
+		snap.RAM[addr] = c.ramBus.ReadByte(uint16(addr))
+	}
+	for i, chip := range c.cias {
+		snap.CIAs[i] = chip.State()
+	}
+	for _, chip := range c.SIDs {
+		snap.SIDs = append(snap.SIDs, chip.State())
+	}
+	return gob.NewEncoder(w).Encode(&snap)
+}
+
+// LoadSnapshot restores a snapshot written by SaveSnapshot. The machine must have the
+// same VIC-II model and number of SIDs as the one the snapshot was taken from.
+func (c *Commodore64) LoadSnapshot(r io.Reader) error {
+	var snap snapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", snap.Version)
+	}
+	if len(snap.RAM) != 0x10000 || len(snap.ColorRAM) != len(c.colorRam.Bytes) {
+		return errors.New("malformed snapshot")
+	}
+	if snap.Model != "" && snap.Model != c.dims.Model {
+		return fmt.Errorf("snapshot was taken on a %s, but the machine has a %s", snap.Model, c.dims.Model)
+	}
+	if len(snap.SIDs) != len(c.SIDs) {
+		return fmt.Errorf("snapshot has %d SIDs, but the machine has %d", len(snap.SIDs), len(c.SIDs))
+	}
+	c.Debugger.Mute()
+	defer c.Debugger.Unmute()
+	for addr, b := range snap.RAM {
+		c.ramBus.WriteByte(uint16(addr), b)
+	}
+	c.Bus.WriteByte(0x0001, snap.RAM[1]) // Restores the memory configuration
+	copy(c.colorRam.Bytes, snap.ColorRAM)
+	c.Cpu.SetState(snap.CPU)
+	c.Vic.SetState(snap.VIC)
+	for i, chip := range c.cias {
+		chip.SetState(snap.CIAs[i])
+	}
+	for i, chip := range c.SIDs {
+		chip.SetState(snap.SIDs[i])
+	}
+	return nil
+}
+
+// Display returns the last complete frame along with the area inside the borders. The
+// frame is nil unless GrabFrames was set. If there's a filter, it has been applied.
+func (c *Commodore64) Display() (*image.RGBA, image.Rectangle) {
+	left := int(c.dims.LeftBorderWidth40Cols)
+	top := int(c.dims.ContentTop25Lines - c.dims.FirstVisibleLine)
+	bottom := int(c.dims.ContentBottom25Lines - c.dims.FirstVisibleLine)
+	if c.Filter != nil {
+		top = c.Filter.OutputHeight(top)
+		bottom = c.Filter.OutputHeight(bottom)
+	}
+	inner := image.Rect(left, top, left+int(c.dims.ContentWidth40Cols), bottom)
+	if c.frames == nil {
+		return nil, inner
+	}
+	return c.frames.Frame(), inner
+}
+
+func (c *Commodore64) Palette() []color.RGBA {
+	return c.Vic.Palette()
+}
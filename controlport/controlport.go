@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package controlport
+
+import (
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/prydin/emu6502/cia"
+)
+
+// Joystick lines. They are all active low.
+const (
+	LineUp    = 0x01
+	LineDown  = 0x02
+	LineLeft  = 0x04
+	LineRight = 0x08
+	LineFire  = 0x10
+)
+
+// Device is anything that can be plugged into one of the control ports.
+type Device interface {
+	// ReadLines returns the state of the five digital lines. Cleared bits are pulled low.
+	ReadLines() uint8
+
+	// ReadPots returns the values the SID would read from POTX and POTY. 0xff means
+	// the line is left floating.
+	ReadPots() (uint8, uint8)
+}
+
+// MouseProvider is the host mouse. Typically a *pixelgl.Window.
+type MouseProvider interface {
+	MousePosition() pixel.Vec
+	Pressed(b pixelgl.Button) bool
+	Bounds() pixel.Rect
+}
+
+// ControlPorts connects the devices in the two control ports to CIA1 and the SID.
+// The digital lines of port 1 are shared with the keyboard rows on port B and
+// port 2 with the keyboard columns on port A. Only one port at a time is connected
+// to the POT lines of the SID. Bits 6 and 7 of CIA1 port A drive the analog
+// switches selecting port 1 and port 2 respectively.
+type ControlPorts struct {
+	cia   *cia.CIA
+	Ports [2]Device
+
+	// The light pen input of the VIC-II, which is wired to PB4 and the fire line of port 1
+	LightPen LightPenInput
+}
+
+// LightPenInput is implemented by chips with a light pen input, i.e. the VIC-II.
+type LightPenInput interface {
+	SetLightPen(low bool)
+}
+
+func (c *ControlPorts) Init(cia *cia.CIA) {
+	c.cia = cia
+}
+
+// Clock drives the digital lines of the ports. Must be clocked after the keyboard,
+// since the keyboard sets the port B inputs from scratch every cycle. Port 2 drives
+// port A alone, so its lines are set from scratch here. Both ports pull output lines
+// low too, since the KERNAL leaves port A configured as output. The light pen input
+// follows PB4, which the keyboard and CIA1 itself can pull low as well.
+func (c *ControlPorts) Clock() {
+	c.cia.PortA.SetInputs(c.readLines(1))
+	c.cia.PortB.PullInputsLow(c.readLines(0))
+	if c.LightPen != nil {
+		c.LightPen.SetLightPen(c.cia.PortB.ReadPins()&LineFire == 0)
+	}
+}
+
+func (c *ControlPorts) readLines(port int) uint8 {
+	if c.Ports[port] == nil {
+		return 0xff
+	}
+	return c.Ports[port].ReadLines() | 0xe0
+}
+
+func (c *ControlPorts) ReadPots() (uint8, uint8) {
+	x := uint8(0xff)
+	y := uint8(0xff)
+	selected := c.cia.PortA.ReadOutputs() >> 6
+	for i, d := range c.Ports {
+		if selected&(1<<uint(i)) == 0 || d == nil {
+			continue
+		}
+		// If both ports are selected, the resistances are in parallel and the
+		// capacitor charges faster. The lower of the two values is a good approximation.
+		px, py := d.ReadPots()
+		if px < x {
+			x = px
+		}
+		if py < y {
+			y = py
+		}
+	}
+	return x, y
+}
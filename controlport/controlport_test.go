@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package controlport
+
+import (
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/prydin/emu6502/cia"
+	"github.com/prydin/emu6502/core"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type TestMouseProvider struct {
+	pos     pixel.Vec
+	buttons map[pixelgl.Button]bool
+}
+
+func (t *TestMouseProvider) MousePosition() pixel.Vec {
+	return t.pos
+}
+
+func (t *TestMouseProvider) Pressed(b pixelgl.Button) bool {
+	return t.buttons[b]
+}
+
+func (t *TestMouseProvider) Bounds() pixel.Rect {
+	return pixel.R(0, 0, 256, 256)
+}
+
+func initPorts() (*ControlPorts, *core.Bus) {
+	bus := core.Bus{}
+	c := cia.CIA{}
+	c.Init(&bus)
+	bus.Connect(&c, 0xdc00, 0xdcff)
+	bus.WriteByte(0xdc02, 0xff) // Port A is output
+	bus.WriteByte(0xdc03, 0x00) // Port B is input
+	ports := &ControlPorts{}
+	ports.Init(&c)
+	return ports, &bus
+}
+
+func TestControlPorts_PotSelection(t *testing.T) {
+	ports, bus := initPorts()
+	p1 := &TestMouseProvider{pos: pixel.V(0, 0)}
+	p2 := &TestMouseProvider{pos: pixel.V(256, 256)}
+	ports.Ports[0] = NewPaddles(p1)
+	ports.Ports[1] = NewPaddles(p2)
+
+	bus.WriteByte(0xdc00, 0x40) // Select port 1
+	x, y := ports.ReadPots()
+	require.Equal(t, uint8(0xff), x)
+	require.Equal(t, uint8(0xff), y)
+
+	bus.WriteByte(0xdc00, 0x80) // Select port 2
+	x, y = ports.ReadPots()
+	require.Equal(t, uint8(0x00), x)
+	require.Equal(t, uint8(0x00), y)
+
+	bus.WriteByte(0xdc00, 0x00) // Nothing selected
+	x, _ = ports.ReadPots()
+	require.Equal(t, uint8(0xff), x)
+}
+
+func TestControlPorts_Lines(t *testing.T) {
+	ports, bus := initPorts()
+	mouse := &TestMouseProvider{buttons: map[pixelgl.Button]bool{pixelgl.MouseButtonLeft: true}}
+	ports.Ports[0] = NewMouse1351(mouse)
+	ports.cia.PortB.SetInputs(0xff) // What the keyboard drives with no keys pressed
+	ports.Clock()
+	require.Equal(t, uint8(0xff&^LineFire), bus.ReadByte(0xdc01), "Left mouse button should pull fire line low")
+}
+
+func TestControlPorts_LinesPort2(t *testing.T) {
+	ports, bus := initPorts() // DDRA=$FF, like the KERNAL leaves it
+	mouse := &TestMouseProvider{buttons: map[pixelgl.Button]bool{pixelgl.MouseButtonLeft: true}}
+	ports.Ports[1] = NewMouse1351(mouse)
+	bus.WriteByte(0xdc00, 0x7f)
+	ports.Clock()
+	require.Equal(t, uint8(0x7f&^LineFire), bus.ReadByte(0xdc00), "Left mouse button should pull fire line low")
+	mouse.buttons[pixelgl.MouseButtonLeft] = false
+	ports.Clock()
+	require.Equal(t, uint8(0x7f), bus.ReadByte(0xdc00))
+}
+
+func TestMouse1351_Movement(t *testing.T) {
+	mouse := &TestMouseProvider{pos: pixel.V(100, 100)}
+	m := NewMouse1351(mouse)
+	x0, y0 := m.ReadPots()
+	mouse.pos = pixel.V(105, 97)
+	x1, y1 := m.ReadPots()
+	require.Equal(t, uint8(10), (x1-x0)&0x7e, "Horizontal movement should be reported in bits 1-6")
+	require.Equal(t, uint8(0x7e&-6), (y1-y0)&0x7e, "Vertical movement should be reported in bits 1-6")
+}
+
+// Maps host positions one to one to pixels, with rows counted from the top
+type testDisplay struct{}
+
+func (testDisplay) PixelAt(pos pixel.Vec) (int, int, bool) {
+	return int(pos.X), 255 - int(pos.Y), pos.X >= 0 && pos.Y >= 0 && pos.X < 256 && pos.Y < 256
+}
+
+type testBeam struct {
+	beam vic_ii.Beam
+}
+
+func (t *testBeam) Beam() vic_ii.Beam {
+	return t.beam
+}
+
+type testLightPenInput struct {
+	low bool
+}
+
+func (t *testLightPenInput) SetLightPen(low bool) {
+	t.low = low
+}
+
+func TestLightPen(t *testing.T) {
+	ports, _ := initPorts()
+	mouse := &TestMouseProvider{pos: pixel.V(20, 255-10), buttons: map[pixelgl.Button]bool{}}
+	beam := &testBeam{}
+	input := &testLightPenInput{}
+	pen := NewLightPen(mouse, testDisplay{}, beam, vic_ii.PALDimensions)
+	ports.Ports[0] = pen
+	ports.LightPen = input
+	clock := func() {
+		ports.cia.PortB.SetInputs(0xff) // What the keyboard drives with no keys pressed
+		ports.Clock()
+	}
+
+	// Pixel 20 is drawn in the third visible cycle
+	beam.beam = vic_ii.Beam{RasterLine: 10 + vic_ii.PalFirstVisibleLine, Cycle: vic_ii.PalFirstVisibleCycle + 2}
+	clock()
+	require.False(t, input.low, "Nothing happens until the button is pressed")
+	mouse.buttons[pixelgl.MouseButtonLeft] = true
+	clock()
+	require.True(t, input.low)
+	beam.beam.Cycle++
+	clock()
+	require.False(t, input.low, "The pen only sees the beam while it passes")
+
+	// A slow pen fires later
+	pen.Delay = 8
+	clock()
+	require.True(t, input.low)
+
+	// Outside of the picture
+	mouse.pos = pixel.V(-1, 0)
+	clock()
+	require.False(t, input.low)
+}
+
+func TestLightPen_PB4(t *testing.T) {
+	ports, bus := initPorts()
+	input := &testLightPenInput{}
+	ports.LightPen = input
+	ports.cia.PortB.SetInputs(0xff)
+	ports.Clock()
+	require.False(t, input.low)
+
+	// Software can trigger the light pen by driving PB4 low, e.g. to latch the beam position
+	bus.WriteByte(0xdc03, LineFire)
+	bus.WriteByte(0xdc01, 0x00)
+	ports.cia.PortB.SetInputs(0xff)
+	ports.Clock()
+	require.True(t, input.low)
+	bus.WriteByte(0xdc01, LineFire)
+	ports.cia.PortB.SetInputs(0xff)
+	ports.Clock()
+	require.False(t, input.low)
+
+	// So does a key in the same row of the keyboard matrix
+	bus.WriteByte(0xdc03, 0x00)
+	ports.cia.PortB.SetInputs(0xff &^ LineFire)
+	ports.Clock()
+	require.True(t, input.low)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package controlport
+
+import (
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
+)
+
+// Display maps positions of the host mouse to pixels of the emulated picture, counted
+// from the top left of the visible area. Typically a *screen.Screen.
+type Display interface {
+	PixelAt(pos pixel.Vec) (int, int, bool)
+}
+
+// BeamSource knows where the raster beam is. Typically the VIC-II.
+type BeamSource interface {
+	Beam() vic_ii.Beam
+}
+
+// LightPen emulates a light pen pointed at the pixel under the host mouse. While the
+// left button is pressed, the pen pulls the fire line low in the cycle the beam draws
+// that pixel, which makes the VIC-II latch the beam position. The pen doesn't look
+// at the color of the pixel, so it sees black just as well as white. It only works
+// in port 1, since that's where the light pen input of the VIC-II is.
+type LightPen struct {
+	provider MouseProvider
+	display  Display
+	beam     BeamSource
+	dims     vic_ii.ScreenDimensions
+
+	// Pixels the pen reacts late. Real pens need some time to respond to the light,
+	// so programs usually expect LPX to be a little to the right of the tip.
+	Delay int
+}
+
+func NewLightPen(provider MouseProvider, display Display, beam BeamSource, dims vic_ii.ScreenDimensions) *LightPen {
+	return &LightPen{provider: provider, display: display, beam: beam, dims: dims}
+}
+
+func (l *LightPen) ReadLines() uint8 {
+	lines := uint8(0xff)
+	if !l.provider.Pressed(pixelgl.MouseButtonLeft) {
+		return lines
+	}
+	x, y, ok := l.display.PixelAt(l.provider.MousePosition())
+	if !ok {
+		return lines
+	}
+	cycle := (int(l.dims.FirstVisibleCycle) + (x+l.Delay)/8) % int(l.dims.CyclesPerLine)
+	b := l.beam.Beam()
+	if int(b.RasterLine) == y+int(l.dims.FirstVisibleLine) && int(b.Cycle) == cycle {
+		lines &^= LineFire
+	}
+	return lines
+}
+
+// A light pen has no analog lines.
+func (l *LightPen) ReadPots() (uint8, uint8) {
+	return 0xff, 0xff
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package controlport
+
+import (
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+)
+
+// Mouse1351 emulates the Commodore 1351 mouse in proportional mode. The mouse keeps
+// track of its own position and reports it modulo 64 in bits 1-6 of the POT values.
+// Software compares successive readings to find out how far the mouse moved.
+type Mouse1351 struct {
+	provider    MouseProvider
+	Sensitivity float64 // Emulated mouse steps per host pixel
+	x           float64
+	y           float64
+	lastPos     pixel.Vec
+	tracking    bool
+}
+
+func NewMouse1351(provider MouseProvider) *Mouse1351 {
+	return &Mouse1351{provider: provider, Sensitivity: 1.0}
+}
+
+// The left button is connected to the fire line and the right button to the up line.
+func (m *Mouse1351) ReadLines() uint8 {
+	lines := uint8(0xff)
+	if m.provider.Pressed(pixelgl.MouseButtonLeft) {
+		lines &^= LineFire
+	}
+	if m.provider.Pressed(pixelgl.MouseButtonRight) {
+		lines &^= LineUp
+	}
+	return lines
+}
+
+func (m *Mouse1351) ReadPots() (uint8, uint8) {
+	pos := m.provider.MousePosition()
+	if m.tracking {
+		// The host Y axis points up, just like the one of the 1351.
+		m.x += (pos.X - m.lastPos.X) * m.Sensitivity
+		m.y += (pos.Y - m.lastPos.Y) * m.Sensitivity
+	}
+	m.lastPos = pos
+	m.tracking = true
+	return uint8(int(m.x)&0x3f) << 1, uint8(int(m.y)&0x3f) << 1
+}
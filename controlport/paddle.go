@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package controlport
+
+import (
+	"github.com/faiface/pixel/pixelgl"
+)
+
+// Paddles emulates a pair of paddles driven by the host mouse. The horizontal mouse
+// position turns the first paddle and the vertical position turns the second one.
+// The left and right mouse buttons are the respective fire buttons.
+type Paddles struct {
+	provider MouseProvider
+}
+
+func NewPaddles(provider MouseProvider) *Paddles {
+	return &Paddles{provider: provider}
+}
+
+// The fire buttons of the paddles are connected to the joystick left and right lines.
+func (p *Paddles) ReadLines() uint8 {
+	lines := uint8(0xff)
+	if p.provider.Pressed(pixelgl.MouseButtonLeft) {
+		lines &^= LineLeft
+	}
+	if p.provider.Pressed(pixelgl.MouseButtonRight) {
+		lines &^= LineRight
+	}
+	return lines
+}
+
+// Turning a paddle clockwise lowers its resistance, so moving the mouse right or up
+// lowers the value read by the SID.
+func (p *Paddles) ReadPots() (uint8, uint8) {
+	pos := p.provider.MousePosition()
+	b := p.provider.Bounds()
+	return toPaddleValue(pos.X, b.Min.X, b.Max.X), toPaddleValue(pos.Y, b.Min.Y, b.Max.Y)
+}
+
+func toPaddleValue(pos, min, max float64) uint8 {
+	if max <= min {
+		return 0x80
+	}
+	f := (pos - min) / (max - min)
+	if f < 0 {
+		f = 0
+	}
+	if f > 1 {
+		f = 1
+	}
+	return uint8(255 - f*255)
+}
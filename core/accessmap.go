@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// AccessConfigs is the number of memory configurations an access map keeps apart,
+// one for each setting of the bank switching bits in the CPU port.
+const AccessConfigs = 8
+
+// DefaultFadeCycles is how often the heat fades unless told otherwise. One PAL frame.
+const DefaultFadeCycles = 19656
+
+// Heat added per access and the most an address can get
+const (
+	heatPerAccess = 64
+	maxHeat       = 0xffff
+)
+
+// Kinds of accesses counted by an access map
+const (
+	AccessExec = iota // Instruction bytes, i.e. opcodes and operands
+	AccessRead
+	AccessWrite
+	accessKinds
+)
+
+// AccessCounts holds the number of accesses of each kind to an address.
+type AccessCounts [accessKinds]uint64
+
+// AccessMap counts the CPU's accesses to each address, separately for each memory
+// configuration, so that ROM and the RAM underneath it aren't mixed up. It also keeps
+// a heat value per address that fades over time and can be rendered as an image,
+// showing what the program is doing right now. Attach it to a CPU with
+// CPU.SetAccessMap.
+type AccessMap struct {
+	counts [AccessConfigs][0x10000]AccessCounts
+	heat   [accessKinds][0x10000]uint16
+
+	// Number of CPU cycles between each time the heat fades by 1/16
+	FadeCycles uint64
+	nextFade   uint64
+}
+
+func NewAccessMap() *AccessMap {
+	return &AccessMap{FadeCycles: DefaultFadeCycles}
+}
+
+// Clear forgets all accesses.
+func (m *AccessMap) Clear() {
+	m.counts = [AccessConfigs][0x10000]AccessCounts{}
+	m.heat = [accessKinds][0x10000]uint16{}
+}
+
+// Counts returns the accesses to an address in a memory configuration.
+func (m *AccessMap) Counts(config int, addr uint16) AccessCounts {
+	return m.counts[config][addr]
+}
+
+// Used returns true if anything was accessed while in a memory configuration.
+func (m *AccessMap) Used(config int) bool {
+	for i := range m.counts[config] {
+		if m.counts[config][i] != (AccessCounts{}) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *AccessMap) access(config int, addr uint16, kind int, cycle uint64) {
+	m.counts[config][addr][kind]++
+	h := &m.heat[kind][addr]
+	if *h > maxHeat-heatPerAccess {
+		*h = maxHeat
+	} else {
+		*h += heatPerAccess
+	}
+	if cycle >= m.nextFade {
+		m.Fade()
+		m.nextFade = cycle + m.FadeCycles
+	}
+}
+
+// Fade makes the heat of every address fade by 1/16. Called by the CPU as it runs.
+func (m *AccessMap) Fade() {
+	for k := range m.heat {
+		for i, h := range m.heat[k] {
+			fade := h >> 4
+			if fade == 0 && h > 0 {
+				fade = 1 // Make sure it reaches zero
+			}
+			m.heat[k][i] = h - fade
+		}
+	}
+}
+
+// Image renders the heat as a 256x256 image with an address per pixel, one page per
+// row. Writes are red, reads are blue and execution is green, so code that's running
+// shows up as green and self modifying code as yellow.
+func (m *AccessMap) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for addr := 0; addr < 0x10000; addr++ {
+		img.SetRGBA(addr&0xff, addr>>8, color.RGBA{
+			R: heatLevel(m.heat[AccessWrite][addr]),
+			G: heatLevel(m.heat[AccessExec][addr]),
+			B: heatLevel(m.heat[AccessRead][addr]),
+			A: 0xff,
+		})
+	}
+	return img
+}
+
+// Map heat to brightness so that a single access is visible and a busy loop saturates
+func heatLevel(h uint16) uint8 {
+	if h == 0 {
+		return 0
+	}
+	return uint8(64 + 191*uint32(h)/(uint32(h)+8*heatPerAccess))
+}
+
+// WriteCoverage lists, for every memory configuration that was used, the ranges of
+// addresses that were executed as code, accessed as data or both. Lines look like
+// "7 e5cd-e5d3 code", with the configuration first. Addresses that weren't touched
+// aren't listed.
+func (m *AccessMap) WriteCoverage(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	for config := 0; config < AccessConfigs; config++ {
+		start, kind := 0, ""
+		for addr := 0; addr <= 0x10000; addr++ {
+			k := ""
+			if addr < 0x10000 {
+				k = coverageKind(m.counts[config][addr])
+			}
+			if k == kind {
+				continue
+			}
+			if kind != "" {
+				if _, err := fmt.Fprintf(out, "%d %04x-%04x %s\n", config, start, addr-1, kind); err != nil {
+					return err
+				}
+			}
+			start, kind = addr, k
+		}
+	}
+	return out.Flush()
+}
+
+func coverageKind(c AccessCounts) string {
+	code := c[AccessExec] > 0
+	data := c[AccessRead] > 0 || c[AccessWrite] > 0
+	switch {
+	case code && data:
+		return "code+data"
+	case code:
+		return "code"
+	case data:
+		return "data"
+	}
+	return ""
+}
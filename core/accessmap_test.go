@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAccessMap(t *testing.T) {
+	cpu, _ := loadProgram(`
+	LDX #$00
+LOOP	INX
+	STX $2000
+	LDA $2000
+	JMP LOOP
+`)
+	cpu.Trace = false
+	m := NewAccessMap()
+	cpu.SetAccessMap(m)
+	for cpu.Instructions() < 41 {
+		cpu.Clock()
+	}
+	FinishInstruction(cpu, cpu.Clock)
+
+	require.Equal(t, AccessCounts{1, 0, 0}, m.Counts(0, 0x1000))
+	require.Equal(t, AccessCounts{1, 0, 0}, m.Counts(0, 0x1001), "Operands are code")
+	require.Equal(t, AccessCounts{10, 0, 0}, m.Counts(0, 0x1002))
+	require.Equal(t, AccessCounts{0, 10, 10}, m.Counts(0, 0x2000))
+	require.True(t, m.Used(0))
+	require.False(t, m.Used(7))
+
+	// The reset vector was read too
+	var out bytes.Buffer
+	require.NoError(t, m.WriteCoverage(&out))
+	require.Equal(t, "0 1000-100b code\n0 2000-2000 data\n0 fffc-fffd data\n", out.String())
+
+	// Code is green and data purple
+	img := m.Image()
+	require.Equal(t, uint8(0), img.RGBAAt(0x02, 0x10).R)
+	require.NotZero(t, img.RGBAAt(0x02, 0x10).G)
+	require.NotZero(t, img.RGBAAt(0x00, 0x20).R)
+	require.NotZero(t, img.RGBAAt(0x00, 0x20).B)
+	require.Zero(t, img.RGBAAt(0x00, 0x30).G)
+
+	// Heat fades away, but the counts stay
+	for i := 0; i < 200; i++ {
+		m.Fade()
+	}
+	require.Zero(t, m.Image().RGBAAt(0x02, 0x10).G)
+	require.Equal(t, AccessCounts{10, 0, 0}, m.Counts(0, 0x1002))
+	m.Clear()
+	require.False(t, m.Used(0))
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// AudioClock paces the emulation by the rate at which the audio device consumes
+// samples. The emulator pushes samples into a bounded buffer through WriteSample
+// and the audio device pulls them through Read as 16 bit little endian stereo PCM.
+// NextTick blocks while the buffer holds more than the requested latency, so the
+// emulation runs exactly as fast as the audio hardware plays. Since there's only one
+// time source, audio and video can't drift apart and the buffer never runs dry
+// as long as the host is fast enough.
+type AudioClock struct {
+	mutex     sync.Mutex
+	drained   *sync.Cond // Signalled when the consumer has removed samples
+	filled    *sync.Cond // Signalled when the producer has added samples
+	buffer    [][2]int16 // Ring buffer of stereo samples
+	head      int
+	count     int
+	highWater int
+	ticks     uint64
+	closed    bool
+	overruns  uint64
+}
+
+// Number of ticks between checks of the buffer level
+const AudioClockBatch = 1000
+
+// NewAudioClock creates a clock that keeps about latency worth of samples buffered.
+func NewAudioClock(sampleRate int, latency time.Duration) *AudioClock {
+	highWater := int(int64(sampleRate) * int64(latency) / int64(time.Second))
+	if highWater < 1 {
+		highWater = 1
+	}
+	a := &AudioClock{
+		buffer:    make([][2]int16, 2*highWater),
+		highWater: highWater,
+	}
+	a.drained = sync.NewCond(&a.mutex)
+	a.filled = sync.NewCond(&a.mutex)
+	return a
+}
+
+// WriteSample queues a sample for playback. It never blocks. If the buffer is full,
+// the sample is dropped.
+func (a *AudioClock) WriteSample(left, right int16) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.count == len(a.buffer) {
+		a.overruns++
+		return
+	}
+	a.buffer[(a.head+a.count)%len(a.buffer)] = [2]int16{left, right}
+	a.count++
+	a.filled.Signal()
+}
+
+func (a *AudioClock) NextTick() {
+	a.ticks++
+	if a.ticks%AudioClockBatch != 0 {
+		return
+	}
+	a.mutex.Lock()
+	for a.count >= a.highWater && !a.closed {
+		a.drained.Wait()
+	}
+	a.mutex.Unlock()
+}
+
+// Read fills p with as many whole samples as are available, blocking until there's
+// at least one. Returns io.EOF once the clock is closed and the buffer is empty.
+func (a *AudioClock) Read(p []byte) (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for a.count == 0 && !a.closed {
+		a.filled.Wait()
+	}
+	if a.count == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for ; n+4 <= len(p) && a.count > 0; n += 4 {
+		s := a.buffer[a.head]
+		binary.LittleEndian.PutUint16(p[n:], uint16(s[0]))
+		binary.LittleEndian.PutUint16(p[n+2:], uint16(s[1]))
+		a.head = (a.head + 1) % len(a.buffer)
+		a.count--
+	}
+	a.drained.Signal()
+	return n, nil
+}
+
+// Close releases the emulation and any reader waiting for samples. The clock doesn't
+// pace the emulation after it's been closed.
+func (a *AudioClock) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.closed = true
+	a.drained.Broadcast()
+	a.filled.Broadcast()
+	return nil
+}
+
+// Buffered returns the number of samples waiting to be played.
+func (a *AudioClock) Buffered() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.count
+}
+
+// Overruns returns the number of samples dropped because the buffer was full.
+func (a *AudioClock) Overruns() uint64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.overruns
+}
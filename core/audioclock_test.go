@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAudioClock_Pacing(t *testing.T) {
+	// One sample per batch of ticks with room for ten samples
+	a := NewAudioClock(1000, 10*time.Millisecond)
+	done := make(chan uint64)
+	go func() {
+		for i := 0; i < 100*AudioClockBatch; i++ {
+			if i%AudioClockBatch == 0 {
+				a.WriteSample(int16(i/AudioClockBatch), -1)
+			}
+			a.NextTick()
+		}
+		done <- a.ticks
+	}()
+
+	// The producer must stall once the buffer is full
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 10, a.Buffered())
+
+	// Consuming the samples should let it run to completion
+	buf := make([]byte, 4)
+	for i := 0; i < 100; i++ {
+		n, err := a.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, 4, n)
+		require.Equal(t, int16(i), int16(binary.LittleEndian.Uint16(buf)))
+		require.Equal(t, int16(-1), int16(binary.LittleEndian.Uint16(buf[2:])))
+	}
+	require.Equal(t, uint64(100*AudioClockBatch), <-done)
+	require.Zero(t, a.Overruns())
+
+	a.Close()
+	_, err := a.Read(buf)
+	require.Equal(t, io.EOF, err)
+}
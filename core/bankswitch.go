@@ -34,6 +34,10 @@ func (b *Bank) ReadByte(addr uint16) uint8 {
 	return b.devices[b.selector].ReadByte(addr)
 }
 
+func (b *Bank) Peek(addr uint16) uint8 {
+	return Peek(b.devices[b.selector], addr)
+}
+
 func (b *Bank) WriteByte(addr uint16, data uint8) {
 	b.devices[b.selector].WriteByte(addr, data)
 }
@@ -44,6 +48,14 @@ func (bs* BankSwitcher) Switch(selector int) {
 	}
 }
 
+// Selector returns the currently selected configuration.
+func (bs *BankSwitcher) Selector() int {
+	if len(bs.banks) == 0 {
+		return 0
+	}
+	return bs.banks[0].selector
+}
+
 func (bs *BankSwitcher) GetBank(index int) AddressSpace{
 	return &bs.banks[index]
 }
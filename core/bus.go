@@ -21,7 +21,10 @@
 
 package core
 
-import "fmt"
+import (
+	"fmt"
+	"math/bits"
+)
 
 type AddressSpace interface {
 	ReadByte(addr uint16) uint8
@@ -29,6 +32,38 @@ type AddressSpace interface {
 	WriteByte(addr uint16, data uint8)
 }
 
+// Peeker is implemented by address spaces with registers that change state when read,
+// like interrupt flags that are cleared by reading them. Peek returns what ReadByte
+// would, but leaves the state alone. Used by debuggers.
+type Peeker interface {
+	Peek(addr uint16) uint8
+}
+
+// PeekSpace is a view of an address space where reads have no side effects. Writes
+// go straight through.
+type PeekSpace struct {
+	AddressSpace
+}
+
+// Peek reads a byte without side effects if the address space supports it.
+func Peek(mem AddressSpace, addr uint16) uint8 {
+	if p, ok := mem.(Peeker); ok {
+		return p.Peek(addr)
+	}
+	return mem.ReadByte(addr)
+}
+
+func (p PeekSpace) ReadByte(addr uint16) uint8 {
+	return Peek(p.AddressSpace, addr)
+}
+
+// Names of the standard memory banks, as returned by e.g. Commodore64.MemoryBanks
+const (
+	BankCPU = "cpu" // Memory as seen by the CPU, including ROM and I/O
+	BankRAM = "ram" // The underlying RAM
+	BankVIC = "vic" // Memory as seen by the VIC-II
+)
+
 type Clockable interface {
 	Clock()
 }
@@ -58,6 +93,7 @@ type Bus struct {
 	phase2     []Clockable
 	dmaAllowed bool
 	switcher *BankSwitcher
+	watcher  *Debugger // Only set while there are watchpoints
 
 	// Event pins
 	RDY    TriState
@@ -69,6 +105,14 @@ type PagedSpace struct {
 	pages []AddressSpace
 }
 
+// DecodedSpace works like a PagedSpace, but decodes addresses in slots of a configurable
+// size rather than whole pages. Devices see addresses relative to the start of the range
+// they were mapped to, just like devices connected to a Bus.
+type DecodedSpace struct {
+	shift int
+	slots []Device
+}
+
 func MakeRAM(size uint16) *RAM {
 	return &RAM{Bytes: make([]uint8, size)}
 }
@@ -103,13 +147,29 @@ func (b *Bus) ClockPh2() {
 func (b *Bus) ReadByte(addr uint16) uint8 {
 	d := b.pages[addr >> 8]
 	if d.device != nil {
-		return d.device.ReadByte(addr - d.start)
+		data := d.device.ReadByte(addr - d.start)
+		if b.watcher != nil {
+			b.watcher.checkAccess(addr, data, BreakRead)
+		}
+		return data
 	} else {
 		return 0
 	}
 }
 
+// Peek reads a byte without side effects and without triggering watchpoints.
+func (b *Bus) Peek(addr uint16) uint8 {
+	d := b.pages[addr >> 8]
+	if d.device == nil {
+		return 0
+	}
+	return Peek(d.device, addr-d.start)
+}
+
 func (b *Bus) WriteByte(addr uint16, data uint8) {
+	if b.watcher != nil {
+		b.watcher.checkAccess(addr, data, BreakWrite)
+	}
 	// Special case: Write to the "CPU port" configures the bank switcher
 	if addr == 0x0001 && b.switcher != nil {
 		b.switcher.Switch(int(data & 0x07))
@@ -206,6 +266,14 @@ func (p *PagedSpace) ReadByte(addr uint16) uint8 {
 	return 0
 }
 
+func (p *PagedSpace) Peek(addr uint16) uint8 {
+	n := int(addr >> 8)
+	if n >= len(p.pages) || p.pages[n] == nil {
+		return 0
+	}
+	return Peek(p.pages[n], addr)
+}
+
 func (p *PagedSpace) WriteByte(addr uint16, data uint8) {
 	n := int(addr >> 8)
 	if n >= len(p.pages) {
@@ -219,4 +287,57 @@ func (p *PagedSpace) WriteByte(addr uint16, data uint8) {
 
 func NewPagedSpace(pages []AddressSpace) *PagedSpace {
 	return &PagedSpace{ pages: pages }
-}
\ No newline at end of file
+}
+
+func NewDecodedSpace(size int, granularity uint16) *DecodedSpace {
+	if granularity == 0 || granularity&(granularity-1) != 0 {
+		panic(fmt.Sprintf("Decoding granularity must be a power of two, got %d", granularity))
+	}
+	shift := bits.TrailingZeros16(granularity)
+	return &DecodedSpace{
+		shift: shift,
+		slots: make([]Device, (size+int(granularity)-1)>>shift),
+	}
+}
+
+// Map connects a device to the address range start-end. Later mappings take precedence
+// over earlier ones, so a device can be mapped over a larger range and then partially
+// overlaid by other devices.
+func (d *DecodedSpace) Map(device AddressSpace, start, end uint16) {
+	dev := Device{start, end, device}
+	for slot := int(start) >> d.shift; slot <= int(end)>>d.shift && slot < len(d.slots); slot++ {
+		d.slots[slot] = dev
+	}
+}
+
+func (d *DecodedSpace) ReadByte(addr uint16) uint8 {
+	n := int(addr) >> d.shift
+	if n >= len(d.slots) {
+		return 0
+	}
+	dev := d.slots[n]
+	if dev.device == nil {
+		return 0
+	}
+	return dev.device.ReadByte(addr - dev.start)
+}
+
+func (d *DecodedSpace) Peek(addr uint16) uint8 {
+	n := int(addr) >> d.shift
+	if n >= len(d.slots) || d.slots[n].device == nil {
+		return 0
+	}
+	dev := d.slots[n]
+	return Peek(dev.device, addr-dev.start)
+}
+
+func (d *DecodedSpace) WriteByte(addr uint16, data uint8) {
+	n := int(addr) >> d.shift
+	if n >= len(d.slots) {
+		return
+	}
+	dev := d.slots[n]
+	if dev.device != nil {
+		dev.device.WriteByte(addr-dev.start, data)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDecodedSpace(t *testing.T) {
+	big := MakeRAM(0x400)
+	small := MakeRAM(0x20)
+	ds := NewDecodedSpace(0x1000, 0x20)
+	ds.Map(big, 0x0400, 0x07ff)
+	ds.Map(small, 0x0420, 0x043f)
+
+	ds.WriteByte(0x0400, 1)
+	ds.WriteByte(0x0420, 2)
+	ds.WriteByte(0x0440, 3)
+	require.Equal(t, uint8(1), big.Bytes[0x0000], "Mapped device should see relative addresses")
+	require.Equal(t, uint8(2), small.Bytes[0x0000], "Overlaid device should take precedence")
+	require.Equal(t, uint8(0), big.Bytes[0x0020], "Overlaid range should not reach underlying device")
+	require.Equal(t, uint8(3), big.Bytes[0x0040], "Underlying device should be visible after overlay")
+	require.Equal(t, uint8(2), ds.ReadByte(0x0420))
+	require.Equal(t, uint8(0), ds.ReadByte(0x0800), "Unmapped slots should read as zero")
+	require.Equal(t, uint8(0), ds.ReadByte(0x2000), "Addresses outside the space should read as zero")
+}
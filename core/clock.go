@@ -24,6 +24,7 @@ package core
 import (
 	"fmt"
 	"github.com/dterei/gotsc"
+	"runtime"
 	"time"
 )
 
@@ -116,8 +117,84 @@ func (h *HighPrecisionClock) NextTick() {
 	h.lastTsc = t1
 }
 
+// TimeClock is a portable, lower precision clock based on time.Now. Rather than
+// spinning on every tick, it checks the time once per batch of ticks and sleeps if
+// it's ahead of real time. This gives up cycle level precision in exchange for
+// not keeping a CPU core busy and working on any architecture.
+type TimeClock struct {
+	freq uint64
+	nsPerTick float64
+	ticks uint64
+	start time.Time
+	resyncs uint64
+	now func() time.Time // Replaceable for tests
+	sleep func(time.Duration)
+}
+
+// Number of ticks between checks of the wall clock
+const TimeClockBatch = 1000
+
+// If we fall further behind than this, we give up catching up and start over.
+const MaxClockLag = 100 * time.Millisecond
+
+func NewTimeClock(freq uint64) *TimeClock {
+	return &TimeClock{
+		freq: freq,
+		nsPerTick: 1e9 / float64(freq),
+		now: time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+func (c *TimeClock) NextTick() {
+	c.ticks++
+	if c.ticks % TimeClockBatch != 0 {
+		return
+	}
+	now := c.now()
+	if c.start.IsZero() {
+		c.start = now
+		c.ticks = 0
+		return
+	}
+	ahead := c.start.Add(time.Duration(float64(c.ticks) * c.nsPerTick)).Sub(now)
+	if ahead > 0 {
+		c.sleep(ahead)
+	} else if ahead < -MaxClockLag {
+		// Running behind, e.g. because the host was busy. Bursting to catch up
+		// would just make things worse, so start counting from now instead.
+		c.resyncs++
+		c.start = now
+		c.ticks = 0
+	}
+}
+
+// Resyncs returns the number of times the clock fell too far behind and had to
+// give up catching up.
+func (c *TimeClock) Resyncs() uint64 {
+	return c.resyncs
+}
+
+// HalfCycleClock drives a clock that ticks once per cycle from a loop that runs half a
+// cycle at a time, like the main loop clocking the VIC-II does.
+type HalfCycleClock struct {
+	Clock Clock
+	phase2 bool
+}
+
+func (h *HalfCycleClock) NextTick() {
+	h.phase2 = !h.phase2
+	if h.phase2 {
+		h.Clock.NextTick()
+	}
+}
+
+// NewClock returns the most precise clock available on the host. The TSC is only
+// available on x86, so other architectures get a TimeClock.
 func NewClock(freq uint64) Clock {
-	// TODO: Create lower precision clock on non-x86 architectures
+	if runtime.GOARCH != "amd64" {
+		return NewTimeClock(freq)
+	}
 	c := &HighPrecisionClock{
 		freq: freq,
 	}
@@ -24,6 +24,7 @@ package core
 import (
 	"fmt"
 	"github.com/dterei/gotsc"
+	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
 )
@@ -61,4 +62,61 @@ func TestHighPrecisionClock_NextTick(t *testing.T) {
 	}
 	elapsed := time.Now().Sub(start)
 	fmt.Printf("Elapsed time: %s", elapsed)
-}
\ No newline at end of file
+}
+
+// Time that only passes when the clock sleeps or the test says so
+type fakeTime struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func newFakeClock(freq uint64) (*TimeClock, *fakeTime) {
+	f := &fakeTime{now: time.Unix(1000, 0)}
+	c := NewTimeClock(freq)
+	c.now = func() time.Time { return f.now }
+	c.sleep = func(d time.Duration) {
+		f.slept += d
+		f.now = f.now.Add(d)
+	}
+	return c, f
+}
+
+func TestTimeClock_NextTick(t *testing.T) {
+	c, f := newFakeClock(1000000)
+	for i := 0; i < 200000; i++ {
+		c.NextTick()
+	}
+	// The first batch only notes the start time
+	require.Equal(t, 199*time.Millisecond, f.slept)
+
+	// Running a little slow is made up for
+	f.now = f.now.Add(50 * time.Millisecond)
+	f.slept = 0
+	for i := 0; i < 100000; i++ {
+		c.NextTick()
+	}
+	require.Equal(t, 50*time.Millisecond, f.slept)
+	require.Zero(t, c.Resyncs())
+
+	// Running far behind starts over
+	f.now = f.now.Add(time.Second)
+	f.slept = 0
+	for i := 0; i < 100000; i++ {
+		c.NextTick()
+	}
+	require.Equal(t, uint64(1), c.Resyncs())
+	require.Equal(t, 99*time.Millisecond, f.slept)
+}
+
+func TestHalfCycleClock_Frame(t *testing.T) {
+	inner, f := newFakeClock(985248) // PAL
+	c := &HalfCycleClock{Clock: inner}
+	for i := 0; i < 2*TimeClockBatch; i++ {
+		c.NextTick() // Let the clock note its start time
+	}
+	for i := 0; i < 2*63*312; i++ {
+		c.NextTick()
+	}
+	// A frame takes 20 ms. The clock sleeps until the last whole batch of ticks.
+	require.Equal(t, time.Duration(float64(19*TimeClockBatch)*inner.nsPerTick), f.slept)
+}
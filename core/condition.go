@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition is a boolean expression over registers and memory that's attached to a
+// breakpoint, e.g. "A == $20 && $D012 > 100". The following operands are supported:
+//
+//	A, X, Y, SP, PC, FL   Registers (FL is the status register)
+//	$d012, 0xd012, 53266  Numbers in hex or decimal
+//	[expr], @expr         The contents of memory at an address
+//	(expr)                Grouping
+//
+// Operators are ==, !=, <, <=, >, >=, && and ||, plus ! for negation. As a shorthand,
+// a number on the left hand side of a comparison refers to memory, so "$D012 > 100"
+// compares the contents of $D012 and "A == $20" compares A to a constant.
+type Condition struct {
+	text string
+	eval func(cpu *CPU, mem AddressSpace) int
+}
+
+func ParseCondition(text string) (*Condition, error) {
+	p := &conditionParser{text: text}
+	p.next()
+	eval, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		return nil, fmt.Errorf("unexpected '%s' in condition", p.token)
+	}
+	return &Condition{text: strings.TrimSpace(text), eval: eval.fn}, nil
+}
+
+// Evaluate the condition. Memory is read through the address space provided, without
+// side effects if it supports peeking.
+func (c *Condition) Evaluate(cpu *CPU, mem AddressSpace) bool {
+	return c.eval(cpu, mem) != 0
+}
+
+func (c *Condition) String() string {
+	return c.text
+}
+
+type conditionNode struct {
+	fn      func(cpu *CPU, mem AddressSpace) int
+	literal bool // A plain number
+	value   int  // Value of the number if literal
+}
+
+type conditionParser struct {
+	text  string
+	pos   int
+	token string
+}
+
+var conditionOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "(", ")", "[", "]", "@", "!"}
+
+// Advance to the next token. An empty token means we've reached the end.
+func (p *conditionParser) next() {
+	for p.pos < len(p.text) && unicode.IsSpace(rune(p.text[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.text) {
+		p.token = ""
+		return
+	}
+	for _, op := range conditionOperators {
+		if strings.HasPrefix(p.text[p.pos:], op) {
+			p.pos += len(op)
+			p.token = op
+			return
+		}
+	}
+	for p.pos < len(p.text) && (p.text[p.pos] == '$' || unicode.IsLetter(rune(p.text[p.pos])) || unicode.IsDigit(rune(p.text[p.pos]))) {
+		p.pos++
+	}
+	if p.pos == start {
+		p.pos++ // Unknown character. Let the parser complain about it.
+	}
+	p.token = p.text[start:p.pos]
+}
+
+func (p *conditionParser) parseOr() (*conditionNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.token == "||" {
+		p.next()
+		var right *conditionNode
+		if right, err = p.parseAnd(); err == nil {
+			l, r := left.fn, right.fn
+			left = &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int {
+				return toInt(l(cpu, mem) != 0 || r(cpu, mem) != 0)
+			}}
+		}
+	}
+	return left, err
+}
+
+func (p *conditionParser) parseAnd() (*conditionNode, error) {
+	left, err := p.parseComparison()
+	for err == nil && p.token == "&&" {
+		p.next()
+		var right *conditionNode
+		if right, err = p.parseComparison(); err == nil {
+			l, r := left.fn, right.fn
+			left = &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int {
+				return toInt(l(cpu, mem) != 0 && r(cpu, mem) != 0)
+			}}
+		}
+	}
+	return left, err
+}
+
+var comparisons = map[string]func(a, b int) bool{
+	"==": func(a, b int) bool { return a == b },
+	"!=": func(a, b int) bool { return a != b },
+	"<":  func(a, b int) bool { return a < b },
+	"<=": func(a, b int) bool { return a <= b },
+	">":  func(a, b int) bool { return a > b },
+	">=": func(a, b int) bool { return a >= b },
+}
+
+func (p *conditionParser) parseComparison() (*conditionNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	compare, ok := comparisons[p.token]
+	if !ok {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if left.literal {
+		left = memoryNode(left)
+	}
+	l, r := left.fn, right.fn
+	return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int {
+		return toInt(compare(l(cpu, mem), r(cpu, mem)))
+	}}, nil
+}
+
+func (p *conditionParser) parseOperand() (*conditionNode, error) {
+	token := p.token
+	switch token {
+	case "":
+		return nil, fmt.Errorf("unexpected end of condition")
+	case "(", "[":
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing := map[string]string{"(": ")", "[": "]"}[token]
+		if p.token != closing {
+			return nil, fmt.Errorf("expected '%s' in condition", closing)
+		}
+		p.next()
+		if token == "[" {
+			return memoryNode(inner), nil
+		}
+		return &conditionNode{fn: inner.fn}, nil
+	case "@":
+		p.next()
+		addr, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return memoryNode(addr), nil
+	case "!":
+		p.next()
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		f := operand.fn
+		return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int { return toInt(f(cpu, mem) == 0) }}, nil
+	}
+	p.next()
+	switch strings.ToUpper(token) {
+	case "A":
+		return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int { return int(cpu.a) }}, nil
+	case "X":
+		return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int { return int(cpu.x) }}, nil
+	case "Y":
+		return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int { return int(cpu.y) }}, nil
+	case "SP":
+		return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int { return int(cpu.sp) }}, nil
+	case "PC":
+		return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int { return int(cpu.pc) }}, nil
+	case "FL":
+		return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int { return int(cpu.flags) }}, nil
+	}
+	value, err := parseConditionNumber(token)
+	if err != nil {
+		return nil, err
+	}
+	return &conditionNode{
+		fn:      func(cpu *CPU, mem AddressSpace) int { return value },
+		literal: true,
+		value:   value,
+	}, nil
+}
+
+func memoryNode(addr *conditionNode) *conditionNode {
+	f := addr.fn
+	return &conditionNode{fn: func(cpu *CPU, mem AddressSpace) int {
+		return int(Peek(mem, uint16(f(cpu, mem))))
+	}}
+}
+
+func parseConditionNumber(token string) (int, error) {
+	var n uint64
+	var err error
+	switch {
+	case strings.HasPrefix(token, "$"):
+		n, err = strconv.ParseUint(token[1:], 16, 16)
+	case strings.HasPrefix(strings.ToLower(token), "0x"):
+		n, err = strconv.ParseUint(token[2:], 16, 16)
+	default:
+		n, err = strconv.ParseUint(token, 10, 16)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid number or register in condition: %s", token)
+	}
+	return int(n), nil
+}
+
+func toInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -262,6 +262,29 @@ type CPU struct {
 
 	// Stunned by someone pulling RDY low?
 	stunned bool
+
+	// Number of instructions (including interrupts) started since power on
+	instructions uint64
+
+	// Number of clock cycles since power on, including cycles stunned by DMA
+	cycles uint64
+
+	tracer   *Tracer    // Only set while tracing
+	profiler *Profiler  // Only set while profiling
+	accesses *AccessMap // Only set while mapping memory accesses
+
+	// Only set while there are execution breakpoints
+	debugger *Debugger
+}
+
+// Registers holds the programmer visible registers of the CPU.
+type Registers struct {
+	PC    uint16
+	SP    uint8
+	A     uint8
+	X     uint8
+	Y     uint8
+	Flags uint8
 }
 
 func (c *CPU) Init(bus *Bus) {
@@ -556,9 +579,16 @@ func (c *CPU) Reset() {
 	c.sp = 0xfd
 	c.instruction = &c.rstPI // Load RST pseudo instruction
 	c.microPc = 0
+	if c.profiler != nil {
+		c.profiler.reset()
+	}
 }
 
 func (c *CPU) Clock() {
+	c.cycles++
+	if c.profiler != nil {
+		c.profiler.clock(c.stunned) // Account for the previous cycle
+	}
 	if c.bus.RDY.Get() {
 		c.stunned = false
 		c.bus.CPUClaimBus() // No more DMA for you!
@@ -569,6 +599,12 @@ func (c *CPU) Clock() {
 
 	// About to load a new instruction?
 	if c.instruction == nil || c.microPc >= len(c.instruction.Microcode) {
+		if c.tracer != nil {
+			c.tracer.begin(c, c.cycles)
+		}
+		if c.profiler != nil {
+			c.profiler.begin(c)
+		}
 		if c.bus.NotNMI.GetEdge() == -1 && !c.inNMI {
 			c.instruction = &c.nmiPI
 			c.inNMI = true
@@ -582,6 +618,13 @@ func (c *CPU) Clock() {
 			}
 		}
 		c.microPc = 0
+		c.instructions++
+		if c.tracer != nil {
+			c.tracer.decoded(c.instruction)
+		}
+		if c.profiler != nil {
+			c.profiler.decoded(c)
+		}
 	} else {
 		c.instruction.Microcode[c.microPc]()
 		if c.stunned {
@@ -599,6 +642,96 @@ func (c *CPU) SetPC(pc uint16) {
 	c.microPc = 0
 }
 
+func (c *CPU) Registers() Registers {
+	return Registers{PC: c.pc, SP: c.sp, A: c.a, X: c.x, Y: c.y, Flags: c.flags}
+}
+
+// SetRegisters updates the programmer visible registers. Should only be called
+// between instructions. Changing the PC makes the CPU fetch a new instruction
+// from the new location.
+func (c *CPU) SetRegisters(r Registers) {
+	if r.PC != c.pc {
+		c.pc = r.PC
+		c.instruction = nil
+		c.microPc = 0
+	}
+	c.sp = r.SP
+	c.a = r.A
+	c.x = r.X
+	c.y = r.Y
+	c.flags = r.Flags
+}
+
+// AtInstructionBoundary returns true if the current instruction is done and the
+// next clock cycle starts a new one.
+func (c *CPU) AtInstructionBoundary() bool {
+	return c.instruction == nil || c.microPc >= len(c.instruction.Microcode)
+}
+
+// Instructions returns the number of instructions started since power on, including
+// interrupt sequences.
+func (c *CPU) Instructions() uint64 {
+	return c.instructions
+}
+
+// CPUState is the part of the CPU state that is saved in snapshots. Snapshots are
+// only taken between instructions, so there is no microcode state to save.
+type CPUState struct {
+	Registers Registers
+	InIRQ     bool
+	InNMI     bool
+}
+
+func (c *CPU) State() CPUState {
+	return CPUState{Registers: c.Registers(), InIRQ: c.inIRQ, InNMI: c.inNMI}
+}
+
+// SetState restores the CPU from a snapshot. The CPU continues with a new instruction.
+func (c *CPU) SetState(s CPUState) {
+	c.SetRegisters(s.Registers)
+	c.instruction = nil
+	c.microPc = 0
+	c.inIRQ = s.InIRQ
+	c.inNMI = s.InNMI
+	c.halted = false
+	c.stunned = false
+}
+
+// Cycles returns the number of clock cycles since power on.
+func (c *CPU) Cycles() uint64 {
+	return c.cycles
+}
+
+// SetTracer starts recording executed instructions. Pass nil to stop.
+func (c *CPU) SetTracer(t *Tracer) {
+	c.tracer = t
+}
+
+// Tracer returns the tracer recording instructions, or nil if there is none.
+func (c *CPU) Tracer() *Tracer {
+	return c.tracer
+}
+
+// SetProfiler starts counting cycles per address and subroutine. Pass nil to stop.
+func (c *CPU) SetProfiler(p *Profiler) {
+	c.profiler = p
+}
+
+// Profiler returns the profiler counting cycles, or nil if there is none.
+func (c *CPU) Profiler() *Profiler {
+	return c.profiler
+}
+
+// SetAccessMap starts counting memory accesses. Pass nil to stop.
+func (c *CPU) SetAccessMap(m *AccessMap) {
+	c.accesses = m
+}
+
+// AccessMap returns the map counting memory accesses, or nil if there is none.
+func (c *CPU) AccessMap() *AccessMap {
+	return c.accesses
+}
+
 func (c *CPU) StateAsString() string {
 	code := ""
 	if c.microPc == 0 {
@@ -613,19 +746,55 @@ func (c *CPU) readByte(addr uint16) uint8 {
 		c.stunned = true
 		c.bus.CPUReleaseBus() // Allow DMA
 	}
-	return c.bus.ReadByte(addr)
+	data := c.bus.ReadByte(addr)
+	if c.tracer != nil && !c.stunned {
+		c.tracer.access(addr, data, TraceRead)
+	}
+	if c.accesses != nil && !c.stunned {
+		// Opcodes and operands are always fetched from the PC
+		if addr == c.pc {
+			c.accesses.access(c.bankConfig(), addr, AccessExec, c.cycles)
+		} else {
+			c.accesses.access(c.bankConfig(), addr, AccessRead, c.cycles)
+		}
+	}
+	return data
 }
 
 func (c *CPU) writeByte(addr uint16, data uint8) {
+	if c.accesses != nil {
+		// Before the write, since writing to $0001 changes the configuration
+		c.accesses.access(c.bankConfig(), addr, AccessWrite, c.cycles)
+	}
 	c.bus.WriteByte(addr, data)
+	if c.tracer != nil {
+		c.tracer.access(addr, data, TraceWrite)
+	}
+}
+
+// Memory configuration for the access map. Zero if there's no bank switching.
+func (c *CPU) bankConfig() int {
+	if c.bus.switcher == nil {
+		return 0
+	}
+	return c.bus.switcher.Selector()
 }
 
 func (c *CPU) fetchOpcode() {
+	// Stop before the opcode is fetched, so that the instruction runs in full once the
+	// machine is resumed. The CPU idles in the meantime, as if RDY was low.
+	if c.debugger != nil && c.debugger.checkExec(c.pc) {
+		c.stunned = true
+		return
+	}
 	opcode := c.readByte(c.pc)
 	if c.stunned {
 		return
 	}
 	if c.CrashOnInvalidInst && len(c.instructionSet[opcode].Microcode) == 0 {
+		if c.tracer != nil {
+			c.tracer.crashed()
+		}
 		log.Fatalf("Unknown opcode: %2x at address %4x", opcode, c.pc)
 	}
 	c.instruction = &c.instructionSet[opcode]
@@ -0,0 +1,311 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+type BreakKind int
+
+const (
+	BreakExec BreakKind = 1 << iota
+	BreakRead
+	BreakWrite
+)
+
+// Breakpoint stops the machine when an instruction in a range is about to be
+// executed, or when an address in a range is read or written.
+type Breakpoint struct {
+	ID          int
+	Kind        BreakKind
+	Start       uint16
+	End         uint16     // Inclusive
+	Condition   *Condition // Only stop if this is true. Stop unconditionally if nil.
+	IgnoreCount int        // Number of hits to ignore before stopping
+	Hits        int        // Number of times the breakpoint was hit with the condition true
+	Disabled    bool
+}
+
+// StopEvent describes why the machine stopped.
+type StopEvent struct {
+	Breakpoint *Breakpoint
+	Kind       BreakKind // The kind of access that triggered the breakpoint
+	Address    uint16
+	Data       uint8 // Value read or written, if stopped by a watchpoint
+}
+
+// Debugger manages breakpoints and watchpoints for a CPU and its bus. Breakpoints are
+// checked by the CPU and the bus themselves, but only while there are any. Without
+// breakpoints, the cost is a single nil check. The debugger can't pause the machine
+// by itself. Instead, the emulation loop should check Stopped after every cycle.
+type Debugger struct {
+	cpu         *CPU
+	bus         *Bus
+	breakpoints []*Breakpoint
+	nextID      int
+	exec        []bool      // Addresses with execution breakpoints
+	watch       []BreakKind // Kinds of access watched for each address
+	stop        *StopEvent
+	muted       int
+	resumed     bool   // Resumed from an execution breakpoint
+	resumedAt   uint16 // The address of the instruction to let through once
+}
+
+func NewDebugger(cpu *CPU, bus *Bus) *Debugger {
+	return &Debugger{
+		cpu:    cpu,
+		bus:    bus,
+		nextID: 1,
+	}
+}
+
+// Add a breakpoint or watchpoint. Returns the breakpoint so the caller can see the
+// ID assigned to it.
+func (d *Debugger) Add(kind BreakKind, start, end uint16, condition *Condition) *Breakpoint {
+	bp := &Breakpoint{
+		ID:        d.nextID,
+		Kind:      kind,
+		Start:     start,
+		End:       end,
+		Condition: condition,
+	}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	d.update()
+	return bp
+}
+
+func (d *Debugger) Get(id int) *Breakpoint {
+	for _, bp := range d.breakpoints {
+		if bp.ID == id {
+			return bp
+		}
+	}
+	return nil
+}
+
+// Remove a breakpoint. Returns false if there was no breakpoint with the ID.
+func (d *Debugger) Remove(id int) bool {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			d.update()
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) RemoveAll() {
+	d.breakpoints = nil
+	d.update()
+}
+
+// Enable or disable a breakpoint. Returns false if there was no breakpoint with the ID.
+func (d *Debugger) Enable(id int, enabled bool) bool {
+	bp := d.Get(id)
+	if bp == nil {
+		return false
+	}
+	bp.Disabled = !enabled
+	d.update()
+	return true
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return d.breakpoints
+}
+
+// Stopped returns true if a breakpoint has been hit since the last call to Resume.
+func (d *Debugger) Stopped() bool {
+	return d.stop != nil
+}
+
+// StopEvent returns the reason the machine stopped, or nil if it hasn't.
+func (d *Debugger) StopEvent() *StopEvent {
+	return d.stop
+}
+
+// Resume clears the stop. If we stopped at an execution breakpoint, the instruction
+// there is allowed to run.
+func (d *Debugger) Resume() {
+	if d.stop != nil && d.stop.Kind == BreakExec {
+		d.resumed = true
+		d.resumedAt = d.stop.Address
+	}
+	d.stop = nil
+}
+
+// Mute suspends checking of watchpoints, e.g. while a debugger is reading memory
+// through the bus. Calls can be nested and must be balanced by calls to Unmute.
+func (d *Debugger) Mute() {
+	d.muted++
+}
+
+func (d *Debugger) Unmute() {
+	d.muted--
+}
+
+// Rebuild the lookup tables and only hook into the CPU and bus if needed.
+func (d *Debugger) update() {
+	d.exec = nil
+	d.watch = nil
+	for _, bp := range d.breakpoints {
+		if bp.Disabled {
+			continue
+		}
+		for addr := int(bp.Start); addr <= int(bp.End); addr++ {
+			if bp.Kind&BreakExec != 0 {
+				if d.exec == nil {
+					d.exec = make([]bool, 0x10000)
+				}
+				d.exec[addr] = true
+			}
+			if bp.Kind&(BreakRead|BreakWrite) != 0 {
+				if d.watch == nil {
+					d.watch = make([]BreakKind, 0x10000)
+				}
+				d.watch[addr] |= bp.Kind
+			}
+		}
+	}
+	d.cpu.debugger = nil
+	if d.exec != nil {
+		d.cpu.debugger = d
+	}
+	d.bus.watcher = nil
+	if d.watch != nil {
+		d.bus.watcher = d
+	}
+}
+
+// StepInstruction runs the machine until the CPU has executed one instruction, or the
+// interrupt sequence that was dispatched instead of it. The clock function advances
+// the whole machine by one step, which may be a whole cycle or half of one. A
+// breakpoint at the instruction we start from doesn't stop us, but one at the
+// instruction we end up at does.
+func StepInstruction(cpu *CPU, clock func()) {
+	d := cpu.debugger
+	if d != nil {
+		d.resumed = true
+		d.resumedAt = cpu.pc
+	}
+	n := cpu.Instructions()
+	for cpu.Instructions() == n && !cpu.heldAtBreakpoint() {
+		clock()
+	}
+	for !cpu.AtInstructionBoundary() {
+		clock()
+	}
+	if d != nil && d.stop == nil {
+		d.checkExec(cpu.pc)
+	}
+}
+
+// Is the CPU waiting at an execution breakpoint for the machine to be resumed?
+func (c *CPU) heldAtBreakpoint() bool {
+	d := c.debugger
+	return d != nil && d.stop != nil && d.stop.Kind == BreakExec && d.stop.Address == c.pc && c.AtInstructionBoundary()
+}
+
+// FinishInstruction runs the machine until the CPU is between instructions. The clock
+// function is called the same way as by StepInstruction.
+func FinishInstruction(cpu *CPU, clock func()) {
+	for !cpu.AtInstructionBoundary() {
+		clock()
+	}
+}
+
+// checkExec is called before the CPU fetches an opcode. Returns true if the CPU must
+// hold off, since we're stopped at a breakpoint there.
+func (d *Debugger) checkExec(pc uint16) bool {
+	if d.resumed {
+		d.resumed = false
+		if pc == d.resumedAt {
+			return false
+		}
+	}
+	if d.exec[pc] && d.stop == nil {
+		d.hit(BreakExec, pc, 0)
+	}
+	return d.stop != nil && d.stop.Kind == BreakExec && d.stop.Address == pc
+}
+
+func (d *Debugger) checkAccess(addr uint16, data uint8, kind BreakKind) {
+	if d.watch[addr]&kind != 0 && d.muted == 0 {
+		d.hit(kind, addr, data)
+	}
+}
+
+func (d *Debugger) hit(kind BreakKind, addr uint16, data uint8) {
+	// Conditions read memory, which must not trigger any watchpoints
+	d.muted++
+	defer func() { d.muted-- }()
+	for _, bp := range d.breakpoints {
+		if bp.Disabled || bp.Kind&kind == 0 || addr < bp.Start || addr > bp.End {
+			continue
+		}
+		if bp.Condition != nil && !bp.Condition.Evaluate(d.cpu, d.bus) {
+			continue
+		}
+		bp.Hits++
+		if bp.Hits <= bp.IgnoreCount {
+			continue
+		}
+		if d.stop == nil {
+			d.stop = &StopEvent{Breakpoint: bp, Kind: kind, Address: addr, Data: data}
+		}
+	}
+}
+
+func (k BreakKind) String() string {
+	var parts []string
+	for _, kind := range []struct {
+		kind BreakKind
+		name string
+	}{{BreakExec, "exec"}, {BreakRead, "load"}, {BreakWrite, "store"}} {
+		if k&kind.kind != 0 {
+			parts = append(parts, kind.name)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+func (bp *Breakpoint) String() string {
+	s := fmt.Sprintf("#%d (%s) $%04x", bp.ID, bp.Kind, bp.Start)
+	if bp.End != bp.Start {
+		s += fmt.Sprintf("-$%04x", bp.End)
+	}
+	if bp.Condition != nil {
+		s += " if " + bp.Condition.String()
+	}
+	if bp.IgnoreCount > 0 {
+		s += fmt.Sprintf(" ignore %d", bp.IgnoreCount)
+	}
+	s += fmt.Sprintf(" hits %d", bp.Hits)
+	if bp.Disabled {
+		s += " (disabled)"
+	}
+	return s
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCondition(t *testing.T) {
+	bus := Bus{}
+	mem := MakeRAM(0xffff)
+	bus.Connect(mem, 0x0000, 0xffff)
+	cpu := CPU{}
+	cpu.Init(&bus)
+	cpu.SetRegisters(Registers{PC: 0x1234, A: 0x20, X: 3, SP: 0xf0})
+	mem.Bytes[0xd012] = 101
+	mem.Bytes[0x0003] = 0x42
+
+	for text, expected := range map[string]bool{
+		"A == $20 && $D012 > 100":  true,
+		"A == $20 && $D012 > 101":  false,
+		"a != 0x20 || pc == $1234": true,
+		"[X] == $42":               true,
+		"@3 == 66 && !(SP < $f0)":  true,
+		"X":                        true,
+		"Y":                        false,
+	} {
+		c, err := ParseCondition(text)
+		require.NoError(t, err, text)
+		require.Equal(t, expected, c.Evaluate(&cpu, &bus), text)
+	}
+	for _, text := range []string{"", "A ==", "(A == 1", "A == $10000", "B == 1", "A == 1 )"} {
+		_, err := ParseCondition(text)
+		require.Error(t, err, text)
+	}
+}
+
+func TestDebugger_Breakpoints(t *testing.T) {
+	cpu, _ := loadProgram(`
+	LDX #$00
+LOOP	INX
+	STX $2000
+	LDA $2000
+	JMP LOOP
+`)
+	cpu.Trace = false
+	bus := cpu.bus // loadProgram returns a copy of the bus
+	d := NewDebugger(cpu, bus)
+	run := func() {
+		for i := 0; i < 1000 && !d.Stopped(); i++ {
+			cpu.Clock()
+		}
+	}
+
+	// Nothing should be hooked in without breakpoints
+	bp := d.Add(BreakExec, 0x1002, 0x1002, nil)
+	require.NotNil(t, cpu.debugger)
+	require.Nil(t, bus.watcher)
+	run()
+	require.True(t, d.Stopped())
+	require.Equal(t, uint16(0x1002), cpu.Registers().PC)
+	require.True(t, cpu.AtInstructionBoundary())
+	d.Resume()
+
+	// Ignore count
+	bp.IgnoreCount = 3
+	run()
+	require.Equal(t, uint8(3), cpu.Registers().X)
+	require.Equal(t, 4, bp.Hits)
+	d.Resume()
+	d.Enable(bp.ID, false)
+	require.Nil(t, cpu.debugger)
+
+	// Watchpoints with conditions
+	c, err := ParseCondition("X == 7")
+	require.NoError(t, err)
+	watch := d.Add(BreakRead, 0x2000, 0x20ff, c)
+	require.NotNil(t, bus.watcher)
+	run()
+	require.Equal(t, &StopEvent{Breakpoint: watch, Kind: BreakRead, Address: 0x2000, Data: 7}, d.StopEvent())
+	d.Resume()
+
+	// Muted accesses don't count
+	d.Mute()
+	bus.ReadByte(0x2000)
+	d.Unmute()
+	require.False(t, d.Stopped())
+
+	d.RemoveAll()
+	require.Nil(t, cpu.debugger)
+	require.Nil(t, bus.watcher)
+}
+
+func TestDebugger_BreakAtFetch(t *testing.T) {
+	cpu, _ := loadProgram(`
+	LDA #$00
+	CLI
+	STA $8000
+LOOP	NOP
+	JMP LOOP
+	INC $3000
+	LDA #$01
+	STA $8000
+	RTI
+`)
+	cpu.Trace = false
+	bus := cpu.bus
+	bus.WriteByte(IRQ_VEC, 0x0a)
+	bus.WriteByte(IRQ_VEC+1, 0x10)
+	d := NewDebugger(cpu, bus)
+	run := func() {
+		for i := 0; i < 1000 && !d.Stopped(); i++ {
+			cpu.Clock()
+		}
+	}
+
+	// The interrupt is dispatched instead of the NOP, so we stop once it's done
+	d.Add(BreakExec, 0x1006, 0x1006, nil)
+	run()
+	require.True(t, d.Stopped())
+	require.Equal(t, uint16(0x1006), cpu.Registers().PC)
+	require.Equal(t, uint8(1), bus.ReadByte(0x3000), "Stopped before the interrupt")
+
+	// The CPU holds off until resumed and then runs the instruction
+	n := cpu.Instructions()
+	cpu.Clock()
+	require.Equal(t, n, cpu.Instructions())
+	d.Resume()
+	cpu.Clock()
+	require.Equal(t, n+1, cpu.Instructions())
+
+	// The first instruction after a jump in the debugger
+	d.Add(BreakExec, 0x1000, 0x1000, nil)
+	cpu.SetRegisters(Registers{PC: 0x1000, Flags: FLAG_I})
+	run()
+	require.True(t, d.Stopped())
+	require.Equal(t, uint16(0x1000), cpu.Registers().PC)
+
+	// Stepping from a breakpoint onto another stops at the second one
+	d.Resume()
+	d.Add(BreakExec, 0x1002, 0x1002, nil)
+	StepInstruction(cpu, cpu.Clock)
+	require.True(t, d.Stopped())
+	require.Equal(t, uint16(0x1002), cpu.Registers().PC)
+	d.Resume()
+	StepInstruction(cpu, cpu.Clock)
+	require.False(t, d.Stopped())
+	require.Equal(t, uint16(0x1003), cpu.Registers().PC)
+}
+
+type sideEffects struct {
+	RAM
+	reads int
+}
+
+func (s *sideEffects) ReadByte(addr uint16) uint8 {
+	s.reads++
+	return s.RAM.ReadByte(addr)
+}
+
+func (s *sideEffects) Peek(addr uint16) uint8 {
+	return s.RAM.ReadByte(addr)
+}
+
+func TestPeek(t *testing.T) {
+	dev := &sideEffects{RAM: *MakeRAM(0x100)}
+	dev.Bytes[0x10] = 0x42
+	io := NewDecodedSpace(0x1000, 0x100)
+	io.Map(dev, 0x0200, 0x02ff)
+	bus := &Bus{}
+	bus.Connect(io, 0xd000, 0xdfff)
+	cpu := &CPU{}
+	cpu.Init(bus)
+	d := NewDebugger(cpu, bus)
+	d.Add(BreakRead, 0xd210, 0xd210, nil)
+
+	require.Equal(t, uint8(0x42), Peek(bus, 0xd210))
+	require.Equal(t, uint8(0x42), PeekSpace{bus}.ReadByte(0xd210))
+	require.Equal(t, 0, dev.reads)
+	require.False(t, d.Stopped(), "Peeking doesn't trigger watchpoints")
+
+	c, err := ParseCondition("$d210 == $42")
+	require.NoError(t, err)
+	require.True(t, c.Evaluate(cpu, bus))
+	require.Equal(t, 0, dev.reads)
+
+	require.Equal(t, uint8(0x42), bus.ReadByte(0xd210))
+	require.Equal(t, 1, dev.reads)
+	require.True(t, d.Stopped())
+}
@@ -107,7 +107,7 @@ func (i *Instruction) Dissasemble(memory AddressSpace, pc uint16) string {
 	case ModeIndirect:
 		s += fmt.Sprintf("($%04x)", uint16(memory.ReadByte(pc))+uint16(memory.ReadByte(pc+1)<<8))
 	case ModeRelative:
-		s += fmt.Sprintf("%02x", memory.ReadByte(pc))
+		s += fmt.Sprintf("$%04x", pc+1+uint16(int8(memory.ReadByte(pc))))
 	}
 	return s
 }
@@ -0,0 +1,267 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import "sort"
+
+// Deepest call stack tracked by the profiler. The 6502 stack only has room for 128
+// return addresses, so anything deeper means we've lost track.
+const maxProfileDepth = 256
+
+// Cost is what a piece of code has cost so far.
+type Cost struct {
+	Cycles     uint64 // Clock cycles, including the stalls
+	Stalls     uint64 // Cycles the CPU was stunned by DMA, e.g. bad lines and sprites
+	Executions uint64 // Number of instructions executed
+}
+
+// Add adds another cost to this one.
+func (c *Cost) Add(o Cost) {
+	c.Cycles += o.Cycles
+	c.Stalls += o.Stalls
+	c.Executions += o.Executions
+}
+
+// CallNode is a subroutine in the call tree. A subroutine gets a node for every
+// path of callers and call sites it's reached through, so its cost can be broken
+// down by who called it.
+type CallNode struct {
+	Function  uint16 // Entry point. Unused for the root.
+	CallSite  uint16 // Address of the JSR or BRK, or where an interrupt hit
+	Interrupt bool   // Entered through an interrupt rather than a subroutine call
+	Calls     uint64
+	Parent    *CallNode
+	Children  []*CallNode      // In order of first call
+	Self      map[uint16]*Cost // Cost per address, not counting subroutines
+
+	children map[uint32]*CallNode
+}
+
+func newCallNode(parent *CallNode, function, callSite uint16, interrupt bool) *CallNode {
+	return &CallNode{
+		Function:  function,
+		CallSite:  callSite,
+		Interrupt: interrupt,
+		Parent:    parent,
+		Self:      make(map[uint16]*Cost),
+		children:  make(map[uint32]*CallNode),
+	}
+}
+
+// SelfCost is the cost of the node's own code.
+func (n *CallNode) SelfCost() Cost {
+	var c Cost
+	for _, cost := range n.Self {
+		c.Add(*cost)
+	}
+	return c
+}
+
+// TotalCost is the cost of the node's code and everything it called.
+func (n *CallNode) TotalCost() Cost {
+	c := n.SelfCost()
+	for _, child := range n.Children {
+		c.Add(child.TotalCost())
+	}
+	return c
+}
+
+// Addresses returns the addresses the node has spent cycles at, in ascending order.
+func (n *CallNode) Addresses() []uint16 {
+	addrs := make([]uint16, 0, len(n.Self))
+	for addr := range n.Self {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+func (n *CallNode) charge(addr uint16, cost Cost) {
+	c, ok := n.Self[addr]
+	if !ok {
+		c = &Cost{}
+		n.Self[addr] = c
+	}
+	c.Add(cost)
+}
+
+func (n *CallNode) child(function, callSite uint16, interrupt bool) *CallNode {
+	key := uint32(callSite)<<16 | uint32(function)
+	c, ok := n.children[key]
+	if !ok {
+		c = newCallNode(n, function, callSite, interrupt)
+		n.children[key] = c
+		n.Children = append(n.Children, c)
+	}
+	c.Calls++
+	return c
+}
+
+type profileFrame struct {
+	node *CallNode
+	sp   uint8 // Stack pointer before the call
+}
+
+// Profiler counts the clock cycles spent by the instruction at each address, with the
+// cycles the CPU is stunned by the VIC-II's DMA charged to the instruction that was
+// held up. It also builds a tree of subroutine calls and interrupts, following JSR,
+// BRK and interrupts in and RTS and RTI out. Returns are matched against the stack
+// pointer, so code that drops return addresses or jumps through RTS is handled.
+// Attach it to a CPU with CPU.SetProfiler.
+type Profiler struct {
+	costs [0x10000]Cost
+	root  *CallNode
+	stack []profileFrame
+
+	// Instruction in progress
+	started   bool
+	decoding  bool // Opcode fetch was interrupted by DMA and will be retried
+	pc        uint16
+	sp        uint8
+	interrupt bool
+	call      bool // JSR or BRK
+	ret       bool // RTS or RTI
+	cost      Cost
+}
+
+func NewProfiler() *Profiler {
+	p := &Profiler{}
+	p.Clear()
+	return p
+}
+
+// Clear throws away everything counted so far.
+func (p *Profiler) Clear() {
+	p.costs = [0x10000]Cost{}
+	p.root = newCallNode(nil, 0, 0, false)
+	p.stack = p.stack[:0]
+	p.started = false
+	p.decoding = false
+}
+
+// Cost returns the cost of the instruction at an address. For interrupts, the cycles
+// spent getting to the handler are charged to the first instruction of the handler.
+func (p *Profiler) Cost(addr uint16) Cost {
+	return p.costs[addr]
+}
+
+// Total returns the cost of everything profiled.
+func (p *Profiler) Total() Cost {
+	var c Cost
+	for i := range p.costs {
+		c.Add(p.costs[i])
+	}
+	return c
+}
+
+// Root returns the top of the call tree, which holds the code that isn't in any
+// subroutine we saw being called.
+func (p *Profiler) Root() *CallNode {
+	return p.root
+}
+
+func (p *Profiler) current() *CallNode {
+	if len(p.stack) == 0 {
+		return p.root
+	}
+	return p.stack[len(p.stack)-1].node
+}
+
+// Called at the start of every clock cycle with what happened in the previous one
+func (p *Profiler) clock(stunned bool) {
+	if !p.started {
+		return
+	}
+	p.cost.Cycles++
+	if stunned {
+		p.cost.Stalls++
+	}
+}
+
+// Called when the CPU is about to start a new instruction or interrupt sequence
+func (p *Profiler) begin(c *CPU) {
+	if p.decoding {
+		return
+	}
+	p.finish(c)
+	p.started = true
+	p.decoding = true
+	p.pc = c.pc
+	p.sp = c.sp
+	p.cost = Cost{}
+}
+
+func (p *Profiler) decoded(c *CPU) {
+	p.decoding = false
+	i := c.instruction
+	p.interrupt = i == &c.irqPI || i == &c.nmiPI
+	p.call = i == &c.instructionSet[JSR_A] || i == &c.instructionSet[BRK]
+	p.ret = i == &c.instructionSet[RTS] || i == &c.instructionSet[RTI]
+}
+
+// Charge the instruction that just finished and follow calls and returns. The CPU
+// is at the start of the next instruction, so its PC tells where we went.
+func (p *Profiler) finish(c *CPU) {
+	if !p.started || p.decoding {
+		return
+	}
+	node := p.current()
+	switch {
+	case p.interrupt:
+		node = p.push(node, c.pc, p.pc, true)
+		p.charge(node, c.pc, p.cost)
+	case p.call:
+		p.cost.Executions = 1
+		p.charge(node, p.pc, p.cost)
+		p.push(node, c.pc, p.pc, false)
+	case p.ret:
+		p.cost.Executions = 1
+		p.charge(node, p.pc, p.cost)
+		for len(p.stack) > 0 && p.stack[len(p.stack)-1].sp <= c.sp {
+			p.stack = p.stack[:len(p.stack)-1]
+		}
+	default:
+		p.cost.Executions = 1
+		p.charge(node, p.pc, p.cost)
+	}
+}
+
+func (p *Profiler) push(node *CallNode, function, callSite uint16, interrupt bool) *CallNode {
+	if len(p.stack) >= maxProfileDepth {
+		return node
+	}
+	child := node.child(function, callSite, interrupt)
+	p.stack = append(p.stack, profileFrame{node: child, sp: p.sp})
+	return child
+}
+
+func (p *Profiler) charge(node *CallNode, addr uint16, cost Cost) {
+	p.costs[addr].Add(cost)
+	node.charge(addr, cost)
+}
+
+// Called on reset, which leaves whatever was on the stack behind
+func (p *Profiler) reset() {
+	p.stack = p.stack[:0]
+	p.started = false
+	p.decoding = false
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	cpu, _ := loadProgram(`
+	LDX #$00
+LOOP	JSR SUB
+	JMP LOOP
+SUB	INX
+	RTS
+`)
+	cpu.Trace = false
+	profiler := NewProfiler()
+	cpu.SetProfiler(profiler)
+	for cpu.Instructions() < 20 {
+		cpu.Clock()
+	}
+
+	// Fetching the INX is stunned for three cycles by DMA
+	for !(cpu.AtInstructionBoundary() && cpu.Registers().PC == 0x1008) {
+		cpu.Clock()
+	}
+	cpu.bus.RDY.PullDown()
+	for i := 0; i < 3; i++ {
+		cpu.Clock()
+	}
+	cpu.bus.RDY.Release()
+	for cpu.Instructions() < 40 {
+		cpu.Clock()
+	}
+	FinishInstruction(cpu, cpu.Clock)
+	cpu.Clock() // Charge the last instruction
+
+	require.Equal(t, Cost{Cycles: 2, Executions: 1}, profiler.Cost(0x1000))
+	jsr := profiler.Cost(0x1002)
+	require.Equal(t, jsr.Executions*6, jsr.Cycles)
+	inx := profiler.Cost(0x1008)
+	require.Equal(t, uint64(3), inx.Stalls)
+	require.Equal(t, inx.Executions*2+3, inx.Cycles)
+	rts := profiler.Cost(0x1009)
+	require.Equal(t, rts.Executions*6, rts.Cycles)
+
+	// The subroutine is called from one place and has its own node
+	root := profiler.Root()
+	require.Len(t, root.Children, 1)
+	sub := root.Children[0]
+	require.Equal(t, uint16(0x1008), sub.Function)
+	require.Equal(t, uint16(0x1002), sub.CallSite)
+	require.Equal(t, jsr.Executions, sub.Calls)
+	require.Equal(t, inx.Cycles+rts.Cycles, sub.TotalCost().Cycles)
+	require.Equal(t, profiler.Total(), root.TotalCost())
+	require.Empty(t, sub.Children)
+
+	profiler.Clear()
+	require.Equal(t, Cost{}, profiler.Total())
+}
+
+func TestProfiler_StallMidInstruction(t *testing.T) {
+	cpu, _ := loadProgram(`
+LOOP	LDA $2000
+	JMP LOOP
+`)
+	cpu.Trace = false
+	profiler := NewProfiler()
+	cpu.SetProfiler(profiler)
+	for cpu.Instructions() < 4 {
+		cpu.Clock()
+	}
+
+	// Hold up the LDA while it reads its operand
+	for !(cpu.AtInstructionBoundary() && cpu.Registers().PC == 0x1000) {
+		cpu.Clock()
+	}
+	cpu.Clock() // Opcode
+	cpu.Clock() // Low byte of the address
+	cpu.bus.RDY.PullDown()
+	for i := 0; i < 5; i++ {
+		cpu.Clock()
+	}
+	cpu.bus.RDY.Release()
+	for !(cpu.AtInstructionBoundary() && cpu.Registers().PC == 0x1000) {
+		cpu.Clock()
+	}
+	cpu.Clock() // Charge the JMP
+
+	lda := profiler.Cost(0x1000)
+	require.Equal(t, uint64(5), lda.Stalls)
+	require.Equal(t, lda.Executions*4+5, lda.Cycles)
+	jmp := profiler.Cost(0x1003)
+	require.Equal(t, uint64(0), jmp.Stalls)
+	require.Equal(t, jmp.Executions*3, jmp.Cycles)
+}
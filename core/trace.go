@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/beevik/go6502/cpu"
+	"io"
+	"strings"
+)
+
+// DefaultTraceSize is the number of instructions kept by a tracer unless told otherwise.
+const DefaultTraceSize = 1000000
+
+// Kinds of memory access recorded in a trace
+const (
+	TraceNone = iota
+	TraceRead
+	TraceWrite
+)
+
+// TraceRecord describes an executed instruction or interrupt sequence. Registers are
+// the ones before the instruction was executed.
+type TraceRecord struct {
+	Cycle       uint64 // CPU cycle the opcode was fetched in
+	PC          uint16
+	Opcode      [3]uint8 // Instruction bytes
+	Length      uint8    // Number of instruction bytes. Zero for interrupts.
+	A, X, Y     uint8
+	SP          uint8
+	Flags       uint8
+	Access      uint8  // TraceNone, TraceRead or TraceWrite
+	Address     uint16 // Address of the last data access by the instruction
+	Data        uint8  // Value read or written
+	Instruction *Instruction
+}
+
+// Tracer records executed instructions in a preallocated ring buffer, so that it's
+// possible to find out how the machine got to where it is after a crash or at a
+// breakpoint. Attach it to a CPU with CPU.SetTracer.
+type Tracer struct {
+	records  []TraceRecord
+	next     int  // Slot of the next record
+	count    int  // Number of valid records
+	fetching bool // Opcode fetch was interrupted by DMA and will be retried
+	want     uint8
+	lengths  [256]uint8
+
+	// Called if the CPU crashes on an invalid instruction, e.g. to save the trace
+	OnCrash func(t *Tracer)
+
+	// Labels and source lines are added to the text form if set
+	Symbols Symbols
+}
+
+// Symbols describes addresses in terms of the program source, e.g. "loop main.s:12".
+// An empty string means there's nothing to say.
+type Symbols interface {
+	Describe(addr uint16) string
+}
+
+// NewTracer creates a tracer that keeps the last size instructions. The size must be
+// positive.
+func NewTracer(size int) *Tracer {
+	if size <= 0 {
+		panic(fmt.Sprintf("Trace buffer size must be positive, got %d", size))
+	}
+	t := &Tracer{records: make([]TraceRecord, size)}
+	set := cpu.GetInstructionSet(cpu.NMOS)
+	for i := range t.lengths {
+		t.lengths[i] = set.Lookup(uint8(i)).Length
+	}
+	return t
+}
+
+// Records returns the recorded instructions, oldest first.
+func (t *Tracer) Records() []TraceRecord {
+	result := make([]TraceRecord, 0, t.count)
+	start := t.next - t.count
+	if start < 0 {
+		result = append(result, t.records[start+len(t.records):]...)
+		start = 0
+	}
+	return append(result, t.records[start:t.next]...)
+}
+
+func (t *Tracer) Len() int {
+	return t.count
+}
+
+func (t *Tracer) Clear() {
+	t.next = 0
+	t.count = 0
+	t.fetching = false
+}
+
+// Called before the CPU dispatches a new instruction or interrupt.
+func (t *Tracer) begin(c *CPU, cycle uint64) {
+	if !t.fetching {
+		t.next++
+		if t.next == len(t.records) {
+			t.next = 0
+		}
+		if t.count < len(t.records) {
+			t.count++
+		}
+	}
+	slot := t.next - 1
+	if slot < 0 {
+		slot = len(t.records) - 1
+	}
+	t.records[slot] = TraceRecord{Cycle: cycle, PC: c.pc, A: c.a, X: c.x, Y: c.y, SP: c.sp, Flags: c.flags}
+	t.fetching = true
+	t.want = 1
+}
+
+// Called once the instruction has been fetched.
+func (t *Tracer) decoded(instruction *Instruction) {
+	r := t.current()
+	r.Instruction = instruction
+	t.fetching = false
+	if r.Length == 0 {
+		t.want = 0 // Interrupt
+	} else {
+		t.want = t.lengths[r.Opcode[0]]
+	}
+}
+
+// Called for every memory access made by the CPU. Sequential reads of the
+// instruction bytes are told apart from data accesses.
+func (t *Tracer) access(addr uint16, data uint8, kind uint8) {
+	r := t.current()
+	if kind == TraceRead && r.Length < t.want && addr == r.PC+uint16(r.Length) {
+		r.Opcode[r.Length] = data
+		r.Length++
+		return
+	}
+	r.Access = kind
+	r.Address = addr
+	r.Data = data
+}
+
+func (t *Tracer) current() *TraceRecord {
+	if t.next == 0 {
+		return &t.records[len(t.records)-1]
+	}
+	return &t.records[t.next-1]
+}
+
+func (t *Tracer) crashed() {
+	if t.OnCrash != nil {
+		t.OnCrash(t)
+	}
+}
+
+// OperandFormats holds the Printf formats for the operands of each addressing mode of
+// go6502, in the order they're declared. Implied and accumulator modes have none.
+var OperandFormats = []string{"#$%02x", "", "$%04x", "$%02x", "$%02x,X", "$%02x,Y", "$%04x", "$%04x,X", "$%04x,Y", "($%04x)", "($%02x,X)", "($%02x),Y", ""}
+
+// String formats a record like the nestest log, which most trace diff tools understand:
+// address, instruction bytes, disassembly and registers. The address and value of the
+// last data access follow the disassembly.
+func (r *TraceRecord) String() string {
+	var hex strings.Builder
+	for _, b := range r.Opcode[:r.Length] {
+		fmt.Fprintf(&hex, "%02X ", b)
+	}
+	var text string
+	if r.Length == 0 {
+		if r.Instruction != nil {
+			text = r.Instruction.Mnemonic
+		}
+	} else {
+		inst := cpu.GetInstructionSet(cpu.NMOS).Lookup(r.Opcode[0])
+		operand := 0
+		switch r.Length {
+		case 2:
+			operand = int(r.Opcode[1])
+		case 3:
+			operand = int(r.Opcode[1]) | int(r.Opcode[2])<<8
+		}
+		if inst.Mode == cpu.REL {
+			operand = int(r.PC) + 2 + int(int8(operand))
+		}
+		text = inst.Name
+		if format := OperandFormats[inst.Mode]; format != "" {
+			text += " " + strings.ToUpper(fmt.Sprintf(format, operand&0xffff))
+		}
+		switch {
+		case r.Access == TraceNone, inst.Name == "JMP", inst.Name == "JSR":
+		case inst.Mode == cpu.IMM, inst.Mode == cpu.IMP, inst.Mode == cpu.ACC, inst.Mode == cpu.REL:
+		default:
+			text += fmt.Sprintf(" @ %04X = %02X", r.Address, r.Data)
+		}
+	}
+	return fmt.Sprintf("%04X  %-9s %-31s A:%02X X:%02X Y:%02X P:%02X SP:%02X CYC:%d",
+		r.PC, hex.String(), text, r.A, r.X, r.Y, r.Flags, r.SP, r.Cycle)
+}
+
+// WriteText writes the last n records in text form, oldest first.
+func (t *Tracer) WriteText(w io.Writer, n int) error {
+	records := t.Records()
+	if n < len(records) {
+		records = records[len(records)-n:]
+	}
+	out := bufio.NewWriter(w)
+	for i := range records {
+		line := records[i].String()
+		if t.Symbols != nil {
+			if desc := t.Symbols.Describe(records[i].PC); desc != "" {
+				line += " ; " + desc
+			}
+		}
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package core
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	cpu, _ := loadProgram(`
+	LDX #$00
+LOOP	INX
+	STX $2000
+	JMP LOOP
+`)
+	cpu.Trace = false
+	tracer := NewTracer(4)
+	cpu.SetTracer(tracer)
+	for cpu.Instructions() < 8 {
+		cpu.Clock()
+	}
+	FinishInstruction(cpu, cpu.Clock)
+
+	// Only the last four instructions are kept
+	records := tracer.Records()
+	require.Len(t, records, 4)
+	require.Equal(t, []uint16{0x1002, 0x1003, 0x1006, 0x1002}, []uint16{records[0].PC, records[1].PC, records[2].PC, records[3].PC})
+	store := records[1]
+	require.Equal(t, uint8(3), store.Length)
+	require.Equal(t, [3]uint8{0x8e, 0x00, 0x20}, store.Opcode)
+	require.Equal(t, uint8(2), store.X)
+	require.Equal(t, uint8(TraceWrite), store.Access)
+	require.Equal(t, uint16(0x2000), store.Address)
+	require.Equal(t, uint8(2), store.Data)
+	require.Equal(t, records[0].Cycle+2, store.Cycle)
+	require.Equal(t, uint8(TraceNone), records[2].Access, "Instruction bytes aren't data")
+
+	out := &bytes.Buffer{}
+	require.NoError(t, tracer.WriteText(out, 2))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, "1006  4C 02 10  JMP $1002                       A:00 X:02 Y:00 P:00 SP:FD CYC:21", lines[0])
+	require.True(t, strings.HasPrefix(lines[1], "1002  E8        INX "), lines[1])
+
+	// Symbols are appended
+	tracer.Symbols = testSymbols{0x1002: "LOOP"}
+	out.Reset()
+	require.NoError(t, tracer.WriteText(out, 1))
+	require.True(t, strings.HasSuffix(out.String(), "CYC:24 ; LOOP\n"), out.String())
+
+	// Writing the store shows the effective address and value
+	require.Contains(t, store.String(), "STX $2000 @ 2000 = 02")
+	tracer.Clear()
+	require.Empty(t, tracer.Records())
+}
+
+func TestNewTracer_Size(t *testing.T) {
+	require.Panics(t, func() { NewTracer(0) })
+	require.Panics(t, func() { NewTracer(-1) })
+}
+
+type testSymbols map[uint16]string
+
+func (s testSymbols) Describe(addr uint16) string {
+	return s[addr]
+}
@@ -0,0 +1,439 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package disasm turns machine code back into source. Code is told apart from
+// data by following the control flow from one or more entry points, so that
+// branch and jump targets can be given labels. Known hardware registers and ROM entry
+// points are shown by name. The output can be assembled again with go6502's
+// assembler or ca65 and gives back the same bytes.
+package disasm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/beevik/go6502/cpu"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/symbols"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Syntax selects the assembler the output is written for.
+type Syntax int
+
+const (
+	Go6502 Syntax = iota // github.com/beevik/go6502/asm
+	CA65
+)
+
+// Kinds of labels, which decide the prefix of generated names
+const (
+	labelData = iota
+	labelJump
+	labelSubroutine
+)
+
+var labelPrefixes = []string{"D", "L", "S"}
+
+// Number of bytes per .byte line
+const bytesPerLine = 8
+
+// Disassembler holds a piece of memory and what's been found out about it.
+type Disassembler struct {
+	Syntax  Syntax
+	Symbols *symbols.Table // Labels to use instead of generated ones. May be nil.
+
+	origin  uint16
+	data    []byte
+	entries []uint16
+
+	analyzed bool
+	start    []bool // Instruction starts here
+	code     []bool // Byte is part of an instruction
+	labels   map[uint16]int
+}
+
+// New creates a disassembler for data loaded at origin.
+func New(origin uint16, data []byte) *Disassembler {
+	return &Disassembler{origin: origin, data: data}
+}
+
+// NewFromMemory creates a disassembler for the range start to end, inclusive.
+func NewFromMemory(mem core.AddressSpace, start, end uint16) *Disassembler {
+	data := make([]byte, int(end)-int(start)+1)
+	for i := range data {
+		data[i] = mem.ReadByte(start + uint16(i))
+	}
+	return New(start, data)
+}
+
+// NewFromPRG creates a disassembler for a PRG file. If the program starts with a
+// BASIC line with a SYS command, like most machine code programs do, the SYS address
+// is added as an entry point. Otherwise the load address is.
+func NewFromPRG(prg []byte) (*Disassembler, error) {
+	if len(prg) < 3 {
+		return nil, errors.New("PRG file is too short")
+	}
+	d := New(uint16(prg[0])|uint16(prg[1])<<8, prg[2:])
+	if addr, ok := d.sysAddress(); ok {
+		d.AddEntry(addr)
+	} else {
+		d.AddEntry(d.origin)
+	}
+	return d, nil
+}
+
+// Find the address of a SYS in the first BASIC line, e.g. 10 SYS 2064
+func (d *Disassembler) sysAddress() (uint16, bool) {
+	const sysToken = 0x9e
+	if d.origin != 0x0801 || len(d.data) < 6 {
+		return 0, false
+	}
+	line := d.data[4:] // Skip the link and line number
+	i := 0
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+	if i == len(line) || line[i] != sysToken {
+		return 0, false
+	}
+	i++
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+	end := i
+	for end < len(line) && line[end] >= '0' && line[end] <= '9' {
+		end++
+	}
+	n, err := strconv.ParseUint(string(line[i:end]), 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(n), true
+}
+
+// AddEntry adds an address where execution can start. Without entry points, the
+// start of the data is used.
+func (d *Disassembler) AddEntry(addr uint16) {
+	d.entries = append(d.entries, addr)
+	d.analyzed = false
+}
+
+func (d *Disassembler) contains(addr uint16) bool {
+	return addr >= d.origin && int(addr-d.origin) < len(d.data)
+}
+
+func (d *Disassembler) byteAt(addr uint16) byte {
+	return d.data[addr-d.origin]
+}
+
+// Decode the instruction at an address. Returns nil if it's invalid or doesn't fit.
+func (d *Disassembler) decode(addr uint16) *cpu.Instruction {
+	inst := cpu.GetInstructionSet(cpu.NMOS).Lookup(d.byteAt(addr))
+	if inst.Name == "???" || int(addr-d.origin)+int(inst.Length) > len(d.data) {
+		return nil
+	}
+	return inst
+}
+
+func (d *Disassembler) operand(addr uint16, inst *cpu.Instruction) uint16 {
+	switch inst.Length {
+	case 2:
+		if inst.Mode == cpu.REL {
+			return addr + 2 + uint16(int8(d.byteAt(addr+1)))
+		}
+		return uint16(d.byteAt(addr + 1))
+	case 3:
+		return uint16(d.byteAt(addr+1)) | uint16(d.byteAt(addr+2))<<8
+	}
+	return 0
+}
+
+func (d *Disassembler) addLabel(addr uint16, kind int) {
+	if old, ok := d.labels[addr]; !ok || kind > old {
+		d.labels[addr] = kind
+	}
+}
+
+// Analyze follows the control flow from the entry points to find the code. Called
+// by Write if needed.
+func (d *Disassembler) Analyze() {
+	d.start = make([]bool, len(d.data))
+	d.code = make([]bool, len(d.data))
+	d.labels = make(map[uint16]int)
+	work := append([]uint16{}, d.entries...)
+	if len(work) == 0 {
+		work = append(work, d.origin)
+	}
+	for _, addr := range work {
+		d.addLabel(addr, labelJump)
+	}
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for d.contains(addr) && !d.start[addr-d.origin] {
+			inst := d.decode(addr)
+			if inst == nil || d.overlaps(addr, inst) {
+				break
+			}
+			d.start[addr-d.origin] = true
+			for i := uint16(0); i < uint16(inst.Length); i++ {
+				d.code[addr-d.origin+i] = true
+			}
+			target := d.operand(addr, inst)
+			next := addr + uint16(inst.Length)
+			stop := false
+			switch {
+			case inst.Mode == cpu.REL:
+				d.addLabel(target, labelJump)
+				work = append(work, target)
+			case inst.Name == "JSR":
+				d.addLabel(target, labelSubroutine)
+				work = append(work, target)
+			case inst.Name == "JMP" && inst.Mode == cpu.ABS:
+				d.addLabel(target, labelJump)
+				work = append(work, target)
+				stop = true
+			case inst.Name == "JMP", inst.Name == "RTS", inst.Name == "RTI", inst.Name == "BRK":
+				stop = true
+			}
+			if stop {
+				break
+			}
+			addr = next
+		}
+	}
+
+	// Data accessed by the code gets labels too
+	for i := range d.start {
+		if !d.start[i] {
+			continue
+		}
+		addr := d.origin + uint16(i)
+		inst := d.decode(addr)
+		switch inst.Mode {
+		case cpu.ABS:
+			if inst.Name != "JMP" && inst.Name != "JSR" {
+				d.addLabel(d.operand(addr, inst), labelData)
+			}
+		case cpu.ABX, cpu.ABY, cpu.IND:
+			d.addLabel(d.operand(addr, inst), labelData)
+		}
+	}
+	d.analyzed = true
+}
+
+// Does the instruction overlap one we've already found?
+func (d *Disassembler) overlaps(addr uint16, inst *cpu.Instruction) bool {
+	for i := uint16(0); i < uint16(inst.Length); i++ {
+		if d.code[addr-d.origin+i] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCode returns true if the byte at an address was found to be part of an
+// instruction.
+func (d *Disassembler) IsCode(addr uint16) bool {
+	if !d.analyzed {
+		d.Analyze()
+	}
+	return d.contains(addr) && d.code[addr-d.origin]
+}
+
+// Label returns the name used for an address, if it has one. Names come from the
+// symbol table, the known names and the generated labels, in that order. Generated
+// labels are only used for addresses inside the range.
+func (d *Disassembler) Label(addr uint16) (string, bool) {
+	if label, ok := d.Symbols.Label(addr); ok {
+		return label, true
+	}
+	if name, ok := KnownNames[addr]; ok {
+		return name.Label, true
+	}
+	if d.contains(addr) {
+		if kind, ok := d.labels[addr]; ok {
+			return fmt.Sprintf("%s%04X", labelPrefixes[kind], addr), true
+		}
+	}
+	return "", false
+}
+
+// Labels that can't be placed at the start of a line need to be defined as constants
+func (d *Disassembler) placeable(addr uint16) bool {
+	if !d.contains(addr) {
+		return false
+	}
+	i := addr - d.origin
+	return d.start[i] || !d.code[i]
+}
+
+// Format an operand address, by name if it has one. Zero page labels in the code
+// would be forward references, which assemblers treat as absolute addresses.
+func (d *Disassembler) address(addr uint16, zeroPage bool) string {
+	if label, ok := d.Label(addr); ok && !(zeroPage && d.placeable(addr)) {
+		return label
+	}
+	if zeroPage {
+		return fmt.Sprintf("$%02X", addr)
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// Format an instruction. Returns an empty string if it can't be written in a way that
+// assembles to the same bytes.
+func (d *Disassembler) instruction(addr uint16, inst *cpu.Instruction) string {
+	operand := d.operand(addr, inst)
+	var text string
+	switch inst.Mode {
+	case cpu.IMM:
+		text = fmt.Sprintf("#$%02X", operand)
+	case cpu.IMP, cpu.ACC:
+	case cpu.REL:
+		text = d.address(operand, false)
+	case cpu.ZPG:
+		text = d.address(operand, true)
+	case cpu.ZPX:
+		text = d.address(operand, true) + ",X"
+	case cpu.ZPY:
+		text = d.address(operand, true) + ",Y"
+	case cpu.IDX:
+		text = "(" + d.address(operand, true) + ",X)"
+	case cpu.IDY:
+		text = "(" + d.address(operand, true) + "),Y"
+	case cpu.IND:
+		text = "(" + d.address(operand, false) + ")"
+	case cpu.ABS, cpu.ABX, cpu.ABY:
+		text = d.address(operand, false)
+		if operand < 0x100 && inst.Name != "JMP" && inst.Name != "JSR" {
+			// Assemblers pick zero page addressing when they can
+			if d.Syntax != CA65 {
+				return ""
+			}
+			text = "a:" + text
+		}
+		switch inst.Mode {
+		case cpu.ABX:
+			text += ",X"
+		case cpu.ABY:
+			text += ",Y"
+		}
+	}
+	if text == "" {
+		return inst.Name
+	}
+	return inst.Name + " " + text
+}
+
+// Write writes the disassembly as source.
+func (d *Disassembler) Write(w io.Writer) error {
+	if !d.analyzed {
+		d.Analyze()
+	}
+	out := bufio.NewWriter(w)
+	end := int(d.origin) + len(d.data) - 1
+	fmt.Fprintf(out, "; Disassembly of $%04X-$%04X\n", d.origin, end)
+
+	// Constants for everything that isn't placed as a label in the code
+	var constants []uint16
+	seen := make(map[uint16]bool)
+	for i := range d.start {
+		if !d.start[i] {
+			continue
+		}
+		addr := d.origin + uint16(i)
+		inst := d.decode(addr)
+		if inst.Mode == cpu.IMM || inst.Length < 2 {
+			continue
+		}
+		target := d.operand(addr, inst)
+		if _, ok := d.Label(target); ok && !d.placeable(target) && !seen[target] {
+			seen[target] = true
+			constants = append(constants, target)
+		}
+	}
+	sort.Slice(constants, func(i, j int) bool { return constants[i] < constants[j] })
+	if len(constants) > 0 {
+		fmt.Fprintln(out)
+	}
+	for _, addr := range constants {
+		label, _ := d.Label(addr)
+		line := fmt.Sprintf("%s = $%04X", label, addr)
+		if name, ok := KnownNames[addr]; ok && name.Label == label {
+			line = fmt.Sprintf("%-28s ; %s", line, name.Description)
+		}
+		fmt.Fprintln(out, line)
+	}
+
+	fmt.Fprintf(out, "\n    .org $%04X\n", d.origin)
+	var pending []string // Data bytes waiting to be written
+	flush := func() {
+		if len(pending) > 0 {
+			fmt.Fprintf(out, "    .byte %s\n", strings.Join(pending, ","))
+			pending = pending[:0]
+		}
+	}
+	for i := 0; i < len(d.data); {
+		addr := d.origin + uint16(i)
+		if label, ok := d.Label(addr); ok && d.placeable(addr) {
+			flush()
+			fmt.Fprintf(out, "%s:\n", label)
+		}
+		if d.start[i] {
+			flush()
+			inst := d.decode(addr)
+			if text := d.instruction(addr, inst); text != "" {
+				fmt.Fprintf(out, "    %s\n", text)
+			} else {
+				var bytes []string
+				for j := 0; j < int(inst.Length); j++ {
+					bytes = append(bytes, fmt.Sprintf("$%02X", d.data[i+j]))
+				}
+				fmt.Fprintf(out, "    %-24s ; %s\n", ".byte "+strings.Join(bytes, ","), d.rawInstruction(addr, inst))
+			}
+			i += int(inst.Length)
+			continue
+		}
+		pending = append(pending, fmt.Sprintf("$%02X", d.data[i]))
+		if len(pending) == bytesPerLine {
+			flush()
+		}
+		i++
+	}
+	flush()
+	return out.Flush()
+}
+
+// An absolute instruction with its operand as a number, for comments
+func (d *Disassembler) rawInstruction(addr uint16, inst *cpu.Instruction) string {
+	text := fmt.Sprintf("%s $%04X", inst.Name, d.operand(addr, inst))
+	switch inst.Mode {
+	case cpu.ABX:
+		text += ",X"
+	case cpu.ABY:
+		text += ",Y"
+	}
+	return text
+}
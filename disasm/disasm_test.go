@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package disasm
+
+import (
+	"bytes"
+	"github.com/beevik/go6502/asm"
+	"github.com/prydin/emu6502/symbols"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"testing"
+)
+
+var testPRG = []byte{
+	0x01, 0x08, // Load address
+	0x0b, 0x08, 0x0a, 0x00, 0x9e, '2', '0', '6', '4', 0x00, 0x00, 0x00, // 10 SYS2064
+	0x00, 0x00, 0x00,
+	0xa2, 0x00, // 0810 LDX #$00
+	0xbd, 0x24, 0x08, // 0812 LDA $0824,X
+	0xf0, 0x06, // 0815 BEQ $081D
+	0x20, 0xd2, 0xff, // 0817 JSR $FFD2
+	0xe8,       // 081A INX
+	0xd0, 0xf5, // 081B BNE $0812
+	0xad, 0x10, 0x00, // 081D LDA $0010
+	0x8d, 0x20, 0xd0, // 0820 STA $D020
+	0x60,                         // 0823 RTS
+	0x48, 0x49, 0x00, 0x02, 0xff, // 0824 "HI", 0 and junk
+}
+
+func TestDisassembler(t *testing.T) {
+	d, err := NewFromPRG(testPRG)
+	require.NoError(t, err)
+	require.False(t, d.IsCode(0x080f))
+	require.True(t, d.IsCode(0x0810))
+	require.True(t, d.IsCode(0x0823))
+	require.False(t, d.IsCode(0x0824))
+
+	var out bytes.Buffer
+	require.NoError(t, d.Write(&out))
+	source := out.String()
+	require.Contains(t, source, "CHROUT = $FFD2")
+	require.Contains(t, source, "L0810:\n    LDX #$00\n")
+	require.Contains(t, source, "    LDA D0824,X\n")
+	require.Contains(t, source, "    BNE L0812\n")
+	require.Contains(t, source, "    JSR CHROUT\n")
+	require.Contains(t, source, "    STA EXTCOL\n")
+	require.Contains(t, source, "    .byte $AD,$10,$00        ; LDA $0010\n")
+	require.Contains(t, source, "D0824:\n    .byte $48,$49,$00,$02,$FF\n")
+
+	// The source assembles to the same program
+	code, _, err := asm.Assemble(&out, "test.asm", ioutil.Discard, 0)
+	require.NoError(t, err, source)
+	require.Equal(t, testPRG[2:], code.Code)
+}
+
+func TestDisassembler_CA65(t *testing.T) {
+	d := New(0x1000, []byte{0xad, 0x10, 0x00, 0x4c, 0x00, 0x10})
+	d.Syntax = CA65
+	d.Symbols = symbols.New()
+	d.Symbols.AddLabel("start", 0x1000)
+	var out bytes.Buffer
+	require.NoError(t, d.Write(&out))
+	require.Equal(t, "; Disassembly of $1000-$1005\n\n    .org $1000\nstart:\n    LDA a:$0010\n    JMP start\n", out.String())
+}
+
+func TestDisassembler_Overlap(t *testing.T) {
+	// BIT $xxxx is a common trick to skip over an instruction. The branch goes into
+	// the middle of it.
+	d := New(0x1000, []byte{0xd0, 0x01, 0x2c, 0xa9, 0x01, 0x60})
+	var out bytes.Buffer
+	require.NoError(t, d.Write(&out))
+	require.Contains(t, out.String(), "L1003 = $1003\n")
+	require.Contains(t, out.String(), "    BNE L1003\n")
+	code, _, err := asm.Assemble(&out, "test.asm", ioutil.Discard, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xd0, 0x01, 0x2c, 0xa9, 0x01, 0x60}, code.Code)
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package disasm
+
+// Name is a well known address in the C64, with the names from "Mapping the
+// Commodore 64".
+type Name struct {
+	Label       string
+	Description string
+}
+
+// KnownNames maps hardware registers and ROM entry points to names. Addresses
+// in the disassembly that match are shown by name.
+var KnownNames = map[uint16]Name{
+	// VIC-II
+	0xd000: {"SP0X", "Sprite 0 X position"},
+	0xd001: {"SP0Y", "Sprite 0 Y position"},
+	0xd002: {"SP1X", "Sprite 1 X position"},
+	0xd003: {"SP1Y", "Sprite 1 Y position"},
+	0xd004: {"SP2X", "Sprite 2 X position"},
+	0xd005: {"SP2Y", "Sprite 2 Y position"},
+	0xd006: {"SP3X", "Sprite 3 X position"},
+	0xd007: {"SP3Y", "Sprite 3 Y position"},
+	0xd008: {"SP4X", "Sprite 4 X position"},
+	0xd009: {"SP4Y", "Sprite 4 Y position"},
+	0xd00a: {"SP5X", "Sprite 5 X position"},
+	0xd00b: {"SP5Y", "Sprite 5 Y position"},
+	0xd00c: {"SP6X", "Sprite 6 X position"},
+	0xd00d: {"SP6Y", "Sprite 6 Y position"},
+	0xd00e: {"SP7X", "Sprite 7 X position"},
+	0xd00f: {"SP7Y", "Sprite 7 Y position"},
+	0xd010: {"MSIGX", "Most significant bits of sprite X positions"},
+	0xd011: {"SCROLY", "Vertical scroll and control register"},
+	0xd012: {"RASTER", "Raster line"},
+	0xd013: {"LPENX", "Light pen X position"},
+	0xd014: {"LPENY", "Light pen Y position"},
+	0xd015: {"SPENA", "Sprite enable"},
+	0xd016: {"SCROLX", "Horizontal scroll and control register"},
+	0xd017: {"YXPAND", "Sprite vertical expansion"},
+	0xd018: {"VMCSB", "Video matrix and character base"},
+	0xd019: {"VICIRQ", "VIC-II interrupt flags"},
+	0xd01a: {"IRQMSK", "VIC-II interrupt mask"},
+	0xd01b: {"SPBGPR", "Sprite to background priority"},
+	0xd01c: {"SPMC", "Sprite multicolor mode"},
+	0xd01d: {"XXPAND", "Sprite horizontal expansion"},
+	0xd01e: {"SPSPCL", "Sprite to sprite collisions"},
+	0xd01f: {"SPBGCL", "Sprite to background collisions"},
+	0xd020: {"EXTCOL", "Border color"},
+	0xd021: {"BGCOL0", "Background color 0"},
+	0xd022: {"BGCOL1", "Background color 1"},
+	0xd023: {"BGCOL2", "Background color 2"},
+	0xd024: {"BGCOL3", "Background color 3"},
+	0xd025: {"SPMC0", "Sprite multicolor 0"},
+	0xd026: {"SPMC1", "Sprite multicolor 1"},
+	0xd027: {"SP0COL", "Sprite 0 color"},
+	0xd028: {"SP1COL", "Sprite 1 color"},
+	0xd029: {"SP2COL", "Sprite 2 color"},
+	0xd02a: {"SP3COL", "Sprite 3 color"},
+	0xd02b: {"SP4COL", "Sprite 4 color"},
+	0xd02c: {"SP5COL", "Sprite 5 color"},
+	0xd02d: {"SP6COL", "Sprite 6 color"},
+	0xd02e: {"SP7COL", "Sprite 7 color"},
+
+	// SID
+	0xd400: {"FRELO1", "Voice 1 frequency low"},
+	0xd401: {"FREHI1", "Voice 1 frequency high"},
+	0xd402: {"PWLO1", "Voice 1 pulse width low"},
+	0xd403: {"PWHI1", "Voice 1 pulse width high"},
+	0xd404: {"VCREG1", "Voice 1 control"},
+	0xd405: {"ATDCY1", "Voice 1 attack and decay"},
+	0xd406: {"SUREL1", "Voice 1 sustain and release"},
+	0xd407: {"FRELO2", "Voice 2 frequency low"},
+	0xd408: {"FREHI2", "Voice 2 frequency high"},
+	0xd409: {"PWLO2", "Voice 2 pulse width low"},
+	0xd40a: {"PWHI2", "Voice 2 pulse width high"},
+	0xd40b: {"VCREG2", "Voice 2 control"},
+	0xd40c: {"ATDCY2", "Voice 2 attack and decay"},
+	0xd40d: {"SUREL2", "Voice 2 sustain and release"},
+	0xd40e: {"FRELO3", "Voice 3 frequency low"},
+	0xd40f: {"FREHI3", "Voice 3 frequency high"},
+	0xd410: {"PWLO3", "Voice 3 pulse width low"},
+	0xd411: {"PWHI3", "Voice 3 pulse width high"},
+	0xd412: {"VCREG3", "Voice 3 control"},
+	0xd413: {"ATDCY3", "Voice 3 attack and decay"},
+	0xd414: {"SUREL3", "Voice 3 sustain and release"},
+	0xd415: {"CUTLO", "Filter cutoff low"},
+	0xd416: {"CUTHI", "Filter cutoff high"},
+	0xd417: {"RESON", "Filter resonance and routing"},
+	0xd418: {"SIGVOL", "Filter mode and volume"},
+	0xd419: {"POTX", "Paddle X"},
+	0xd41a: {"POTY", "Paddle Y"},
+	0xd41b: {"RANDOM", "Voice 3 oscillator"},
+	0xd41c: {"ENV3", "Voice 3 envelope"},
+
+	// CIA 1
+	0xdc00: {"CIAPRA", "CIA 1 port A, keyboard columns and joystick 2"},
+	0xdc01: {"CIAPRB", "CIA 1 port B, keyboard rows and joystick 1"},
+	0xdc02: {"CIDDRA", "CIA 1 data direction A"},
+	0xdc03: {"CIDDRB", "CIA 1 data direction B"},
+	0xdc04: {"TIMALO", "CIA 1 timer A low"},
+	0xdc05: {"TIMAHI", "CIA 1 timer A high"},
+	0xdc06: {"TIMBLO", "CIA 1 timer B low"},
+	0xdc07: {"TIMBHI", "CIA 1 timer B high"},
+	0xdc08: {"TODTEN", "CIA 1 time of day tenths"},
+	0xdc09: {"TODSEC", "CIA 1 time of day seconds"},
+	0xdc0a: {"TODMIN", "CIA 1 time of day minutes"},
+	0xdc0b: {"TODHRS", "CIA 1 time of day hours"},
+	0xdc0c: {"CIASDR", "CIA 1 serial data"},
+	0xdc0d: {"CIAICR", "CIA 1 interrupt control"},
+	0xdc0e: {"CIACRA", "CIA 1 control A"},
+	0xdc0f: {"CIACRB", "CIA 1 control B"},
+
+	// CIA 2
+	0xdd00: {"CI2PRA", "CIA 2 port A, VIC bank and serial bus"},
+	0xdd01: {"CI2PRB", "CIA 2 port B, user port"},
+	0xdd02: {"C2DDRA", "CIA 2 data direction A"},
+	0xdd03: {"C2DDRB", "CIA 2 data direction B"},
+	0xdd04: {"TI2ALO", "CIA 2 timer A low"},
+	0xdd05: {"TI2AHI", "CIA 2 timer A high"},
+	0xdd06: {"TI2BLO", "CIA 2 timer B low"},
+	0xdd07: {"TI2BHI", "CIA 2 timer B high"},
+	0xdd08: {"TO2TEN", "CIA 2 time of day tenths"},
+	0xdd09: {"TO2SEC", "CIA 2 time of day seconds"},
+	0xdd0a: {"TO2MIN", "CIA 2 time of day minutes"},
+	0xdd0b: {"TO2HRS", "CIA 2 time of day hours"},
+	0xdd0c: {"CI2SDR", "CIA 2 serial data"},
+	0xdd0d: {"CI2ICR", "CIA 2 interrupt control"},
+	0xdd0e: {"CI2CRA", "CIA 2 control A"},
+	0xdd0f: {"CI2CRB", "CIA 2 control B"},
+
+	// RAM vectors
+	0x0314: {"CINV", "IRQ vector"},
+	0x0316: {"CBINV", "BRK vector"},
+	0x0318: {"NMINV", "NMI vector"},
+
+	// BASIC
+	0xa000: {"BASICCOLD", "BASIC cold start vector"},
+	0xa474: {"READY", "Print READY and enter the main loop"},
+	0xa533: {"LINKPRG", "Relink BASIC program lines"},
+	0xa659: {"CLR", "Perform CLR"},
+	0xa68e: {"RUNC", "Reset the text pointer to the start of the program"},
+	0xa7ae: {"NEWSTT", "Execute the next statement"},
+	0xab1e: {"STROUT", "Print a zero terminated string at A/Y"},
+	0xbdcd: {"LINPRT", "Print the number in A/X"},
+	0xe37b: {"WARMST", "BASIC warm start"},
+	0xe394: {"INIT", "BASIC cold start"},
+
+	// Kernal entry points
+	0xe544: {"CLRSCR", "Clear the screen"},
+	0xe566: {"HOME", "Move the cursor home"},
+	0xea31: {"IRQHND", "Standard IRQ handler"},
+	0xea7e: {"IRQACK", "Acknowledge the CIA 1 interrupt and return"},
+	0xea81: {"IRQRET", "Restore registers and return from interrupt"},
+	0xfce2: {"RESET", "Power on reset"},
+	0xfe43: {"NMIHND", "Standard NMI handler"},
+	0xff48: {"IRQENT", "IRQ and BRK entry"},
+
+	// Kernal jump table
+	0xff81: {"CINT", "Initialize the screen editor"},
+	0xff84: {"IOINIT", "Initialize I/O devices"},
+	0xff87: {"RAMTAS", "Test and clear RAM"},
+	0xff8a: {"RESTOR", "Restore the default vectors"},
+	0xff8d: {"VECTOR", "Read or set the vectors"},
+	0xff90: {"SETMSG", "Control kernal messages"},
+	0xff93: {"SECOND", "Send secondary address after LISTEN"},
+	0xff96: {"TKSA", "Send secondary address after TALK"},
+	0xff99: {"MEMTOP", "Read or set the top of memory"},
+	0xff9c: {"MEMBOT", "Read or set the bottom of memory"},
+	0xff9f: {"SCNKEY", "Scan the keyboard"},
+	0xffa2: {"SETTMO", "Set the IEEE timeout"},
+	0xffa5: {"ACPTR", "Read a byte from the serial bus"},
+	0xffa8: {"CIOUT", "Write a byte to the serial bus"},
+	0xffab: {"UNTLK", "Send UNTALK"},
+	0xffae: {"UNLSN", "Send UNLISTEN"},
+	0xffb1: {"LISTEN", "Send LISTEN"},
+	0xffb4: {"TALK", "Send TALK"},
+	0xffb7: {"READST", "Read the I/O status"},
+	0xffba: {"SETLFS", "Set logical file, device and secondary address"},
+	0xffbd: {"SETNAM", "Set the file name"},
+	0xffc0: {"OPEN", "Open a logical file"},
+	0xffc3: {"CLOSE", "Close a logical file"},
+	0xffc6: {"CHKIN", "Open a channel for input"},
+	0xffc9: {"CHKOUT", "Open a channel for output"},
+	0xffcc: {"CLRCHN", "Restore the default channels"},
+	0xffcf: {"CHRIN", "Read a character"},
+	0xffd2: {"CHROUT", "Write a character"},
+	0xffd5: {"LOAD", "Load from a device"},
+	0xffd8: {"SAVE", "Save to a device"},
+	0xffdb: {"SETTIM", "Set the jiffy clock"},
+	0xffde: {"RDTIM", "Read the jiffy clock"},
+	0xffe1: {"STOP", "Check the STOP key"},
+	0xffe4: {"GETIN", "Get a character"},
+	0xffe7: {"CLALL", "Close all files"},
+	0xffea: {"UDTIM", "Update the jiffy clock"},
+	0xffed: {"SCREEN", "Get the screen size"},
+	0xfff0: {"PLOT", "Read or set the cursor position"},
+	0xfff3: {"IOBASE", "Get the I/O base address"},
+
+	// Hardware vectors
+	0xfffa: {"NMIVEC", "NMI vector"},
+	0xfffc: {"RSTVEC", "Reset vector"},
+	0xfffe: {"IRQVEC", "IRQ and BRK vector"},
+}
@@ -0,0 +1,521 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package gdbstub exposes the CPU through the GDB Remote Serial Protocol, so that
+// GDB and other frontends speaking the protocol can debug programs running in the
+// emulator. Since GDB doesn't know about the 6502, the register layout is described
+// to it through a target description: a, x, y, p (flags) and sp are 8 bits wide,
+// followed by the 16 bit pc.
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/prydin/emu6502/core"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.emu6502.cpu">
+    <reg name="a" bitsize="8" regnum="0"/>
+    <reg name="x" bitsize="8" regnum="1"/>
+    <reg name="y" bitsize="8" regnum="2"/>
+    <reg name="p" bitsize="8" regnum="3"/>
+    <reg name="sp" bitsize="8" regnum="4" type="data_ptr"/>
+    <reg name="pc" bitsize="16" regnum="5" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+const (
+	interruptPacket = "\x03" // Sent by GDB to stop a running target
+	sigTrap         = 5
+)
+
+// Stub serves one GDB connection at a time. It never runs the machine on its own.
+// Instead, the emulation loop must call Poll regularly, which picks up new connections
+// and interrupt requests, and call Enter when the debugger has stopped the machine.
+// Both block for as long as GDB keeps the machine halted.
+type Stub struct {
+	cpu         *core.CPU
+	debugger    *core.Debugger
+	clock       func()
+	mem         core.AddressSpace
+	listener    net.Listener
+	accepted    chan net.Conn
+	conn        net.Conn
+	packets     chan string // Packets received from GDB
+	writeLock   sync.Mutex
+	breakpoints map[string]*core.Breakpoint // Breakpoints set by GDB, keyed by type and location
+}
+
+// Listen starts accepting connections on a TCP address such as "localhost:1234". The
+// clock function advances the entire machine by one step, which may be a whole cycle
+// or half of one, like Commodore64.Clock. Memory is accessed through mem, which is
+// typically the CPU bus.
+func Listen(address string, cpu *core.CPU, debugger *core.Debugger, clock func(), mem core.AddressSpace) (*Stub, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stub{
+		cpu:         cpu,
+		debugger:    debugger,
+		clock:       clock,
+		mem:         mem,
+		listener:    listener,
+		accepted:    make(chan net.Conn),
+		breakpoints: make(map[string]*core.Breakpoint),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Stub) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Stub) Close() error {
+	if s.conn != nil {
+		s.detach()
+	}
+	return s.listener.Close()
+}
+
+// Attached returns true while a GDB session is active.
+func (s *Stub) Attached() bool {
+	return s.conn != nil
+}
+
+// Poll checks for new connections and interrupt requests without blocking. If there
+// are any, the machine is halted until GDB resumes it.
+func (s *Stub) Poll() {
+	select {
+	case conn := <-s.accepted:
+		if s.conn != nil {
+			conn.Close() // One session at a time
+			break
+		}
+		s.conn = conn
+		s.packets = make(chan string, 16)
+		go s.readPackets(conn, s.packets)
+
+		// GDB expects the target to be halted when it connects
+		core.FinishInstruction(s.cpu, s.clock)
+		s.serve()
+		return
+	default:
+	}
+	if s.conn == nil {
+		return
+	}
+	select {
+	case p, ok := <-s.packets:
+		if !ok {
+			s.detach()
+		} else if p == interruptPacket {
+			s.Enter()
+		}
+	default:
+	}
+}
+
+// Enter reports that the machine has stopped, e.g. on a breakpoint or because GDB
+// asked us to stop it, and serves GDB until it resumes the machine.
+func (s *Stub) Enter() {
+	core.FinishInstruction(s.cpu, s.clock)
+	s.send(s.stopReply())
+	s.serve()
+}
+
+func (s *Stub) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.accepted <- conn
+	}
+}
+
+func (s *Stub) detach() {
+	for key, bp := range s.breakpoints {
+		s.debugger.Remove(bp.ID)
+		delete(s.breakpoints, key)
+	}
+	s.conn.Close()
+	s.conn = nil
+	s.debugger.Resume()
+}
+
+// Serve requests until GDB resumes the machine or goes away.
+func (s *Stub) serve() {
+	for {
+		p, ok := <-s.packets
+		if !ok {
+			s.detach()
+			return
+		}
+		if p == interruptPacket {
+			continue // Already stopped
+		}
+		reply, resume := s.handle(p)
+		if reply != nil {
+			s.send(*reply)
+		}
+		if resume {
+			return
+		}
+	}
+}
+
+func okReply() *string {
+	return reply("OK")
+}
+
+func reply(format string, args ...interface{}) *string {
+	s := fmt.Sprintf(format, args...)
+	return &s
+}
+
+// Handle a packet. Returns the reply, if any, and whether the machine should resume.
+// An empty reply means that the request isn't supported.
+func (s *Stub) handle(p string) (*string, bool) {
+	if p == "" {
+		return reply(""), false
+	}
+	args := p[1:]
+	switch p[0] {
+	case '?':
+		return reply("S%02x", sigTrap), false
+	case 'g':
+		return reply(hex.EncodeToString(registerBytes(s.cpu.Registers()))), false
+	case 'G':
+		data, err := hex.DecodeString(args)
+		if err != nil || len(data) != 7 {
+			return reply("E01"), false
+		}
+		s.cpu.SetRegisters(core.Registers{A: data[0], X: data[1], Y: data[2], Flags: data[3], SP: data[4], PC: uint16(data[5]) | uint16(data[6])<<8})
+		return okReply(), false
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n > 5 {
+			return reply("E01"), false
+		}
+		data := registerBytes(s.cpu.Registers())
+		if n == 5 {
+			return reply(hex.EncodeToString(data[5:7])), false
+		}
+		return reply("%02x", data[n]), false
+	case 'P':
+		return s.writeRegister(args), false
+	case 'm':
+		return s.readMemory(args), false
+	case 'M':
+		return s.writeMemory(args), false
+	case 'Z', 'z':
+		return s.breakpoint(p[0] == 'Z', args), false
+	case 's':
+		if err := s.resumeAt(args); err != nil {
+			return reply("E01"), false
+		}
+		core.StepInstruction(s.cpu, s.clock)
+		return reply(s.stopReply()), false
+	case 'c':
+		if err := s.resumeAt(args); err != nil {
+			return reply("E01"), false
+		}
+		return nil, true
+	case 'D':
+		s.send("OK")
+		s.detach()
+		return nil, true
+	case 'k':
+		s.detach()
+		return nil, true
+	case 'H', 'T':
+		return okReply(), false
+	case 'q':
+		return s.query(args), false
+	case 'Q':
+		if args == "StartNoAckMode" {
+			return okReply(), false
+		}
+	}
+	return reply(""), false
+}
+
+func (s *Stub) query(q string) *string {
+	switch {
+	case strings.HasPrefix(q, "Supported"):
+		return reply("PacketSize=1000;qXfer:features:read+;QStartNoAckMode+")
+	case q == "Attached":
+		return reply("1")
+	case q == "C":
+		return reply("QC1")
+	case q == "fThreadInfo":
+		return reply("m1")
+	case q == "sThreadInfo":
+		return reply("l")
+	case strings.HasPrefix(q, "Xfer:features:read:target.xml:"):
+		var offset, length int
+		if _, err := fmt.Sscanf(q[len("Xfer:features:read:target.xml:"):], "%x,%x", &offset, &length); err != nil {
+			return reply("E01")
+		}
+		if offset >= len(targetXML) {
+			return reply("l")
+		}
+		chunk := targetXML[offset:]
+		if len(chunk) > length {
+			return reply("m%s", chunk[:length])
+		}
+		return reply("l%s", chunk)
+	}
+	return reply("")
+}
+
+// Registers in the order of the target description. The pc is little endian.
+func registerBytes(r core.Registers) []byte {
+	return []byte{r.A, r.X, r.Y, r.Flags, r.SP, uint8(r.PC), uint8(r.PC >> 8)}
+}
+
+func (s *Stub) writeRegister(args string) *string {
+	parts := strings.SplitN(args, "=", 2)
+	if len(parts) != 2 {
+		return reply("E01")
+	}
+	n, err := strconv.ParseUint(parts[0], 16, 8)
+	if err != nil {
+		return reply("E01")
+	}
+	data, err := hex.DecodeString(parts[1])
+	if err != nil || len(data) == 0 {
+		return reply("E01")
+	}
+	r := s.cpu.Registers()
+	switch n {
+	case 0:
+		r.A = data[0]
+	case 1:
+		r.X = data[0]
+	case 2:
+		r.Y = data[0]
+	case 3:
+		r.Flags = data[0]
+	case 4:
+		r.SP = data[0]
+	case 5:
+		if len(data) != 2 {
+			return reply("E01")
+		}
+		r.PC = uint16(data[0]) | uint16(data[1])<<8
+	default:
+		return reply("E01")
+	}
+	s.cpu.SetRegisters(r)
+	return okReply()
+}
+
+// Parse "addr,length". GDB may use addresses above 64k, which wrap around. Lengths
+// are limited to the size of the address space.
+func parseLocation(s string) (uint16, int, error) {
+	var addr uint64
+	var length int
+	if _, err := fmt.Sscanf(s, "%x,%x", &addr, &length); err != nil {
+		return 0, 0, err
+	}
+	if length < 0 || length > 0x10000 {
+		return 0, 0, fmt.Errorf("bad length: %d", length)
+	}
+	return uint16(addr), length, nil
+}
+
+func (s *Stub) readMemory(args string) *string {
+	addr, length, err := parseLocation(args)
+	if err != nil {
+		return reply("E01")
+	}
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = core.Peek(s.mem, addr+uint16(i))
+	}
+	return reply(hex.EncodeToString(data))
+}
+
+func (s *Stub) writeMemory(args string) *string {
+	parts := strings.SplitN(args, ":", 2)
+	if len(parts) != 2 {
+		return reply("E01")
+	}
+	addr, length, err := parseLocation(parts[0])
+	if err != nil {
+		return reply("E01")
+	}
+	data, err := hex.DecodeString(parts[1])
+	if err != nil || len(data) != length {
+		return reply("E01")
+	}
+	s.debugger.Mute()
+	defer s.debugger.Unmute()
+	for i, b := range data {
+		s.mem.WriteByte(addr+uint16(i), b)
+	}
+	return okReply()
+}
+
+// Kinds of breakpoints indexed by their type in Z and z packets
+var breakKinds = []core.BreakKind{
+	core.BreakExec,                   // Software breakpoint
+	core.BreakExec,                   // Hardware breakpoint
+	core.BreakWrite,                  // Write watchpoint
+	core.BreakRead,                   // Read watchpoint
+	core.BreakRead | core.BreakWrite, // Access watchpoint
+}
+
+// Insert or remove a breakpoint given as "type,addr,kind". For watchpoints, kind is
+// the number of bytes to watch.
+func (s *Stub) breakpoint(insert bool, args string) *string {
+	parts := strings.SplitN(args, ",", 2)
+	t, err := strconv.Atoi(parts[0])
+	if err != nil || t < 0 || t >= len(breakKinds) || len(parts) != 2 {
+		return reply("")
+	}
+	addr, length, err := parseLocation(strings.SplitN(parts[1], ";", 2)[0])
+	if err != nil {
+		return reply("E01")
+	}
+	key := fmt.Sprintf("%d,%04x,%x", t, addr, length)
+	if !insert {
+		if bp, ok := s.breakpoints[key]; ok {
+			s.debugger.Remove(bp.ID)
+			delete(s.breakpoints, key)
+		}
+		return okReply()
+	}
+	if _, ok := s.breakpoints[key]; ok {
+		return okReply()
+	}
+	end := addr
+	if t >= 2 && length > 1 {
+		// Watching past the end of memory would wrap around
+		end = uint16(0xffff)
+		if int(addr)+length-1 < 0xffff {
+			end = addr + uint16(length-1)
+		}
+	}
+	s.breakpoints[key] = s.debugger.Add(breakKinds[t], addr, end, nil)
+	return okReply()
+}
+
+// Continue and step take an optional address to resume at.
+func (s *Stub) resumeAt(args string) error {
+	if args == "" {
+		return nil
+	}
+	addr, err := strconv.ParseUint(args, 16, 16)
+	if err != nil {
+		return err
+	}
+	r := s.cpu.Registers()
+	r.PC = uint16(addr)
+	s.cpu.SetRegisters(r)
+	return nil
+}
+
+// Build a stop reply from the reason the debugger stopped, if any.
+func (s *Stub) stopReply() string {
+	stop := s.debugger.StopEvent()
+	s.debugger.Resume()
+	if stop == nil || stop.Kind == core.BreakExec {
+		return fmt.Sprintf("S%02x", sigTrap)
+	}
+	kind := "awatch"
+	switch stop.Breakpoint.Kind {
+	case core.BreakWrite:
+		kind = "watch"
+	case core.BreakRead:
+		kind = "rwatch"
+	}
+	return fmt.Sprintf("T%02x%s:%04x;", sigTrap, kind, stop.Address)
+}
+
+func checksum(data string) uint8 {
+	sum := uint8(0)
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (s *Stub) send(data string) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	fmt.Fprintf(s.conn, "$%s#%02x", data, checksum(data))
+}
+
+// Read packets and pass them on to the emulation loop. Acknowledgements from GDB are
+// ignored, since we never resend anything over TCP.
+func (s *Stub) readPackets(conn net.Conn, packets chan<- string) {
+	defer close(packets)
+	in := bufio.NewReader(conn)
+	noAck := false
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 0x03:
+			packets <- interruptPacket
+		case '$':
+			data, err := in.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+			var sum [2]byte
+			if _, err := io.ReadFull(in, sum[:]); err != nil {
+				return
+			}
+			if !noAck {
+				ack := "+"
+				if fmt.Sprintf("%02x", checksum(data)) != strings.ToLower(string(sum[:])) {
+					ack = "-"
+				}
+				s.writeLock.Lock()
+				conn.Write([]byte(ack))
+				s.writeLock.Unlock()
+				if ack == "-" {
+					continue
+				}
+			}
+			noAck = noAck || data == "QStartNoAckMode"
+			packets <- data
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/prydin/emu6502/core"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	in   *bufio.Reader
+}
+
+func (c *client) send(packet string) {
+	fmt.Fprintf(c.conn, "$%s#%02x", packet, checksum(packet))
+	ack, err := c.in.ReadByte()
+	require.NoError(c.t, err)
+	require.Equal(c.t, byte('+'), ack)
+}
+
+func (c *client) receive() string {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start, err := c.in.ReadString('$')
+	require.NoError(c.t, err)
+	require.Equal(c.t, "$", start)
+	data, err := c.in.ReadString('#')
+	require.NoError(c.t, err)
+	data = data[:len(data)-1]
+	sum := make([]byte, 2)
+	_, err = c.in.Read(sum)
+	require.NoError(c.t, err)
+	require.Equal(c.t, fmt.Sprintf("%02x", checksum(data)), string(sum))
+	c.conn.Write([]byte("+"))
+	return data
+}
+
+func (c *client) exchange(packet string) string {
+	c.send(packet)
+	return c.receive()
+}
+
+func TestStub(t *testing.T) {
+	// LDX #$00, INX, STX $2000, JMP $1002
+	ram := core.MakeRAM(0xffff)
+	copy(ram.Bytes[0x1000:], []byte{0xa2, 0x00, 0xe8, 0x8e, 0x00, 0x20, 0x4c, 0x02, 0x10})
+	bus := &core.Bus{}
+	bus.Connect(ram, 0x0000, 0xffff)
+	cpu := &core.CPU{}
+	cpu.Init(bus)
+	bus.ConnectClockablePh1(cpu)
+	cpu.SetRegisters(core.Registers{PC: 0x1000, SP: 0xff})
+	debugger := core.NewDebugger(cpu, bus)
+	stub, err := Listen("localhost:0", cpu, debugger, bus.ClockPh1, bus)
+	require.NoError(t, err)
+
+	// Emulation loop
+	var done int32
+	finished := make(chan bool)
+	go func() {
+		for n := 0; atomic.LoadInt32(&done) == 0; n++ {
+			bus.ClockPh1()
+			if debugger.Stopped() {
+				stub.Enter()
+			}
+			if n%100 == 0 {
+				stub.Poll()
+			}
+		}
+		stub.Close()
+		finished <- true
+	}()
+
+	conn, err := net.Dial("tcp", stub.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	c := &client{t: t, conn: conn, in: bufio.NewReader(conn)}
+
+	require.Contains(t, c.exchange("qSupported:multiprocess+"), "qXfer:features:read+")
+	require.Equal(t, "S05", c.exchange("?"))
+	require.True(t, strings.HasPrefix(c.exchange("qXfer:features:read:target.xml:0,1000"), "l<?xml"))
+	require.True(t, strings.HasPrefix(c.exchange("qXfer:features:read:target.xml:0,10"), "m<?xml"))
+
+	// Registers and memory
+	require.Equal(t, "OK", c.exchange("G42000000ff0010"))
+	require.Equal(t, "42000000ff0010", c.exchange("g"))
+	require.Equal(t, "OK", c.exchange("P0=00"))
+	require.Equal(t, "0010", c.exchange("p5"))
+	require.Equal(t, "a200e8", c.exchange("m1000,3"))
+	require.Equal(t, "OK", c.exchange("M3000,2:abcd"))
+	require.Equal(t, "abcd", c.exchange("m13000,2"), "Addresses should wrap around")
+	require.Equal(t, "E01", c.exchange("m0,-1"))
+	require.Equal(t, "E01", c.exchange("m0,10001"))
+	require.Equal(t, "E01", c.exchange("M0,-1:"))
+
+	// Stepping
+	require.Equal(t, "S05", c.exchange("s"))
+	require.Equal(t, "0210", c.exchange("p5"))
+
+	// Breakpoints
+	require.Equal(t, "OK", c.exchange("Z0,1006,1"))
+	c.send("c")
+	require.Equal(t, "S05", c.receive())
+	require.Equal(t, "0610", c.exchange("p5"))
+	require.Equal(t, "01", c.exchange("p1"))
+	require.Equal(t, "OK", c.exchange("z0,1006,1"))
+
+	// Watchpoints
+	require.Equal(t, "OK", c.exchange("Z2,2000,1"))
+	c.send("c")
+	require.Equal(t, "T05watch:2000;", c.receive())
+	require.Equal(t, "02", c.exchange("p1"))
+	require.Equal(t, "OK", c.exchange("z2,2000,1"))
+	require.Equal(t, "", c.exchange("Z-1,2000,1"))
+	require.Equal(t, "OK", c.exchange("Z2,fffe,4"))
+	bps := debugger.Breakpoints()
+	require.Equal(t, uint16(0xffff), bps[len(bps)-1].End, "Watchpoints shouldn't wrap around")
+	require.Equal(t, "OK", c.exchange("z2,fffe,4"))
+
+	// Interrupting
+	c.send("c")
+	conn.Write([]byte{0x03})
+	require.Equal(t, "S05", c.receive())
+
+	require.Equal(t, "OK", c.exchange("D"))
+	atomic.StoreInt32(&done, 1)
+	<-finished
+	require.Empty(t, debugger.Breakpoints())
+}
@@ -26,24 +26,65 @@ import (
 	"github.com/beevik/go6502/asm"
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
+	"github.com/prydin/emu6502/binmon"
 	"github.com/prydin/emu6502/computer"
+	"github.com/prydin/emu6502/controlport"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/gdbstub"
+	"github.com/prydin/emu6502/monitor"
+	"github.com/prydin/emu6502/profiler"
+	"github.com/prydin/emu6502/record"
 	"github.com/prydin/emu6502/screen"
+	"github.com/prydin/emu6502/sid"
+	"github.com/prydin/emu6502/symbols"
 	vic_ii "github.com/prydin/emu6502/vic-ii"
 	"image"
+	"image/png"
+	"io"
 	"log"
 	"os"
 	"runtime/pprof"
+	"strings"
 	"time"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var loadasm = flag.String("loadasm", "", "load assembly language file")
-
-var PalFPS = 50.125
-var PalFrameTime = time.Duration((1 / PalFPS) * 1e9)
+var port1 = flag.String("port1", "none", "device in control port 1 (none, paddles, mouse, lightpen)")
+var port2 = flag.String("port2", "none", "device in control port 2 (none, paddles, mouse)")
+var digiboost = flag.Bool("digiboost", false, "enable digi boost on 8580 SIDs")
+var sids = flag.String("sids", "", "SIDs to attach as address[:model[:pan]],... e.g. d400:6581:-1,d420:8580:1")
+var pacing = flag.String("pacing", "", "timing source (time, tsc, audio). Defaults to audio if -audio is given, otherwise time")
+var audioOut = flag.String("audio", "", "write raw 16 bit stereo PCM to file or pipe, - for stdout. E.g. -audio - | aplay -f S16_LE -c2 -r44100")
+var gdb = flag.String("gdb", "", "accept GDB remote protocol connections on address, e.g. localhost:1234")
+var binaryMonitor = flag.String("binarymonitor", "", "accept VICE binary monitor connections on address, e.g. localhost:6502")
+var trace = flag.Int("trace", 0, "record the last n instructions, e.g. 1000000. Use the monitor's trace command to see them")
+var traceCrash = flag.String("tracecrash", "crash-trace.txt", "file the instruction trace is written to if the CPU crashes")
+var profile = flag.String("profile", "", "profile cycles per address and subroutine and write a pprof profile to file on exit")
+var profileReport = flag.String("profilereport", "", "profile cycles and write a text report of the hotspots to file on exit")
+var heatmap = flag.String("heatmap", "", "count memory accesses and write a heatmap PNG to file on exit")
+var coverage = flag.String("coverage", "", "count memory accesses and write the ranges used as code and data to file on exit")
+var labels = flag.String("labels", "", "load labels and source lines from VICE label or ca65 debug info files, separated by commas")
+var model = flag.String("model", "pal", "machine model (pal, ntsc, ntsc-old, pal-n) or VIC-II revision (6569, 6567r8, 6567r56a, 6572)")
+var paletteName = flag.String("palette", "pepto", "palette (pepto, colodore, vice, c64wiki), a VICE .vpl file or generate[:brightness,contrast,saturation,gamma]")
+var crt = flag.String("crt", "", "post-processing effects, e.g. pal,blur=0.3,scanlines=0.4 for PAL blending, blur and scanlines")
+var recordVideo = flag.String("record", "", "record video to a .gif, .png (animated) or .y4m file, with the sound in a .wav file next to it")
+var sidlog = flag.String("sidlog", "", "log writes to the first SID to file (convert with sid2midi)")
 
 func main() {
 	flag.Parse()
+	dims, ok := vic_ii.Models[strings.ToLower(*model)]
+	if !ok {
+		log.Fatalf("Unknown model: %s", *model)
+	}
+	palette, err := vic_ii.LoadPalette(*paletteName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter, err := vic_ii.ParseCRTFilter(*crt)
+	if err != nil {
+		log.Fatal(err)
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -69,8 +110,82 @@ func main() {
 		}
 	}
 
+	// Keep the labels and source lines around for the debugging tools
+	syms := symbols.New()
+	if sourceMap != nil {
+		syms.AddSourceMap(sourceMap)
+	}
+	if *labels != "" {
+		for _, name := range strings.Split(*labels, ",") {
+			if err := syms.Load(name); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	var sidSlots []computer.SIDSlot
+	if *sids != "" {
+		var err error
+		sidSlots, err = computer.ParseSIDSlots(*sids)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *digiboost {
+		if len(sidSlots) == 0 {
+			sidSlots = append(sidSlots, computer.DefaultSIDSlots...)
+		}
+		for i := range sidSlots {
+			sidSlots[i].DigiBoost = true
+		}
+	}
+
+	var audio *core.AudioClock
+	if *audioOut != "" {
+		out := os.Stdout
+		if *audioOut != "-" {
+			var err error
+			if out, err = os.Create(*audioOut); err != nil {
+				log.Fatal(err)
+			}
+		}
+		audio = core.NewAudioClock(computer.DefaultSampleRate, 50*time.Millisecond)
+		go func() {
+			if _, err := io.Copy(out, audio); err != nil {
+				log.Print(err)
+			}
+			out.Close()
+		}()
+		defer audio.Close()
+	}
+	if *pacing == "" {
+		*pacing = "time"
+		if audio != nil {
+			*pacing = "audio"
+		}
+	}
+	var clock core.Clock
+	switch *pacing {
+	case "time":
+		clock = core.NewTimeClock(uint64(dims.ClockFrequency))
+	case "tsc":
+		clock = core.NewClock(uint64(dims.ClockFrequency))
+	case "audio":
+		if audio == nil {
+			log.Fatal("Audio pacing requires -audio")
+		}
+		clock = audio
+	default:
+		log.Fatalf("Unknown pacing: %s", *pacing)
+	}
+	// The clocks tick once per cycle, but c64.Clock() only runs half of one
+	clock = &core.HalfCycleClock{Clock: clock}
+
 	pixelgl.Run(func() {
-		c64 := computer.Commodore64{}
+		c64 := computer.Commodore64{SIDSlots: sidSlots}
+		if audio != nil {
+			c64.Audio = audio
+		}
 		cfg := pixelgl.WindowConfig{
 			Title:     "Gommodore64",
 			Bounds:    pixel.R(0, 0, 1024, 768),
@@ -82,34 +197,143 @@ func main() {
 			panic(err)
 		}
 		win.SetSmooth(true) // Gives a nice blurry retro look!
-		scr := screen.New(win, image.Rectangle{
+		scr := screen.NewWithFilter(win, image.Rectangle{
 			Min: image.Point{},
-			Max: image.Point{vic_ii.PalVisibleWidth, vic_ii.PalVisibleHeight},
-		})
+			Max: image.Point{int(dims.VisibleWidth), int(dims.VisibleHeight)},
+		}, filter)
 
-		c64.Cpu.CrashOnInvalidInst = true // TODO: Make configurable
-		c64.Init(scr, vic_ii.PALDimensions)
+		// Frames are recorded as the VIC-II draws them, before any post-processing
+		var raster vic_ii.Raster = scr
+		if *recordVideo != "" {
+			recorder, wav, err := startRecording(*recordVideo, scr, dims)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer closeRecording(recorder, wav)
+			raster = recorder
+			c64.Audio = wav
+			if audio != nil {
+				c64.Audio = sid.MultiSink(audio, wav)
+			}
+		}
+
+		c64.Cpu.CrashOnInvalidInst = true     // TODO: Make configurable
+		c64.GrabFrames = *binaryMonitor != "" // For screenshots
+		c64.Filter = filter
+		if err := c64.Init(raster, dims); err != nil {
+			log.Fatal(err)
+		}
+		c64.Vic.SetPalette(palette)
 		c64.Keyboard.SetProvider(win)
+		for i, name := range []string{*port1, *port2} {
+			switch name {
+			case "none":
+			case "paddles":
+				c64.ControlPorts.Ports[i] = controlport.NewPaddles(win)
+			case "mouse":
+				c64.ControlPorts.Ports[i] = controlport.NewMouse1351(win)
+			case "lightpen":
+				if i != 0 {
+					log.Fatal("The light pen only works in control port 1")
+				}
+				c64.ControlPorts.Ports[i] = controlport.NewLightPen(win, scr, &c64.Vic, dims)
+			default:
+				log.Fatalf("Unknown control port device: %s", name)
+			}
+		}
+		if *sidlog != "" {
+			f, err := os.Create(*sidlog)
+			if err != nil {
+				log.Fatal(err)
+			}
+			recorder, err := sid.NewLogWriter(f, sid.LogHeader{Model: c64.SIDs[0].Model(), ClockFreq: dims.ClockFrequency})
+			if err != nil {
+				log.Fatal(err)
+			}
+			c64.SIDs[0].SetRecorder(recorder)
+			defer func() {
+				if err := recorder.Close(); err != nil {
+					log.Print(err)
+				}
+			}()
+		}
+		if *trace > 0 {
+			tracer := core.NewTracer(*trace)
+			tracer.Symbols = syms
+			tracer.OnCrash = func(t *core.Tracer) {
+				f, err := os.Create(*traceCrash)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				defer f.Close()
+				if err := t.WriteText(f, t.Len()); err != nil {
+					log.Print(err)
+				}
+				log.Printf("Instruction trace written to %s", *traceCrash)
+			}
+			c64.Cpu.SetTracer(tracer)
+		}
+		if *profile != "" || *profileReport != "" {
+			prof := core.NewProfiler()
+			c64.Cpu.SetProfiler(prof)
+			defer writeProfile(prof, syms)
+		}
+		if *heatmap != "" || *coverage != "" {
+			accesses := core.NewAccessMap()
+			c64.Cpu.SetAccessMap(accesses)
+			defer writeAccessMap(accesses)
+		}
 		//c64.cpu.Trace = true
 		c64.Cpu.Reset()
 
-		var lastVSynch time.Time
+		// The monitor shares the terminal with raw audio written to stdout
+		monitorOut := os.Stdout
+		if *audioOut == "-" {
+			monitorOut = os.Stderr
+		}
+		mon := monitor.New(&c64.Cpu, c64.Debugger, c64.Clock, c64.MemoryBanks(), os.Stdin, monitorOut)
+		mon.SetSymbols(syms)
+		mon.SetVideo(&c64.Vic)
+		var stub *gdbstub.Stub
+		if *gdb != "" {
+			if stub, err = gdbstub.Listen(*gdb, &c64.Cpu, c64.Debugger, c64.Clock, &c64.Bus); err != nil {
+				log.Fatal(err)
+			}
+			defer stub.Close()
+		}
+		var binMon *binmon.Server
+		if *binaryMonitor != "" {
+			if binMon, err = binmon.Listen(*binaryMonitor, &c64.Cpu, c64.Debugger, &c64); err != nil {
+				log.Fatal(err)
+			}
+			defer binMon.Close()
+		}
+
 		n := 0
 		for {
-			if c64.Vic.IsVSynch() {
-				now := time.Now()
-				frameTime := now.Sub(lastVSynch)
-
-				// Sleeping precision is too low, so we spin instead
-				if frameTime < PalFrameTime {
-					target := now.Add(PalFrameTime - frameTime)
-					for time.Now().Before(target) {
-						// Do nothing
-					}
+			clock.NextTick()
+			c64.Clock()
+			if c64.Debugger.Stopped() || n%20000 == 0 && win.JustPressed(pixelgl.KeyF12) {
+				// Breakpoints are reported to remote debuggers while they're attached
+				stopped := c64.Debugger.Stopped()
+				if stub != nil && stub.Attached() && stopped {
+					stub.Enter()
+				} else if binMon != nil && binMon.Attached() && stopped {
+					binMon.Enter()
+				} else {
+					mon.Enter()
+				}
+			}
+			if stub != nil && n%20000 == 0 {
+				stub.Poll()
+			}
+			if binMon != nil && n%20000 == 0 {
+				binMon.Poll()
+				if binMon.QuitRequested() {
+					break
 				}
-				lastVSynch = time.Now()
 			}
-			c64.Clock()
 			if code != nil && n > 10000000 {
 				for i := uint16(0); i < uint16(sourceMap.Size); i++ {
 					c64.Bus.WriteByte(i+sourceMap.Origin, code.Code[i])
@@ -125,3 +349,61 @@ func main() {
 		}
 	})
 }
+
+// Record the video to a file and the sound to a WAV file with the same name
+func startRecording(name string, raster vic_ii.Raster, dims vic_ii.ScreenDimensions) (*record.Recorder, *record.WAVWriter, error) {
+	width, height := int(dims.VisibleWidth), int(dims.VisibleHeight)
+	encoder, err := record.Create(name, width, height, record.RateOf(dims))
+	if err != nil {
+		return nil, nil, err
+	}
+	wav, err := record.CreateWAV(record.SoundFile(name), computer.DefaultSampleRate)
+	if err != nil {
+		encoder.Close()
+		return nil, nil, err
+	}
+	return record.NewRecorder(raster, encoder, width, height), wav, nil
+}
+
+func closeRecording(recorder *record.Recorder, wav *record.WAVWriter) {
+	if err := recorder.Close(); err != nil {
+		log.Print(err)
+	}
+	if err := wav.Close(); err != nil {
+		log.Print(err)
+	}
+	log.Printf("Recorded %d frames", recorder.Frames())
+}
+
+// Write a file on exit if a name was given. Errors are logged, since we're exiting
+// anyway.
+func writeOnExit(name string, writer func(w io.Writer) error) {
+	if name == "" {
+		return
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer f.Close()
+	if err := writer(f); err != nil {
+		log.Print(err)
+	}
+}
+
+func writeProfile(prof *core.Profiler, syms *symbols.Table) {
+	writeOnExit(*profile, func(w io.Writer) error {
+		return profiler.WritePprof(w, prof, syms)
+	})
+	writeOnExit(*profileReport, func(w io.Writer) error {
+		return profiler.WriteReport(w, prof, syms, 100)
+	})
+}
+
+func writeAccessMap(accesses *core.AccessMap) {
+	writeOnExit(*heatmap, func(w io.Writer) error {
+		return png.Encode(w, accesses.Image())
+	})
+	writeOnExit(*coverage, accesses.WriteCoverage)
+}
@@ -0,0 +1,1031 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/beevik/go6502/asm"
+	"github.com/beevik/go6502/cpu"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/disasm"
+	"github.com/prydin/emu6502/profiler"
+	"github.com/prydin/emu6502/symbols"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{[]string{"help", "?"}, "", "Show this help", (*Monitor).help},
+		{[]string{"r", "registers"}, "[reg=value ...]", "Show or set registers (pc, a, x, y, sp, fl)", (*Monitor).registers},
+		{[]string{"m", "mem"}, "[start [end]]", "Dump memory", (*Monitor).dump},
+		{[]string{"d", "disass"}, "[start [end]]", "Disassemble", (*Monitor).disass},
+		{[]string{"ds", "dsave"}, "\"file\" start end [entry ...]", "Save a disassembly as source that can be assembled again", (*Monitor).saveDisassembly},
+		{[]string{"a"}, "address [instruction]", "Assemble. Enter an empty line to stop", (*Monitor).assemble},
+		{[]string{"f", "fill"}, "start end data ...", "Fill memory with a pattern", (*Monitor).fill},
+		{[]string{"c", "compare"}, "start end dest", "Compare memory ranges", (*Monitor).compare},
+		{[]string{"h", "hunt"}, "start end data ...", "Search memory for a pattern", (*Monitor).hunt},
+		{[]string{"t", "transfer"}, "start end dest", "Copy memory", (*Monitor).transfer},
+		{[]string{"l", "load"}, "\"file\" [address]", "Load a PRG file, optionally at another address", (*Monitor).load},
+		{[]string{"bl", "bload"}, "\"file\" address", "Load a raw binary file", (*Monitor).bload},
+		{[]string{"s", "save"}, "\"file\" start end", "Save memory as a PRG file", (*Monitor).save},
+		{[]string{"bs", "bsave"}, "\"file\" start end", "Save memory as a raw binary file", (*Monitor).bsave},
+		{[]string{"z", "step"}, "[count]", "Step instructions", (*Monitor).stepCmd},
+		{[]string{"n", "next"}, "[count]", "Step instructions, treating subroutine calls as one", (*Monitor).next},
+		{[]string{"hs", "halfstep"}, "[count]", "Step half cycles", (*Monitor).halfStep},
+		{[]string{"cs", "cyclestep"}, "[count]", "Step cycles", (*Monitor).cycleStep},
+		{[]string{"nl", "nextline"}, "[count]", "Run to the start of the next raster line", (*Monitor).nextLine},
+		{[]string{"rl", "runline"}, "line [cycle]", "Run until the beam is at a raster line and cycle", (*Monitor).runToLine},
+		{[]string{"nf", "nextframe"}, "[count]", "Run to the start of the next frame", (*Monitor).nextFrame},
+		{[]string{"beam"}, "", "Show the beam position and the RDY, IRQ and NMI lines", (*Monitor).beam},
+		{[]string{"view"}, "charset|mccharset|sprites|mcsprites|bitmap \"file\"", "Save the graphics the video chip would use as PNG", (*Monitor).view},
+		{[]string{"pal", "palette"}, "[name | \"file\" | generate[:b,c,s,g] | save \"file\"]", "List palettes, select one, generate one or save the current one as .vpl", (*Monitor).palette},
+		{[]string{"g", "goto"}, "[address]", "Resume execution, optionally at another address", (*Monitor).goCmd},
+		{[]string{"x", "exit"}, "", "Resume execution", (*Monitor).exit},
+		{[]string{"bank"}, "[name]", "Show or select the memory bank", (*Monitor).selectBank},
+		{[]string{"break", "bk"}, "[start [end]] [if cond]", "List breakpoints or set one", (*Monitor).setBreakpoint},
+		{[]string{"watch", "w"}, "[load|store] start [end] [if cond]", "Set a watchpoint", (*Monitor).setWatchpoint},
+		{[]string{"del", "delete"}, "[number]", "Delete a breakpoint, or all of them", (*Monitor).deleteBreakpoint},
+		{[]string{"enable", "en"}, "number", "Enable a breakpoint", (*Monitor).enableBreakpoint},
+		{[]string{"disable", "dis"}, "number", "Disable a breakpoint", (*Monitor).disableBreakpoint},
+		{[]string{"cond", "condition"}, "number [cond]", "Set or clear the condition of a breakpoint", (*Monitor).setCondition},
+		{[]string{"ignore"}, "number [count]", "Ignore the next hits of a breakpoint", (*Monitor).ignoreBreakpoint},
+		{[]string{"trace", "tr"}, "[count | save \"file\"]", "Show the last traced instructions or save all", (*Monitor).trace},
+		{[]string{"profile", "prof"}, "[on | off | clear | count | save \"file\"]", "Profile cycles per address and subroutine, show the top entries or save for pprof", (*Monitor).profile},
+		{[]string{"heatmap", "hm"}, "[on | off | clear | save \"file\"]", "Count memory accesses, or save a heatmap of them as PNG", (*Monitor).heatmap},
+		{[]string{"coverage", "cov"}, "[\"file\"]", "Show or save the ranges accessed as code and data", (*Monitor).coverage},
+		{[]string{"ll", "load_labels"}, "\"file\"", "Load a VICE label file or a ca65 debug info file", (*Monitor).loadLabels},
+		{[]string{"sl", "save_labels"}, "\"file\"", "Save labels in VICE format", (*Monitor).saveLabels},
+		{[]string{"shl", "show_labels"}, "[filter]", "Show labels, optionally only those containing filter", (*Monitor).showLabels},
+		{[]string{"al", "add_label"}, "address .label", "Define a label", (*Monitor).addLabel},
+		{[]string{"dl", "delete_label"}, ".label", "Remove a label", (*Monitor).deleteLabel},
+		{[]string{"cl", "clear_labels"}, "", "Remove all labels and source lines", (*Monitor).clearLabels},
+	}
+}
+
+func (m *Monitor) help(args []string) error {
+	for _, c := range commands {
+		fmt.Fprintf(m.out, "%-16s %-22s %s\n", strings.Join(c.names, ", "), c.args, c.help)
+	}
+	return nil
+}
+
+func (m *Monitor) registers(args []string) error {
+	r := m.cpu.Registers()
+	for _, a := range args {
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected register=value, got %s", a)
+		}
+		if strings.ToLower(parts[0]) == "pc" {
+			pc, err := m.parseAddress(parts[1])
+			if err != nil {
+				return err
+			}
+			r.PC = pc
+			continue
+		}
+		v, err := parseByte(parts[1])
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(parts[0]) {
+		case "a":
+			r.A = v
+		case "x":
+			r.X = v
+		case "y":
+			r.Y = v
+		case "sp":
+			r.SP = v
+		case "fl":
+			r.Flags = v
+		default:
+			return fmt.Errorf("unknown register: %s", parts[0])
+		}
+	}
+	if len(args) > 0 {
+		m.cpu.SetRegisters(r)
+	}
+	fmt.Fprintf(m.out, "  ADDR A  X  Y  SP NV-BDIZC\n.;%04x %02x %02x %02x %02x %08b\n", r.PC, r.A, r.X, r.Y, r.SP, r.Flags)
+	return nil
+}
+
+// Parse an optional range. Without arguments, we continue where we left off. Without
+// an end, we show a default amount.
+func (m *Monitor) optionalRange(args []string, next uint16, length int) (uint16, uint16, error) {
+	start := next
+	if len(args) > 0 {
+		var err error
+		if start, err = m.parseAddress(args[0]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(args) > 1 {
+		return m.parseRange(args[0], args[1])
+	}
+	end := int(start) + length - 1
+	if end > 0xffff {
+		end = 0xffff
+	}
+	return start, uint16(end), nil
+}
+
+func (m *Monitor) dump(args []string) error {
+	start, end, err := m.optionalRange(args, m.nextDump, 0x80)
+	if err != nil {
+		return err
+	}
+	mem := m.memory()
+	for addr := int(start); addr <= int(end); addr += 16 {
+		var hex strings.Builder
+		var text strings.Builder
+		for i := addr; i < addr+16 && i <= int(end); i++ {
+			b := mem.ReadByte(uint16(i))
+			fmt.Fprintf(&hex, "%02x ", b)
+			if b >= 0x20 && b < 0x7f {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(m.out, ">C:%04x  %-48s %s\n", addr, hex.String(), text.String())
+	}
+	m.nextDump = end + 1
+	return nil
+}
+
+func (m *Monitor) disass(args []string) error {
+	start, end, err := m.optionalRange(args, m.nextDisasm, 0x20)
+	if err != nil {
+		return err
+	}
+	addr := start
+	for {
+		m.printSymbols(addr)
+		line, next := disassemble(m.memory(), addr, m.symbols)
+		fmt.Fprintln(m.out, line)
+		if next > end || next <= addr {
+			m.nextDisasm = next
+			return nil
+		}
+		addr = next
+	}
+}
+
+// Disassemble a single instruction. Operand addresses that have labels are shown as
+// labels. Returns the formatted line and the address of the next instruction.
+func disassemble(mem core.AddressSpace, addr uint16, table *symbols.Table) (string, uint16) {
+	inst := cpu.GetInstructionSet(cpu.NMOS).Lookup(mem.ReadByte(addr))
+	var hex strings.Builder
+	for i := uint16(0); i < uint16(inst.Length); i++ {
+		fmt.Fprintf(&hex, "%02x ", mem.ReadByte(addr+i))
+	}
+	operand := 0
+	switch inst.Length {
+	case 2:
+		operand = int(mem.ReadByte(addr + 1))
+	case 3:
+		operand = int(mem.ReadByte(addr+1)) | int(mem.ReadByte(addr+2))<<8
+	}
+	if inst.Mode == cpu.REL {
+		operand = int(addr) + 2 + int(int8(operand))
+	}
+	text := inst.Name
+	if format := core.OperandFormats[inst.Mode]; format != "" {
+		if label, ok := table.Label(uint16(operand)); ok && inst.Mode != cpu.IMM {
+			format = strings.Replace(strings.Replace(format, "$%04x", ".%s", 1), "$%02x", ".%s", 1)
+			text += " " + fmt.Sprintf(format, label)
+		} else {
+			text += " " + fmt.Sprintf(format, operand&0xffff)
+		}
+	}
+	return fmt.Sprintf(".C:%04x  %-9s  %s", addr, hex.String(), text), addr + uint16(inst.Length)
+}
+
+func (m *Monitor) saveDisassembly(args []string) error {
+	if len(args) < 3 {
+		return errors.New("usage: dsave \"file\" start end [entry ...]")
+	}
+	start, end, err := m.parseRange(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	d := disasm.NewFromMemory(m.memory(), start, end)
+	d.Symbols = m.symbols
+	for _, a := range args[3:] {
+		entry, err := m.parseAddress(a)
+		if err != nil {
+			return err
+		}
+		d.AddEntry(entry)
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	err = d.Write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (m *Monitor) assemble(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing address")
+	}
+	addr, err := m.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		if addr, err = m.assembleLine(addr, strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+	}
+
+	// Keep assembling until we get an empty line
+	for {
+		fmt.Fprintf(m.out, ".C:%04x  ", addr)
+		if !m.in.Scan() {
+			return nil
+		}
+		line := strings.TrimSpace(m.in.Text())
+		if line == "" {
+			return nil
+		}
+		next, err := m.assembleLine(addr, line)
+		if err != nil {
+			fmt.Fprintf(m.out, "%s\n", err)
+			continue
+		}
+		addr = next
+	}
+}
+
+func (m *Monitor) assembleLine(addr uint16, line string) (uint16, error) {
+	source := fmt.Sprintf("\t.ORG $%04x\n\t%s\n", addr, line)
+	code, _, err := asm.Assemble(strings.NewReader(source), "monitor", ioutil.Discard, 0)
+	if err != nil {
+		if len(code.Errors) > 0 {
+			return addr, errors.New(code.Errors[0])
+		}
+		return addr, err
+	}
+	for i, b := range code.Code {
+		m.memory().WriteByte(addr+uint16(i), b)
+	}
+	return addr + uint16(len(code.Code)), nil
+}
+
+func (m *Monitor) fill(args []string) error {
+	if len(args) < 3 {
+		return errors.New("usage: f start end data ...")
+	}
+	start, end, err := m.parseRange(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	data, err := parseBytes(args[2:])
+	if err != nil {
+		return err
+	}
+	for i := 0; i <= int(end-start); i++ {
+		m.memory().WriteByte(start+uint16(i), data[i%len(data)])
+	}
+	return nil
+}
+
+func (m *Monitor) compare(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: c start end dest")
+	}
+	start, end, err := m.parseRange(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	dest, err := m.parseAddress(args[2])
+	if err != nil {
+		return err
+	}
+	mem := m.memory()
+	for i := 0; i <= int(end-start); i++ {
+		a, b := mem.ReadByte(start+uint16(i)), mem.ReadByte(dest+uint16(i))
+		if a != b {
+			fmt.Fprintf(m.out, "$%04x $%04x: %02x %02x\n", start+uint16(i), dest+uint16(i), a, b)
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) hunt(args []string) error {
+	if len(args) < 3 {
+		return errors.New("usage: h start end data ...")
+	}
+	start, end, err := m.parseRange(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	data, err := parseBytes(args[2:])
+	if err != nil {
+		return err
+	}
+	mem := m.memory()
+	found := 0
+	for addr := int(start); addr+len(data)-1 <= int(end); addr++ {
+		match := true
+		for i, b := range data {
+			if mem.ReadByte(uint16(addr+i)) != b {
+				match = false
+				break
+			}
+		}
+		if match {
+			fmt.Fprintf(m.out, "%04x ", addr)
+			found++
+		}
+	}
+	if found > 0 {
+		fmt.Fprintln(m.out)
+	}
+	return nil
+}
+
+func (m *Monitor) transfer(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: t start end dest")
+	}
+	start, end, err := m.parseRange(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	dest, err := m.parseAddress(args[2])
+	if err != nil {
+		return err
+	}
+	// Read everything first so overlapping ranges are handled correctly
+	mem := m.memory()
+	data := m.readRange(start, end)
+	for i, b := range data {
+		mem.WriteByte(dest+uint16(i), b)
+	}
+	return nil
+}
+
+func (m *Monitor) readRange(start, end uint16) []uint8 {
+	data := make([]uint8, int(end-start)+1)
+	for i := range data {
+		data[i] = m.memory().ReadByte(start + uint16(i))
+	}
+	return data
+}
+
+func (m *Monitor) writeData(addr uint16, data []uint8) {
+	for i, b := range data {
+		m.memory().WriteByte(addr+uint16(i), b)
+	}
+	fmt.Fprintf(m.out, "Loaded $%04x-$%04x\n", addr, int(addr)+len(data)-1)
+}
+
+func (m *Monitor) load(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: l \"file\" [address]")
+	}
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if len(data) < 2 {
+		return errors.New("file is too short to be a PRG")
+	}
+	addr := uint16(data[0]) | uint16(data[1])<<8
+	if len(args) == 2 {
+		if addr, err = m.parseAddress(args[1]); err != nil {
+			return err
+		}
+	}
+	m.writeData(addr, data[2:])
+	return nil
+}
+
+func (m *Monitor) bload(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: bl \"file\" address")
+	}
+	addr, err := m.parseAddress(args[1])
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	m.writeData(addr, data)
+	return nil
+}
+
+func (m *Monitor) save(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: s \"file\" start end")
+	}
+	start, end, err := m.parseRange(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	data := append([]uint8{uint8(start), uint8(start >> 8)}, m.readRange(start, end)...)
+	return ioutil.WriteFile(args[0], data, 0644)
+}
+
+func (m *Monitor) bsave(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: bs \"file\" start end")
+	}
+	start, end, err := m.parseRange(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(args[0], m.readRange(start, end), 0644)
+}
+
+func parseCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := parseNumber(args[0], 1<<31)
+	return int(n), err
+}
+
+func (m *Monitor) stepCmd(args []string) error {
+	count, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		ok := m.step()
+		m.printStep()
+		if !ok {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) next(args []string) error {
+	count, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		r := m.cpu.Registers()
+		var ok bool
+		if m.cpuMemory().ReadByte(r.PC) == core.JSR_A {
+			// Run until the subroutine returns to the instruction after the JSR
+			ret := r.PC + 3
+			ok = m.runUntil(func() bool {
+				now := m.cpu.Registers()
+				return now.PC == ret && now.SP >= r.SP
+			})
+		} else {
+			ok = m.step()
+		}
+		m.printStep()
+		if !ok {
+			break
+		}
+	}
+	return nil
+}
+
+var errNoVideo = errors.New("no video chip to follow the beam")
+
+// Run to a beam position count times, showing where we are each time.
+func (m *Monitor) stepBeam(args []string, done func(b vic_ii.Beam) bool) error {
+	if m.video == nil {
+		return errNoVideo
+	}
+	count, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		ok, err := m.runBeam(done)
+		if err != nil {
+			return err
+		}
+		m.printBeam()
+		if !ok {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) halfStep(args []string) error {
+	return m.stepBeam(args, func(b vic_ii.Beam) bool { return true })
+}
+
+func (m *Monitor) cycleStep(args []string) error {
+	return m.stepBeam(args, func(b vic_ii.Beam) bool { return b.Phase == 1 })
+}
+
+func (m *Monitor) nextLine(args []string) error {
+	return m.stepBeam(args, func(b vic_ii.Beam) bool { return b.Cycle == 0 && b.Phase == 1 })
+}
+
+func (m *Monitor) nextFrame(args []string) error {
+	return m.stepBeam(args, vic_ii.Beam.AtFrameStart)
+}
+
+func (m *Monitor) runToLine(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("expected line and optional cycle")
+	}
+	line, err := parseNumber(args[0], 0x1ff)
+	if err != nil {
+		return err
+	}
+	var cycle uint64
+	if len(args) > 1 {
+		if cycle, err = parseNumber(args[1], 0xff); err != nil {
+			return err
+		}
+	}
+	return m.stepBeam(nil, func(b vic_ii.Beam) bool {
+		return uint64(b.RasterLine) == line && uint64(b.Cycle) == cycle && b.Phase == 1
+	})
+}
+
+func (m *Monitor) beam(args []string) error {
+	if m.video == nil {
+		return errNoVideo
+	}
+	m.printBeam()
+	return nil
+}
+
+func (m *Monitor) palette(args []string) error {
+	colors, ok := m.video.(Colors)
+	if !ok {
+		return errors.New("no video chip with a palette")
+	}
+	switch {
+	case len(args) == 0:
+		fmt.Fprintf(m.out, "%s\n", strings.Join(vic_ii.PaletteNames(), " "))
+		return nil
+	case len(args) == 2 && args[0] == "save":
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		err = vic_ii.WriteVPL(f, colors.Palette())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	case len(args) == 1:
+		palette, err := vic_ii.LoadPalette(args[0])
+		if err != nil {
+			return err
+		}
+		colors.SetPalette(palette)
+		return nil
+	}
+	return errors.New("usage: palette [name | \"file\" | generate[:b,c,s,g] | save \"file\"]")
+}
+
+func (m *Monitor) view(args []string) error {
+	viewer, ok := m.video.(Viewer)
+	if !ok {
+		return errors.New("no video chip to view")
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: view %s \"file\"", strings.Join(vic_ii.ViewNames, "|"))
+	}
+	img, err := viewer.View(args[0])
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, img)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (m *Monitor) goCmd(args []string) error {
+	if len(args) > 0 {
+		addr, err := m.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		r := m.cpu.Registers()
+		r.PC = addr
+		m.cpu.SetRegisters(r)
+	}
+	m.resume = true
+	return nil
+}
+
+func (m *Monitor) exit(args []string) error {
+	m.resume = true
+	return nil
+}
+
+func (m *Monitor) selectBank(args []string) error {
+	if len(args) == 0 {
+		for _, name := range m.bankNames() {
+			marker := " "
+			if name == m.bank {
+				marker = "*"
+			}
+			fmt.Fprintf(m.out, "%s%s\n", marker, name)
+		}
+		return nil
+	}
+	name := strings.ToLower(args[0])
+	if _, ok := m.banks[name]; !ok {
+		return fmt.Errorf("unknown bank: %s", args[0])
+	}
+	m.bank = name
+	return nil
+}
+
+func (m *Monitor) listBreakpoints() {
+	for _, bp := range m.debugger.Breakpoints() {
+		fmt.Fprintln(m.out, bp)
+	}
+}
+
+// Parse "[start [end]] [if condition]" and add a breakpoint of the given kind.
+func (m *Monitor) addBreakpoint(kind core.BreakKind, args []string) error {
+	var condition *core.Condition
+	for i, a := range args {
+		if strings.ToLower(a) == "if" {
+			var err error
+			if condition, err = core.ParseCondition(strings.Join(args[i+1:], " ")); err != nil {
+				return err
+			}
+			args = args[:i]
+			break
+		}
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("expected an address or a range")
+	}
+	start, err := m.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	end := start
+	if len(args) == 2 {
+		if start, end, err = m.parseRange(args[0], args[1]); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(m.out, m.debugger.Add(kind, start, end, condition))
+	return nil
+}
+
+func (m *Monitor) setBreakpoint(args []string) error {
+	if len(args) == 0 {
+		m.listBreakpoints()
+		return nil
+	}
+	return m.addBreakpoint(core.BreakExec, args)
+}
+
+func (m *Monitor) setWatchpoint(args []string) error {
+	if len(args) == 0 {
+		m.listBreakpoints()
+		return nil
+	}
+	kind := core.BreakRead | core.BreakWrite
+	switch strings.ToLower(args[0]) {
+	case "load":
+		kind = core.BreakRead
+		args = args[1:]
+	case "store":
+		kind = core.BreakWrite
+		args = args[1:]
+	}
+	return m.addBreakpoint(kind, args)
+}
+
+func parseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid breakpoint number: %s", s)
+	}
+	return id, nil
+}
+
+// Look up the breakpoint given by the first argument.
+func (m *Monitor) breakpointArg(args []string) (*core.Breakpoint, error) {
+	if len(args) == 0 {
+		return nil, errors.New("missing breakpoint number")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	bp := m.debugger.Get(id)
+	if bp == nil {
+		return nil, fmt.Errorf("no such breakpoint: %d", id)
+	}
+	return bp, nil
+}
+
+func (m *Monitor) deleteBreakpoint(args []string) error {
+	if len(args) == 0 {
+		m.debugger.RemoveAll()
+		return nil
+	}
+	bp, err := m.breakpointArg(args)
+	if err != nil {
+		return err
+	}
+	m.debugger.Remove(bp.ID)
+	return nil
+}
+
+func (m *Monitor) enableBreakpoint(args []string) error {
+	bp, err := m.breakpointArg(args)
+	if err != nil {
+		return err
+	}
+	m.debugger.Enable(bp.ID, true)
+	return nil
+}
+
+func (m *Monitor) disableBreakpoint(args []string) error {
+	bp, err := m.breakpointArg(args)
+	if err != nil {
+		return err
+	}
+	m.debugger.Enable(bp.ID, false)
+	return nil
+}
+
+func (m *Monitor) setCondition(args []string) error {
+	bp, err := m.breakpointArg(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		bp.Condition = nil
+		return nil
+	}
+	condition, err := core.ParseCondition(strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+	bp.Condition = condition
+	return nil
+}
+
+func (m *Monitor) ignoreBreakpoint(args []string) error {
+	bp, err := m.breakpointArg(args)
+	if err != nil {
+		return err
+	}
+	count := 1
+	if len(args) > 1 {
+		if count, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid count: %s", args[1])
+		}
+	}
+	// Ignore the next count hits
+	bp.IgnoreCount = bp.Hits + count
+	return nil
+}
+
+// Traced instructions shown unless a count is given
+const defaultTraceCount = 20
+
+func (m *Monitor) trace(args []string) error {
+	tracer := m.cpu.Tracer()
+	if tracer == nil {
+		return errors.New("tracing is off")
+	}
+	if len(args) > 0 && args[0] == "save" {
+		if len(args) != 2 {
+			return errors.New("usage: trace save \"file\"")
+		}
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		err = tracer.WriteText(f, tracer.Len())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	count := defaultTraceCount
+	if len(args) > 0 {
+		n, err := parseNumber(args[0], 1<<31)
+		if err != nil {
+			return err
+		}
+		count = int(n)
+	}
+	return tracer.WriteText(m.out, count)
+}
+
+// Profile entries shown unless a count is given
+const defaultProfileCount = 20
+
+func (m *Monitor) profile(args []string) error {
+	p := m.cpu.Profiler()
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			if p == nil {
+				m.cpu.SetProfiler(core.NewProfiler())
+			}
+			return nil
+		case "off":
+			m.cpu.SetProfiler(nil)
+			return nil
+		}
+	}
+	if p == nil {
+		return errors.New("profiling is off")
+	}
+	if len(args) > 0 && args[0] == "clear" {
+		p.Clear()
+		return nil
+	}
+	if len(args) > 0 && args[0] == "save" {
+		if len(args) != 2 {
+			return errors.New("usage: profile save \"file\"")
+		}
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		err = profiler.WritePprof(f, p, m.symbols)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	count := defaultProfileCount
+	if len(args) > 0 {
+		n, err := parseNumber(args[0], 1<<31)
+		if err != nil {
+			return err
+		}
+		count = int(n)
+	}
+	return profiler.WriteReport(m.out, p, m.symbols, count)
+}
+
+func (m *Monitor) heatmap(args []string) error {
+	accesses := m.cpu.AccessMap()
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			if accesses == nil {
+				m.cpu.SetAccessMap(core.NewAccessMap())
+			}
+			return nil
+		case "off":
+			m.cpu.SetAccessMap(nil)
+			return nil
+		}
+	}
+	if accesses == nil {
+		return errors.New("access counting is off")
+	}
+	switch {
+	case len(args) == 0:
+		fmt.Fprintln(m.out, "access counting is on")
+		return nil
+	case args[0] == "clear":
+		accesses.Clear()
+		return nil
+	case args[0] == "save" && len(args) == 2:
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		err = png.Encode(f, accesses.Image())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return errors.New("usage: heatmap [on | off | clear | save \"file\"]")
+}
+
+func (m *Monitor) coverage(args []string) error {
+	accesses := m.cpu.AccessMap()
+	if accesses == nil {
+		return errors.New("access counting is off (try heatmap on)")
+	}
+	if len(args) == 0 {
+		return accesses.WriteCoverage(m.out)
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	err = accesses.WriteCoverage(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (m *Monitor) loadLabels(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: ll \"file\"")
+	}
+	return m.symbols.Load(args[0])
+}
+
+func (m *Monitor) saveLabels(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: sl \"file\"")
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	err = m.symbols.WriteVICELabels(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (m *Monitor) showLabels(args []string) error {
+	for _, name := range m.symbols.Names() {
+		if len(args) > 0 && !strings.Contains(strings.ToLower(name), strings.ToLower(args[0])) {
+			continue
+		}
+		addr, _ := m.symbols.Lookup(name)
+		fmt.Fprintf(m.out, "$%04x .%s\n", addr, name)
+	}
+	return nil
+}
+
+func (m *Monitor) addLabel(args []string) error {
+	if len(args) != 2 || !strings.HasPrefix(args[1], ".") || len(args[1]) < 2 {
+		return errors.New("usage: al address .label")
+	}
+	addr, err := m.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	m.symbols.AddLabel(args[1][1:], addr)
+	return nil
+}
+
+func (m *Monitor) deleteLabel(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: dl .label")
+	}
+	name := strings.TrimPrefix(args[0], ".")
+	if !m.symbols.RemoveLabel(name) {
+		return fmt.Errorf("unknown label: %s", name)
+	}
+	return nil
+}
+
+func (m *Monitor) clearLabels(args []string) error {
+	m.symbols.Clear()
+	return nil
+}
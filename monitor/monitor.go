@@ -0,0 +1,386 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package monitor implements an interactive machine language monitor modeled after
+// the one in VICE. It runs in the emulation goroutine, so the machine is paused
+// while the monitor is active.
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/symbols"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type command struct {
+	names []string
+	args  string
+	help  string
+	run   func(m *Monitor, args []string) error
+}
+
+type Monitor struct {
+	cpu   *core.CPU
+	clock func()
+	banks map[string]core.AddressSpace
+	bank  string
+	in    *bufio.Scanner
+	out   io.Writer
+
+	nextDump   uint16 // Where "m" without arguments continues
+	nextDisasm uint16 // Where "d" without arguments continues
+	debugger   *core.Debugger
+	symbols    *symbols.Table
+	video      Video // Only set if the beam can be followed
+	resume     bool  // Set by commands that leave the monitor
+}
+
+// Video is implemented by video chips that know where the raster beam is, i.e. the
+// VIC-II. It lets the monitor step the machine by cycles and raster lines.
+type Video interface {
+	Beam() vic_ii.Beam
+}
+
+// Colors is implemented by video chips whose palette can be changed, i.e. the VIC-II.
+type Colors interface {
+	Palette() []color.RGBA
+	SetPalette(palette []color.RGBA)
+}
+
+// Viewer is implemented by video chips that can draw the graphics in their memory,
+// i.e. the VIC-II.
+type Viewer interface {
+	View(name string) (*image.RGBA, error)
+}
+
+// New creates a monitor for a CPU. The clock function advances the entire machine by
+// half a cycle, like Commodore64.Clock. Stepping by instructions also works with a
+// clock that runs whole cycles, but the commands enabled by SetVideo don't. The banks
+// map names to views of memory. The first bank in alphabetical order is selected,
+// unless there's one called core.BankCPU. Breakpoints are managed through the debugger.
+func New(cpu *core.CPU, debugger *core.Debugger, clock func(), banks map[string]core.AddressSpace, in io.Reader, out io.Writer) *Monitor {
+	m := &Monitor{
+		cpu:      cpu,
+		clock:    clock,
+		banks:    banks,
+		in:       bufio.NewScanner(in),
+		out:      out,
+		debugger: debugger,
+		symbols:  symbols.New(),
+	}
+	if _, ok := banks[core.BankCPU]; ok {
+		m.bank = core.BankCPU
+	} else {
+		m.bank = m.bankNames()[0]
+	}
+	return m
+}
+
+// SetSymbols replaces the labels and source lines used to show and parse addresses.
+func (m *Monitor) SetSymbols(table *symbols.Table) {
+	m.symbols = table
+}
+
+// Symbols returns the labels and source lines known to the monitor.
+func (m *Monitor) Symbols() *symbols.Table {
+	return m.symbols
+}
+
+// SetVideo enables the commands that step by cycles and raster lines.
+func (m *Monitor) SetVideo(video Video) {
+	m.video = video
+}
+
+// Enter runs the monitor until the user resumes execution. If we're in the middle
+// of an instruction, e.g. because a watchpoint was hit, it's completed first.
+// Returns false if the input was closed.
+func (m *Monitor) Enter() bool {
+	core.FinishInstruction(m.cpu, m.clock)
+	m.reportStop()
+	m.nextDisasm = m.cpu.Registers().PC
+	m.printStep()
+	m.resume = false
+	for !m.resume {
+		fmt.Fprintf(m.out, "(%s:$%04x) ", m.bank, m.cpu.Registers().PC)
+		if !m.in.Scan() {
+			return false
+		}
+		if err := m.Execute(m.in.Text()); err != nil {
+			fmt.Fprintf(m.out, "%s\n", err)
+		}
+	}
+	return true
+}
+
+// Execute runs a single command line.
+func (m *Monitor) Execute(line string) error {
+	// Looking at memory must not trigger watchpoints
+	m.debugger.Mute()
+	defer m.debugger.Unmute()
+	args, err := tokenize(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	name := strings.ToLower(args[0])
+	for i := range commands {
+		for _, n := range commands[i].names {
+			if n == name {
+				return commands[i].run(m, args[1:])
+			}
+		}
+	}
+	return fmt.Errorf("unknown command: %s (try help)", args[0])
+}
+
+// Reads through the monitor have no side effects, so looking at e.g. the interrupt
+// registers doesn't acknowledge any interrupts.
+func (m *Monitor) memory() core.AddressSpace {
+	return core.PeekSpace{AddressSpace: m.banks[m.bank]}
+}
+
+// Memory as seen by the CPU, regardless of the selected bank
+func (m *Monitor) cpuMemory() core.AddressSpace {
+	if mem, ok := m.banks[core.BankCPU]; ok {
+		return core.PeekSpace{AddressSpace: mem}
+	}
+	return m.memory()
+}
+
+func (m *Monitor) bankNames() []string {
+	names := make([]string, 0, len(m.banks))
+	for name := range m.banks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Step a single instruction, including any interrupt sequence that's dispatched
+// instead of it.
+// Returns false if we hit a breakpoint.
+func (m *Monitor) step() bool {
+	m.debugger.Unmute()
+	defer m.debugger.Mute()
+	core.StepInstruction(m.cpu, m.clock)
+	return !m.reportStop()
+}
+
+// Run until done returns true at an instruction boundary. Returns false if we hit a
+// breakpoint first.
+func (m *Monitor) runUntil(done func() bool) bool {
+	for {
+		if !m.step() {
+			return false
+		}
+		if done() {
+			return true
+		}
+	}
+}
+
+// Run half a cycle at a time until done returns true for the beam position. Returns
+// false if we hit a breakpoint first. Gives up once the beam has passed the start of
+// a frame twice, since the position may not exist.
+func (m *Monitor) runBeam(done func(b vic_ii.Beam) bool) (bool, error) {
+	m.debugger.Unmute()
+	defer m.debugger.Mute()
+	frames := 0
+	for {
+		m.clock()
+		if m.reportStop() {
+			return false, nil
+		}
+		b := m.video.Beam()
+		if done(b) {
+			return true, nil
+		}
+		if b.AtFrameStart() {
+			if frames++; frames == 2 {
+				return false, errors.New("the beam never got there")
+			}
+		}
+	}
+}
+
+// Print the reason we stopped, if any. Returns true if we had stopped.
+func (m *Monitor) reportStop() bool {
+	stop := m.debugger.StopEvent()
+	if stop == nil {
+		return false
+	}
+	if stop.Kind == core.BreakExec {
+		fmt.Fprintf(m.out, "#%d (Stop on exec $%04x)\n", stop.Breakpoint.ID, stop.Address)
+	} else {
+		fmt.Fprintf(m.out, "#%d (Stop on %s $%04x = $%02x)\n", stop.Breakpoint.ID, stop.Kind, stop.Address, stop.Data)
+	}
+	m.debugger.Resume()
+	return true
+}
+
+func (m *Monitor) printStep() {
+	r := m.cpu.Registers()
+	m.printSymbols(r.PC)
+	line, _ := disassemble(m.cpuMemory(), r.PC, m.symbols)
+	m.printRegisters(line)
+}
+
+// Print the beam position and the state of the CPU. The next instruction is only
+// shown if we're between instructions.
+func (m *Monitor) printBeam() {
+	fmt.Fprintln(m.out, m.video.Beam())
+	if m.cpu.AtInstructionBoundary() {
+		m.printStep()
+	} else {
+		m.printRegisters(fmt.Sprintf(".C:%04x  (in instruction)", m.cpu.Registers().PC))
+	}
+}
+
+func (m *Monitor) printRegisters(line string) {
+	r := m.cpu.Registers()
+	fmt.Fprintf(m.out, "%-36s - A:%02x X:%02x Y:%02x SP:%02x %s\n", line, r.A, r.X, r.Y, r.SP, flagString(r.Flags))
+}
+
+// Print the labels at an address and the source line that generated it, if known.
+func (m *Monitor) printSymbols(addr uint16) {
+	for _, name := range m.symbols.Labels(addr) {
+		fmt.Fprintf(m.out, ".%s:\n", name)
+	}
+	if l, ok := m.symbols.Line(addr); ok {
+		text, _ := m.symbols.Source(l)
+		fmt.Fprintf(m.out, "%s  %s\n", l, strings.TrimSpace(text))
+	}
+}
+
+func flagString(flags uint8) string {
+	s := []byte("NV-BDIZC")
+	for i := range s {
+		if flags&(0x80>>i) == 0 && s[i] != '-' {
+			s[i] = '.'
+		}
+	}
+	return string(s)
+}
+
+// Split a command line into arguments. Arguments can be quoted to include spaces.
+func tokenize(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			args = append(args, line[1:end+1])
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		args = append(args, line[:end])
+		line = line[end:]
+	}
+}
+
+// Numbers are hexadecimal by default, like in VICE. A $ prefix is allowed and a +
+// prefix makes the number decimal.
+func parseNumber(s string, max uint64) (uint64, error) {
+	base := 16
+	if strings.HasPrefix(s, "+") {
+		base = 10
+		s = s[1:]
+	} else {
+		s = strings.TrimPrefix(s, "$")
+	}
+	n, err := strconv.ParseUint(s, base, 64)
+	if err != nil || n > max {
+		return 0, fmt.Errorf("invalid number: %s", s)
+	}
+	return n, nil
+}
+
+// Addresses can also be given as labels or file:line. Labels that look like numbers
+// need a leading dot, like in VICE.
+func (m *Monitor) parseAddress(s string) (uint16, error) {
+	if strings.HasPrefix(s, ".") || strings.Contains(s, ":") {
+		return m.symbols.Resolve(s)
+	}
+	n, err := parseNumber(s, 0xffff)
+	if err != nil {
+		if addr, ok := m.symbols.Lookup(s); ok {
+			return addr, nil
+		}
+	}
+	return uint16(n), err
+}
+
+func parseByte(s string) (uint8, error) {
+	n, err := parseNumber(s, 0xff)
+	return uint8(n), err
+}
+
+// Parse a start and end address, where the end is inclusive and must not come
+// before the start.
+func (m *Monitor) parseRange(start, end string) (uint16, uint16, error) {
+	s, err := m.parseAddress(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	e, err := m.parseAddress(end)
+	if err != nil {
+		return 0, 0, err
+	}
+	if e < s {
+		return 0, 0, errors.New("end of range must not come before start")
+	}
+	return s, e, nil
+}
+
+func parseBytes(args []string) ([]uint8, error) {
+	if len(args) == 0 {
+		return nil, errors.New("missing data")
+	}
+	data := make([]uint8, len(args))
+	for i, a := range args {
+		var err error
+		if data[i], err = parseByte(a); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
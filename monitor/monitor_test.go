@@ -0,0 +1,407 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/prydin/emu6502/core"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestMonitor(input string) (*Monitor, *core.CPU, *bytes.Buffer) {
+	m, cpu, _, out := newTestMonitorWithDebugger(input)
+	return m, cpu, out
+}
+
+func newTestMonitorWithDebugger(input string) (*Monitor, *core.CPU, *core.Debugger, *bytes.Buffer) {
+	bus := &core.Bus{}
+	bus.Connect(core.MakeRAM(0xffff), 0x0000, 0xffff)
+	cpu := &core.CPU{}
+	cpu.Init(bus)
+	bus.ConnectClockablePh1(cpu)
+	cpu.SetRegisters(core.Registers{PC: 0x1000, SP: 0xff})
+	out := &bytes.Buffer{}
+	debugger := core.NewDebugger(cpu, bus)
+	m := New(cpu, debugger, bus.ClockPh1, map[string]core.AddressSpace{core.BankCPU: bus}, strings.NewReader(input), out)
+	return m, cpu, debugger, out
+}
+
+func TestMonitor_AssembleAndStep(t *testing.T) {
+	m, cpu, out := newTestMonitor("jsr $2000\nbrk\n\n")
+	require.NoError(t, m.Execute("a 1000 ldx #$03"))
+	require.NoError(t, m.Execute("a 2000 inx"))
+	require.NoError(t, m.Execute("a 2001 rts"))
+	require.Equal(t, ".C:1002  .C:1005  .C:1006  .C:2001  .C:2002  ", out.String())
+
+	out.Reset()
+	require.NoError(t, m.Execute("d 1000 1002"))
+	require.Equal(t, ".C:1000  a2 03      LDX #$03\n.C:1002  20 00 20   JSR $2000\n", out.String())
+
+	// Stepping into the subroutine, then over it
+	require.NoError(t, m.Execute("z 2"))
+	require.Equal(t, uint16(0x2000), cpu.Registers().PC)
+	require.NoError(t, m.Execute("r pc=1002 x=10 sp=ff"))
+	require.NoError(t, m.Execute("n"))
+	require.Equal(t, core.Registers{PC: 0x1005, SP: 0xff, X: 0x11}, cpu.Registers())
+}
+
+func TestMonitor_Memory(t *testing.T) {
+	m, _, out := newTestMonitor("")
+	require.NoError(t, m.Execute("f 2000 2007 41 42"))
+	require.NoError(t, m.Execute("t 2000 2007 2004"))
+	require.NoError(t, m.Execute("m 2000 200b"))
+	require.Equal(t, ">C:2000  41 42 41 42 41 42 41 42 41 42 41 42              ABABABABABAB\n", out.String())
+
+	out.Reset()
+	require.NoError(t, m.Execute("h 2000 200f 42 41"))
+	require.Equal(t, "2001 2003 2005 2007 2009 \n", out.String())
+
+	out.Reset()
+	require.NoError(t, m.Execute("c 2000 2003 2001"))
+	require.Equal(t, "$2000 $2001: 41 42\n$2001 $2002: 42 41\n$2002 $2003: 41 42\n$2003 $2004: 42 41\n", out.String())
+
+	require.Error(t, m.Execute("f 2000 1000 00"))
+	require.Error(t, m.Execute("m 10000"))
+	require.Error(t, m.Execute("bogus"))
+}
+
+func TestMonitor_LoadSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test file.prg")
+
+	m, _, _ := newTestMonitor("")
+	require.NoError(t, m.Execute("f c000 c003 01 02 03 04"))
+	require.NoError(t, m.Execute(`s "`+file+`" c000 c003`))
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0xc0, 1, 2, 3, 4}, data)
+
+	require.NoError(t, m.Execute(`l "`+file+`" 3000`))
+	require.NoError(t, m.Execute(`bl "`+file+`" 4000`))
+	require.Equal(t, uint8(4), m.memory().ReadByte(0x3003))
+	require.Equal(t, uint8(0xc0), m.memory().ReadByte(0x4001))
+}
+
+func TestMonitor_Breakpoints(t *testing.T) {
+	m, cpu, debugger, out := newTestMonitorWithDebugger("inx\nstx $2000\njmp $1000\n\nbk 1002\ng\n")
+	require.NoError(t, m.Execute("a 1000 nop"))
+	require.True(t, m.Enter())
+	require.Contains(t, out.String(), "(cpu:$1000) ")
+	for !debugger.Stopped() {
+		m.clock()
+	}
+	require.Equal(t, uint16(0x1002), cpu.Registers().PC)
+
+	// Entering the monitor reports the stop and clears it
+	m.in = bufio.NewScanner(strings.NewReader("x\n"))
+	require.True(t, m.Enter())
+	require.Contains(t, out.String(), "#1 (Stop on exec $1002)")
+	require.False(t, debugger.Stopped())
+	require.False(t, m.Enter(), "Input should be closed")
+
+	// Stepping stops on watchpoints. The store is done at the end of the instruction
+	out.Reset()
+	require.NoError(t, m.Execute("del"))
+	require.NoError(t, m.Execute("watch store 2000 if x == 3"))
+	require.NoError(t, m.Execute("z 20"))
+	require.Contains(t, out.String(), "#2 (Stop on store $2000 = $03)")
+	require.Equal(t, uint16(0x1005), cpu.Registers().PC)
+
+	// Ignore the next hit
+	out.Reset()
+	require.NoError(t, m.Execute("cond 2 x > 3"))
+	require.NoError(t, m.Execute("ignore 2"))
+	require.NoError(t, m.Execute("z 20"))
+	require.Contains(t, out.String(), "#2 (Stop on store $2000 = $05)")
+	require.NoError(t, m.Execute("bk"))
+	require.Contains(t, out.String(), "#2 (store) $2000 if x > 3 ignore 2 hits 3")
+
+	require.Error(t, m.Execute("watch load 2000 if a =="))
+	require.Error(t, m.Execute("disable 42"))
+}
+
+func TestMonitor_Trace(t *testing.T) {
+	m, cpu, out := newTestMonitor("")
+	require.Error(t, m.Execute("trace"), "Tracing should be off")
+	cpu.SetTracer(core.NewTracer(100))
+	require.NoError(t, m.Execute("a 1000 ldx #$03"))
+	require.NoError(t, m.Execute("a 1002 inx"))
+	require.NoError(t, m.Execute("z 2"))
+	out.Reset()
+	require.NoError(t, m.Execute("tr 1"))
+	require.True(t, strings.HasPrefix(out.String(), "1002  E8        INX "), out.String())
+}
+
+func TestMonitor_Symbols(t *testing.T) {
+	m, cpu, out := newTestMonitor("")
+	require.NoError(t, m.Execute("a 1000 jsr $1010"))
+	require.NoError(t, m.Execute("a 1010 inx"))
+	require.NoError(t, m.Execute("al 1010 .count"))
+	m.Symbols().AddLine(0x1010, "test.s", 12)
+	out.Reset()
+	require.NoError(t, m.Execute("d 1000 1000"))
+	require.Equal(t, ".C:1000  20 10 10   JSR .count\n", out.String())
+	out.Reset()
+	require.NoError(t, m.Execute("d .count 1010"))
+	require.Equal(t, ".count:\ntest.s:12  \n.C:1010  e8         INX\n", out.String())
+
+	// Breakpoints by label and source line
+	require.NoError(t, m.Execute("bk count"))
+	require.NoError(t, m.Execute("bk test.s:12"))
+	require.Error(t, m.Execute("bk test.s:13"))
+	require.Error(t, m.Execute("bk .nothing"))
+	out.Reset()
+	require.NoError(t, m.Execute("z"))
+	require.Contains(t, out.String(), "#1 (Stop on exec $1010)")
+	require.Equal(t, uint16(0x1010), cpu.Registers().PC)
+
+	require.NoError(t, m.Execute("dl .count"))
+	require.Error(t, m.Execute("bk count"))
+	require.NoError(t, m.Execute("cl"))
+	require.Error(t, m.Execute("bk test.s:12"))
+}
+
+func TestMonitor_Profile(t *testing.T) {
+	m, _, out := newTestMonitor("")
+	require.Error(t, m.Execute("profile"), "Profiling should be off")
+	require.NoError(t, m.Execute("profile on"))
+	require.NoError(t, m.Execute("a 1000 inx"))
+	require.NoError(t, m.Execute("a 1001 jmp $1000"))
+	require.NoError(t, m.Execute("z 10"))
+	out.Reset()
+	require.NoError(t, m.Execute("prof 2"))
+	require.Contains(t, out.String(), "Total: ")
+	require.Contains(t, out.String(), "$1001")
+
+	dir, err := ioutil.TempDir("", "monitor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "c64.pb.gz")
+	require.NoError(t, m.Execute("profile save \""+file+"\""))
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.NotZero(t, info.Size())
+	require.NoError(t, m.Execute("profile off"))
+	require.Error(t, m.Execute("profile clear"))
+}
+
+func TestMonitor_Heatmap(t *testing.T) {
+	m, _, out := newTestMonitor("")
+	require.Error(t, m.Execute("coverage"), "Access counting should be off")
+	require.NoError(t, m.Execute("hm on"))
+	require.NoError(t, m.Execute("a 1000 inc $2000"))
+	require.NoError(t, m.Execute("a 1003 jmp $1000"))
+	require.NoError(t, m.Execute("z 4"))
+	out.Reset()
+	require.NoError(t, m.Execute("cov"))
+	require.Equal(t, "0 1000-1005 code\n0 2000-2000 data\n", out.String())
+
+	dir, err := ioutil.TempDir("", "monitor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "heatmap.png")
+	require.NoError(t, m.Execute("heatmap save \""+file+"\""))
+	_, err = os.Stat(file)
+	require.NoError(t, err)
+	require.NoError(t, m.Execute("hm off"))
+	require.Error(t, m.Execute("hm clear"))
+}
+
+func TestMonitor_SaveDisassembly(t *testing.T) {
+	m, _, _ := newTestMonitor("")
+	require.NoError(t, m.Execute("a 1000 jsr $ffd2"))
+	require.NoError(t, m.Execute("a 1003 rts"))
+	dir, err := ioutil.TempDir("", "monitor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "out.asm")
+	require.NoError(t, m.Execute("dsave \""+file+"\" 1000 1003"))
+	source, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.Contains(t, string(source), "    JSR CHROUT\n    RTS\n")
+}
+
+// A video chip with four lines of eight cycles that runs the CPU in the first half
+// of each cycle.
+type testVideo struct {
+	bus        *core.Bus
+	halfCycles int
+}
+
+func (v *testVideo) clock() {
+	if v.halfCycles%2 == 0 {
+		v.bus.ClockPh1()
+	}
+	v.halfCycles = (v.halfCycles + 1) % 64
+}
+
+func (v *testVideo) Beam() vic_ii.Beam {
+	cycle := v.halfCycles / 2
+	return vic_ii.Beam{RasterLine: uint16(cycle / 8), Cycle: uint16(cycle % 8), Phase: uint8(v.halfCycles%2 + 1), RDY: true, IRQ: true, NMI: true}
+}
+
+func TestMonitor_Beam(t *testing.T) {
+	m, _, out := newTestMonitor("")
+	require.Equal(t, errNoVideo, m.Execute("hs"))
+	video := &testVideo{bus: m.banks[core.BankCPU].(*core.Bus)}
+	m.clock = video.clock
+	m.SetVideo(video)
+	require.NoError(t, m.Execute("a 1000 lda #$01"))
+	require.NoError(t, m.Execute("a 1002 jmp $1000"))
+
+	out.Reset()
+	require.NoError(t, m.Execute("hs"))
+	require.Equal(t, "LINE:000 (0) CYCLE:00.2 BADLINE:0 RDY:1 IRQ:1 NMI:1\n"+
+		".C:1001  (in instruction)            - A:00 X:00 Y:00 SP:ff ..-.....\n", out.String())
+	out.Reset()
+	require.NoError(t, m.Execute("cs"))
+	require.Contains(t, out.String(), "CYCLE:01.1")
+	out.Reset()
+	require.NoError(t, m.Execute("cs"))
+	require.Equal(t, "LINE:000 (0) CYCLE:02.1 BADLINE:0 RDY:1 IRQ:1 NMI:1\n"+
+		".C:1002  4c 00 10   JMP $1000        - A:01 X:00 Y:00 SP:ff ..-.....\n", out.String())
+
+	out.Reset()
+	require.NoError(t, m.Execute("cs 2"))
+	require.Equal(t, 4, strings.Count(out.String(), "\n"))
+	require.NoError(t, m.Execute("nl"))
+	require.Equal(t, vic_ii.Beam{RasterLine: 1, Phase: 1, RDY: true, IRQ: true, NMI: true}, video.Beam())
+	require.NoError(t, m.Execute("rl 3 5"))
+	require.Equal(t, 3*16+5*2, video.halfCycles)
+	require.NoError(t, m.Execute("nf"))
+	require.True(t, video.Beam().AtFrameStart())
+	require.Error(t, m.Execute("rl 4"), "Line out of range for the chip")
+	require.Error(t, m.Execute("rl 200"), "Line out of range for any chip")
+
+	// Breakpoints stop us on the way
+	require.NoError(t, m.Execute("bk 1002"))
+	out.Reset()
+	require.NoError(t, m.Execute("nf"))
+	require.Contains(t, out.String(), "(Stop on exec $1002)")
+	require.False(t, video.Beam().AtFrameStart())
+}
+
+type testColors struct {
+	testVideo
+	palette []color.RGBA
+}
+
+func (v *testColors) Palette() []color.RGBA {
+	return v.palette
+}
+
+func (v *testColors) SetPalette(palette []color.RGBA) {
+	v.palette = palette
+}
+
+func TestMonitor_Palette(t *testing.T) {
+	m, _, out := newTestMonitor("")
+	require.Error(t, m.Execute("palette"))
+	video := &testColors{palette: vic_ii.C64Colors}
+	m.SetVideo(video)
+	require.NoError(t, m.Execute("palette"))
+	require.Equal(t, "c64wiki colodore pepto vice\n", out.String())
+	require.NoError(t, m.Execute("pal vice"))
+	require.Equal(t, vic_ii.Palettes["vice"], video.palette)
+	require.NoError(t, m.Execute("pal generate"))
+	require.Equal(t, vic_ii.Palettes["colodore"], video.palette)
+	require.Error(t, m.Execute("pal nonexistent"))
+
+	name := filepath.Join(t.TempDir(), "test.vpl")
+	require.NoError(t, m.Execute(`pal save "`+name+`"`))
+	video.palette = nil
+	require.NoError(t, m.Execute(`pal "`+name+`"`))
+	require.Equal(t, vic_ii.Palettes["colodore"], video.palette)
+}
+
+type testViewer struct {
+	testVideo
+}
+
+func (v *testViewer) View(name string) (*image.RGBA, error) {
+	if name != "charset" {
+		return nil, errors.New("unknown view")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	img.SetRGBA(1, 2, color.RGBA{1, 2, 3, 255})
+	return img, nil
+}
+
+func TestMonitor_View(t *testing.T) {
+	m, _, _ := newTestMonitor("")
+	name := filepath.Join(t.TempDir(), "charset.png")
+	require.Error(t, m.Execute(`view charset "`+name+`"`))
+	m.SetVideo(&testViewer{})
+	require.Error(t, m.Execute("view charset"))
+	require.Error(t, m.Execute(`view bitmap "`+name+`"`))
+	require.NoError(t, m.Execute(`view charset "`+name+`"`))
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 128, 128), img.Bounds())
+	require.Equal(t, color.RGBA{1, 2, 3, 255}, color.RGBAModel.Convert(img.At(1, 2)))
+}
+
+// A register that's cleared by reading it, like the collision registers of the VIC-II
+type clearOnRead struct {
+	value uint8
+}
+
+func (c *clearOnRead) ReadByte(addr uint16) uint8 {
+	v := c.value
+	c.value = 0
+	return v
+}
+
+func (c *clearOnRead) WriteByte(addr uint16, data uint8) {
+	c.value = data
+}
+
+func (c *clearOnRead) Peek(addr uint16) uint8 {
+	return c.value
+}
+
+func TestMonitor_NoSideEffects(t *testing.T) {
+	m, _, out := newTestMonitor("")
+	reg := &clearOnRead{value: 0x81}
+	m.banks[core.BankCPU].(*core.Bus).Connect(reg, 0xd000, 0xd0ff)
+	require.NoError(t, m.Execute("m d01e d01e"))
+	require.True(t, strings.HasPrefix(out.String(), ">C:d01e  81"), out.String())
+	require.Equal(t, uint8(0x81), reg.value)
+	require.NoError(t, m.Execute("break 1000 if $d01e == $81"))
+	require.Equal(t, uint8(0x81), reg.value)
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package profiler
+
+import (
+	"compress/gzip"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/symbols"
+	"io"
+)
+
+// Field numbers from pprof's profile.proto
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileMapping       = 3
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profilePeriodType    = 11
+	profilePeriod        = 12
+	profileDefaultType   = 14
+	valueTypeType        = 1
+	valueTypeUnit        = 2
+	sampleLocationID     = 1
+	sampleValue          = 2
+	mappingID            = 1
+	mappingMemoryLimit   = 3
+	mappingFilename      = 5
+	mappingHasFunctions  = 7
+	mappingHasFilenames  = 8
+	mappingHasLineNumber = 9
+	locationID           = 1
+	locationMappingID    = 2
+	locationAddress      = 3
+	locationLine         = 4
+	lineFunctionID       = 1
+	lineLine             = 2
+	functionID           = 1
+	functionName         = 2
+	functionSystemName   = 3
+	functionFilename     = 4
+	functionStartLine    = 5
+)
+
+// Function key used for the root of the call tree, which isn't a real address
+const rootFunction = 0x10000
+
+// Minimal protocol buffer encoder, which is all it takes to write a profile
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.data = append(b.data, byte(v)|0x80)
+		v >>= 7
+	}
+	b.data = append(b.data, byte(v))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	if v {
+		b.uint64(field, 1)
+	}
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.bytes(field, m.data)
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	var p protoBuffer
+	for _, v := range values {
+		p.varint(v)
+	}
+	b.bytes(field, p.data)
+}
+
+type pprofWriter struct {
+	profile   protoBuffer
+	table     *symbols.Table
+	strings   map[string]uint64
+	functions map[int]uint64
+	locations map[[2]int]uint64
+	stringTab []string
+}
+
+func (w *pprofWriter) str(s string) uint64 {
+	if id, ok := w.strings[s]; ok {
+		return id
+	}
+	id := uint64(len(w.stringTab))
+	w.strings[s] = id
+	w.stringTab = append(w.stringTab, s)
+	return id
+}
+
+func (w *pprofWriter) function(key int) uint64 {
+	if id, ok := w.functions[key]; ok {
+		return id
+	}
+	id := uint64(len(w.functions) + 1)
+	w.functions[key] = id
+	name := topLevel
+	var f protoBuffer
+	f.uint64(functionID, id)
+	if key != rootFunction {
+		name = labelOrAddress(uint16(key), w.table)
+		if l, ok := w.table.Line(uint16(key)); ok {
+			f.uint64(functionFilename, w.str(l.File))
+			f.uint64(functionStartLine, uint64(l.Line))
+		}
+	}
+	f.uint64(functionName, w.str(name))
+	f.uint64(functionSystemName, w.str(name))
+	w.profile.message(profileFunction, &f)
+	return id
+}
+
+// Locations are per address and subroutine, since code can be shared by several
+// subroutines when they fall through into each other.
+func (w *pprofWriter) location(addr uint16, function int) uint64 {
+	key := [2]int{int(addr), function}
+	if id, ok := w.locations[key]; ok {
+		return id
+	}
+	id := uint64(len(w.locations) + 1)
+	w.locations[key] = id
+	var line protoBuffer
+	line.uint64(lineFunctionID, w.function(function))
+	if l, ok := w.table.Line(addr); ok {
+		line.uint64(lineLine, uint64(l.Line))
+	}
+	var loc protoBuffer
+	loc.uint64(locationID, id)
+	loc.uint64(locationMappingID, 1)
+	loc.uint64(locationAddress, uint64(addr))
+	loc.message(locationLine, &line)
+	w.profile.message(profileLocation, &loc)
+	return id
+}
+
+func (w *pprofWriter) valueType(field int, typ, unit string) {
+	var v protoBuffer
+	v.uint64(valueTypeType, w.str(typ))
+	v.uint64(valueTypeUnit, w.str(unit))
+	w.profile.message(field, &v)
+}
+
+func nodeFunction(n *core.CallNode) int {
+	if n.Parent == nil {
+		return rootFunction
+	}
+	return int(n.Function)
+}
+
+func (w *pprofWriter) samples(n *core.CallNode) {
+	// The stack above this node is the same for all of its samples
+	var callers []uint64
+	for c := n; c.Parent != nil; c = c.Parent {
+		callers = append(callers, w.location(c.CallSite, nodeFunction(c.Parent)))
+	}
+	for _, addr := range n.Addresses() {
+		cost := n.Self[addr]
+		var s protoBuffer
+		s.packed(sampleLocationID, append([]uint64{w.location(addr, nodeFunction(n))}, callers...))
+		s.packed(sampleValue, []uint64{cost.Cycles, cost.Stalls, cost.Executions})
+		w.profile.message(profileSample, &s)
+	}
+	for _, child := range n.Children {
+		w.samples(child)
+	}
+}
+
+// WritePprof writes a gzipped profile in pprof's format. Every address in every
+// subroutine becomes a sample, with the call stack that led there, so pprof can show
+// both the call graph and costs per source line. The table may be nil.
+func WritePprof(out io.Writer, p *core.Profiler, table *symbols.Table) error {
+	w := &pprofWriter{
+		table:     table,
+		strings:   make(map[string]uint64),
+		functions: make(map[int]uint64),
+		locations: make(map[[2]int]uint64),
+	}
+	w.str("") // The empty string must come first
+	w.valueType(profileSampleType, "cycles", "count")
+	w.valueType(profileSampleType, "stalls", "count")
+	w.valueType(profileSampleType, "instructions", "count")
+
+	// Everything lives in a single mapping covering the address space
+	var m protoBuffer
+	m.uint64(mappingID, 1)
+	m.uint64(mappingMemoryLimit, 0x10000)
+	m.uint64(mappingFilename, w.str("c64"))
+	m.bool(mappingHasFunctions, true)
+	m.bool(mappingHasFilenames, true)
+	m.bool(mappingHasLineNumber, true)
+	w.profile.message(profileMapping, &m)
+
+	w.samples(p.Root())
+	w.valueType(profilePeriodType, "cycles", "count")
+	w.profile.uint64(profilePeriod, 1)
+	w.profile.uint64(profileDefaultType, w.str("cycles"))
+	for _, s := range w.stringTab {
+		w.profile.bytes(profileStringTable, []byte(s))
+	}
+
+	z := gzip.NewWriter(out)
+	if _, err := z.Write(w.profile.data); err != nil {
+		return err
+	}
+	return z.Close()
+}
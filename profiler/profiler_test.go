@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/symbols"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Main loop calls OUTER, which calls INNER, which calls itself every other time
+var testProgram = []byte{
+	0xa0, 0x00, // 1000 LDY #$00
+	0x20, 0x08, 0x10, // 1002 JSR $1008
+	0x4c, 0x02, 0x10, // 1005 JMP $1002
+	0x20, 0x0c, 0x10, // 1008 OUTER JSR $100c
+	0x60,       // 100b RTS
+	0xc8,       // 100c INNER INY
+	0x98,       // 100d TYA
+	0x29, 0x01, // 100e AND #$01
+	0xd0, 0x03, // 1010 BNE $1015
+	0x20, 0x0c, 0x10, // 1012 JSR $100c
+	0x60, // 1015 RTS
+}
+
+func runProfiler(t *testing.T) *core.Profiler {
+	ram := core.MakeRAM(0xffff)
+	copy(ram.Bytes[0x1000:], testProgram)
+	bus := &core.Bus{}
+	bus.Connect(ram, 0x0000, 0xffff)
+	cpu := &core.CPU{}
+	cpu.Init(bus)
+	bus.ConnectClockablePh1(cpu)
+	cpu.SetRegisters(core.Registers{PC: 0x1000, SP: 0xff})
+	profiler := core.NewProfiler()
+	cpu.SetProfiler(profiler)
+	for cpu.Instructions() < 1000 {
+		bus.ClockPh1()
+	}
+	return profiler
+}
+
+func TestSubroutines(t *testing.T) {
+	subroutines := Subroutines(runProfiler(t))
+	require.Len(t, subroutines, 3)
+	top, outer, inner := subroutines[0], subroutines[1], subroutines[2]
+	require.True(t, top.TopLevel)
+	require.Equal(t, uint16(0x1008), outer.Function)
+	require.Equal(t, uint16(0x100c), inner.Function)
+	require.Greater(t, inner.Calls, outer.Calls, "The inner subroutine sometimes calls itself")
+
+	// Recursion isn't counted twice
+	require.Equal(t, top.Total.Cycles, top.Self.Cycles+outer.Total.Cycles)
+	require.Equal(t, inner.Self.Cycles, inner.Total.Cycles)
+}
+
+func TestWriteReport(t *testing.T) {
+	table := symbols.New()
+	table.AddLabel("outer", 0x1008)
+	table.AddLabel("inner", 0x100c)
+	var out bytes.Buffer
+	require.NoError(t, WriteReport(&out, runProfiler(t), table, 5))
+	lines := strings.Split(out.String(), "\n")
+	require.True(t, strings.HasPrefix(lines[0], "Total: "), lines[0])
+	require.Contains(t, out.String(), "$100c  inner\n")
+	require.Contains(t, out.String(), "(top level)")
+	require.Len(t, lines, 1+1+1+5+1+1+3+1)
+}
+
+func TestWritePprof(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, WritePprof(&out, runProfiler(t), nil))
+	z, err := gzip.NewReader(&out)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(z)
+	require.NoError(t, err)
+
+	// The first field is the sample type
+	require.Equal(t, uint8(profileSampleType<<3|2), data[0])
+	require.Contains(t, string(data), "cycles")
+	require.Contains(t, string(data), "$100c")
+}
+
+func TestWriteReport_Stalls(t *testing.T) {
+	ram := core.MakeRAM(0xffff)
+	copy(ram.Bytes[0x1000:], testProgram)
+	bus := &core.Bus{}
+	bus.Connect(ram, 0x0000, 0xffff)
+	cpu := &core.CPU{}
+	cpu.Init(bus)
+	bus.ConnectClockablePh1(cpu)
+	cpu.SetRegisters(core.Registers{PC: 0x1000, SP: 0xff})
+	profiler := core.NewProfiler()
+	cpu.SetProfiler(profiler)
+
+	// DMA holds up the first JSR in the middle of fetching its address
+	for cpu.Instructions() < 2 {
+		bus.ClockPh1()
+	}
+	bus.ClockPh1()
+	bus.RDY.PullDown()
+	for i := 0; i < 7; i++ {
+		bus.ClockPh1()
+	}
+	bus.RDY.Release()
+	for cpu.Instructions() < 10 {
+		bus.ClockPh1()
+	}
+	core.FinishInstruction(cpu, bus.ClockPh1)
+	bus.ClockPh1()
+
+	require.Equal(t, uint64(7), profiler.Cost(0x1002).Stalls)
+	require.Equal(t, uint64(7), profiler.Total().Stalls)
+	var out bytes.Buffer
+	require.NoError(t, WriteReport(&out, profiler, nil, 10))
+	require.Contains(t, out.String(), " 7 stalled ")
+	found := false
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "$1002") {
+			require.Equal(t, "7", strings.Fields(line)[2], line)
+			found = true
+		}
+	}
+	require.True(t, found, out.String())
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package profiler turns what core.Profiler has counted into reports: a text report
+// of the hotspots and subroutines sorted by cost, and a profile in the format of
+// pprof, so that "go tool pprof" can be used to dig into it. Labels and source lines
+// are taken from a symbol table when there is one.
+package profiler
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/prydin/emu6502/core"
+	"github.com/prydin/emu6502/symbols"
+	"io"
+	"sort"
+)
+
+// Name of the code that isn't in any subroutine we saw being called
+const topLevel = "(top level)"
+
+// Subroutine is the cost of a subroutine summed up over all its callers.
+type Subroutine struct {
+	Function  uint16
+	TopLevel  bool // The root of the call tree rather than a subroutine
+	Interrupt bool // Entered through an interrupt
+	Calls     uint64
+	Self      core.Cost
+	Total     core.Cost // Including subroutines, but counting recursion only once
+}
+
+// Subroutines sums up the call tree per subroutine, most expensive first.
+func Subroutines(p *core.Profiler) []Subroutine {
+	byFunction := make(map[uint16]*Subroutine)
+	root := &Subroutine{TopLevel: true, Self: p.Root().SelfCost(), Total: p.Root().TotalCost()}
+	active := make(map[uint16]bool)
+	var walk func(n *core.CallNode)
+	walk = func(n *core.CallNode) {
+		for _, child := range n.Children {
+			s, ok := byFunction[child.Function]
+			if !ok {
+				s = &Subroutine{Function: child.Function}
+				byFunction[child.Function] = s
+			}
+			s.Interrupt = s.Interrupt || child.Interrupt
+			s.Calls += child.Calls
+			s.Self.Add(child.SelfCost())
+			recursive := active[child.Function]
+			if !recursive {
+				s.Total.Add(child.TotalCost())
+				active[child.Function] = true
+			}
+			walk(child)
+			if !recursive {
+				delete(active, child.Function)
+			}
+		}
+	}
+	walk(p.Root())
+
+	subroutines := []Subroutine{*root}
+	for _, s := range byFunction {
+		subroutines = append(subroutines, *s)
+	}
+	sort.Slice(subroutines, func(i, j int) bool {
+		a, b := subroutines[i], subroutines[j]
+		if a.Total.Cycles != b.Total.Cycles {
+			return a.Total.Cycles > b.Total.Cycles
+		}
+		return a.Function < b.Function
+	})
+	return subroutines
+}
+
+// Hotspots returns the addresses that have cost anything, most expensive first.
+func Hotspots(p *core.Profiler) []uint16 {
+	var addrs []uint16
+	for addr := 0; addr < 0x10000; addr++ {
+		if p.Cost(uint16(addr)).Cycles > 0 {
+			addrs = append(addrs, uint16(addr))
+		}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return p.Cost(addrs[i]).Cycles > p.Cost(addrs[j]).Cycles
+	})
+	return addrs
+}
+
+// WriteReport writes the n most expensive addresses and subroutines. The table may
+// be nil.
+func WriteReport(w io.Writer, p *core.Profiler, table *symbols.Table, n int) error {
+	out := bufio.NewWriter(w)
+	total := p.Total()
+	fmt.Fprintf(out, "Total: %d cycles, %d stalled (%s), %d instructions\n\n",
+		total.Cycles, total.Stalls, percent(total.Stalls, total.Cycles), total.Executions)
+
+	fmt.Fprintf(out, "%10s %6s %10s %10s  %-5s  %s\n", "Cycles", "%", "Stalls", "Execs", "Addr", "Location")
+	for i, addr := range Hotspots(p) {
+		if i == n {
+			break
+		}
+		c := p.Cost(addr)
+		fmt.Fprintf(out, "%10d %6s %10d %10d  $%04x  %s\n",
+			c.Cycles, percent(c.Cycles, total.Cycles), c.Stalls, c.Executions, addr, table.Describe(addr))
+	}
+
+	fmt.Fprintf(out, "\n%10s %6s %10s %6s %10s  %-5s  %s\n", "Total", "%", "Self", "%", "Calls", "Entry", "Subroutine")
+	for i, s := range Subroutines(p) {
+		if i == n {
+			break
+		}
+		entry := fmt.Sprintf("$%04x", s.Function)
+		if s.TopLevel {
+			entry = "-"
+		}
+		fmt.Fprintf(out, "%10d %6s %10d %6s %10d  %-5s  %s\n",
+			s.Total.Cycles, percent(s.Total.Cycles, total.Cycles), s.Self.Cycles, percent(s.Self.Cycles, total.Cycles),
+			s.Calls, entry, subroutineName(s, table))
+	}
+	return out.Flush()
+}
+
+func subroutineName(s Subroutine, table *symbols.Table) string {
+	if s.TopLevel {
+		return topLevel
+	}
+	name := labelOrAddress(s.Function, table)
+	if s.Interrupt {
+		name += " (interrupt)"
+	}
+	return name
+}
+
+func labelOrAddress(addr uint16, table *symbols.Table) string {
+	if label, ok := table.Label(addr); ok {
+		return label
+	}
+	return fmt.Sprintf("$%04x", addr)
+}
+
+func percent(part, whole uint64) string {
+	if whole == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(whole))
+}
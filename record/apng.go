@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// The acTL chunk follows the signature and the IHDR chunk, and is rewritten with the
+// number of frames when the file is closed
+const actlOffset = 8 + 12 + 13
+
+// APNGEncoder writes an animated PNG that loops forever. Each frame is compressed
+// by image/png and its image data moved into the frame chunks of APNG.
+type APNGEncoder struct {
+	file     WriteSeekCloser
+	out      *bufio.Writer
+	encoder  png.Encoder
+	buf      bytes.Buffer
+	width    int
+	height   int
+	rate     FrameRate
+	frame    int
+	sequence uint32
+	header   []byte // IHDR of the first frame, which all others must match
+}
+
+func NewAPNGEncoder(w WriteSeekCloser, width, height int, rate FrameRate) (*APNGEncoder, error) {
+	return &APNGEncoder{
+		file:    w,
+		out:     bufio.NewWriter(w),
+		encoder: png.Encoder{CompressionLevel: png.BestSpeed},
+		width:   width,
+		height:  height,
+		rate:    rate,
+	}, nil
+}
+
+func (a *APNGEncoder) WriteFrame(img *image.RGBA) error {
+	a.buf.Reset()
+	if err := a.encoder.Encode(&a.buf, img); err != nil {
+		return err
+	}
+	header, data, err := splitPNG(a.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if a.frame == 0 {
+		// The header is written once the first frame tells us what image/png makes of it
+		a.header = append([]byte(nil), header...)
+		if _, err := a.out.WriteString(pngSignature); err != nil {
+			return err
+		}
+		if err := a.writeChunk("IHDR", header); err != nil {
+			return err
+		}
+		if err := a.writeChunk("acTL", make([]byte, 8)); err != nil {
+			return err
+		}
+	} else if !bytes.Equal(header, a.header) {
+		return errors.New("frames must all have the same format")
+	}
+
+	// Frame control with the size, the delay and no disposal or blending
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], a.nextSequence())
+	binary.BigEndian.PutUint32(fctl[4:], uint32(a.width))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(a.height))
+	binary.BigEndian.PutUint16(fctl[20:], uint16(a.rate.delay(a.frame, 10000)))
+	binary.BigEndian.PutUint16(fctl[22:], 10000)
+	if err := a.writeChunk("fcTL", fctl); err != nil {
+		return err
+	}
+	for _, d := range data {
+		if a.frame == 0 {
+			err = a.writeChunk("IDAT", d)
+		} else {
+			seq := make([]byte, 4, 4+len(d))
+			binary.BigEndian.PutUint32(seq, a.nextSequence())
+			err = a.writeChunk("fdAT", append(seq, d...))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	a.frame++
+	return nil
+}
+
+func (a *APNGEncoder) nextSequence() uint32 {
+	a.sequence++
+	return a.sequence - 1
+}
+
+func (a *APNGEncoder) writeChunk(kind string, data []byte) error {
+	_, err := a.out.Write(chunk(kind, data))
+	return err
+}
+
+func chunk(kind string, data []byte) []byte {
+	c := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(c, uint32(len(data)))
+	copy(c[4:], kind)
+	c = append(c, data...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(c[4:]))
+	return append(c, crc[:]...)
+}
+
+// Returns the IHDR data and the IDAT chunks of a PNG file
+func splitPNG(b []byte) ([]byte, [][]byte, error) {
+	if !bytes.HasPrefix(b, []byte(pngSignature)) {
+		return nil, nil, errors.New("not a PNG")
+	}
+	b = b[len(pngSignature):]
+	var header []byte
+	var data [][]byte
+	for len(b) >= 12 {
+		n := int(binary.BigEndian.Uint32(b))
+		if 12+n > len(b) {
+			break
+		}
+		switch string(b[4:8]) {
+		case "IHDR":
+			header = b[8 : 8+n]
+		case "IDAT":
+			data = append(data, b[8:8+n])
+		case "PLTE", "tRNS":
+			return nil, nil, errors.New("palette images aren't supported")
+		}
+		b = b[12+n:]
+	}
+	if header == nil || data == nil {
+		return nil, nil, errors.New("truncated PNG")
+	}
+	return header, data, nil
+}
+
+// Close writes the end of the file, fills in the number of frames and closes the
+// underlying file.
+func (a *APNGEncoder) Close() error {
+	err := a.finish()
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (a *APNGEncoder) finish() error {
+	if a.frame == 0 {
+		return errors.New("no frames recorded")
+	}
+	if err := a.writeChunk("IEND", nil); err != nil {
+		return err
+	}
+	if err := a.out.Flush(); err != nil {
+		return err
+	}
+	if _, err := a.file.Seek(actlOffset, io.SeekStart); err != nil {
+		return err
+	}
+	actl := make([]byte, 8) // Frames followed by the number of loops, where 0 is forever
+	binary.BigEndian.PutUint32(actl, uint32(a.frame))
+	_, err := a.file.Write(chunk("acTL", actl))
+	return err
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package record
+
+import (
+	"bufio"
+	"compress/lzw"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Viewers play frames with shorter delays than this, in hundredths of a second, much
+// slower than asked for
+const minGIFDelay = 2
+
+// GIFEncoder writes an animated GIF that loops forever. Unlike image/gif, which
+// needs all frames up front, frames are written as they come. Each frame has its
+// own color table, so palette changes while recording are kept. GIF delays are in
+// hundredths of a second and viewers don't show frames faster than every other
+// hundredth, so frames that would come sooner are dropped. On NTSC, that's every
+// other frame.
+type GIFEncoder struct {
+	out     *bufio.Writer
+	closer  io.Closer
+	width   int
+	height  int
+	rate    FrameRate
+	frame   int // Number of the next frame passed to WriteFrame
+	next    int // Number of the next frame to write to the file
+	indexes []uint8
+	colors  map[color.RGBA]uint8
+	palette []color.RGBA
+}
+
+func NewGIFEncoder(w io.WriteCloser, width, height int, rate FrameRate) (*GIFEncoder, error) {
+	g := &GIFEncoder{
+		out:     bufio.NewWriter(w),
+		closer:  w,
+		width:   width,
+		height:  height,
+		rate:    rate,
+		indexes: make([]uint8, width*height),
+	}
+	header := []byte("GIF89a")
+	header = appendU16(header, width)
+	header = appendU16(header, height)
+	header = append(header, 0, 0, 0) // No global color table
+
+	// Make it loop forever
+	header = append(header, 0x21, 0xff, 11)
+	header = append(header, "NETSCAPE2.0"...)
+	header = append(header, 3, 1, 0, 0, 0)
+	if _, err := g.out.Write(header); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func appendU16(b []byte, v int) []byte {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], uint16(v))
+	return append(b, buf[:]...)
+}
+
+func (g *GIFEncoder) WriteFrame(img *image.RGBA) error {
+	if g.frame < g.next {
+		g.frame++ // Too soon after the last one written
+		return nil
+	}
+
+	// The frame is shown until the first one that comes late enough
+	start := g.rate.at(g.frame, 100)
+	g.next = g.frame + 1
+	for g.rate.at(g.next, 100)-start < minGIFDelay {
+		g.next++
+	}
+
+	g.mapColors(img)
+	bits := 2 // The smallest code size GIF allows
+	for 1<<bits < len(g.palette) {
+		bits++
+	}
+
+	// Graphic control extension with the delay and the image descriptor
+	block := []byte{0x21, 0xf9, 4, 0}
+	block = appendU16(block, g.rate.at(g.next, 100)-start)
+	block = append(block, 0, 0, 0x2c, 0, 0, 0, 0)
+	block = appendU16(block, g.width)
+	block = appendU16(block, g.height)
+	block = append(block, 0x80|uint8(bits-1)) // Local color table
+	for i := 0; i < 1<<bits; i++ {
+		var c color.RGBA
+		if i < len(g.palette) {
+			c = g.palette[i]
+		}
+		block = append(block, c.R, c.G, c.B)
+	}
+	block = append(block, uint8(bits))
+	if _, err := g.out.Write(block); err != nil {
+		return err
+	}
+	blocks := &subBlockWriter{out: g.out}
+	compressor := lzw.NewWriter(blocks, lzw.LSB, bits)
+	if _, err := compressor.Write(g.indexes); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if err := blocks.Close(); err != nil {
+		return err
+	}
+	g.frame++
+	return nil
+}
+
+// Turns the pixels into indexes to a color table of at most 256 entries. Once the
+// table is full, the closest color is used.
+func (g *GIFEncoder) mapColors(img *image.RGBA) {
+	g.colors = map[color.RGBA]uint8{}
+	g.palette = g.palette[:0]
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			c := img.RGBAAt(x, y)
+			index, ok := g.colors[c]
+			if !ok {
+				if len(g.palette) < 256 {
+					index = uint8(len(g.palette))
+					g.palette = append(g.palette, c)
+				} else {
+					index = closest(g.palette, c)
+				}
+				g.colors[c] = index
+			}
+			g.indexes[y*g.width+x] = index
+		}
+	}
+}
+
+func closest(palette []color.RGBA, c color.RGBA) uint8 {
+	best, bestDist := 0, -1
+	for i, p := range palette {
+		dr, dg, db := int(p.R)-int(c.R), int(p.G)-int(c.G), int(p.B)-int(c.B)
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return uint8(best)
+}
+
+// Close writes the trailer and closes the underlying writer.
+func (g *GIFEncoder) Close() error {
+	err := g.out.WriteByte(0x3b)
+	if flushErr := g.out.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := g.closer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GIF image data is split into sub-blocks of at most 255 bytes, each preceded by its
+// length and ended by an empty block.
+type subBlockWriter struct {
+	out *bufio.Writer
+	buf [255]byte
+	n   int
+}
+
+func (s *subBlockWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(s.buf[s.n:], p)
+		s.n += n
+		p = p[n:]
+		written += n
+		if s.n == len(s.buf) {
+			if err := s.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (s *subBlockWriter) flush() error {
+	if s.n == 0 {
+		return nil
+	}
+	if err := s.out.WriteByte(uint8(s.n)); err != nil {
+		return err
+	}
+	_, err := s.out.Write(s.buf[:s.n])
+	s.n = 0
+	return err
+}
+
+func (s *subBlockWriter) Close() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.out.WriteByte(0)
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package record writes the video and sound the emulator produces to files. Frames
+// are taken from the raster the VIC-II draws on and sound from the SID mixer, so
+// recording works the same with and without a screen.
+package record
+
+import (
+	"fmt"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Encoder writes frames to a video file.
+type Encoder interface {
+	// WriteFrame adds a frame to the video. The image is only valid during the call.
+	WriteFrame(img *image.RGBA) error
+
+	// Close finishes the file and closes the underlying writer.
+	Close() error
+}
+
+// WriteSeekCloser is a writer that can go back and fill in headers when closed, like
+// an *os.File.
+type WriteSeekCloser interface {
+	io.WriteSeeker
+	io.Closer
+}
+
+// FrameRate is a frame rate in frames per second as a fraction, which is how the
+// video formats want it.
+type FrameRate struct {
+	Num int
+	Den int
+}
+
+// RateOf returns the exact frame rate of a machine model.
+func RateOf(dims vic_ii.ScreenDimensions) FrameRate {
+	num, den := int(dims.ClockFrequency), int(dims.Cycles)
+	a, b := num, den
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return FrameRate{num / a, den / a}
+}
+
+// Converts frame numbers to time in the given units, e.g. 100 for the hundredths of
+// a second used by GIF. The delays are rounded so that they add up to the exact
+// time, instead of drifting.
+func (r FrameRate) delay(frame, units int) int {
+	return r.at(frame+1, units) - r.at(frame, units)
+}
+
+// Returns the time a frame starts at, rounded to the given units.
+func (r FrameRate) at(frame, units int) int {
+	return int((int64(frame)*int64(units)*int64(r.Den) + int64(r.Num)/2) / int64(r.Num))
+}
+
+// Recorder is a raster that records every frame before passing the pixels on to
+// another raster, which may be nil. Frames are encoded as part of Flip, so none are
+// dropped however slow the encoder is. Flips without anything drawn, like the one
+// at the very start, are skipped.
+type Recorder struct {
+	raster  vic_ii.Raster
+	encoder Encoder
+	frame   *image.RGBA
+	drawn   bool
+	frames  int
+	err     error
+}
+
+// NewRecorder creates a recorder for a screen of the given size.
+func NewRecorder(raster vic_ii.Raster, encoder Encoder, width, height int) *Recorder {
+	r := &Recorder{
+		raster:  raster,
+		encoder: encoder,
+		frame:   image.NewRGBA(image.Rect(0, 0, width, height)),
+	}
+	for i := 3; i < len(r.frame.Pix); i += 4 {
+		r.frame.Pix[i] = 0xff // Opaque black
+	}
+	return r
+}
+
+func (r *Recorder) SetPixel(x, y uint16, color color.RGBA) {
+	if int(x) < r.frame.Rect.Max.X && int(y) < r.frame.Rect.Max.Y {
+		r.frame.SetRGBA(int(x), int(y), color)
+		r.drawn = true
+	}
+	if r.raster != nil {
+		r.raster.SetPixel(x, y, color)
+	}
+}
+
+func (r *Recorder) Flip() {
+	if r.drawn && r.err == nil {
+		r.err = r.encoder.WriteFrame(r.frame)
+		r.frames++
+	}
+	r.drawn = false
+	if r.raster != nil {
+		r.raster.Flip()
+	}
+}
+
+// Frames returns the number of frames recorded.
+func (r *Recorder) Frames() int {
+	return r.frames
+}
+
+// Close finishes the recording. Returns the first error encountered while encoding,
+// if any. Recording stops at the first error.
+func (r *Recorder) Close() error {
+	if err := r.encoder.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Create creates a video file in the format given by the extension of the name: .gif
+// for animated GIF, .png or .apng for animated PNG and .y4m for uncompressed YUV4MPEG2
+// that ffmpeg and most other video tools read.
+func Create(name string, width, height int, rate FrameRate) (Encoder, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".gif", ".png", ".apng", ".y4m":
+	default:
+		return nil, fmt.Errorf("unknown video format: %s", ext)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	var encoder Encoder
+	switch ext {
+	case ".gif":
+		encoder, err = NewGIFEncoder(f, width, height, rate)
+	case ".y4m":
+		encoder, err = NewY4MEncoder(f, width, height, rate)
+	default:
+		encoder, err = NewAPNGEncoder(f, width, height, rate)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return encoder, nil
+}
+
+// SoundFile returns the name of the WAV file recorded along with a video.
+func SoundFile(video string) string {
+	return strings.TrimSuffix(video, filepath.Ext(video)) + ".wav"
+}
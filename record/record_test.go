@@ -0,0 +1,290 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/prydin/emu6502/core"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Frames with a different color in the top left corner
+func testFrames() []*image.RGBA {
+	var frames []*image.RGBA
+	for _, c := range []color.RGBA{vic_ii.C64Colors[1], vic_ii.C64Colors[2], vic_ii.C64Colors[3]} {
+		img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+		for i := range img.Pix {
+			img.Pix[i] = 0xff
+		}
+		img.SetRGBA(0, 0, c)
+		img.SetRGBA(15, 7, vic_ii.C64Colors[6])
+		frames = append(frames, img)
+	}
+	return frames
+}
+
+func writeFrames(t *testing.T, name string) string {
+	name = filepath.Join(t.TempDir(), name)
+	enc, err := Create(name, 16, 8, RateOf(vic_ii.PALDimensions))
+	require.NoError(t, err)
+	for _, img := range testFrames() {
+		require.NoError(t, enc.WriteFrame(img))
+	}
+	require.NoError(t, enc.Close())
+	return name
+}
+
+func TestRateOf(t *testing.T) {
+	rate := RateOf(vic_ii.PALDimensions)
+	require.Equal(t, FrameRate{13684, 273}, rate)
+	require.InDelta(t, vic_ii.PALDimensions.FrameRate(), float64(rate.Num)/float64(rate.Den), 1e-9)
+	total := 0
+	for i := 0; i < 1000; i++ {
+		d := rate.delay(i, 100)
+		require.True(t, d == 1 || d == 2)
+		total += d
+	}
+	require.Equal(t, 1995, total, "Delays don't drift")
+}
+
+func TestCreate(t *testing.T) {
+	_, err := Create(filepath.Join(t.TempDir(), "video.mp4"), 16, 8, FrameRate{50, 1})
+	require.EqualError(t, err, "unknown video format: .mp4")
+	require.Equal(t, "dir/video.wav", SoundFile("dir/video.gif"))
+}
+
+func TestGIF(t *testing.T) {
+	f, err := os.Open(writeFrames(t, "video.gif"))
+	require.NoError(t, err)
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	require.NoError(t, err)
+	require.Len(t, anim.Image, 3)
+	require.Equal(t, 0, anim.LoopCount)
+	require.Equal(t, []int{2, 2, 2}, anim.Delay)
+	for i, img := range testFrames() {
+		require.Equal(t, img.RGBAAt(0, 0), color.RGBAModel.Convert(anim.Image[i].At(0, 0)))
+		require.Equal(t, img.RGBAAt(1, 0), color.RGBAModel.Convert(anim.Image[i].At(1, 0)))
+		require.Equal(t, vic_ii.C64Colors[6], color.RGBAModel.Convert(anim.Image[i].At(15, 7)))
+	}
+}
+
+func TestGIFDelays(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	for _, dims := range []vic_ii.ScreenDimensions{vic_ii.PALDimensions, vic_ii.NTSCDimensions} {
+		var buf closeBuffer
+		rate := RateOf(dims)
+		enc, err := NewGIFEncoder(&buf, 1, 1, rate)
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, enc.WriteFrame(img))
+		}
+		require.NoError(t, enc.Close())
+		anim, err := gif.DecodeAll(&buf.Buffer)
+		require.NoError(t, err)
+
+		// Frames are dropped rather than shown for less than 2/100 s, which viewers
+		// would slow down, and the animation plays at the speed of the machine
+		total := 0
+		for _, d := range anim.Delay {
+			require.GreaterOrEqual(t, d, 2, dims.Model)
+			total += d
+		}
+		require.InDelta(t, rate.at(1000, 100), total, 2, dims.Model)
+	}
+}
+
+func TestGIFManyColors(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 1))
+	for x := 0; x < 300; x++ {
+		img.SetRGBA(x, 0, color.RGBA{uint8(x), uint8(x >> 8), 0, 255})
+	}
+	var buf closeBuffer
+	enc, err := NewGIFEncoder(&buf, 300, 1, FrameRate{50, 1})
+	require.NoError(t, err)
+	require.NoError(t, enc.WriteFrame(img))
+	require.NoError(t, enc.Close())
+	decoded, err := gif.Decode(&buf.Buffer)
+	require.NoError(t, err)
+	require.Equal(t, color.RGBA{43, 1, 0, 255}, img.RGBAAt(299, 0))
+	require.Equal(t, color.RGBA{43, 0, 0, 255}, color.RGBAModel.Convert(decoded.At(299, 0)), "Closest color once the table is full")
+}
+
+type closeBuffer struct {
+	bytes.Buffer
+}
+
+func (c *closeBuffer) Close() error {
+	return nil
+}
+
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+func readChunks(t *testing.T, b []byte) []pngChunk {
+	require.Equal(t, pngSignature, string(b[:8]))
+	b = b[8:]
+	var chunks []pngChunk
+	for len(b) > 0 {
+		n := binary.BigEndian.Uint32(b)
+		chunks = append(chunks, pngChunk{string(b[4:8]), b[8 : 8+n]})
+		b = b[12+n:]
+	}
+	return chunks
+}
+
+func TestAPNG(t *testing.T) {
+	data, err := ioutil.ReadFile(writeFrames(t, "video.png"))
+	require.NoError(t, err)
+
+	// Viewers that don't know APNG show the first frame
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, testFrames()[0].RGBAAt(0, 0), color.RGBAModel.Convert(img.At(0, 0)))
+
+	chunks := readChunks(t, data)
+	require.Equal(t, "IHDR", chunks[0].kind)
+	require.Equal(t, "acTL", chunks[1].kind)
+	require.Equal(t, uint32(3), binary.BigEndian.Uint32(chunks[1].data), "Number of frames")
+	require.Equal(t, "IEND", chunks[len(chunks)-1].kind)
+	sequence := uint32(0)
+	frames := 0
+	var last []byte
+	for _, c := range chunks {
+		switch c.kind {
+		case "fcTL":
+			require.Equal(t, sequence, binary.BigEndian.Uint32(c.data))
+			require.InDelta(t, 199.5, binary.BigEndian.Uint16(c.data[20:]), 0.5)
+			require.Equal(t, uint16(10000), binary.BigEndian.Uint16(c.data[22:]))
+			sequence++
+			frames++
+			last = nil
+		case "fdAT":
+			require.Equal(t, sequence, binary.BigEndian.Uint32(c.data))
+			sequence++
+			last = append(last, c.data[4:]...)
+		}
+	}
+	require.Equal(t, 3, frames)
+
+	// The data of the last frame makes a PNG of its own
+	frame := []byte(pngSignature)
+	frame = append(frame, chunk("IHDR", chunks[0].data)...)
+	frame = append(frame, chunk("IDAT", last)...)
+	frame = append(frame, chunk("IEND", nil)...)
+	img, err = png.Decode(bytes.NewReader(frame))
+	require.NoError(t, err)
+	require.Equal(t, testFrames()[2].RGBAAt(0, 0), color.RGBAModel.Convert(img.At(0, 0)))
+}
+
+func TestY4M(t *testing.T) {
+	f, err := os.Open(writeFrames(t, "video.y4m"))
+	require.NoError(t, err)
+	defer f.Close()
+	in := bufio.NewReader(f)
+	header, err := in.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "YUV4MPEG2 W16 H8 F13684:273 Ip A1:1 C444\n", header)
+	for i := 0; i < 3; i++ {
+		frame, err := in.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "FRAME\n", frame)
+		planes := make([]byte, 3*16*8)
+		_, err = in.Read(planes)
+		require.NoError(t, err)
+		require.Equal(t, []byte{235, 128, 128}, []byte{planes[1], planes[128+1], planes[256+1]}, "White")
+	}
+	_, err = in.ReadByte()
+	require.Error(t, err, "Nothing after the last frame")
+}
+
+func TestWAV(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sound.wav")
+	wav, err := CreateWAV(name, 44100)
+	require.NoError(t, err)
+	wav.WriteSample(1, -1)
+	wav.WriteSample(0x1234, -0x1234)
+	require.NoError(t, wav.Close())
+	data, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	require.Len(t, data, 44+8)
+	require.Equal(t, "RIFF", string(data[:4]))
+	require.Equal(t, uint32(36+8), binary.LittleEndian.Uint32(data[4:]))
+	require.Equal(t, "WAVEfmt ", string(data[8:16]))
+	require.Equal(t, uint32(44100), binary.LittleEndian.Uint32(data[24:]))
+	require.Equal(t, "data", string(data[36:40]))
+	require.Equal(t, uint32(8), binary.LittleEndian.Uint32(data[40:]))
+	require.Equal(t, []byte{1, 0, 0xff, 0xff, 0x34, 0x12, 0xcc, 0xed}, data[44:])
+}
+
+type testEncoder struct {
+	frames []*image.RGBA
+	closed bool
+}
+
+func (e *testEncoder) WriteFrame(img *image.RGBA) error {
+	frame := image.NewRGBA(img.Rect)
+	copy(frame.Pix, img.Pix)
+	e.frames = append(e.frames, frame)
+	return nil
+}
+
+func (e *testEncoder) Close() error {
+	e.closed = true
+	return nil
+}
+
+func TestRecorder_Headless(t *testing.T) {
+	dims := vic_ii.PALDimensions
+	enc := &testEncoder{}
+	screen := image.NewRGBA(image.Rect(0, 0, int(dims.VisibleWidth), int(dims.VisibleHeight)))
+	rec := NewRecorder(&vic_ii.ImageRaster{Img: screen}, enc, int(dims.VisibleWidth), int(dims.VisibleHeight))
+	bus := &core.Bus{}
+	bus.Connect(core.MakeRAM(0x4000), 0x0000, 0x3fff)
+	vicii := vic_ii.VicII{}
+	vicii.Init(bus, bus, core.MakeRAM(1024), rec, dims)
+
+	// Every frame is recorded, but not the flip at the start before anything is drawn
+	for i := 0; i < 3*2*int(dims.Cycles)+2; i++ {
+		vicii.Clock()
+	}
+	require.Equal(t, 3, len(enc.frames))
+	require.Equal(t, 3, rec.Frames())
+	border := vic_ii.C64Colors[4]
+	require.Equal(t, border, enc.frames[2].RGBAAt(0, 0))
+	require.Equal(t, border, screen.RGBAAt(0, 0), "Pixels are passed on")
+	require.NoError(t, rec.Close())
+	require.True(t, enc.closed)
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// The sizes in the RIFF and data chunk headers are filled in when the file is closed
+const (
+	wavHeaderSize    = 44
+	riffSizeOffset   = 4
+	dataSizeOffset   = 40
+	wavBytesPerFrame = 4 // 16 bit stereo
+)
+
+// WAVWriter writes 16 bit stereo PCM to a WAV file. It's an audio sink for the SID
+// mixer.
+type WAVWriter struct {
+	file  WriteSeekCloser
+	out   *bufio.Writer
+	bytes uint32
+	err   error
+	buf   [wavBytesPerFrame]byte
+}
+
+func NewWAVWriter(w WriteSeekCloser, sampleRate int) (*WAVWriter, error) {
+	wav := &WAVWriter{file: w, out: bufio.NewWriter(w)}
+	var h [wavHeaderSize]byte
+	copy(h[0:], "RIFF")
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16) // Size of the format chunk
+	binary.LittleEndian.PutUint16(h[20:], 1)  // PCM
+	binary.LittleEndian.PutUint16(h[22:], 2)  // Channels
+	binary.LittleEndian.PutUint32(h[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(sampleRate*wavBytesPerFrame))
+	binary.LittleEndian.PutUint16(h[32:], wavBytesPerFrame)
+	binary.LittleEndian.PutUint16(h[34:], 16) // Bits per sample
+	copy(h[36:], "data")
+	if _, err := wav.out.Write(h[:]); err != nil {
+		return nil, err
+	}
+	return wav, nil
+}
+
+// CreateWAV creates a WAV file.
+func CreateWAV(name string, sampleRate int) (*WAVWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	wav, err := NewWAVWriter(f, sampleRate)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return wav, nil
+}
+
+func (w *WAVWriter) WriteSample(left, right int16) {
+	if w.err != nil {
+		return
+	}
+	binary.LittleEndian.PutUint16(w.buf[0:], uint16(left))
+	binary.LittleEndian.PutUint16(w.buf[2:], uint16(right))
+	_, w.err = w.out.Write(w.buf[:])
+	w.bytes += wavBytesPerFrame
+}
+
+// Close fills in the sizes and closes the file. Returns the first error encountered
+// while writing, if any.
+func (w *WAVWriter) Close() error {
+	if w.err == nil {
+		w.err = w.finish()
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *WAVWriter) finish() error {
+	if err := w.out.Flush(); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], wavHeaderSize-8+w.bytes)
+	if _, err := w.file.Seek(riffSizeOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(size[:]); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(size[:], w.bytes)
+	if _, err := w.file.Seek(dataSizeOffset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := w.file.Write(size[:])
+	return err
+}
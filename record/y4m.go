@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package record
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Y4MEncoder writes uncompressed YUV4MPEG2 video with full resolution chroma (4:4:4)
+// and BT.601 levels, for transcoding with e.g. ffmpeg -i video.y4m video.mp4.
+type Y4MEncoder struct {
+	out    *bufio.Writer
+	closer io.Closer
+	width  int
+	height int
+	planes []byte
+}
+
+func NewY4MEncoder(w io.WriteCloser, width, height int, rate FrameRate) (*Y4MEncoder, error) {
+	y := &Y4MEncoder{
+		out:    bufio.NewWriter(w),
+		closer: w,
+		width:  width,
+		height: height,
+		planes: make([]byte, 3*width*height),
+	}
+	if _, err := fmt.Fprintf(y.out, "YUV4MPEG2 W%d H%d F%d:%d Ip A1:1 C444\n", width, height, rate.Num, rate.Den); err != nil {
+		return nil, err
+	}
+	return y, nil
+}
+
+func (y *Y4MEncoder) WriteFrame(img *image.RGBA) error {
+	size := y.width * y.height
+	for row := 0; row < y.height; row++ {
+		for x := 0; x < y.width; x++ {
+			c := img.RGBAAt(x, row)
+			r, g, b := float64(c.R), float64(c.G), float64(c.B)
+			i := row*y.width + x
+			y.planes[i] = uint8(16.5 + (65.481*r+128.553*g+24.966*b)/255)
+			y.planes[size+i] = uint8(128.5 + (-37.797*r-74.203*g+112.0*b)/255)
+			y.planes[2*size+i] = uint8(128.5 + (112.0*r-93.786*g-18.214*b)/255)
+		}
+	}
+	if _, err := y.out.WriteString("FRAME\n"); err != nil {
+		return err
+	}
+	_, err := y.out.Write(y.planes)
+	return err
+}
+
+// Close flushes the video and closes the underlying writer.
+func (y *Y4MEncoder) Close() error {
+	err := y.out.Flush()
+	if closeErr := y.closer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
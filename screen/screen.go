@@ -26,6 +26,7 @@ import (
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
 	"github.com/faiface/pixel/text"
+	vic_ii "github.com/prydin/emu6502/vic-ii"
 	"golang.org/x/image/font/basicfont"
 	"image"
 	"image/color"
@@ -41,9 +42,18 @@ type Screen struct {
 	fps     int64
 	jobs    chan *pixel.PictureData
 	atlas   *text.Atlas
+
+	// Post-processing. Only touched by the screen update goroutine once it's running.
+	filter   *vic_ii.CRTFilter
+	filtered *pixel.PictureData
 }
 
 func New(win *pixelgl.Window, bounds image.Rectangle) *Screen {
+	return NewWithFilter(win, bounds, nil)
+}
+
+// NewWithFilter creates a screen that post-processes each frame before showing it.
+func NewWithFilter(win *pixelgl.Window, bounds image.Rectangle, filter *vic_ii.CRTFilter) *Screen {
 	s := &Screen{
 		window:  win,
 		front:   pixel.MakePictureData(toRect(bounds)),
@@ -51,18 +61,40 @@ func New(win *pixelgl.Window, bounds image.Rectangle) *Screen {
 		lastFps: time.Now(),
 		jobs:    make(chan *pixel.PictureData),
 		atlas:   text.NewAtlas(basicfont.Face7x13, text.ASCII),
+		filter:  filter,
+	}
+	if filter != nil {
+		height := filter.OutputHeight(bounds.Dy())
+		s.filtered = pixel.MakePictureData(pixel.R(0, 0, float64(bounds.Dx()), float64(height)))
 	}
 	go s.runScreenUpdate()
 	return s
 }
 
+// Returns the rows of a picture from the top down
+func rows(pd *pixel.PictureData) [][]color.RGBA {
+	width := int(pd.Bounds().W())
+	r := make([][]color.RGBA, int(pd.Bounds().H()))
+	for y := range r {
+		start := (len(r) - 1 - y) * pd.Stride
+		r[y] = pd.Pix[start : start+width]
+	}
+	return r
+}
+
 func (s *Screen) runScreenUpdate() {
 	for {
 		pd := <-s.jobs
 		s.window.Canvas().Clear(color.Black)
-		sprite := pixel.NewSprite(pd, pd.Bounds())
 		scale := s.window.Bounds().W() / pd.Bounds().W()
-		sprite.Draw(s.window.Canvas(), pixel.IM.Moved(s.window.Bounds().Center()).Scaled(s.window.Bounds().Center(), scale))
+		stretch := 1.0
+		if s.filter != nil {
+			s.filter.Apply(rows(s.filtered), rows(pd))
+			stretch = pd.Bounds().H() / s.filtered.Bounds().H()
+			pd = s.filtered
+		}
+		sprite := pixel.NewSprite(pd, pd.Bounds())
+		sprite.Draw(s.window.Canvas(), pixel.IM.Moved(s.window.Bounds().Center()).ScaledXY(s.window.Bounds().Center(), pixel.V(scale, scale*stretch)))
 
 		txt := text.New(pixel.V(10, 10), s.atlas)
 		fmt.Fprintf(txt, "FPS: %d", s.fps)
@@ -96,6 +128,19 @@ func (s *Screen) SetPixel(x, y uint16, color color.RGBA) {
 	s.back.Pix[int(x)+int(uint16(s.back.Rect.Max.Y-1)-y)*s.back.Stride] = color
 }
 
+// PixelAt maps a position in the window, e.g. that of the mouse, to the pixel of the
+// picture drawn there. Rows are counted from the top, like in SetPixel. Returns false
+// if the position is outside of the picture.
+func (s *Screen) PixelAt(pos pixel.Vec) (int, int, bool) {
+	b := s.front.Bounds()
+	scale := s.window.Bounds().W() / b.W()
+	p := pos.Sub(s.window.Bounds().Center()).Scaled(1 / scale).Add(b.Center())
+	if p.X < b.Min.X || p.X >= b.Max.X || p.Y < b.Min.Y || p.Y >= b.Max.Y {
+		return 0, 0, false
+	}
+	return int(p.X - b.Min.X), int(b.Max.Y-1) - int(p.Y-b.Min.Y), true
+}
+
 func toRectangle(rect pixel.Rect) image.Rectangle {
 	return image.Rectangle{
 		Min: image.Point{X: int(rect.Min.X), Y: int(rect.Min.Y)},
@@ -34,3 +34,43 @@ const (
 	MOS6581 = iota
 	MOS8580
 )
+
+// Voice registers. Offsets are relative to the first register of each voice.
+const (
+	REG_FREQ_LO         = 0x00
+	REG_FREQ_HI         = 0x01
+	REG_PW_LO           = 0x02
+	REG_PW_HI           = 0x03
+	REG_CONTROL         = 0x04
+	REG_ATTACK_DECAY    = 0x05
+	REG_SUSTAIN_RELEASE = 0x06
+
+	VoiceRegisters = 7
+)
+
+// Filter and misc registers
+const (
+	REG_FC_LO    = 0x15
+	REG_FC_HI    = 0x16
+	REG_RES_FILT = 0x17
+	REG_MODE_VOL = 0x18
+	REG_POTX     = 0x19
+	REG_POTY     = 0x1a
+	REG_OSC3     = 0x1b
+	REG_ENV3     = 0x1c
+)
+
+// Control register bits
+const (
+	CTRL_GATE     = 0x01
+	CTRL_SYNC     = 0x02
+	CTRL_RINGMOD  = 0x04
+	CTRL_TEST     = 0x08
+	CTRL_TRIANGLE = 0x10
+	CTRL_SAWTOOTH = 0x20
+	CTRL_PULSE    = 0x40
+	CTRL_NOISE    = 0x80
+)
+
+// PALClockFrequency is the system clock driving the SID in a PAL machine
+const PALClockFrequency = 985248
@@ -23,6 +23,7 @@ package sid
 
 import (
 	"github.com/cnkei/gospline"
+	"math"
 )
 
 // Maximum cutoff frequency is specified as
@@ -61,8 +62,9 @@ var f0Points8580X = []float64{
 var f0Points8580Y = []float64{0, 0, 800, 1600, 2500, 3300, 4100, 4800, 5600, 6500, 7500, 8400, 9200, 9800,
 	10500, 11000, 11700, 12500, 12500}
 
-var curve6581 gospline.Spline
-var curve8580 gospline.Spline
+// Cutoff frequency tables. FC is an 11 bit register.
+var f0Table6581 [2048]soundSample
+var f0Table8580 [2048]soundSample
 
 type Filter struct {
 	// User accessible filters
@@ -83,16 +85,142 @@ type Filter struct {
 	vnf soundSample // not filtered
 
 	// Cutoff frequency, resonance.
-	w0, w0Ceil1, w0CeilDt soundSample
-	Q1024div              soundSample
-
-	// Cutoff frequency tables.
-	// FC is an 11 bit register.
-	/*sound_sample f0_6581[2048]
-	sound_sample f0_8580[2048]
-	sound_sample* f0
-	fc_point* f0_points
-	int f0_count */
+	w0, w0Ceil1 soundSample
+	Q1024div    soundSample
+
+	f0 *[2048]soundSample // Cutoff frequency table for the current chip model
+}
+
+func (f *Filter) setChipModel(model int) {
+	if model == MOS6581 {
+		// The mixer has a small input DC offset. This is found as follows:
+		//
+		// The "zero" output level of the mixer measured on the SID audio
+		// output pin is 5.50V at zero volume, and 5.44 at full
+		// volume. This yields a DC offset of (5.44V - 5.50V) = -0.06V.
+		//
+		// The DC offset is thus -0.06V/1.05V ~ -1/18 of the dynamic range
+		// of one voice.
+		f.mixerDC = -0xfff * 0xff / 18 >> 7
+		f.f0 = &f0Table6581
+	} else {
+		// No DC offsets in the MOS8580.
+		f.mixerDC = 0
+		f.f0 = &f0Table8580
+	}
+	f.setW0()
+	f.setQ()
+}
+
+func (f *Filter) setFcLo(data uint8) {
+	f.fc = f.fc&0x7f8 | reg12(data&0x07)
+	f.setW0()
+}
+
+func (f *Filter) setFcHi(data uint8) {
+	f.fc = reg12(data)<<3&0x7f8 | f.fc&0x007
+	f.setW0()
+}
+
+func (f *Filter) setResFilt(data uint8) {
+	f.res = reg8(data>>4) & 0x0f
+	f.setQ()
+	f.filt = reg8(data & 0x0f)
+}
+
+func (f *Filter) setModeVol(data uint8) {
+	f.voice3off = data&0x80 != 0
+	f.mode = reg8(data>>4) & 0x07
+	f.vol = reg4(data & 0x0f)
+}
+
+func (f *Filter) setW0() {
+	f.w0 = toW0(float64(f.f0[f.fc]))
+
+	// Limit f0 to 16kHz to keep 1 cycle filter stable.
+	w0Max1 := toW0(16000)
+	f.w0Ceil1 = f.w0
+	if f.w0Ceil1 > w0Max1 {
+		f.w0Ceil1 = w0Max1
+	}
+}
+
+// Convert a cutoff frequency to angular frequency. We multiply with 1.048576 to
+// facilitate division by 1 000 000 by right-shifting 20 times (2 ^ 20 = 1048576).
+func toW0(f0 float64) soundSample {
+	return soundSample(2 * math.Pi * f0 * 1.048576)
+}
+
+func (f *Filter) setQ() {
+	// Q is controlled linearly by res. Q has approximate range [0.707, 1.7].
+	// As resonance is increased, the filter must be clocked more often to keep
+	// stable.
+
+	// The coefficient 1024 is dispensed of later by right-shifting 10 times
+	// (2 ^ 10 = 1024).
+	f.Q1024div = soundSample(1024.0 / (0.707 + 1.0*float64(f.res)/15.0))
+}
+
+// Clock the filter one cycle. The voice inputs are 20 bit values.
+func (f *Filter) clock(voice1, voice2, voice3, extIn soundSample) {
+	// Scale each voice down from 20 to 13 bits.
+	voice1 >>= 7
+	voice2 >>= 7
+
+	// NB! Voice 3 is not silenced by voice3off if it is routed through
+	// the filter.
+	if f.voice3off && f.filt&0x04 == 0 {
+		voice3 = 0
+	} else {
+		voice3 >>= 7
+	}
+	extIn >>= 7
+
+	if !f.enabled {
+		f.vnf = voice1 + voice2 + voice3 + extIn
+		f.vhp = 0
+		f.vbp = 0
+		f.vlp = 0
+		return
+	}
+
+	// Route voices into or around the filter.
+	var vi soundSample
+	vi, f.vnf = 0, 0
+	for i, v := range [4]soundSample{voice1, voice2, voice3, extIn} {
+		if f.filt&(1<<uint(i)) != 0 {
+			vi += v
+		} else {
+			f.vnf += v
+		}
+	}
+
+	// delta_t = 1 is converted to seconds given a 1MHz clock by dividing
+	// with 1 000 000.
+	dVbp := f.w0Ceil1 * f.vhp >> 20
+	dVlp := f.w0Ceil1 * f.vbp >> 20
+	f.vbp -= dVbp
+	f.vlp -= dVlp
+	f.vhp = (f.vbp * f.Q1024div >> 10) - f.vlp - vi
+}
+
+// Returns the mixed output of the filter and the unfiltered voices, scaled by the
+// master volume.
+func (f *Filter) output() soundSample {
+	if !f.enabled {
+		return (f.vnf + f.mixerDC) * soundSample(f.vol)
+	}
+	var vf soundSample
+	if f.mode&0x01 != 0 {
+		vf += f.vlp
+	}
+	if f.mode&0x02 != 0 {
+		vf += f.vbp
+	}
+	if f.mode&0x04 != 0 {
+		vf += f.vhp
+	}
+	return (f.vnf + vf + f.mixerDC) * soundSample(f.vol)
 }
 
 func (f *Filter) reset() {
@@ -111,31 +239,29 @@ func (f *Filter) reset() {
 	f.vnf = 0
 
 	// Initialize filter parameters
-	//f.setW0()
-	//f.setQ()
+	f.setW0()
+	f.setQ()
 }
 
-/*
-
-func (f *Filter) setW0() {
-	// Multiply with 1.048576 to facilitate division by 1 000 000 by right-
-	// shifting 20 times (2 ^ 20 = 1048576).
-	w0 := soundSample(2 * math.Pi * f0[f.fc] * 1.048576)
-
-	// Limit f0 to 16kHz to keep 1 cycle filter stable.
-	const sound_sample w0_max_1 = static_cast < sound_sample > (2 * pi * 16000 * 1.048576)
-	w0_ceil_1 = w0 <= w0_max_1 ? w0:
-	w0_max_1
-
-	// Limit f0 to 4kHz to keep delta_t cycle filter stable.
-	const sound_sample w0_max_dt = static_cast < sound_sample > (2 * pi * 4000 * 1.048576)
-	w0_ceil_dt = w0 <= w0_max_dt ? w0:
-	w0_max_dt
+// Interpolate a cutoff frequency table from the measured points. The point lists repeat
+// their end points, which the spline can't handle, so we drop any repeated points first.
+func interpolateCurve(xs, ys []float64, table *[2048]soundSample) {
+	var x, y []float64
+	for i := range xs {
+		if i > 0 && xs[i] == xs[i-1] {
+			continue
+		}
+		x = append(x, xs[i])
+		y = append(y, ys[i])
+	}
+	curve := gospline.NewMonotoneSpline(x, y)
+	for i := range table {
+		table[i] = soundSample(curve.At(float64(i)))
+	}
 }
 
 func init() {
 	// Pre-calculate smoothed filter curves
-	curve6581 = gospline.NewCubicSpline(f0Points6581X, f0Points6581Y)
-	curve8580 = gospline.NewCubicSpline(f0Points8580X, f0Points8580Y)
+	interpolateCurve(f0Points6581X, f0Points6581Y, &f0Table6581)
+	interpolateCurve(f0Points8580X, f0Points8580Y, &f0Table8580)
 }
-*/
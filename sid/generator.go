@@ -28,6 +28,7 @@ type Source interface {
 type Generator struct {
 	test     bool // Test mode (voice disabled, pulse output perpetual high)
 	ringMod  bool // Ring modulator enabled
+	sync     bool // Hard sync with the sync source enabled
 	waveform reg8 // Waveform register
 
 	syncTrigger bool // Triggers voice synch
@@ -56,6 +57,7 @@ func NewGenerator() *Generator {
 		wavePST: wave8580PST,
 	}
 	g.syncSource = g
+	g.syncTarget = g
 	g.reset()
 	return g
 }
@@ -72,11 +74,11 @@ func (g *Generator) Clock() {
 	g.accumulator += reg24(g.freq)
 	g.accumulator &= 0xffffff
 
-	// Did we flip bit 19?
+	// Did we flip the MSB? That's what triggers synchronization of the target oscillator.
 	g.syncTrigger = oldAcc&0x800000 == 0 && (g.accumulator&0x800000) != 0
 
 	// Shift noise register once for each time accumulator bit 19 is set high.
-	if g.syncTrigger {
+	if oldAcc&0x080000 == 0 && g.accumulator&0x080000 != 0 {
 		bit0 := ((g.shiftRegister >> 22) ^ (g.shiftRegister >> 17)) & 0x1
 		g.shiftRegister <<= 1
 		g.shiftRegister &= 0x7fffff
@@ -84,6 +86,67 @@ func (g *Generator) Clock() {
 	}
 }
 
+// Hard sync the target oscillator if our MSB went high during this cycle. A special case
+// occurs when we were synced ourselves on the same cycle as our MSB went high. In that
+// case, the target won't be synced.
+func (g *Generator) synchronize() {
+	if g.syncTrigger && g.syncTarget.sync && !(g.sync && g.syncSource.syncTrigger) {
+		g.syncTarget.accumulator = 0
+	}
+}
+
+func (g *Generator) setSyncSource(source *Generator) {
+	g.syncSource = source
+	source.syncTarget = g
+}
+
+func (g *Generator) setChipModel(model int) {
+	if model == MOS6581 {
+		g.wavePS = wave6581PS
+		g.wavePT = wave6581PT
+		g.waveST = wave6581ST
+		g.wavePST = wave6581PST
+	} else {
+		g.wavePS = wave8580PS
+		g.wavePT = wave8580PT
+		g.waveST = wave8580ST
+		g.wavePST = wave8580PST
+	}
+}
+
+func (g *Generator) setFreqLo(data uint8) {
+	g.freq = g.freq&0xff00 | reg16(data)
+}
+
+func (g *Generator) setFreqHi(data uint8) {
+	g.freq = g.freq&0x00ff | reg16(data)<<8
+}
+
+func (g *Generator) setPulseWidthLo(data uint8) {
+	g.pulseWidth = g.pulseWidth&0xf00 | reg12(data)
+}
+
+func (g *Generator) setPulseWidthHi(data uint8) {
+	g.pulseWidth = g.pulseWidth&0x0ff | reg12(data&0x0f)<<8
+}
+
+func (g *Generator) setControl(control uint8) {
+	g.waveform = reg8(control >> 4)
+	g.ringMod = control&CTRL_RINGMOD != 0
+	g.sync = control&CTRL_SYNC != 0
+	testNext := control&CTRL_TEST != 0
+
+	// Setting the test bit clears the accumulator and the noise shift register. Once the
+	// test bit is cleared, the accumulator starts counting and the shift register is reset.
+	if testNext {
+		g.accumulator = 0
+		g.shiftRegister = 0
+	} else if g.test {
+		g.shiftRegister = 0x7ffff8
+	}
+	g.test = testNext
+}
+
 func (g *Generator) genSawtooth() reg12 {
 	return reg12(g.accumulator >> 12)
 }
@@ -170,7 +233,7 @@ func (g *Generator) reset() {
 
 	g.test = false
 	g.ringMod = false
-	//g.sync = false
+	g.sync = false
 
 	g.syncTrigger = false
 }
@@ -0,0 +1,271 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package sid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The MIDI file uses a tempo of 120 BPM and a division of 500 ticks per quarter
+// note, which makes one tick exactly one millisecond.
+const (
+	midiDivision = 500
+	midiTempo    = 500000 // Microseconds per quarter note
+	drumChannel  = 9
+)
+
+// General MIDI programs (zero based) used for sustained and percussive sounds
+// respectively, indexed by the waveform bits of the control register.
+var sustainedPrograms = map[uint8]uint8{
+	CTRL_TRIANGLE: 73, // Flute
+	CTRL_SAWTOOTH: 81, // Lead 2 (sawtooth)
+	CTRL_PULSE:    80, // Lead 1 (square)
+}
+
+var percussivePrograms = map[uint8]uint8{
+	CTRL_TRIANGLE: 12, // Marimba
+	CTRL_SAWTOOTH: 38, // Synth bass 1
+	CTRL_PULSE:    6,  // Harpsichord
+}
+
+type midiEvent struct {
+	tick uint64
+	data []byte
+}
+
+type midiVoice struct {
+	channel    uint8
+	freq       uint16
+	control    uint8
+	ad         uint8
+	sr         uint8
+	note       int    // Currently playing note or -1
+	noteChan   uint8  // Channel the current note is playing on
+	instrument uint32 // Waveform and ADSR of the current program, or 0xffffffff if none
+	events     []midiEvent
+}
+
+// ConvertToMIDI turns a log of register writes into a format 1 standard MIDI file
+// with one track per voice. Notes are derived from the frequency and gate bits.
+// Every distinct combination of waveform and ADSR settings becomes an instrument
+// that's mapped to the closest general MIDI program and named after its settings.
+// Voices playing noise are mapped to drums.
+func ConvertToMIDI(header LogHeader, entries []LogEntry, w io.Writer) error {
+	if header.ClockFreq == 0 {
+		return errors.New("no clock frequency to convert cycles to time")
+	}
+	voices := make([]*midiVoice, 3)
+	for i := range voices {
+		voices[i] = &midiVoice{channel: uint8(i), note: -1, instrument: 0xffffffff}
+	}
+	vol := uint8(0x0f)
+	var firstCycle, tick uint64
+	if len(entries) > 0 {
+		firstCycle = entries[0].Cycle
+	}
+	for _, e := range entries {
+		tick = ((e.Cycle-firstCycle)*1000 + uint64(header.ClockFreq)/2) / uint64(header.ClockFreq)
+		reg := e.Reg & 0x1f
+		if reg == REG_MODE_VOL {
+			vol = e.Data & 0x0f
+			continue
+		}
+		if reg >= 3*VoiceRegisters {
+			continue
+		}
+		v := voices[reg/VoiceRegisters]
+		switch reg % VoiceRegisters {
+		case REG_FREQ_LO:
+			v.freq = v.freq&0xff00 | uint16(e.Data)
+			v.retrigger(tick, header.ClockFreq, vol)
+		case REG_FREQ_HI:
+			v.freq = v.freq&0x00ff | uint16(e.Data)<<8
+			v.retrigger(tick, header.ClockFreq, vol)
+		case REG_CONTROL:
+			wasGated := v.control&CTRL_GATE != 0
+			v.control = e.Data
+			gated := v.control&CTRL_GATE != 0
+			if gated && !wasGated {
+				v.noteOn(tick, header.ClockFreq, vol)
+			} else if !gated && wasGated {
+				v.noteOff(tick)
+			}
+		case REG_ATTACK_DECAY:
+			v.ad = e.Data
+		case REG_SUSTAIN_RELEASE:
+			v.sr = e.Data
+		}
+	}
+	for _, v := range voices {
+		v.noteOff(tick)
+	}
+
+	// Write the header chunk, a conductor track and one track per voice
+	var buf bytes.Buffer
+	buf.WriteString("MThd")
+	binary.Write(&buf, binary.BigEndian, uint32(6))
+	binary.Write(&buf, binary.BigEndian, []uint16{1, uint16(len(voices) + 1), midiDivision})
+	writeTrack(&buf, []midiEvent{{0, []byte{0xff, 0x51, 0x03, midiTempo >> 16, midiTempo >> 8 & 0xff, midiTempo & 0xff}}})
+	for i, v := range voices {
+		name := fmt.Sprintf("Voice %d", i+1)
+		events := append([]midiEvent{{0, metaText(0x03, name)}}, v.events...)
+		writeTrack(&buf, events)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// If the frequency changes while a note is playing, we treat it as a new note unless
+// it's too small a change to make a difference.
+func (v *midiVoice) retrigger(tick uint64, clockFreq uint32, vol uint8) {
+	if v.note < 0 || v.noteChan == drumChannel {
+		return
+	}
+	if v.noteNumber(clockFreq) != v.note {
+		v.noteOff(tick)
+		v.noteOn(tick, clockFreq, vol)
+	}
+}
+
+func (v *midiVoice) noteOn(tick uint64, clockFreq uint32, vol uint8) {
+	waveform := v.control & 0xf0
+	if v.control&CTRL_TEST != 0 || waveform == 0 || v.freq == 0 {
+		return
+	}
+	note := v.noteNumber(clockFreq)
+	channel := v.channel
+	if waveform&CTRL_NOISE != 0 {
+		channel = drumChannel
+		note = drumNote(note)
+	} else {
+		v.selectInstrument(tick, waveform)
+	}
+	velocity := uint8(1 + int(vol)*126/15)
+	v.events = append(v.events, midiEvent{tick, []byte{0x90 | channel, uint8(note), velocity}})
+	v.note = note
+	v.noteChan = channel
+}
+
+func (v *midiVoice) noteOff(tick uint64) {
+	if v.note < 0 {
+		return
+	}
+	v.events = append(v.events, midiEvent{tick, []byte{0x80 | v.noteChan, uint8(v.note), 0}})
+	v.note = -1
+}
+
+// Emit a program change and an instrument name if the waveform or ADSR settings
+// have changed since the last note.
+func (v *midiVoice) selectInstrument(tick uint64, waveform uint8) {
+	instrument := uint32(waveform) | uint32(v.ad)<<8 | uint32(v.sr)<<16
+	if instrument == v.instrument {
+		return
+	}
+	v.instrument = instrument
+	programs := sustainedPrograms
+	if v.sr&0xf0 == 0 {
+		programs = percussivePrograms
+	}
+	program := programs[CTRL_PULSE] // Combined waveforms sound closest to a pulse
+	for _, w := range []uint8{CTRL_PULSE, CTRL_SAWTOOTH, CTRL_TRIANGLE} {
+		if waveform == w {
+			program = programs[w]
+		}
+	}
+	name := fmt.Sprintf("%s A%X D%X S%X R%X", waveformName(waveform), v.ad>>4, v.ad&0x0f, v.sr>>4, v.sr&0x0f)
+	v.events = append(v.events,
+		midiEvent{tick, metaText(0x04, name)},
+		midiEvent{tick, []byte{0xc0 | v.channel, program}})
+}
+
+// Convert the oscillator frequency to the closest MIDI note. Fout = Fn*Fclk/2^24.
+func (v *midiVoice) noteNumber(clockFreq uint32) int {
+	hz := float64(v.freq) * float64(clockFreq) / (1 << 24)
+	note := int(math.Round(69 + 12*math.Log2(hz/440)))
+	if note < 0 {
+		return 0
+	}
+	if note > 127 {
+		return 127
+	}
+	return note
+}
+
+// Map the pitch of noise to a kick drum, a snare drum or a closed hihat.
+func drumNote(note int) int {
+	switch {
+	case note < 60:
+		return 36
+	case note < 90:
+		return 38
+	default:
+		return 42
+	}
+}
+
+func waveformName(waveform uint8) string {
+	name := ""
+	for _, w := range []struct {
+		bit  uint8
+		name string
+	}{{CTRL_TRIANGLE, "Triangle"}, {CTRL_SAWTOOTH, "Sawtooth"}, {CTRL_PULSE, "Pulse"}, {CTRL_NOISE, "Noise"}} {
+		if waveform&w.bit != 0 {
+			if name != "" {
+				name += "+"
+			}
+			name += w.name
+		}
+	}
+	return name
+}
+
+func metaText(kind uint8, text string) []byte {
+	return append(append([]byte{0xff, kind}, varLen(uint64(len(text)))...), text...)
+}
+
+func writeTrack(buf *bytes.Buffer, events []midiEvent) {
+	var track bytes.Buffer
+	last := uint64(0)
+	for _, e := range events {
+		track.Write(varLen(e.tick - last))
+		track.Write(e.data)
+		last = e.tick
+	}
+	track.Write([]byte{0x00, 0xff, 0x2f, 0x00}) // End of track
+	buf.WriteString("MTrk")
+	binary.Write(buf, binary.BigEndian, uint32(track.Len()))
+	buf.Write(track.Bytes())
+}
+
+// Encode a number as a MIDI variable length quantity
+func varLen(n uint64) []byte {
+	b := []byte{uint8(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		b = append([]byte{uint8(n&0x7f) | 0x80}, b...)
+	}
+	return b
+}
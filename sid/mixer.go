@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package sid
+
+// AudioSink receives the final stereo mix of all sound chips at the sample rate
+// the mixer was created with.
+type AudioSink interface {
+	WriteSample(left, right int16)
+}
+
+type multiSink []AudioSink
+
+// MultiSink creates a sink that passes every sample on to all of the given sinks,
+// e.g. to play the sound while also recording it.
+func MultiSink(sinks ...AudioSink) AudioSink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) WriteSample(left, right int16) {
+	for _, s := range m {
+		s.WriteSample(left, right)
+	}
+}
+
+type mixerChannel struct {
+	chip  *SID
+	left  int // Left gain (0-256)
+	right int // Right gain (0-256)
+}
+
+// Mixer clocks one or more SID chips and mixes their output down to a stereo
+// signal that's delivered to an AudioSink. The output of every chip is averaged over
+// all cycles making up a sample rather than just sampled once per sample period. That
+// way, writes to the volume register between samples are still heard, which is
+// what most digi players rely on.
+type Mixer struct {
+	channels    []mixerChannel
+	sink        AudioSink
+	cycleStep   uint64 // Fraction of a sample produced every cycle (16.16 fixed point)
+	sampleClock uint64
+	sumLeft     int
+	sumRight    int
+	cycles      int
+}
+
+func NewMixer(clockFreq, sampleRate int, sink AudioSink) *Mixer {
+	return &Mixer{
+		sink:      sink,
+		cycleStep: uint64(sampleRate) << 16 / uint64(clockFreq),
+	}
+}
+
+// Attach adds a chip to the mix. The pan ranges from -1.0 (hard left) through 0.0
+// (center) to 1.0 (hard right). Centered chips play at full volume in both channels.
+func (m *Mixer) Attach(chip *SID, pan float64) {
+	if pan < -1 {
+		pan = -1
+	}
+	if pan > 1 {
+		pan = 1
+	}
+	left := 1 - pan
+	right := 1 + pan
+	if left > 1 {
+		left = 1
+	}
+	if right > 1 {
+		right = 1
+	}
+	m.channels = append(m.channels, mixerChannel{
+		chip:  chip,
+		left:  int(left * 256),
+		right: int(right * 256),
+	})
+}
+
+func (m *Mixer) Clock() {
+	for i := range m.channels {
+		m.channels[i].chip.Clock()
+	}
+	if m.sink == nil {
+		return
+	}
+	m.mix()
+	m.sampleClock += m.cycleStep
+	if m.sampleClock < 1<<16 {
+		return
+	}
+	m.sampleClock -= 1 << 16
+	m.sink.WriteSample(clip16(m.sumLeft/m.cycles), clip16(m.sumRight/m.cycles))
+	m.sumLeft = 0
+	m.sumRight = 0
+	m.cycles = 0
+}
+
+// Chips are simply added together, just like the outputs of multiple SIDs
+// connected to the same amplifier would be.
+func (m *Mixer) mix() {
+	for _, c := range m.channels {
+		sample := int(c.chip.Output())
+		m.sumLeft += sample * c.left >> 8
+		m.sumRight += sample * c.right >> 8
+	}
+	m.cycles++
+}
+
+func clip16(sample int) int16 {
+	if sample > 32767 {
+		return 32767
+	}
+	if sample < -32768 {
+		return -32768
+	}
+	return int16(sample)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package sid
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A SID log starts with a header consisting of the magic string, a format version,
+// the chip model and the clock frequency in Hz as a little endian uint32. The header
+// is followed by one entry per register write, each consisting of the number of
+// cycles since the previous write (as an unsigned varint), the register number and
+// the value written.
+const (
+	logMagic   = "SIDL"
+	logVersion = 1
+)
+
+// WriteRecorder is notified of every write to the registers of a SID.
+type WriteRecorder interface {
+	RecordWrite(cycle uint64, reg uint8, data uint8)
+}
+
+type LogHeader struct {
+	Model     int
+	ClockFreq uint32
+}
+
+type LogEntry struct {
+	Cycle uint64 // Absolute cycle of the write
+	Reg   uint8
+	Data  uint8
+}
+
+// LogWriter writes a compact binary log of register writes. Install it on a chip
+// using SID.SetRecorder and call Close when done.
+type LogWriter struct {
+	out       *bufio.Writer
+	closer    io.Closer
+	lastCycle uint64
+	started   bool
+	err       error
+	buf       [binary.MaxVarintLen64 + 2]byte
+}
+
+func NewLogWriter(w io.WriteCloser, header LogHeader) (*LogWriter, error) {
+	l := &LogWriter{out: bufio.NewWriter(w), closer: w}
+	var h [10]byte
+	copy(h[:], logMagic)
+	h[4] = logVersion
+	h[5] = uint8(header.Model)
+	binary.LittleEndian.PutUint32(h[6:], header.ClockFreq)
+	if _, err := l.out.Write(h[:]); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LogWriter) RecordWrite(cycle uint64, reg uint8, data uint8) {
+	if l.err != nil {
+		return
+	}
+	// Timestamps are stored relative to the first write
+	if !l.started {
+		l.lastCycle = cycle
+		l.started = true
+	}
+	n := binary.PutUvarint(l.buf[:], cycle-l.lastCycle)
+	l.buf[n] = reg
+	l.buf[n+1] = data
+	_, l.err = l.out.Write(l.buf[:n+2])
+	l.lastCycle = cycle
+}
+
+// Close flushes the log and closes the underlying writer. Returns the first error
+// encountered while writing, if any.
+func (l *LogWriter) Close() error {
+	if err := l.out.Flush(); err != nil && l.err == nil {
+		l.err = err
+	}
+	if err := l.closer.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
+
+func ReadLog(r io.Reader) (LogHeader, []LogEntry, error) {
+	in := bufio.NewReader(r)
+	var h [10]byte
+	if _, err := io.ReadFull(in, h[:]); err != nil {
+		return LogHeader{}, nil, err
+	}
+	if string(h[:4]) != logMagic {
+		return LogHeader{}, nil, errors.New("not a SID log")
+	}
+	if h[4] != logVersion {
+		return LogHeader{}, nil, fmt.Errorf("unsupported SID log version: %d", h[4])
+	}
+	header := LogHeader{
+		Model:     int(h[5]),
+		ClockFreq: binary.LittleEndian.Uint32(h[6:]),
+	}
+	if header.ClockFreq == 0 {
+		return LogHeader{}, nil, errors.New("SID log has no clock frequency")
+	}
+	var entries []LogEntry
+	cycle := uint64(0)
+	for {
+		delta, err := binary.ReadUvarint(in)
+		if err == io.EOF {
+			return header, entries, nil
+		}
+		if err != nil {
+			return header, entries, err
+		}
+		var rd [2]byte
+		if _, err := io.ReadFull(in, rd[:]); err != nil {
+			return header, entries, fmt.Errorf("truncated SID log: %w", err)
+		}
+		cycle += delta
+		entries = append(entries, LogEntry{Cycle: cycle, Reg: rd[0], Data: rd[1]})
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package sid
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func recordLog(t *testing.T, play func(s *SID)) *bufferCloser {
+	buf := &bufferCloser{}
+	l, err := NewLogWriter(buf, LogHeader{Model: MOS8580, ClockFreq: PALClockFrequency})
+	require.NoError(t, err)
+	s := NewSID(MOS8580)
+	s.SetRecorder(l)
+	play(s)
+	require.NoError(t, l.Close())
+	return buf
+}
+
+func TestLogWriter_RoundTrip(t *testing.T) {
+	buf := recordLog(t, func(s *SID) {
+		for i := 0; i < 100; i++ {
+			s.Clock()
+		}
+		s.WriteByte(REG_MODE_VOL, 0x0f)
+		for i := 0; i < 1000; i++ {
+			s.Clock()
+		}
+		s.WriteByte(0x20+REG_CONTROL, 0x11) // Mirrored
+	})
+	// Cycles are relative to the first write
+	header, entries, err := ReadLog(buf)
+	require.NoError(t, err)
+	require.Equal(t, LogHeader{Model: MOS8580, ClockFreq: PALClockFrequency}, header)
+	require.Equal(t, []LogEntry{
+		{Cycle: 0, Reg: REG_MODE_VOL, Data: 0x0f},
+		{Cycle: 1000, Reg: REG_CONTROL, Data: 0x11},
+	}, entries)
+
+	_, _, err = ReadLog(bytes.NewReader([]byte("MThd\x00\x00\x00\x06\x00\x01")))
+	require.Error(t, err)
+
+	// A corrupt header without a clock frequency
+	_, _, err = ReadLog(bytes.NewReader(append([]byte(logMagic), logVersion, MOS8580, 0, 0, 0, 0)))
+	require.Error(t, err)
+	require.Error(t, ConvertToMIDI(LogHeader{}, entries, ioutil.Discard))
+}
+
+func TestConvertToMIDI(t *testing.T) {
+	buf := recordLog(t, func(s *SID) {
+		wait := func(ms int) {
+			for i := 0; i < ms*PALClockFrequency/1000; i++ {
+				s.Clock()
+			}
+		}
+		s.WriteByte(REG_MODE_VOL, 0x0f)
+		s.WriteByte(REG_FREQ_LO, 0xd6) // A4 (440 Hz) is 0x1cd6 on a PAL machine
+		s.WriteByte(REG_FREQ_HI, 0x1c)
+		s.WriteByte(REG_ATTACK_DECAY, 0x09)
+		s.WriteByte(REG_SUSTAIN_RELEASE, 0x00)
+		s.WriteByte(REG_CONTROL, CTRL_PULSE|CTRL_GATE)
+		wait(10)
+		s.WriteByte(REG_CONTROL, CTRL_PULSE)
+	})
+	header, entries, err := ReadLog(buf)
+	require.NoError(t, err)
+	var midi bytes.Buffer
+	require.NoError(t, ConvertToMIDI(header, entries, &midi))
+	data := midi.Bytes()
+	require.Equal(t, "MThd", string(data[:4]))
+	require.Equal(t, []byte{0, 1, 0, 4, 0x01, 0xf4}, data[8:14], "Format 1, 4 tracks, 500 ticks per quarter")
+
+	// The first voice should play A4 for 10ms with a harpsichord
+	require.True(t, bytes.Contains(data, []byte("Pulse A0 D9 S0 R0")))
+	require.True(t, bytes.Contains(data, []byte{0xc0, 6}), "Program change missing")
+	require.True(t, bytes.Contains(data, []byte{0x90, 69, 127, 10, 0x80, 69, 0}), "Note on/off missing")
+}
@@ -21,6 +21,201 @@
 
 package sid
 
+// The POT inputs are sampled once every 512 cycles. During the first half of the
+// period, the external capacitor is discharged and during the second half the SID
+// counts until it's charged again.
+const PotSamplePeriod = 512
+
+// The 8580 has no DC offset in its mixer, so digis played through the volume register
+// are almost inaudible. The common "digi boost" hardware fix feeds a DC level into EXT IN
+// to make up for this. We inject roughly the DC offset of one 6581 voice.
+const digiBoostLevel = soundSample(0x800 * 0xff)
+
+// PotSource is whatever is connected to the POTX and POTY pins of the SID.
+type PotSource interface {
+	// ReadPots returns the values the A/D converters would settle on for POTX and POTY.
+	ReadPots() (uint8, uint8)
+}
+
 type SID struct {
-	voices [3]*Generator
+	voices [3]*Voice
+	filter Filter
+	model  int
+
+	digiBoost bool // Feed a DC level into EXT IN to make volume register digis audible on 8580s
+
+	// Paddle A/D converters
+	pots       PotSource
+	potX       uint8
+	potY       uint8
+	potCounter int
+
+	busValue  uint8       // Last value written. Reading a write-only register returns it.
+	registers [0x20]uint8 // Last value written to each register, for snapshots
+
+	cycles   uint64        // Number of cycles clocked since the chip was created
+	recorder WriteRecorder // Notified of every register write, if set
+}
+
+func NewSID(model int) *SID {
+	s := &SID{model: model}
+	for i := range s.voices {
+		g := NewGenerator()
+		g.setChipModel(model)
+		s.voices[i] = NewVoice(g, &EnvelopeGenerator{}, model)
+	}
+
+	// Each oscillator is synced and ring modulated by the previous one
+	for i, v := range s.voices {
+		v.generator.setSyncSource(s.voices[(i+2)%3].generator)
+	}
+	s.filter.enabled = true
+	s.filter.setChipModel(model)
+	s.filter.reset()
+	s.potX = 0xff
+	s.potY = 0xff
+	return s
+}
+
+// SetDigiBoost turns the digi boost modification on or off. Only has an effect on the
+// 8580, since the 6581 has enough DC offset in its mixer to play digis anyway.
+func (s *SID) SetDigiBoost(on bool) {
+	s.digiBoost = on && s.model == MOS8580
+}
+
+// SetRecorder installs a recorder that's notified of every register write. Pass nil
+// to stop recording.
+func (s *SID) SetRecorder(recorder WriteRecorder) {
+	s.recorder = recorder
+}
+
+func (s *SID) SetPotSource(pots PotSource) {
+	s.pots = pots
+}
+
+func (s *SID) Model() int {
+	return s.model
+}
+
+func (s *SID) ReadByte(addr uint16) uint8 {
+	addr &= 0x1f
+	switch addr {
+	case REG_POTX:
+		return s.potX
+	case REG_POTY:
+		return s.potY
+	case REG_OSC3:
+		return uint8(s.voices[2].generator.ReadOutput() >> 4)
+	case REG_ENV3:
+		return uint8(s.voices[2].envelope.ReadOutput())
+	}
+	return s.busValue
+}
+
+func (s *SID) WriteByte(addr uint16, data uint8) {
+	addr &= 0x1f
+	s.busValue = data
+	s.registers[addr] = data
+	if s.recorder != nil {
+		s.recorder.RecordWrite(s.cycles, uint8(addr), data)
+	}
+	if addr < 3*VoiceRegisters {
+		v := s.voices[addr/VoiceRegisters]
+		switch addr % VoiceRegisters {
+		case REG_FREQ_LO:
+			v.generator.setFreqLo(data)
+		case REG_FREQ_HI:
+			v.generator.setFreqHi(data)
+		case REG_PW_LO:
+			v.generator.setPulseWidthLo(data)
+		case REG_PW_HI:
+			v.generator.setPulseWidthHi(data)
+		case REG_CONTROL:
+			v.generator.setControl(data)
+			v.envelope.setControl(reg8(data))
+		case REG_ATTACK_DECAY:
+			v.envelope.setAttackDecay(data)
+		case REG_SUSTAIN_RELEASE:
+			v.envelope.setSustainRelease(data)
+		}
+		return
+	}
+	switch addr {
+	case REG_FC_LO:
+		s.filter.setFcLo(data)
+	case REG_FC_HI:
+		s.filter.setFcHi(data)
+	case REG_RES_FILT:
+		s.filter.setResFilt(data)
+	case REG_MODE_VOL:
+		s.filter.setModeVol(data)
+	}
+}
+
+// Clock advances the chip by one system clock cycle.
+func (s *SID) Clock() {
+	s.cycles++
+	for _, v := range s.voices {
+		v.envelope.Clock()
+	}
+	for _, v := range s.voices {
+		v.generator.Clock()
+	}
+	for _, v := range s.voices {
+		v.generator.synchronize()
+	}
+	extIn := soundSample(0)
+	if s.digiBoost {
+		extIn = digiBoostLevel
+	}
+	s.filter.clock(s.voices[0].ReadOutput(), s.voices[1].ReadOutput(), s.voices[2].ReadOutput(), extIn)
+
+	// Latch new paddle values at the end of each sampling period. Nothing connected
+	// means the capacitor never charges and the counter saturates.
+	s.potCounter++
+	if s.potCounter >= PotSamplePeriod {
+		s.potCounter = 0
+		if s.pots != nil {
+			s.potX, s.potY = s.pots.ReadPots()
+		} else {
+			s.potX, s.potY = 0xff, 0xff
+		}
+	}
+}
+
+// Output returns the current output level of the chip as a 16 bit sample.
+func (s *SID) Output() int16 {
+	// Scale the 20 bit output of the filter to 16 bits and clip.
+	const divisor = (4095 * 255 >> 7) * 3 * 15 * 2 / (1 << 16)
+	return clip16(int(s.filter.output() / divisor))
+}
+
+func (s *SID) Reset() {
+	for _, v := range s.voices {
+		v.reset()
+	}
+	s.filter.reset()
+	s.potCounter = 0
+	s.busValue = 0
+	s.registers = [0x20]uint8{}
+}
+
+// State is the part of the SID state that is saved in snapshots. Only the register
+// contents are saved, so oscillators and envelopes restart from the register values.
+type State struct {
+	Registers [0x20]uint8
+}
+
+func (s *SID) State() State {
+	return State{Registers: s.registers}
+}
+
+// SetState restores the registers from a snapshot. The writes aren't recorded.
+func (s *SID) SetState(state State) {
+	recorder := s.recorder
+	s.recorder = nil
+	for addr, data := range state.Registers[:REG_POTX] {
+		s.WriteByte(uint16(addr), data)
+	}
+	s.recorder = recorder
 }
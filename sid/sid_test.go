@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package sid
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type sampleRecorder struct {
+	left  []int16
+	right []int16
+}
+
+func (s *sampleRecorder) WriteSample(left, right int16) {
+	s.left = append(s.left, left)
+	s.right = append(s.right, right)
+}
+
+func TestSID_Oscillator3(t *testing.T) {
+	s := NewSID(MOS8580)
+	s.WriteByte(2*VoiceRegisters+REG_FREQ_HI, 0x10)
+	s.WriteByte(2*VoiceRegisters+REG_CONTROL, CTRL_SAWTOOTH)
+	last := s.ReadByte(REG_OSC3)
+	for i := 0; i < 1000; i++ {
+		s.Clock()
+	}
+	require.NotEqual(t, last, s.ReadByte(REG_OSC3), "Oscillator 3 should be running")
+	require.Equal(t, uint8(0), s.ReadByte(REG_ENV3), "Envelope should be silent without gate")
+
+	s.WriteByte(2*VoiceRegisters+REG_CONTROL, CTRL_SAWTOOTH|CTRL_GATE)
+	for i := 0; i < 1000; i++ {
+		s.Clock()
+	}
+	require.NotEqual(t, uint8(0), s.ReadByte(REG_ENV3), "Envelope should be in attack phase")
+}
+
+func TestSID_Mirroring(t *testing.T) {
+	s := NewSID(MOS6581)
+	s.WriteByte(0x20+2*VoiceRegisters+REG_CONTROL, CTRL_TEST)
+	require.True(t, s.voices[2].generator.test, "Registers should be mirrored every 32 bytes")
+}
+
+type fixedPots struct {
+	x, y uint8
+}
+
+func (f *fixedPots) ReadPots() (uint8, uint8) {
+	return f.x, f.y
+}
+
+func TestSID_PotSampling(t *testing.T) {
+	s := NewSID(MOS6581)
+	require.Equal(t, uint8(0xff), s.ReadByte(REG_POTX), "Unconnected POTX should read 0xff")
+	pots := &fixedPots{x: 0x12, y: 0x34}
+	s.SetPotSource(pots)
+	for i := 0; i < PotSamplePeriod-1; i++ {
+		s.Clock()
+	}
+	require.Equal(t, uint8(0xff), s.ReadByte(REG_POTX), "POTX should not change until the end of the period")
+	s.Clock()
+	require.Equal(t, uint8(0x12), s.ReadByte(REG_POTX))
+	require.Equal(t, uint8(0x34), s.ReadByte(REG_POTY))
+}
+
+func TestMixer_Pan(t *testing.T) {
+	rec := &sampleRecorder{}
+	m := NewMixer(PALClockFrequency, 44100, rec)
+	left := NewSID(MOS8580)
+	right := NewSID(MOS8580)
+	m.Attach(left, -1)
+	m.Attach(right, 1)
+
+	// Play a square wave on the left chip only
+	left.WriteByte(REG_MODE_VOL, 0x0f)
+	left.WriteByte(REG_ATTACK_DECAY, 0x00)
+	left.WriteByte(REG_SUSTAIN_RELEASE, 0xf0)
+	left.WriteByte(REG_PW_HI, 0x08)
+	left.WriteByte(REG_FREQ_HI, 0x10)
+	left.WriteByte(REG_CONTROL, CTRL_PULSE|CTRL_GATE)
+	right.WriteByte(REG_MODE_VOL, 0x0f)
+	for i := 0; i < PALClockFrequency/10; i++ {
+		m.Clock()
+	}
+	require.InDelta(t, 4410, len(rec.left), 1, "Wrong number of samples produced")
+	var leftEnergy, rightEnergy int
+	for i := 1; i < len(rec.left); i++ {
+		leftEnergy += abs(int(rec.left[i]) - int(rec.left[i-1]))
+		rightEnergy += abs(int(rec.right[i]) - int(rec.right[i-1]))
+	}
+	require.Greater(t, leftEnergy, 0, "Left channel should carry the signal")
+	require.Equal(t, 0, rightEnergy, "Right channel should be silent")
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Toggle the master volume with all voices silent and return the swing in output level.
+func volumeSwing(s *SID) int {
+	s.WriteByte(REG_MODE_VOL, 0x00)
+	s.Clock()
+	low := int(s.Output())
+	s.WriteByte(REG_MODE_VOL, 0x0f)
+	s.Clock()
+	return abs(int(s.Output()) - low)
+}
+
+func TestSID_VolumeDigis(t *testing.T) {
+	require.Greater(t, volumeSwing(NewSID(MOS6581)), 1000, "DC offset should make volume changes audible on 6581")
+	require.Equal(t, 0, volumeSwing(NewSID(MOS8580)), "8580 without digi boost should be silent")
+	boosted := NewSID(MOS8580)
+	boosted.SetDigiBoost(true)
+	require.Greater(t, volumeSwing(boosted), 1000, "Digi boost should make volume changes audible on 8580")
+}
+
+func TestMixer_CycleExactVolume(t *testing.T) {
+	rec := &sampleRecorder{}
+	m := NewMixer(PALClockFrequency, 44100, rec)
+	s := NewSID(MOS6581)
+	m.Attach(s, 0)
+
+	// Let the output settle at full volume, then at zero volume
+	s.WriteByte(REG_MODE_VOL, 0x0f)
+	for len(rec.left) < 10 {
+		m.Clock()
+	}
+	high := rec.left[len(rec.left)-1]
+	s.WriteByte(REG_MODE_VOL, 0x00)
+	for len(rec.left) < 20 {
+		m.Clock()
+	}
+	low := rec.left[len(rec.left)-1]
+
+	// Now pulse the volume for half of every sample period. A point sampling mixer
+	// would only ever see one of the levels.
+	for len(rec.left) < 40 {
+		s.WriteByte(REG_MODE_VOL, 0x0f)
+		for i := 0; i < 11; i++ {
+			m.Clock()
+		}
+		s.WriteByte(REG_MODE_VOL, 0x00)
+		for i := 0; i < 11; i++ {
+			m.Clock()
+		}
+	}
+	mid := rec.left[len(rec.left)-1]
+	require.True(t, mid > low && mid < high || mid < low && mid > high, "Volume pulses should be averaged. Got %d between %d and %d", mid, low, high)
+}
+
+func TestMultiSink(t *testing.T) {
+	a := &sampleRecorder{}
+	b := &sampleRecorder{}
+	m := MultiSink(a, b)
+	m.WriteSample(1, -1)
+	m.WriteSample(2, -2)
+	require.Equal(t, []int16{1, 2}, a.left)
+	require.Equal(t, a, b)
+}
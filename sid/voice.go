@@ -47,7 +47,7 @@ func NewVoice(generator *Generator, envelopeGenerator *EnvelopeGenerator, chipTy
 }
 
 func (v *Voice) ReadOutput() soundSample {
-	return soundSample(v.generator.ReadOutput()) - v.waveZero*soundSample(v.envelope.ReadOutput()) + v.voiceDC
+	return (soundSample(v.generator.ReadOutput())-v.waveZero)*soundSample(v.envelope.ReadOutput()) + v.voiceDC
 }
 
 func (v *Voice) reset() {
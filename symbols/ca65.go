@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Debug info files start with a version record
+const ca65Magic = "version\t"
+
+// Line types in debug info files. Lines in macros point into the macro definition,
+// which isn't where you want a breakpoint to go.
+const ca65LineMacro = 2
+
+type ca65Span struct {
+	seg, start, size int
+}
+
+type ca65Line struct {
+	file, line int
+	spans      []int
+}
+
+// ReadCA65Debug reads a debug info file written by ld65 with the --dbgfile option.
+// Address labels and source lines are picked up. Everything else, like scopes and
+// C symbols, is ignored.
+func (t *Table) ReadCA65Debug(r io.Reader) error {
+	files := make(map[int]string)
+	segs := make(map[int]int) // Segment id to start address
+	spans := make(map[int]ca65Span)
+	var lines []ca65Line
+	type symbol struct {
+		name  string
+		value int
+	}
+	var symbols []symbol
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		attrs, err := parseCA65Attributes(parts[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		switch parts[0] {
+		case "version":
+			if attrs.number("major") != 2 {
+				return fmt.Errorf("unsupported debug info version: %s", attrs["major"])
+			}
+		case "file":
+			files[attrs.number("id")] = attrs["name"]
+		case "seg":
+			segs[attrs.number("id")] = attrs.number("start")
+		case "span":
+			spans[attrs.number("id")] = ca65Span{seg: attrs.number("seg"), start: attrs.number("start"), size: attrs.number("size")}
+		case "line":
+			if attrs["span"] == "" || attrs.number("type") == ca65LineMacro {
+				continue
+			}
+			l := ca65Line{file: attrs.number("file"), line: attrs.number("line")}
+			for _, id := range strings.Split(attrs["span"], "+") {
+				span, err := strconv.Atoi(id)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid span: %s", n, id)
+				}
+				l.spans = append(l.spans, span)
+			}
+			lines = append(lines, l)
+		case "sym":
+			if attrs["type"] == "lab" && attrs["val"] != "" {
+				symbols = append(symbols, symbol{attrs["name"], attrs.number("val")})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Spans and segments come after the lines that refer to them, so addresses can only
+	// be worked out now. When several lines cover the same address, like a line that
+	// includes a file and the lines in it, the one with the shortest span wins.
+	sizes := make(map[uint16]int)
+	for _, l := range lines {
+		file, ok := files[l.file]
+		if !ok {
+			continue
+		}
+		for _, id := range l.spans {
+			span, ok := spans[id]
+			if !ok {
+				continue
+			}
+			addr := uint16(segs[span.seg] + span.start)
+			if size, ok := sizes[addr]; ok && size <= span.size {
+				continue
+			}
+			sizes[addr] = span.size
+			t.AddLine(addr, file, l.line)
+		}
+	}
+	for _, s := range symbols {
+		t.AddLabel(s.name, uint16(s.value))
+	}
+	return nil
+}
+
+// Attributes of a debug info record, e.g. id=3,name="main.s",size=1234
+type ca65Attributes map[string]string
+
+func parseCA65Attributes(s string) (ca65Attributes, error) {
+	attrs := make(ca65Attributes)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("expected key=value: %s", s)
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string: %s", s)
+			}
+			value = s[1 : end+1]
+			s = s[end+2:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = s[:end]
+			s = s[end:]
+		}
+		attrs[key] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+	return attrs, nil
+}
+
+// Numbers are decimal or hexadecimal with a 0x prefix. Missing attributes are zero and
+// invalid ones -1, which won't match anything.
+func (a ca65Attributes) number(key string) int {
+	s, ok := a[key]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 0, 32)
+	if err != nil {
+		return -1
+	}
+	return int(n)
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package symbols maps addresses to labels and source lines and back, so that the
+// debugging tools can talk about a program in the terms it was written in. Symbols
+// come from the built-in assembler, VICE label files or ca65 debug info files.
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/beevik/go6502/asm"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Location is a line in a source file.
+type Location struct {
+	File string
+	Line int // 1-based
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", filepath.Base(l.File), l.Line)
+}
+
+// Table holds labels and source lines. The zero value is not usable, use New. A nil
+// table has no symbols, so callers don't need to check before looking things up.
+type Table struct {
+	labels  map[string]uint16
+	names   map[uint16][]string
+	lines   map[uint16]Location
+	sources map[string][]string // Source file contents, loaded on demand
+}
+
+func New() *Table {
+	t := &Table{}
+	t.Clear()
+	return t
+}
+
+// Clear removes all symbols.
+func (t *Table) Clear() {
+	t.labels = make(map[string]uint16)
+	t.names = make(map[uint16][]string)
+	t.lines = make(map[uint16]Location)
+	t.sources = make(map[string][]string)
+}
+
+// AddLabel defines a label, replacing any earlier definition with the same name.
+func (t *Table) AddLabel(name string, addr uint16) {
+	if old, ok := t.labels[name]; ok {
+		t.removeName(old, name)
+	}
+	t.labels[name] = addr
+	names := append(t.names[addr], name)
+	sort.Strings(names)
+	t.names[addr] = names
+}
+
+// RemoveLabel removes a label. Returns false if there was no such label.
+func (t *Table) RemoveLabel(name string) bool {
+	addr, ok := t.labels[name]
+	if !ok {
+		return false
+	}
+	delete(t.labels, name)
+	t.removeName(addr, name)
+	return true
+}
+
+func (t *Table) removeName(addr uint16, name string) {
+	names := t.names[addr]
+	for i, n := range names {
+		if n == name {
+			names = append(names[:i], names[i+1:]...)
+			break
+		}
+	}
+	if len(names) == 0 {
+		delete(t.names, addr)
+	} else {
+		t.names[addr] = names
+	}
+}
+
+// AddLine records that the code at an address was generated by a source line.
+func (t *Table) AddLine(addr uint16, file string, line int) {
+	t.lines[addr] = Location{File: file, Line: line}
+}
+
+// AddSourceMap adds the source lines and exported labels of a program assembled by
+// go6502.
+func (t *Table) AddSourceMap(m *asm.SourceMap) {
+	for _, l := range m.Lines {
+		if l.FileIndex < len(m.Files) {
+			t.AddLine(uint16(l.Address), m.Files[l.FileIndex], l.Line)
+		}
+	}
+	for _, e := range m.Exports {
+		t.AddLabel(e.Label, e.Address)
+	}
+}
+
+// Label returns the first label, in alphabetical order, at an address.
+func (t *Table) Label(addr uint16) (string, bool) {
+	if t == nil || len(t.names[addr]) == 0 {
+		return "", false
+	}
+	return t.names[addr][0], true
+}
+
+// Labels returns all labels at an address, in alphabetical order.
+func (t *Table) Labels(addr uint16) []string {
+	if t == nil {
+		return nil
+	}
+	return t.names[addr]
+}
+
+// Lookup returns the address of a label.
+func (t *Table) Lookup(name string) (uint16, bool) {
+	if t == nil {
+		return 0, false
+	}
+	addr, ok := t.labels[name]
+	return addr, ok
+}
+
+// Names returns all label names in alphabetical order.
+func (t *Table) Names() []string {
+	if t == nil {
+		return nil
+	}
+	names := make([]string, 0, len(t.labels))
+	for name := range t.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Line returns the source line that generated the code at an address.
+func (t *Table) Line(addr uint16) (Location, bool) {
+	if t == nil {
+		return Location{}, false
+	}
+	l, ok := t.lines[addr]
+	return l, ok
+}
+
+// Address returns the address of the code generated by a source line. The file may
+// be given without a directory. If the line didn't generate any code, the next one
+// that did is used, like debuggers usually do.
+func (t *Table) Address(file string, line int) (uint16, error) {
+	if t == nil {
+		return 0, fmt.Errorf("no source lines for %s", file)
+	}
+	found := false
+	var best Location
+	var bestAddr uint16
+	for addr, l := range t.lines {
+		if l.Line < line || !sameFile(l.File, file) {
+			continue
+		}
+		if !found || l.Line < best.Line || l.Line == best.Line && addr < bestAddr {
+			found, best, bestAddr = true, l, addr
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no code at or after %s:%d", file, line)
+	}
+	return bestAddr, nil
+}
+
+func sameFile(path, name string) bool {
+	if path == name {
+		return true
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return filepath.Clean(path) == filepath.Clean(name)
+	}
+	return filepath.Base(path) == name
+}
+
+// Resolve parses a label name, optionally with a leading dot like VICE wants it, or a
+// file:line source location.
+func (t *Table) Resolve(s string) (uint16, error) {
+	if i := strings.LastIndexByte(s, ':'); i > 0 {
+		if line, err := strconv.Atoi(s[i+1:]); err == nil {
+			return t.Address(s[:i], line)
+		}
+	}
+	name := strings.TrimPrefix(s, ".")
+	if addr, ok := t.Lookup(name); ok {
+		return addr, nil
+	}
+	return 0, fmt.Errorf("unknown label: %s", name)
+}
+
+// Source returns the text of a source line, if the file can be found.
+func (t *Table) Source(l Location) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	text, ok := t.sources[l.File]
+	if !ok {
+		// Remember failures too, so we don't try again for every line
+		if f, err := os.Open(l.File); err == nil {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				text = append(text, scanner.Text())
+			}
+			f.Close()
+		}
+		t.sources[l.File] = text
+	}
+	if l.Line < 1 || l.Line > len(text) {
+		return "", false
+	}
+	return text[l.Line-1], true
+}
+
+// Describe returns the labels and source location of an address, or an empty string
+// if there are none. Used to annotate instruction traces.
+func (t *Table) Describe(addr uint16) string {
+	if t == nil {
+		return ""
+	}
+	parts := append([]string{}, t.names[addr]...)
+	if l, ok := t.lines[addr]; ok {
+		parts = append(parts, l.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Load reads a VICE label file or a ca65 debug info file, telling them apart by
+// content.
+func (t *Table) Load(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	in := bufio.NewReader(f)
+	head, _ := in.Peek(len(ca65Magic))
+	if string(head) == ca65Magic {
+		err = t.ReadCA65Debug(in)
+	} else {
+		err = t.ReadVICELabels(in)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package symbols
+
+import (
+	"bytes"
+	"github.com/beevik/go6502/asm"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTable_SourceMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symbols")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	source := "    .OR $1000\n    .EX start\nstart\n    LDX #$00\n\nloop\n    INX\n    JMP loop\n"
+	file := filepath.Join(dir, "test.asm")
+	require.NoError(t, ioutil.WriteFile(file, []byte(source), 0644))
+	_, sourceMap, err := asm.Assemble(strings.NewReader(source), file, ioutil.Discard, 0)
+	require.NoError(t, err)
+
+	table := New()
+	table.AddSourceMap(sourceMap)
+	label, ok := table.Label(0x1000)
+	require.True(t, ok)
+	require.Equal(t, "start", label)
+	loc, ok := table.Line(0x1002)
+	require.True(t, ok)
+	require.Equal(t, "test.asm:7", loc.String())
+	text, ok := table.Source(loc)
+	require.True(t, ok)
+	require.Equal(t, "    INX", text)
+
+	// Lines without code resolve to the next one with code
+	addr, err := table.Resolve("test.asm:5")
+	require.NoError(t, err)
+	require.Equal(t, uint16(0x1002), addr)
+	addr, err = table.Resolve(file + ":8")
+	require.NoError(t, err)
+	require.Equal(t, uint16(0x1003), addr)
+	addr, err = table.Resolve(".start")
+	require.NoError(t, err)
+	require.Equal(t, uint16(0x1000), addr)
+	_, err = table.Resolve("test.asm:9")
+	require.Error(t, err)
+	_, err = table.Resolve("nowhere")
+	require.Error(t, err)
+	require.Equal(t, "start test.asm:4", table.Describe(0x1000))
+}
+
+func TestTable_VICELabels(t *testing.T) {
+	table := New()
+	require.NoError(t, table.ReadVICELabels(strings.NewReader("al C:080d .start\nal 0810 .loop\n\nbreak 0810\n")))
+	addr, ok := table.Lookup("loop")
+	require.True(t, ok)
+	require.Equal(t, uint16(0x0810), addr)
+	require.Error(t, table.ReadVICELabels(strings.NewReader("al C:zz .bad\n")))
+
+	// Redefining a label moves it
+	table.AddLabel("start", 0x0900)
+	_, ok = table.Label(0x080d)
+	require.False(t, ok)
+	require.True(t, table.RemoveLabel("loop"))
+	require.False(t, table.RemoveLabel("loop"))
+
+	var out bytes.Buffer
+	require.NoError(t, table.WriteVICELabels(&out))
+	require.Equal(t, "al C:0900 .start\n", out.String())
+}
+
+const testDebugInfo = `version	major=2,minor=0
+info	csym=0,file=2,lib=0,line=4,mod=1,scope=1,seg=2,span=3,sym=2,type=0
+file	id=0,name="main.s",size=90,mtime=0x5F000000,mod=0
+file	id=1,name="macros.inc",size=20,mtime=0x5F000000,mod=0
+line	id=0,file=0,line=3,span=0
+line	id=1,file=0,line=4,span=1
+line	id=2,file=1,line=2,type=2,span=2
+line	id=3,file=0,line=5,span=2
+mod	id=0,name="main.o",file=0
+scope	id=0,name="",mod=0,size=6,span=0+1+2
+seg	id=0,name="LOADADDR",start=0x0007FF,size=0x0002,addrsize=absolute,type=ro,oname="main.prg",ooffs=0
+seg	id=1,name="CODE",start=0x000801,size=0x0006,addrsize=absolute,type=ro,oname="main.prg",ooffs=2
+span	id=0,seg=1,start=0,size=2
+span	id=1,seg=1,start=2,size=1
+span	id=2,seg=1,start=3,size=3
+sym	id=0,name="main",addrsize=absolute,scope=0,def=0,ref=1,val=0x801,seg=1,type=lab
+sym	id=1,name="COUNT",addrsize=zeropage,scope=0,def=1,val=0x10,type=equ
+`
+
+func TestTable_CA65Debug(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symbols")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.dbg")
+	require.NoError(t, ioutil.WriteFile(file, []byte(testDebugInfo), 0644))
+
+	table := New()
+	require.NoError(t, table.Load(file))
+	require.Equal(t, []string{"main"}, table.Names(), "Only address labels should be loaded")
+	loc, ok := table.Line(0x0804)
+	require.True(t, ok)
+	require.Equal(t, Location{File: "main.s", Line: 5}, loc, "Macro lines should be skipped")
+	addr, err := table.Resolve("main.s:4")
+	require.NoError(t, err)
+	require.Equal(t, uint16(0x0803), addr)
+
+	require.Error(t, New().ReadCA65Debug(strings.NewReader("version\tmajor=1,minor=0\n")))
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadVICELabels reads labels in the format VICE's monitor uses for its "ll" and "sl"
+// commands, and which most cross assemblers can produce. Each line looks like
+// "al C:080d .start". The memory space prefix is optional. Other commands are ignored.
+func (t *Table) ReadVICELabels(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.ToLower(fields[0]) != "al" {
+			continue
+		}
+		if len(fields) != 3 {
+			return fmt.Errorf("line %d: expected al address .label", n)
+		}
+		addr := fields[1]
+		if i := strings.IndexByte(addr, ':'); i >= 0 {
+			addr = addr[i+1:]
+		}
+		value, err := strconv.ParseUint(addr, 16, 16)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid address: %s", n, fields[1])
+		}
+		name := strings.TrimPrefix(fields[2], ".")
+		if name == "" {
+			return fmt.Errorf("line %d: missing label", n)
+		}
+		t.AddLabel(name, uint16(value))
+	}
+	return scanner.Err()
+}
+
+// WriteVICELabels writes all labels in a form ReadVICELabels and VICE can read.
+func (t *Table) WriteVICELabels(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	for _, name := range t.Names() {
+		if _, err := fmt.Fprintf(out, "al C:%04x .%s\n", t.labels[name], name); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
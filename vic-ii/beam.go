@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+import "fmt"
+
+// Beam describes where the VIC-II is in the frame and the state of the bus lines it
+// drives. It's meant for debuggers that step the machine by half cycles.
+type Beam struct {
+	RasterLine uint16 // Line the beam is on. Changes at the start of cycle 0.
+	Cycle      uint16 // Cycle within the line, numbered like in the scan logic
+	Phase      uint8  // The half cycle that runs next: 1 for the VIC-II, 2 for the CPU
+	BadLine    bool
+	RDY        bool // Level of RDY. Low while the VIC-II needs the bus.
+	IRQ        bool // Level of the IRQ line of the CPU, which is active low
+	NMI        bool // Level of the NMI line of the CPU, which is active low
+}
+
+// Beam returns the position of the beam. Unlike the raster counter, the line is known
+// before the first half cycle of the line has run.
+func (v *VicII) Beam() Beam {
+	b := Beam{
+		RasterLine: v.cycle / v.dimensions.CyclesPerLine,
+		Cycle:      v.cycle % v.dimensions.CyclesPerLine,
+		Phase:      1,
+		BadLine:    v.badLine,
+		RDY:        v.cpuBus.RDY.Get(),
+		IRQ:        v.cpuBus.NotIRQ.Get(),
+		NMI:        v.cpuBus.NotNMI.Get(),
+	}
+	if v.clockPhase2 {
+		b.Phase = 2
+	}
+	return b
+}
+
+// AtFrameStart returns true if the next half cycle is the first one of a frame.
+func (b Beam) AtFrameStart() bool {
+	return b.RasterLine == 0 && b.Cycle == 0 && b.Phase == 1
+}
+
+func (b Beam) String() string {
+	return fmt.Sprintf("LINE:%03X (%d) CYCLE:%02d.%d BADLINE:%d RDY:%d IRQ:%d NMI:%d",
+		b.RasterLine, b.RasterLine, b.Cycle, b.Phase, level(b.BadLine), level(b.RDY), level(b.IRQ), level(b.NMI))
+}
+
+func level(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CRTFilter post-processes complete frames to look more like they did on a PAL TV.
+// Frames are given as rows from the top of the screen down. A filter keeps buffers
+// between calls, so it must only be used by one goroutine at a time.
+type CRTFilter struct {
+	PALBlending bool    // Mix the chroma of each line with the line above, like the delay line of a PAL TV
+	Blur        float64 // How much of the neighbouring pixels to mix into each pixel, 0-1
+	Scanlines   float64 // How much to darken the gap below each line, 0-1. Doubles the height.
+
+	line   []rgb
+	prevUV [][2]float64
+}
+
+type rgb struct {
+	r, g, b float64
+}
+
+// ParseCRTFilter parses a comma separated list of effects: "pal" for PAL blending,
+// "blur=amount" and "scanlines=amount". Returns nil for an empty list.
+func ParseCRTFilter(spec string) (*CRTFilter, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	f := &CRTFilter{}
+	for _, effect := range strings.Split(strings.ToLower(spec), ",") {
+		parts := strings.SplitN(effect, "=", 2)
+		if parts[0] == "pal" && len(parts) == 1 {
+			f.PALBlending = true
+			continue
+		}
+		var amount *float64
+		switch parts[0] {
+		case "blur":
+			amount = &f.Blur
+		case "scanlines":
+			amount = &f.Scanlines
+		default:
+			return nil, fmt.Errorf("unknown effect: %s", effect)
+		}
+		*amount = 0.5
+		if len(parts) == 2 {
+			v, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return nil, err
+			}
+			if v < 0 || v > 1 {
+				return nil, fmt.Errorf("%s must be between 0 and 1", parts[0])
+			}
+			*amount = v
+		}
+	}
+	return f, nil
+}
+
+// Clone returns a filter with the same settings but buffers of its own, so that it
+// can be used by another goroutine.
+func (f *CRTFilter) Clone() *CRTFilter {
+	return &CRTFilter{PALBlending: f.PALBlending, Blur: f.Blur, Scanlines: f.Scanlines}
+}
+
+// OutputHeight returns the number of rows the filter makes out of a frame.
+func (f *CRTFilter) OutputHeight(height int) int {
+	if f.Scanlines > 0 {
+		return height * 2
+	}
+	return height
+}
+
+// Apply filters the rows of src into dst, which must have OutputHeight rows of the
+// same width.
+func (f *CRTFilter) Apply(dst, src [][]color.RGBA) {
+	if len(src) == 0 {
+		return
+	}
+	width := len(src[0])
+	if len(f.line) != width {
+		f.line = make([]rgb, width)
+		f.prevUV = make([][2]float64, width)
+	}
+	for y, row := range src {
+		for x, c := range row {
+			f.line[x] = rgb{float64(c.R), float64(c.G), float64(c.B)}
+		}
+		if f.PALBlending {
+			f.blendChroma(y == 0)
+		}
+		if f.Blur > 0 {
+			f.blur()
+		}
+		if f.Scanlines > 0 {
+			f.store(dst[2*y], 1)
+			f.store(dst[2*y+1], 1-f.Scanlines)
+		} else {
+			f.store(dst[y], 1)
+		}
+	}
+}
+
+// Averages the chroma of the line with that of the line above, keeping the luma. The
+// first line has nothing to mix with.
+func (f *CRTFilter) blendChroma(first bool) {
+	for x, c := range f.line {
+		y := 0.299*c.r + 0.587*c.g + 0.114*c.b
+		uv := [2]float64{0.492 * (c.b - y), 0.877 * (c.r - y)}
+		prev := f.prevUV[x]
+		f.prevUV[x] = uv
+		if first {
+			continue
+		}
+		u := (uv[0] + prev[0]) / 2
+		v := (uv[1] + prev[1]) / 2
+		f.line[x] = rgb{y + 1.140*v, y - 0.395*u - 0.581*v, y + 2.032*u}
+	}
+}
+
+// Mixes half of the blur amount from each neighbour into the pixels of the line
+func (f *CRTFilter) blur() {
+	left := f.line[0]
+	for x, c := range f.line {
+		right := c
+		if x+1 < len(f.line) {
+			right = f.line[x+1]
+		}
+		f.line[x] = rgb{
+			c.r*(1-f.Blur) + (left.r+right.r)*f.Blur/2,
+			c.g*(1-f.Blur) + (left.g+right.g)*f.Blur/2,
+			c.b*(1-f.Blur) + (left.b+right.b)*f.Blur/2,
+		}
+		left = c
+	}
+}
+
+func (f *CRTFilter) store(row []color.RGBA, brightness float64) {
+	for x, c := range f.line {
+		row[x] = color.RGBA{clampComponent(c.r * brightness), clampComponent(c.g * brightness), clampComponent(c.b * brightness), 255}
+	}
+}
+
+func clampComponent(v float64) uint8 {
+	return uint8(math.Round(math.Max(math.Min(v, 255), 0)))
+}
+
+// Image filters a picture, e.g. a frame grabbed for a screenshot.
+func (f *CRTFilter) Image(img *image.RGBA) *image.RGBA {
+	bounds := img.Bounds()
+	src := make([][]color.RGBA, bounds.Dy())
+	for y := range src {
+		src[y] = make([]color.RGBA, bounds.Dx())
+		for x := range src[y] {
+			src[y][x] = img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+		}
+	}
+	dst := make([][]color.RGBA, f.OutputHeight(len(src)))
+	for y := range dst {
+		dst[y] = make([]color.RGBA, bounds.Dx())
+	}
+	f.Apply(dst, src)
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), len(dst)))
+	for y, row := range dst {
+		for x, c := range row {
+			out.SetRGBA(x, y, c)
+		}
+	}
+	return out
+}
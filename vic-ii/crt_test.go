@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+import (
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseCRTFilter(t *testing.T) {
+	f, err := ParseCRTFilter("")
+	require.NoError(t, err)
+	require.Nil(t, f)
+	f, err = ParseCRTFilter("PAL,blur=0.3,scanlines")
+	require.NoError(t, err)
+	require.Equal(t, &CRTFilter{PALBlending: true, Blur: 0.3, Scanlines: 0.5}, f)
+	_, err = ParseCRTFilter("pal,bloom")
+	require.EqualError(t, err, "unknown effect: bloom")
+	_, err = ParseCRTFilter("blur=2")
+	require.Error(t, err)
+	_, err = ParseCRTFilter("scanlines=dark")
+	require.Error(t, err)
+}
+
+func filterRows(f *CRTFilter, src [][]color.RGBA) [][]color.RGBA {
+	dst := make([][]color.RGBA, f.OutputHeight(len(src)))
+	for y := range dst {
+		dst[y] = make([]color.RGBA, len(src[0]))
+	}
+	f.Apply(dst, src)
+	return dst
+}
+
+func requireClose(t *testing.T, expected, actual color.RGBA, delta float64) {
+	require.InDelta(t, expected.R, actual.R, delta)
+	require.InDelta(t, expected.G, actual.G, delta)
+	require.InDelta(t, expected.B, actual.B, delta)
+}
+
+func TestPALBlending(t *testing.T) {
+	palette := Palettes["colodore"]
+	f := &CRTFilter{PALBlending: true}
+
+	// Alternating lines of purple and orange, which have the same luma, mix into one
+	// color. This is what demos use to get more colors.
+	src := make([][]color.RGBA, 6)
+	for y := range src {
+		src[y] = []color.RGBA{palette[4], palette[4]}
+		if y%2 == 1 {
+			src[y] = []color.RGBA{palette[8], palette[8]}
+		}
+	}
+	dst := filterRows(f, src)
+	require.Equal(t, palette[4], dst[0][0], "Nothing to mix the first line with")
+	for y := 2; y < len(dst); y++ {
+		requireClose(t, dst[1][0], dst[y][0], 2)
+	}
+	require.NotEqual(t, palette[4], dst[1][0])
+	require.NotEqual(t, palette[8], dst[1][0])
+
+	// Greys have no chroma to mix
+	src = [][]color.RGBA{{palette[11]}, {palette[12]}, {palette[15]}}
+	require.Equal(t, src, filterRows(f, src))
+
+	// A colored line below a grey one loses half of its saturation but keeps its luma
+	src = [][]color.RGBA{{palette[12]}, {palette[2]}}
+	mixed := filterRows(f, src)[1][0]
+	luma := func(c color.RGBA) float64 {
+		return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+	}
+	require.InDelta(t, luma(palette[2]), luma(mixed), 1)
+	require.Less(t, int(mixed.R)-int(mixed.G), int(palette[2].R)-int(palette[2].G))
+	require.Greater(t, mixed.R, mixed.G)
+}
+
+func TestBlur(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 255}
+	white := color.RGBA{0xff, 0xff, 0xff, 255}
+	dst := filterRows(&CRTFilter{Blur: 0.5}, [][]color.RGBA{{white, black, black, white}})
+	require.Equal(t, []color.RGBA{{191, 191, 191, 255}, {64, 64, 64, 255}, {64, 64, 64, 255}, {191, 191, 191, 255}}, dst[0])
+}
+
+func TestScanlines(t *testing.T) {
+	f := &CRTFilter{Scanlines: 0.25}
+	require.Equal(t, 568, f.OutputHeight(284))
+	c := color.RGBA{200, 100, 40, 255}
+	dst := filterRows(f, [][]color.RGBA{{c}, {c}})
+	require.Equal(t, [][]color.RGBA{{c}, {{150, 75, 30, 255}}, {c}, {{150, 75, 30, 255}}}, dst)
+}
+
+func TestCRTFilterImage(t *testing.T) {
+	vicii := initCollision([8]uint8{}, 1)
+	runFrame(vicii)
+	img := vicii.screen.(*ImageRaster).Img
+	out := (&CRTFilter{PALBlending: true, Scanlines: 0.5}).Image(img)
+	require.Equal(t, image.Rect(0, 0, img.Rect.Dx(), img.Rect.Dy()*2), out.Rect)
+	border := C64Colors[vicii.borderCol]
+	requireClose(t, border, out.RGBAAt(0, 20), 1)
+	requireClose(t, color.RGBA{border.R / 2, border.G / 2, border.B / 2, 255}, out.RGBAAt(0, 21), 1)
+}
+
+func TestFrameGrabberFilter(t *testing.T) {
+	vicii := initCollision([8]uint8{}, 1)
+	img := vicii.screen.(*ImageRaster).Img
+	grabber := NewFrameGrabber(vicii.screen, img.Rect.Dx(), img.Rect.Dy())
+	grabber.Filter = &CRTFilter{PALBlending: true, Scanlines: 0.5}
+	vicii.screen = grabber
+	runFrame(vicii)
+	runFrame(vicii)
+	vicii.Clock()
+	vicii.Clock() // Flip
+	require.Equal(t, (&CRTFilter{PALBlending: true, Scanlines: 0.5}).Image(img), grabber.Frame(), "Grabbed frames are filtered")
+	grabber.Filter = nil
+	require.Equal(t, img, grabber.Frame())
+}
+
+func TestCRTFilterClone(t *testing.T) {
+	f := &CRTFilter{PALBlending: true, Blur: 0.3, Scanlines: 0.4}
+	filterRows(f, [][]color.RGBA{{{1, 2, 3, 255}}})
+	clone := f.Clone()
+	require.Equal(t, &CRTFilter{PALBlending: true, Blur: 0.3, Scanlines: 0.4}, clone, "Settings without the buffers")
+}
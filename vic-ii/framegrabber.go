@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+import (
+	"image"
+	"image/color"
+)
+
+// FrameGrabber passes pixels on to another raster while keeping a copy of the last
+// complete frame, so that debuggers and other tools can look at the screen.
+type FrameGrabber struct {
+	Filter *CRTFilter // Post-processing for the frames returned by Frame. Raw frames if nil.
+
+	raster Raster
+	width  int
+	height int
+	back   []color.RGBA // Frame being drawn
+	front  []color.RGBA // Last complete frame
+}
+
+// NewFrameGrabber creates a frame grabber for a screen of the given size. The raster
+// the pixels are passed on to may be nil.
+func NewFrameGrabber(raster Raster, width, height int) *FrameGrabber {
+	return &FrameGrabber{
+		raster: raster,
+		width:  width,
+		height: height,
+		back:   make([]color.RGBA, width*height),
+		front:  make([]color.RGBA, width*height),
+	}
+}
+
+func (f *FrameGrabber) SetPixel(x, y uint16, color color.RGBA) {
+	if int(x) < f.width && int(y) < f.height {
+		f.back[int(y)*f.width+int(x)] = color
+	}
+	if f.raster != nil {
+		f.raster.SetPixel(x, y, color)
+	}
+}
+
+func (f *FrameGrabber) Flip() {
+	f.front, f.back = f.back, f.front
+	if f.raster != nil {
+		f.raster.Flip()
+	}
+}
+
+// Frame returns a copy of the last complete frame. It's taller than the screen if the
+// filter adds scanlines.
+func (f *FrameGrabber) Frame() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, f.width, f.height))
+	for i, c := range f.front {
+		img.SetRGBA(i%f.width, i/f.width, c)
+	}
+	if f.Filter != nil {
+		return f.Filter.Image(img)
+	}
+	return img
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+// SetLightPen sets the level of the light pen input, which is shared with the fire
+// button of control port 1. The beam position is latched into LPX and LPY when the
+// input goes low, at most once per frame. An input that's still low when a new frame
+// starts triggers again in the first cycle of the frame, just like on the real chip.
+//
+// The input is only sampled once per cycle, so LPX moves in steps of four and points
+// to the first pixel of the cycle the pen saw, which may be up to eight pixels to the
+// left of the pen. The value uses the same coordinates as the sprite X registers, so
+// a sprite at LPX*2 covers the position.
+func (v *VicII) SetLightPen(low bool) {
+	if !low || v.lpTriggered {
+		return
+	}
+	v.lpTriggered = true
+	pixel := (int(v.cycle%v.dimensions.CyclesPerLine) - int(v.dimensions.FirstVisibleCycle)) * 8
+	v.lpX = uint8(v.xCoordinate(pixel) >> 1)
+	v.lpY = uint8(v.rasterLine)
+	v.irqLp = true
+	v.updateIRQ()
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Palettes are the built-in palettes by name. C64Colors is the one called "pepto".
+var Palettes = map[string][]color.RGBA{
+	"pepto":    C64Colors,
+	"colodore": rgbPalette(0x000000, 0xffffff, 0x813338, 0x75cec8, 0x8e3c97, 0x56ac4d, 0x2e2c9b, 0xedf171, 0x8e5029, 0x553800, 0xc46c71, 0x4a4a4a, 0x7b7b7b, 0xa9ff9f, 0x706deb, 0xb2b2b2),
+	"vice":     rgbPalette(0x000000, 0xfdfefc, 0xbe1a24, 0x30e6c6, 0xb41ae2, 0x1fd21e, 0x211bae, 0xdff60a, 0xb84104, 0x6a3304, 0xfe4a57, 0x424540, 0x70746f, 0x59fe59, 0x5f53fe, 0xa4a7a2),
+	"c64wiki":  rgbPalette(0x000000, 0xffffff, 0x880000, 0xaaffee, 0xcc44cc, 0x00cc55, 0x0000aa, 0xeeee77, 0xdd8855, 0x664400, 0xff7777, 0x333333, 0x777777, 0xaaff66, 0x0088ff, 0xbbbbbb),
+}
+
+func rgbPalette(colors ...uint32) []color.RGBA {
+	palette := make([]color.RGBA, len(colors))
+	for i, c := range colors {
+		palette[i] = color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 255}
+	}
+	return palette
+}
+
+// PaletteNames returns the names of the built-in palettes in alphabetical order.
+func PaletteNames() []string {
+	names := make([]string, 0, len(Palettes))
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadPalette returns a built-in palette, a palette generated by the Colodore
+// algorithm or one loaded from a VICE .vpl file. Generated palettes are given as
+// "generate[:brightness,contrast,saturation,gamma]", where omitted settings are
+// taken from DefaultColodore.
+func LoadPalette(name string) ([]color.RGBA, error) {
+	if palette, ok := Palettes[strings.ToLower(name)]; ok {
+		return palette, nil
+	}
+	if spec := strings.ToLower(name); spec == "generate" || strings.HasPrefix(spec, "generate:") {
+		settings, err := ParseColodoreSettings(strings.TrimPrefix(strings.TrimPrefix(spec, "generate"), ":"))
+		if err != nil {
+			return nil, err
+		}
+		return settings.Palette(), nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseVPL(f)
+}
+
+// ParseVPL reads a palette in the VICE .vpl format. Each color is a line with the
+// red, green and blue components as hex numbers, optionally followed by a dither
+// value that's ignored. Lines starting with # are comments.
+func ParseVPL(r io.Reader) ([]color.RGBA, error) {
+	var palette []color.RGBA
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected red, green and blue, got %s", lineNo, line)
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			v, err := strconv.ParseUint(fields[i], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			rgb[i] = uint8(v)
+		}
+		palette = append(palette, color.RGBA{rgb[0], rgb[1], rgb[2], 255})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(palette) != 16 {
+		return nil, fmt.Errorf("expected 16 colors, got %d", len(palette))
+	}
+	return palette, nil
+}
+
+// WriteVPL writes a palette in the VICE .vpl format.
+func WriteVPL(w io.Writer, palette []color.RGBA) error {
+	if _, err := fmt.Fprintf(w, "# VICE Palette file\n#\n# Syntax:\n# Red Green Blue Dither\n#\n"); err != nil {
+		return err
+	}
+	for _, c := range palette {
+		if _, err := fmt.Fprintf(w, "%02X %02X %02X 0\n", c.R, c.G, c.B); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ColodoreSettings are the monitor controls of the Colodore palette algorithm, which
+// models the luma and chroma the VIC-II puts out and the CRT showing them.
+type ColodoreSettings struct {
+	Brightness    float64 // 0-100, 50 is neutral
+	Contrast      float64 // 0-100
+	Saturation    float64 // 0-100
+	Gamma         float64 // Gamma of the CRT. 2.8 for PAL, 2.2 for NTSC
+	FirstRevision bool    // Use the luma levels of the first 6569 revision
+}
+
+// DefaultColodore gives the palette called "colodore".
+var DefaultColodore = ColodoreSettings{Brightness: 50, Contrast: 100, Saturation: 50, Gamma: 2.8}
+
+// Luma levels of the colors, from the first and later chip revisions
+var colodoreLuma = [2][16]float64{
+	{0, 32, 8, 24, 16, 16, 8, 24, 16, 8, 24, 16, 16, 24, 16, 24},
+	{0, 32, 10, 20, 12, 16, 8, 24, 12, 8, 16, 10, 15, 24, 15, 20},
+}
+
+// Chroma phase of the colors in sixteenths of a circle. Greys have no chroma.
+var colodoreAngles = [16]float64{0, 0, 4, 12, 2, 10, 15, 7, 5, 6, 4, 0, 0, 10, 15, 0}
+
+// ParseColodoreSettings parses "brightness,contrast,saturation,gamma". Settings
+// that are left out keep their default value.
+func ParseColodoreSettings(s string) (ColodoreSettings, error) {
+	settings := DefaultColodore
+	if s == "" {
+		return settings, nil
+	}
+	fields := []*float64{&settings.Brightness, &settings.Contrast, &settings.Saturation, &settings.Gamma}
+	parts := strings.Split(s, ",")
+	if len(parts) > len(fields) {
+		return settings, fmt.Errorf("expected brightness,contrast,saturation,gamma, got %s", s)
+	}
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return settings, err
+		}
+		*fields[i] = v
+	}
+	return settings, nil
+}
+
+// Palette computes the RGB colors from the settings.
+func (s ColodoreSettings) Palette() []color.RGBA {
+	const screen = 1.0 / 5 // Contrast the CRT adds on its own
+	luma := colodoreLuma[1]
+	if s.FirstRevision {
+		luma = colodoreLuma[0]
+	}
+	brightness := s.Brightness - 50
+	contrast := s.Contrast/100 + screen
+	saturation := s.Saturation * (1 - screen)
+	palette := make([]color.RGBA, 16)
+	for i := range palette {
+		y := (8*luma[i] + brightness) * contrast
+		u, v := 0.0, 0.0
+		if colodoreAngles[i] != 0 {
+			angle := (360.0/32 + colodoreAngles[i]*360/16) * math.Pi / 180
+			u = math.Cos(angle) * saturation * contrast
+			v = math.Sin(angle) * saturation * contrast
+		}
+		palette[i] = color.RGBA{
+			R: s.gammaCorrect(y + 1.140*v),
+			G: s.gammaCorrect(y - 0.396*u - 0.581*v),
+			B: s.gammaCorrect(y + 2.029*u),
+			A: 255,
+		}
+	}
+	return palette
+}
+
+// Converts a component from the gamma of the CRT to the sRGB gamma of 2.2
+func (s ColodoreSettings) gammaCorrect(v float64) uint8 {
+	clamp := func(v float64) float64 {
+		return math.Max(math.Min(v, 255), 0)
+	}
+	v = clamp(math.Pow(255, 1-s.Gamma) * math.Pow(clamp(v), s.Gamma))
+	v = clamp(math.Pow(255, 1-1/2.2) * math.Pow(v, 1/2.2))
+	return uint8(math.Round(v))
+}
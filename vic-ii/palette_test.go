@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPalettes(t *testing.T) {
+	require.Equal(t, []string{"c64wiki", "colodore", "pepto", "vice"}, PaletteNames())
+	for name, palette := range Palettes {
+		require.Len(t, palette, 16, name)
+		require.Equal(t, color.RGBA{0, 0, 0, 255}, palette[0], name)
+	}
+	require.Equal(t, C64Colors, Palettes["pepto"])
+}
+
+func TestColodore(t *testing.T) {
+	require.Equal(t, Palettes["colodore"], DefaultColodore.Palette())
+
+	bright := DefaultColodore
+	bright.Brightness = 70
+	palette := bright.Palette()
+	for _, grey := range []int{11, 12, 15} {
+		c := palette[grey]
+		require.Equal(t, c.R, c.G)
+		require.Equal(t, c.R, c.B)
+		require.Greater(t, c.R, Palettes["colodore"][grey].R)
+	}
+
+	grey := DefaultColodore
+	grey.Saturation = 0
+	for _, c := range grey.Palette() {
+		require.Equal(t, c.R, c.G)
+		require.Equal(t, c.R, c.B)
+	}
+
+	first := DefaultColodore
+	first.FirstRevision = true
+	palette = first.Palette()
+	require.Equal(t, palette[11], palette[12], "The first revision only has 5 luma levels")
+	require.NotEqual(t, Palettes["colodore"][11], Palettes["colodore"][12])
+}
+
+func TestParseColodoreSettings(t *testing.T) {
+	s, err := ParseColodoreSettings("")
+	require.NoError(t, err)
+	require.Equal(t, DefaultColodore, s)
+	s, err = ParseColodoreSettings("60,,40")
+	require.NoError(t, err)
+	require.Equal(t, ColodoreSettings{Brightness: 60, Contrast: 100, Saturation: 40, Gamma: 2.8}, s)
+	_, err = ParseColodoreSettings("1,2,3,4,5")
+	require.Error(t, err)
+	_, err = ParseColodoreSettings("bright")
+	require.Error(t, err)
+}
+
+func TestVPL(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteVPL(&buf, Palettes["vice"]))
+	palette, err := ParseVPL(&buf)
+	require.NoError(t, err)
+	require.Equal(t, Palettes["vice"], palette)
+
+	// Dither values are optional
+	vpl := "# Comment\n\n" + strings.Repeat("01 02 03\n", 15) + "ff fe fd 8\n"
+	palette, err = ParseVPL(strings.NewReader(vpl))
+	require.NoError(t, err)
+	require.Equal(t, color.RGBA{1, 2, 3, 255}, palette[0])
+	require.Equal(t, color.RGBA{0xff, 0xfe, 0xfd, 255}, palette[15])
+
+	_, err = ParseVPL(strings.NewReader("00 00 00\n"))
+	require.EqualError(t, err, "expected 16 colors, got 1")
+	_, err = ParseVPL(strings.NewReader("00 00\n"))
+	require.Error(t, err)
+	_, err = ParseVPL(strings.NewReader("00 00 xx\n"))
+	require.Error(t, err)
+}
+
+func TestLoadPalette(t *testing.T) {
+	palette, err := LoadPalette("Colodore")
+	require.NoError(t, err)
+	require.Equal(t, Palettes["colodore"], palette)
+	palette, err = LoadPalette("generate")
+	require.NoError(t, err)
+	require.Equal(t, Palettes["colodore"], palette)
+	palette, err = LoadPalette("generate:50,100,0")
+	require.NoError(t, err)
+	require.Equal(t, palette[2].R, palette[2].G)
+
+	name := filepath.Join(t.TempDir(), "test.vpl")
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	require.NoError(t, WriteVPL(f, Palettes["c64wiki"]))
+	require.NoError(t, f.Close())
+	palette, err = LoadPalette(name)
+	require.NoError(t, err)
+	require.Equal(t, Palettes["c64wiki"], palette)
+
+	_, err = LoadPalette("nonexistent.vpl")
+	require.Error(t, err)
+}
+
+func TestSetPalette(t *testing.T) {
+	vicii := initCollision([8]uint8{}, 1)
+	require.Equal(t, C64Colors, vicii.Palette())
+	vicii.SetPalette(Palettes["vice"])
+	runFrame(vicii)
+	require.Equal(t, Palettes["vice"][vicii.borderCol], pixelAt(vicii, 100, 0))
+	require.Equal(t, Palettes["vice"][vicii.backgroundColors[0]], pixelAt(vicii, 100, 24))
+}
@@ -30,19 +30,19 @@ func (v *VicII) Clock() {
 		}
 		// ******** CLOCK PHASE 2 ********
 
-		if localCycle >= PalFirstVisibleCycle && localCycle <= PalLastVisibleCycle &&
-			v.rasterLine >= PalFirstVisibleLine && v.rasterLine <= PalLastVisibleLine {
+		if localCycle >= v.dimensions.FirstVisibleCycle && localCycle <= v.dimensions.LastVisibleCycle &&
+			v.rasterLine >= v.dimensions.FirstVisibleLine && v.rasterLine <= v.dimensions.LastVisibleLine {
 			v.renderCycle()
 		}
 
 		// Perform c-access on bad lines
 		if v.badLine && localCycle >= 15 && localCycle <= 54 {
-			v.cAccess()
+			v.cAccess(localCycle)
 		}
 		v.cpuBus.ClockPh2()
 
 		// Perform s-access if sprites are enabled
-		sprite, _ := getSpriteForCycle(localCycle)
+		sprite, _ := v.getSpriteForCycle(localCycle)
 		if sprite != 0x0ff {
 			v.sAccess(sprite)
 		}
@@ -59,11 +59,13 @@ func (v *VicII) Clock() {
 			v.vcBase = 0 // vic-ii.txt: 3.7.2.2
 			v.badLine = false
 			v.rasterLine = 0
+			v.lpTriggered = false
 		}
 
 		switch localCycle {
 		case 0:
 			if v.cycle > 0 {
+				v.checkVerticalBorder() // Still the last cycle of the previous line
 				v.rasterLine++
 				v.rasterInterrupt()
 			}
@@ -75,7 +77,7 @@ func (v *VicII) Clock() {
 		}
 
 		// Handle sprite access if we're in the right part of the line.
-		sprite, pCycle := getSpriteForCycle(localCycle)
+		sprite, pCycle := v.getSpriteForCycle(localCycle)
 		if sprite != 0xff {
 			if pCycle {
 				v.pAccess(sprite)
@@ -89,101 +91,104 @@ func (v *VicII) Clock() {
 		// we do phase 2 accesses to get the sprite data. The
 		// RDY line will be raised at the end of the last S-access.
 		if v.spriteWillNeedBus(localCycle) {
-			v.bus.RDY.PullDown()
+			v.cpuBus.RDY.PullDown()
 		}
 
-		// Handle visible stuff
-		contentBottom := v.dimensions.ContentBottom24Lines
-		if v.line25 {
-			contentBottom = v.dimensions.ContentBottom25Lines
+		// Bad lines can only occur in lines $30-$f7, but the display and sprite logic
+		// runs on every line, so that it keeps going in an opened border.
+		if v.rasterLine == 0x30 && v.enable {
+			v.skipFrame = false
 		}
-		if v.rasterLine >= 48 && v.rasterLine <= contentBottom {
-			if v.rasterLine == 48 && v.enable {
-				v.skipFrame = false
+
+		// The bad line condition is checked in every cycle, so that writes to YSCROLL
+		// take effect in the middle of a line. A bad line condition always switches
+		// to display state.
+		badLineCondition := v.badLineCondition()
+		if badLineCondition {
+			v.displayState = true
+		}
+		switch localCycle {
+		case 14:
+			v.vc = v.vcBase // vic-ii.txt: 3.7.2.2
+			v.vmli = 0
+			if badLineCondition {
+				v.rc = 0 // Not reset if the bad line starts later, which FLI relies on
 			}
-			switch localCycle {
-			case 14:
-				v.vc = v.vcBase // vic-ii.txt: 3.7.2.2
-				v.vmli = 0
-				if v.badLine {
-					v.rc = 0
+		case 15:
+			// In the first phase of cycle 15, it is checked if the expansion flip flop
+			// is set. If so, MCBASE is incremented by 2.
+			for i := range v.sprites {
+				if v.sprites[i].expandYFF {
+					v.sprites[i].mcBase += 2
 				}
-			case 15:
-				// In the first phase of cycle 15, it is checked if the expansion flip flop
-				// is set. If so, MCBASE is incremented by 2.
-				for i := range v.sprites {
-					if v.sprites[i].expandYFF {
-						v.sprites[i].mcBase += 2
-					}
-				}
-			case 16:
-				v.displayState = true
-
-				// In the first phase of cycle 16, it is checked if the expansion flip flop
-				// is set. If so, MCBASE is incremented by 1. After that, the VIC checks if
-				// MCBASE is equal to 63 and turns of the DMA and the display of the sprite
-				// if it is.
-				for i := range v.sprites {
-					s := &v.sprites[i]
-					if s.expandYFF {
-						s.mcBase = (s.mcBase + 1) & 0x3f
-						if s.mcBase == 0x3f {
-							s.dma = false
-						}
+			}
+		case 16:
+			// In the first phase of cycle 16, it is checked if the expansion flip flop
+			// is set. If so, MCBASE is incremented by 1. After that, the VIC checks if
+			// MCBASE is equal to 63 and turns of the DMA and the display of the sprite
+			// if it is.
+			for i := range v.sprites {
+				s := &v.sprites[i]
+				if s.expandYFF {
+					s.mcBase = (s.mcBase + 1) & 0x3f
+					if s.mcBase == 0x3f {
+						s.dma = false
 					}
 				}
-			case 55:
-				// Bad lines always end here regardless of how they started
-				if v.badLine {
-					v.badLine = false
-					v.bus.RDY.Release()
-				}
-				v.checkSpriteAndInit(true)
-			case 56:
-				v.checkSpriteAndInit(false)
-			case 58:
-				if v.rc == 0x07 {
-					v.rc = 0 // vic-ii.txt: 3.7.2.5
-					v.vcBase = v.vc
-					v.displayState = false
-				}
+			}
+		case 55:
+			// Bad lines always end here regardless of how they started
+			if v.badLine {
+				v.badLine = false
+				v.cpuBus.RDY.Release()
+			}
+			v.checkSpriteAndInit(true)
+		case 56:
+			v.checkSpriteAndInit(false)
+		case 58:
+			// vic-ii.txt: 3.7.2.5. The row ends when RC reaches 7, unless there's
+			// a bad line condition. RC stays at 7 in idle state, so display state
+			// without a bad line ends the row after a single line (linecrunch).
+			if v.rc == 0x07 {
+				v.vcBase = v.vc
+				v.displayState = badLineCondition
+			}
+			if v.displayState {
+				v.rc = (v.rc + 1) & 0x07
+			}
 
-				/* 4. In the first phase of cycle 58, the MC of every sprite is loaded from
-				   its belonging MCBASE (MCBASE->MC) and it is checked if the DMA for the
-				   sprite is turned on and the Y coordinate of the sprite matches the lower
-				   8 bits of RASTER. If this is the case, the display of the sprite is
-				   turned on.
-				*/
-				for i := range v.sprites {
-					s := &v.sprites[i]
-					s.mc = s.mcBase
-					if s.dma {
-						s.displayEnabled = true
-					}
-				}
-			case 59:
-				if v.displayState {
-					v.rc = (v.rc + 1) & 0x07
+			/* 4. In the first phase of cycle 58, the MC of every sprite is loaded from
+			   its belonging MCBASE (MCBASE->MC) and it is checked if the DMA for the
+			   sprite is turned on and the Y coordinate of the sprite matches the lower
+			   8 bits of RASTER. If this is the case, the display of the sprite is
+			   turned on.
+			*/
+			for i := range v.sprites {
+				s := &v.sprites[i]
+				s.mc = s.mcBase
+				if s.dma {
+					s.displayEnabled = true
 				}
 			}
+		}
 
-			// Misc stuff that's better handled outside the switch
-			if localCycle >= 16 && localCycle < 56 {
-				v.gAccess()
-			}
-			if localCycle >= 12 && localCycle <= 54 {
-				if !v.skipFrame && v.rasterLine&0x07 == v.scrollY {
-					if !v.badLine {
-						// Flipping from normal to bad
-						v.badLine = true
-						v.bus.RDY.PullDown()
-					}
-				} else {
-					// Flipping from bad to normal
-					if v.badLine {
-						v.badLine = false
-						v.bus.RDY.Release()
-					}
+		// Misc stuff that's better handled outside the switch
+		if localCycle >= 16 && localCycle < 56 {
+			v.gAccess(localCycle - 16)
+		}
+		if localCycle >= 12 && localCycle <= 54 {
+			if badLineCondition {
+				if !v.badLine {
+					// Flipping from normal to bad
+					v.badLine = true
+					v.baLowCycle = localCycle
+					v.cpuBus.RDY.PullDown()
+				}
+			} else {
+				// Flipping from bad to normal
+				if v.badLine {
+					v.badLine = false
+					v.cpuBus.RDY.Release()
 				}
 			}
 		}
@@ -192,22 +197,43 @@ func (v *VicII) Clock() {
 	v.clockPhase2 = !v.clockPhase2
 }
 
-func (v *VicII) cAccess() {
+func (v *VicII) badLineCondition() bool {
+	return v.rasterLine >= 0x30 && v.rasterLine <= 0xf7 && !v.skipFrame && v.rasterLine&0x07 == v.scrollY
+}
+
+func (v *VicII) cAccess(localCycle uint16) {
+	// The CPU keeps the bus for three cycles after BA goes low. If a bad line starts
+	// after cycle 12, the first c-accesses read $ff instead of the video matrix,
+	// which gives the light grey characters on the left of FLI pictures.
+	if localCycle < v.baLowCycle+3 {
+		v.cBuf[v.vmli] = 0x0fff
+		return
+	}
 	ch := v.bus.ReadByte(v.screenMemPtr | v.vc)
 	col := v.colorRam.ReadByte(v.vc)
-	if v.bitmapMode {
-		v.cBuf[v.vmli] = uint16(ch)
-	} else {
-		v.cBuf[v.vmli] = uint16(col)<<8 | uint16(ch)
-	}
+	v.cBuf[v.vmli] = uint16(col&0x0f)<<8 | uint16(ch)
 }
 
-func (v *VicII) gAccess() {
+func (v *VicII) gAccess(column uint16) {
+	// In idle state, the last byte of the bank is read ($39ff in ECM) and the video
+	// matrix data is treated as zero. VC and VMLI stay where they are.
+	if !v.displayState {
+		addr := uint16(0x3fff)
+		if v.extendedClr {
+			addr = 0x39ff
+		}
+		v.mBuf[column] = 0
+		v.gBuf[column] = v.bus.ReadByte(addr)
+		return
+	}
 	basePtr := v.charSetPtr
 	var addr uint16
 	if v.bitmapMode {
 		basePtr &= 0x2000
 		addr = basePtr + v.rc + v.vc<<3
+		if v.extendedClr {
+			addr &= 0x39ff // ECM clears address bits 9 and 10 in bitmap mode too
+		}
 	} else {
 		mask := uint16(0xff)
 		if v.extendedClr {
@@ -215,7 +241,8 @@ func (v *VicII) gAccess() {
 		}
 		addr = basePtr + ((v.cBuf[v.vmli]&mask)<<3 | v.rc) // TODO: Wild poking to the VIC can make vmli out of range
 	}
-	v.gBuf[v.vmli] = v.bus.ReadByte(addr)
+	v.gBuf[column] = v.bus.ReadByte(addr)
+	v.mBuf[column] = v.cBuf[v.vmli]
 
 	// Increment counters and make sure they stay within 6 and 10 bits boundary respectively
 	v.vmli = (v.vmli + 1) & 0x003f
@@ -240,50 +267,85 @@ func (v *VicII) sAccess(spriteIndex uint16) {
 	s.mc++
 	if s.sIndex > 2 {
 		s.sIndex = 0
-		v.bus.RDY.Release()
+		v.cpuBus.RDY.Release()
 	}
 }
 
 func (v *VicII) rasterInterrupt() {
-	if !v.irqRaster && v.irqRasterEnabled && v.rasterLine == v.rasterLineTrigger {
+	if !v.irqRaster && v.rasterLine == v.rasterLineTrigger {
 		v.irqRaster = true
+		v.updateIRQ()
+	}
+}
+
+// Pull the IRQ line low while any of the interrupt latches is set and enabled.
+func (v *VicII) updateIRQ() {
+	active := v.irqRaster && v.irqRasterEnabled ||
+		v.irqSpriteBg && v.irqSpriteBgEnabled ||
+		v.irqSpriteSprite && v.irqSpriteSpriteEnabled ||
+		v.irqLp && v.irqLpEnabled
+	if active == v.irqLine {
+		return
+	}
+	v.irqLine = active
+	if active {
 		v.cpuBus.NotIRQ.PullDown()
+	} else {
+		v.cpuBus.NotIRQ.Release()
+	}
+}
+
+// Returns the comparison values of the vertical border for the current number of rows.
+func (v *VicII) verticalLimits() (uint16, uint16) {
+	if v.line25 {
+		return v.dimensions.ContentTop25Lines, v.dimensions.ContentBottom25Lines
+	}
+	return v.dimensions.ContentTop24Lines, v.dimensions.ContentBottom24Lines
+}
+
+// Applies rules 2 and 3 of the border logic (see renderCycle). Called in the last
+// cycle of a line.
+func (v *VicII) checkVerticalBorder() {
+	top, bottom := v.verticalLimits()
+	if v.rasterLine == bottom {
+		v.vBorderFF = true
+	} else if v.rasterLine == top && v.enable {
+		v.vBorderFF = false
 	}
 }
 
+// Returns the X coordinate, as used by the sprite registers, of a pixel in the visible
+// area. The display window starts at X=24 and the coordinates wrap around at the end
+// of the line, so sprites with X coordinates at the end of the line show up in the
+// left border.
+func (v *VicII) xCoordinate(pixel int) uint16 {
+	lineWidth := int(v.dimensions.CyclesPerLine) * 8
+	x := (pixel - int(v.dimensions.LeftBorderWidth40Cols) + 24) % lineWidth
+	if x < 0 {
+		x += lineWidth
+	}
+	return uint16(x)
+}
+
 func (v *VicII) renderCycle() {
-	var contentLeft uint16
-	var contentRight uint16
-	var contentTop uint16
-	var contentBottom uint16
-	//var scrollOffset uint16
+	contentLeft := v.dimensions.LeftBorderWidth38Cols
+	contentRight := contentLeft + v.dimensions.ContentWidth38Cols
 	if v.col40 {
 		contentLeft = v.dimensions.LeftBorderWidth40Cols
 		contentRight = contentLeft + v.dimensions.ContentWidth40Cols
-	} else {
-		contentLeft = v.dimensions.LeftBorderWidth38Cols
-		contentRight = contentLeft + v.dimensions.ContentWidth38Cols
-	}
-	if v.line25 {
-		contentTop = v.dimensions.ContentTop25Lines
-		contentBottom = v.dimensions.ContentBottom25Lines
-		//scrollOffset = 3
-	} else {
-		contentTop = v.dimensions.ContentTop24Lines
-		contentBottom = v.dimensions.ContentBottom24Lines
-		//scrollOffset = 7
 	}
+	contentTop, contentBottom := v.verticalLimits()
+
+	localCycle := v.cycle % v.dimensions.CyclesPerLine
+	startPixel := (localCycle - v.dimensions.FirstVisibleCycle) << 3
 
-	localCycle := (v.cycle % v.dimensions.CyclesPerLine) - v.dimensions.FirstVisibleCycle
-	startPixel := localCycle << 3
+	// Column of the display window whose data is shown in this cycle
+	column := int(localCycle) - int(v.dimensions.FirstContentCycle)
 
-	lastFgColor := uint8(0)
 	cycleSpriteColl := uint8(0) // Sprite collisions during this cycle
 	cycleBgColl := uint8(0)     // Sprite-bg collisions this cycle
 
 	for pixel := startPixel; pixel < startPixel+8; pixel++ {
-		color := v.borderCol
-
 		// 1. If the X coordinate reaches the right comparison value, the main border
 		//    flip flop is set.
 		// 2. If the Y coordinate reaches the bottom comparison value in cycle 63, the
@@ -298,79 +360,91 @@ func (v *VicII) renderCycle() {
 		//	  the vertical border flip flop is reset.
 		// 6. If the X coordinate reaches the left comparison value and the vertical
 		//    border flip flop is not set, the main flip flop is reset.
+		//
+		// Rules 2 and 3 are handled by checkVerticalBorder. The comparison values
+		// depend on CSEL and RSEL at the time of the comparison, which is what lets
+		// programs open the borders by switching them at the right time.
 		if pixel == contentRight {
 			v.hBorderFF = true
 		}
-		if v.rasterLine == contentBottom {
-			v.vBorderFF = true
-		}
-		if v.rasterLine == contentTop {
-			v.vBorderFF = false
-		}
-		if pixel == contentLeft && !v.vBorderFF && v.enable {
-			v.hBorderFF = false
+		if pixel == contentLeft {
+			if v.rasterLine == contentBottom {
+				v.vBorderFF = true
+			} else if v.rasterLine == contentTop && v.enable {
+				v.vBorderFF = false
+			}
+			if !v.vBorderFF {
+				v.hBorderFF = false
+			}
 		}
-		if !v.hBorderFF {
-			// We're in the content area!
 
-			// If we're at an odd pixel and multicolor mode is on, we just need to repeat the last pixel color
-			if v.multiColor && pixel&1 == 1 {
-				color = lastFgColor
+		// The sequencer keeps running behind the border, so that the first column
+		// is scrolled correctly in 38 column mode. It's fed with zeros outside of
+		// the display window.
+		if (pixel-startPixel)&0x07 == v.scrollX {
+			if column >= 0 && column < 40 {
+				v.cData = v.mBuf[column]
+				v.sequencer = v.gBuf[column]
 			} else {
-				localPixel := pixel - startPixel
-				if localPixel&0x07 == v.scrollX {
-					dataIdx := v.cycle%v.dimensions.CyclesPerLine - v.dimensions.FirstContentCycle
-					v.cData = v.cBuf[dataIdx]
-					v.sequencer = v.gBuf[dataIdx]
-				}
-				c, lpSprites := v.generateSpriteColor(pixel, false) // Low priority sprites
-				if lpSprites != 0 {
-					color = c
-				}
-				graphics := false
-				if v.bitmapMode {
-					c, graphics = v.generateBitmapColor()
-				} else {
-					c, graphics = v.generateTextColor()
-				}
-				// Draw graphics background if an only if no low priority sprite was drawn.
-				// In other words, screen background will have the lowest priority.
-				if graphics || !(lpSprites != 0) {
-					color = c
-				}
-				c, hpSprites := v.generateSpriteColor(pixel, true) // High priority sprites
-				if hpSprites != 0 {
-					color = c
-				}
-				lastFgColor = color
+				v.cData = 0
+				v.sequencer = 0
+			}
+			v.secondHalf = false
+		}
 
-				// Update collision accumulators
-				allSprites := lpSprites | hpSprites
-				if graphics {
-					cycleBgColl |= allSprites
-				}
-				// If zero or one bits are set, there were no collisions. Otherwise, all sprites that were
-				// drawn collided. Use a single round of Kernighan's algorithm to check.
-				if allSprites != 0 && allSprites&(allSprites-1) != 0 {
-					cycleSpriteColl |= allSprites
-				}
+		// Multicolor pixels are two pixels wide, so they're only generated every
+		// other pixel
+		if !v.secondHalf || !v.isMulticolorPixel() {
+			if v.bitmapMode {
+				v.graphicsColor, v.foreground = v.generateBitmapColor()
+			} else {
+				v.graphicsColor, v.foreground = v.generateTextColor()
 			}
 		}
-		v.screen.SetPixel(pixel, v.rasterLine-v.dimensions.FirstVisibleLine, C64Colors[color&0x0f])
+		v.secondHalf = !v.secondHalf
+
+		// The vertical border flip flop turns off the graphics, but not the sprites
+		foreground := v.foreground && !v.vBorderFF
+		color := v.graphicsColor
+		x := v.xCoordinate(int(pixel))
+		c, lpSprites := v.generateSpriteColor(x, false) // Low priority sprites
+		// Low priority sprites are only drawn on top of the background.
+		if lpSprites != 0 && !foreground {
+			color = c
+		}
+		c, hpSprites := v.generateSpriteColor(x, true) // High priority sprites
+		if hpSprites != 0 {
+			color = c
+		}
+		if v.hBorderFF {
+			color = v.borderCol
+		}
+
+		// Update collision accumulators. Sprites collide with the foreground
+		// regardless of priority, and even behind the border.
+		allSprites := lpSprites | hpSprites
+		if foreground {
+			cycleBgColl |= allSprites
+		}
+		// If zero or one bits are set, there were no collisions. Otherwise, all sprites that were
+		// drawn collided. Use a single round of Kernighan's algorithm to check.
+		if allSprites != 0 && allSprites&(allSprites-1) != 0 {
+			cycleSpriteColl |= allSprites
+		}
+		if pixel < v.dimensions.VisibleWidth {
+			v.screen.SetPixel(pixel, v.rasterLine-v.dimensions.FirstVisibleLine, v.palette[color&0x0f])
+		}
 		v.sequencer <<= 1
 	}
-	// New collisions during this cycle? Fire interrupt if enabled!
+	// The interrupt latches are only set by the first collision after the collision
+	// register was read.
 	if v.spriteSpriteColl == 0 && cycleSpriteColl != 0 {
 		v.irqSpriteSprite = true
-		if v.irqSpriteSpriteEnabled {
-			v.bus.NotIRQ.PullDown()
-		}
+		v.updateIRQ()
 	}
 	if v.spriteDataColl == 0 && cycleBgColl != 0 {
 		v.irqSpriteBg = true
-		if v.irqSpriteBgEnabled {
-			v.bus.NotIRQ.PullDown()
-		}
+		v.updateIRQ()
 	}
 
 	v.spriteSpriteColl |= cycleSpriteColl
@@ -391,7 +465,35 @@ func (v *VicII) drawBackground(n uint16, segment []uint8) {
 	}
 }
 
+// Multicolor text mode only shows characters with bit 3 of the color set in
+// multicolor. Multicolor bitmap mode is all multicolor.
+func (v *VicII) isMulticolorPixel() bool {
+	return v.multiColor && (v.bitmapMode || v.cData&0x0800 != 0)
+}
+
+// Returns the color of the graphics and whether it's foreground, which sprites
+// collide with.
 func (v *VicII) generateTextColor() (uint8, bool) {
+	color, foreground := v.textColor()
+	if v.extendedClr && v.multiColor {
+		// ECM and MCM together is an invalid mode. It draws black, but the pixels
+		// are still foreground or background like in multicolor text mode.
+		return 0, foreground
+	}
+	return color, foreground
+}
+
+func (v *VicII) generateBitmapColor() (uint8, bool) {
+	color, foreground := v.bitmapColor()
+	if v.extendedClr {
+		// ECM in bitmap mode is invalid. Like above, it draws black but collides
+		// like the corresponding valid bitmap mode.
+		return 0, foreground
+	}
+	return color, foreground
+}
+
+func (v *VicII) textColor() (uint8, bool) {
 	fgColor := uint8(v.cData>>8) & 0x0f
 	bgIndex := 0
 	if v.extendedClr {
@@ -400,15 +502,19 @@ func (v *VicII) generateTextColor() (uint8, bool) {
 
 	// Multicolor mode
 	if v.multiColor {
-		// N.Bus. This part must only be execute when x is even. Caller is responsible for that.
-		// TODO: Handle illegal modes
-
+		// N.Bus. This part must only be execute for the first of the two pixels. Caller is responsible for that.
+		if !v.isMulticolorPixel() {
+			if v.sequencer&0x80 != 0 {
+				return fgColor & 0x07, true
+			}
+			return v.backgroundColors[0] & 0x0f, false
+		}
 		cIndex := v.sequencer & 0xc0 >> 6
 		if cIndex == 0x03 {
-			return fgColor & 0x0f, true
+			return fgColor & 0x07, true
 		} else {
 			// Color 1 is treated as background too. Go figure!
-			return v.backgroundColors[cIndex] & 0x0f, cIndex != 1
+			return v.backgroundColors[cIndex] & 0x0f, cIndex > 1
 		}
 	} else {
 		if v.sequencer&0x80 != 0 {
@@ -419,15 +525,15 @@ func (v *VicII) generateTextColor() (uint8, bool) {
 	}
 }
 
-func (v *VicII) generateBitmapColor() (uint8, bool) {
+func (v *VicII) bitmapColor() (uint8, bool) {
 	bgColor := uint8(v.cData & 0x0f)
 	fgColor := uint8(v.cData>>4) & 0x0f
 	if v.multiColor {
-		// N.B. This part must only be execute when x is even. Caller is responsible for that.
+		// N.B. This part must only be execute for the first of the two pixels. Caller is responsible for that.
 		cIndex := v.sequencer & 0xc0 >> 6
 		switch cIndex {
 		case 0:
-			return v.backgroundColors[0], false
+			return v.backgroundColors[0] & 0x0f, false
 		case 1:
 			return uint8(v.cData>>4) & 0x0f, false // 01 is treated as background
 		case 2:
@@ -464,8 +570,10 @@ func (v *VicII) generateSpriteColor(x uint16, highPriority bool) (uint8, uint8)
 			continue
 		}
 
-		// Determine for how many pixels (if any) we should repeat
-		idx := x - s.x
+		// Determine for how many pixels (if any) we should repeat. The X coordinates
+		// wrap around at the end of the line.
+		lineWidth := v.dimensions.CyclesPerLine * 8
+		idx := (x + lineWidth - s.x%lineWidth) % lineWidth
 		r := uint8(1)
 		if s.multicolor {
 			r <<= 1
@@ -547,23 +655,23 @@ func (v *VicII) checkSpriteAndInit(first bool) {
 // Predict whether a sprite will need DMA in three cycles to allow
 // the VIC to pull RDY low.
 func (v *VicII) spriteWillNeedBus(localCycle uint16) bool {
-	sIndex, pCycle := getSpriteForCycle((localCycle - 3) % 63)
+	sIndex, pCycle := v.getSpriteForCycle((localCycle + 3) % v.dimensions.CyclesPerLine)
 	return sIndex != 0x0ff && pCycle && v.sprites[sIndex].dma
 }
 
 // Returns the sprite for this cycle (or 0xff if no sprite) and 'true' if this is
-// a p-access cycle.
-func getSpriteForCycle(localCycle uint16) (uint16, bool) {
+// a p-access cycle. Each sprite takes two cycles, starting with sprite 0 in the
+// model's FirstSpriteCycle and wrapping to the next line after the last cycle.
+// That is cycle 58 on PAL, 59 on the 6567R56A and 60 on the 6567R8.
+func (v *VicII) getSpriteForCycle(localCycle uint16) (uint16, bool) {
 	if localCycle == 0 {
-		return 2, false // Wrap around from cycle 63 on previous line
-	}
-	if localCycle <= 10 {
-		return (localCycle-1)/2 + 3, localCycle&1 == 1
+		localCycle = v.dimensions.CyclesPerLine // Wrap around from the end of the previous line
 	}
-	if localCycle >= 58 {
-		return (localCycle - 58) / 2, localCycle&1 == 0
+	offset := (localCycle + v.dimensions.CyclesPerLine - v.dimensions.FirstSpriteCycle) % v.dimensions.CyclesPerLine
+	if offset >= 16 {
+		return 0xff, false
 	}
-	return 0xff, false
+	return offset / 2, offset&1 == 0
 }
 
 func min(a uint16, b uint16) uint16 {
@@ -28,6 +28,7 @@ import (
 	"github.com/prydin/emu6502/core"
 	"github.com/stretchr/testify/require"
 	"image"
+	"image/color"
 	"image/png"
 	"os"
 	"strings"
@@ -363,8 +364,9 @@ func Test_getSpriteFromCycle(t *testing.T) {
 		61: 1,
 		62: 2,
 	}
+	vicii, _ := initVicII(nil, core.MakeRAM(1024))
 	for i := uint16(0); i < 63; i++ {
-		s, pAccess := getSpriteForCycle(i)
+		s, pAccess := vicii.getSpriteForCycle(i)
 		index, present := sprites[i]
 		if !present {
 			index = 0xff
@@ -378,6 +380,82 @@ func Test_getSpriteFromCycle(t *testing.T) {
 	}
 }
 
+func Test_getSpriteFromCycleNTSC(t *testing.T) {
+	vicii, _ := initVicII(nil, core.MakeRAM(1024))
+	vicii.dimensions = NTSCDimensions
+	for cycle, want := range map[uint16]uint16{58: 0xff, 59: 0xff, 60: 0, 64: 2, 0: 2, 1: 3, 10: 7, 11: 0xff} {
+		s, _ := vicii.getSpriteForCycle(cycle)
+		require.Equalf(t, want, s, "Sprite index mismatch at %d", cycle)
+	}
+	vicii.dimensions = OldNTSCDimensions
+	for cycle, want := range map[uint16]uint16{58: 0xff, 59: 0, 63: 2, 0: 2, 1: 3, 10: 7} {
+		s, _ := vicii.getSpriteForCycle(cycle)
+		require.Equalf(t, want, s, "Sprite index mismatch at %d", cycle)
+	}
+
+	// RDY goes low three cycles before the first access
+	vicii.dimensions = NTSCDimensions
+	vicii.sprites[3].dma = true
+	require.True(t, vicii.spriteWillNeedBus(63))
+	require.False(t, vicii.spriteWillNeedBus(62))
+}
+
+// The CPU is stunned from three cycles before the p-access of sprite 0 until its last
+// s-access, which is later on NTSC than on PAL. vic-ii.txt: 3.6.3.
+func TestSpriteStunCycles(t *testing.T) {
+	for _, test := range []struct {
+		dimensions ScreenDimensions
+		cycles     []uint16
+	}{
+		{PALDimensions, []uint16{55, 56, 57, 58, 59}},
+		{OldNTSCDimensions, []uint16{56, 57, 58, 59, 60}},
+		{NTSCDimensions, []uint16{57, 58, 59, 60, 61}},
+		{PALNDimensions, []uint16{57, 58, 59, 60, 61}},
+	} {
+		vicii, _ := initVicII(nil, core.MakeRAM(1024))
+		vicii.dimensions = test.dimensions
+		for b := vicii.Beam(); b.RasterLine != 10 || b.Cycle != 20; b = vicii.Beam() {
+			vicii.Clock()
+		}
+		vicii.sprites[0].dma = true
+		stunned := []uint16{}
+		for b := vicii.Beam(); b.RasterLine != 11 || b.Cycle != 20; b = vicii.Beam() {
+			vicii.Clock()
+			if b = vicii.Beam(); b.Phase == 2 && !b.RDY {
+				stunned = append(stunned, b.Cycle)
+			}
+		}
+		require.Equal(t, test.cycles, stunned, test.dimensions.Model)
+	}
+}
+
+func TestModels(t *testing.T) {
+	require.Equal(t, uint16(17095), NTSCDimensions.Cycles)
+	require.Equal(t, uint16(16768), OldNTSCDimensions.Cycles)
+	require.Equal(t, uint16(20280), PALNDimensions.Cycles)
+	require.InDelta(t, 50.12, PALDimensions.FrameRate(), 0.01)
+	require.InDelta(t, 59.83, NTSCDimensions.FrameRate(), 0.01)
+	require.InDelta(t, 50.47, PALNDimensions.FrameRate(), 0.01)
+	require.Equal(t, "6567R56A", Models["ntsc-old"].Model)
+
+	// A frame takes as many cycles as the model says and the raster counter wraps after
+	// the last line
+	for _, d := range []ScreenDimensions{NTSCDimensions, OldNTSCDimensions, PALNDimensions} {
+		vicii, _ := initVicII(nil, core.MakeRAM(1024))
+		vicii.dimensions = d
+		vicii.Clock()
+		lines := uint16(0)
+		for i := 1; i < int(d.Cycles)*2; i++ {
+			vicii.Clock()
+			if vicii.rasterLine > lines {
+				lines = vicii.rasterLine
+			}
+		}
+		require.True(t, vicii.IsVSynch(), d.Model)
+		require.Equal(t, d.ScreenHeight-1, lines, d.Model)
+	}
+}
+
 func TestPAccess(t *testing.T) {
 	cycles := []uint16{58, 60, 62, 1, 3, 5, 7, 9}
 	for i := 0; i < 8; i++ {
@@ -569,3 +647,580 @@ func TestNoSpriteSpriteCollision(t *testing.T) {
 	}
 	require.Equal(t, uint8(0x00), vicii.spriteSpriteColl, "Collision should not have occurred")
 }
+
+func TestBeam(t *testing.T) {
+	vicii, _ := initVicII(nil, core.MakeRAM(1024))
+	vicii.rasterLineTrigger = 0x33
+	vicii.irqRasterEnabled = true
+	beam := vicii.Beam()
+	require.True(t, beam.AtFrameStart())
+	require.Equal(t, "LINE:000 (0) CYCLE:00.1 BADLINE:0 RDY:1 IRQ:1 NMI:1", beam.String())
+
+	vicii.Clock()
+	require.Equal(t, Beam{Phase: 2, RDY: true, IRQ: true, NMI: true}, vicii.Beam())
+	require.False(t, vicii.Beam().AtFrameStart())
+
+	// The line changes with the cycle counter, before the raster counter does
+	for vicii.Beam() != (Beam{RasterLine: 1, Phase: 1, RDY: true, IRQ: true, NMI: true}) {
+		vicii.Clock()
+	}
+	require.Equal(t, uint16(PalCyclesPerLine), vicii.GetCycle())
+
+	// The bad line condition is checked from cycle 12 and stuns the CPU
+	for vicii.Beam().RasterLine != 0x33 || vicii.Beam().Cycle != 12 {
+		vicii.Clock()
+	}
+	beam = vicii.Beam()
+	require.False(t, beam.BadLine)
+	require.False(t, beam.IRQ, "Raster interrupt at the start of the line")
+	vicii.Clock()
+	beam = vicii.Beam()
+	require.True(t, beam.BadLine)
+	require.False(t, beam.RDY)
+	require.Equal(t, "LINE:033 (51) CYCLE:12.2 BADLINE:1 RDY:0 IRQ:0 NMI:1", beam.String())
+}
+
+// Set up a screen full of one character with a sprite on top of it
+func initCollision(char [8]uint8, color uint8) *VicII {
+	vicii, _ := initVicII(nil, core.MakeRAM(1024))
+	vicii.charSetPtr = 0x2000
+	for i, b := range char {
+		vicii.bus.WriteByte(0x2008+uint16(i), b)
+	}
+	for i := uint16(0); i < 1000; i++ {
+		vicii.bus.WriteByte(0x0400+i, 1)
+		vicii.colorRam.WriteByte(i, color)
+	}
+	for i := uint16(0); i < 63; i++ {
+		vicii.bus.WriteByte(0x3000+i, 0xff)
+	}
+	vicii.bus.WriteByte(0x07f8, 0x3000>>6)
+	vicii.sprites[0].enabled = true
+	vicii.sprites[0].x = 100
+	vicii.sprites[0].y = 100
+	vicii.sprites[0].color = 1
+	return vicii
+}
+
+func runFrame(vicii *VicII) {
+	for c := 0; c < PalScreenWidth*PalScreenHeight/4; c++ {
+		vicii.Clock()
+	}
+}
+
+func TestSpriteBackgroundCollision(t *testing.T) {
+	solid := [8]uint8{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	vicii := initCollision(solid, 1)
+	vicii.WriteByte(REG_IRQ_ENABLE, IRQ_SPRITE_BG_COLL)
+	runFrame(vicii)
+	require.True(t, vicii.irqSpriteBg)
+	require.False(t, vicii.cpuBus.NotIRQ.Get())
+	require.Equal(t, uint8(0xf2|IRQ_HAPPENED), vicii.ReadByte(REG_IRQ))
+
+	// The register is cleared when read
+	require.Equal(t, uint8(0x01), vicii.ReadByte(REG_DATA_COLL))
+	require.Equal(t, uint8(0x00), vicii.ReadByte(REG_DATA_COLL))
+
+	// Acknowledging releases the IRQ line
+	vicii.WriteByte(REG_IRQ, IRQ_SPRITE_BG_COLL)
+	require.True(t, vicii.cpuBus.NotIRQ.Get())
+	require.Equal(t, uint8(0x70), vicii.ReadByte(REG_IRQ))
+
+	// Only the first collision after reading the register sets the latch
+	runFrame(vicii)
+	require.True(t, vicii.irqSpriteBg)
+	vicii.WriteByte(REG_IRQ, IRQ_SPRITE_BG_COLL)
+	runFrame(vicii)
+	require.False(t, vicii.irqSpriteBg)
+	require.True(t, vicii.cpuBus.NotIRQ.Get())
+	require.Equal(t, uint8(0x01), vicii.ReadByte(REG_DATA_COLL))
+
+	// The latch is set while the interrupt is disabled, but the line isn't pulled
+	vicii.WriteByte(REG_IRQ_ENABLE, 0)
+	runFrame(vicii)
+	require.True(t, vicii.irqSpriteBg)
+	require.True(t, vicii.cpuBus.NotIRQ.Get())
+	vicii.WriteByte(REG_IRQ_ENABLE, IRQ_SPRITE_BG_COLL)
+	require.False(t, vicii.cpuBus.NotIRQ.Get())
+}
+
+func TestSpriteBackgroundCollisionModes(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		char      uint8
+		color     uint8
+		multi     bool
+		bitmap    bool
+		ecm       bool
+		collision bool
+	}{
+		{"text", 0x00, 0x01, false, false, false, false},
+		{"text", 0x10, 0x01, false, false, false, true},
+		{"ecm", 0x10, 0x01, false, false, true, true},
+		{"multicolor 01", 0x55, 0x09, true, false, false, false},
+		{"multicolor 10", 0xaa, 0x09, true, false, false, true},
+		{"multicolor 11", 0xc0, 0x09, true, false, false, true},
+		{"multicolor hires char", 0x55, 0x01, true, false, false, true},
+		{"bitmap", 0x01, 0x00, false, true, false, true},
+		{"multicolor bitmap 01", 0x55, 0x00, true, true, false, false},
+		{"multicolor bitmap 10", 0x22, 0x00, true, true, false, true},
+	} {
+		char := [8]uint8{}
+		for i := range char {
+			char[i] = test.char
+		}
+		vicii := initCollision(char, test.color)
+		if test.bitmap {
+			// The bitmap is at $2000 and covers the character set
+			for i := uint16(0); i < 0x1000; i++ {
+				vicii.bus.WriteByte(0x2000+i, test.char)
+			}
+		}
+		vicii.multiColor = test.multi
+		vicii.bitmapMode = test.bitmap
+		vicii.extendedClr = test.ecm
+		runFrame(vicii)
+		require.Equal(t, test.collision, vicii.ReadByte(REG_DATA_COLL) == 0x01, test.name)
+		require.Equal(t, test.collision, vicii.irqSpriteBg, test.name)
+	}
+}
+
+func TestLightPen(t *testing.T) {
+	vicii, _ := initVicII(nil, core.MakeRAM(1024))
+	vicii.WriteByte(REG_IRQ_ENABLE, IRQ_LP)
+	for vicii.Beam() != (Beam{RasterLine: 100, Cycle: 17, Phase: 2, RDY: true, IRQ: true, NMI: true}) {
+		vicii.Clock()
+	}
+	vicii.SetLightPen(false)
+	require.False(t, vicii.irqLp)
+	vicii.SetLightPen(true)
+	require.Equal(t, uint8(100), vicii.ReadByte(REG_LPY))
+	require.Equal(t, uint8(24/2), vicii.ReadByte(REG_LPX), "Same coordinates as the sprites")
+	require.False(t, vicii.cpuBus.NotIRQ.Get())
+	require.Equal(t, uint8(0x70|IRQ_LP|IRQ_HAPPENED), vicii.ReadByte(REG_IRQ))
+	vicii.WriteByte(REG_IRQ, IRQ_LP)
+	require.True(t, vicii.cpuBus.NotIRQ.Get())
+
+	// Only the first trigger in a frame counts
+	vicii.SetLightPen(false)
+	vicii.Clock()
+	vicii.Clock()
+	vicii.SetLightPen(true)
+	require.Equal(t, uint8(24/2), vicii.ReadByte(REG_LPX))
+	require.False(t, vicii.irqLp)
+
+	// Left of the first visible cycle, the coordinates wrap around
+	for !vicii.Beam().AtFrameStart() {
+		vicii.Clock()
+	}
+	for vicii.Beam().Cycle != 1 {
+		vicii.Clock()
+	}
+	vicii.SetLightPen(true)
+	require.Equal(t, uint8(0), vicii.ReadByte(REG_LPY))
+	require.Equal(t, uint8(0x190/2), vicii.ReadByte(REG_LPX))
+	require.True(t, vicii.irqLp)
+}
+
+// Write to a register like the CPU would in the given cycle. CPU writes happen after
+// the VIC-II has drawn the cycle, so they take effect in the next one.
+func writeInCycle(vicii *VicII, line, cycle uint16, reg uint16, data uint8) {
+	for b := vicii.Beam(); b.RasterLine != line || b.Cycle != cycle+1 || b.Phase != 1; b = vicii.Beam() {
+		vicii.Clock()
+	}
+	vicii.WriteByte(reg, data)
+}
+
+func finishFrame(vicii *VicII) {
+	for vicii.Clock(); !vicii.Beam().AtFrameStart(); vicii.Clock() {
+	}
+}
+
+// Returns the color of a pixel given its raster line and X coordinate
+func pixelAt(vicii *VicII, line uint16, x int) color.RGBA {
+	img := vicii.screen.(*ImageRaster).Img
+	return img.RGBAAt(x-24+PalLeftBorderWidth40Cols, int(line)-PalFirstVisibleLine)
+}
+
+func Test38Columns(t *testing.T) {
+	vicii := initCollision([8]uint8{}, 1)
+	vicii.col40 = false
+	runFrame(vicii)
+	border := C64Colors[vicii.borderCol]
+	background := C64Colors[vicii.backgroundColors[0]]
+	require.Equal(t, border, pixelAt(vicii, 100, 30))
+	require.Equal(t, background, pixelAt(vicii, 100, 31))
+	require.Equal(t, background, pixelAt(vicii, 100, 334))
+	require.Equal(t, border, pixelAt(vicii, 100, 335))
+}
+
+func TestOpenSideBorder(t *testing.T) {
+	vicii := initCollision([8]uint8{}, 1)
+	vicii.sprites[0].x = 350
+	vicii.sprites[0].y = 90
+	vicii.bus.WriteByte(0x07f9, 0x3000>>6)
+	vicii.sprites[1] = vicii.sprites[0]
+	vicii.sprites[1].x = 0x1f0 // Left of X=0
+	writeInCycle(vicii, 100, 56, REG_CTRL2, 0)
+	writeInCycle(vicii, 100, 58, REG_CTRL2, CR2_40COLS)
+	finishFrame(vicii)
+	border := C64Colors[vicii.borderCol]
+	background := C64Colors[vicii.backgroundColors[0]]
+	require.Equal(t, border, pixelAt(vicii, 99, 350), "Border is closed on other lines")
+	require.Equal(t, C64Colors[1], pixelAt(vicii, 100, 350), "Sprite should be visible in the opened border")
+	require.Equal(t, background, pixelAt(vicii, 100, 376))
+	require.Equal(t, background, pixelAt(vicii, 101, 20), "The border stays open until the left edge")
+	require.Equal(t, border, pixelAt(vicii, 102, 10))
+	require.Equal(t, C64Colors[1], pixelAt(vicii, 101, -8), "Sprites wrap around to the left border")
+	require.Equal(t, C64Colors[1], pixelAt(vicii, 101, 10))
+	require.Equal(t, border, pixelAt(vicii, 102, -8))
+
+	// Switching too late doesn't open the border
+	vicii = initCollision([8]uint8{}, 1)
+	writeInCycle(vicii, 100, 57, REG_CTRL2, 0)
+	writeInCycle(vicii, 100, 58, REG_CTRL2, CR2_40COLS)
+	finishFrame(vicii)
+	require.Equal(t, border, pixelAt(vicii, 100, 350))
+}
+
+func TestOpenTopBottomBorder(t *testing.T) {
+	vicii := initCollision([8]uint8{}, 1)
+	vicii.sprites[0].y = 0xfc
+	writeInCycle(vicii, 0xf9, 20, REG_CTRL1, CR1_ENABLE|3)
+	writeInCycle(vicii, 0xff, 20, REG_CTRL1, CR1_ENABLE|CR1_25LINES|3)
+	finishFrame(vicii)
+	border := C64Colors[vicii.borderCol]
+	background := C64Colors[vicii.backgroundColors[0]]
+	require.Equal(t, C64Colors[1], pixelAt(vicii, 0x100, 100), "Sprite should be visible in the opened border")
+	require.Equal(t, background, pixelAt(vicii, 0x100, 50))
+	require.Equal(t, border, pixelAt(vicii, 0x100, 10), "Side borders are still there")
+	writeInCycle(vicii, 0x40, 20, REG_CTRL1, CR1_ENABLE|CR1_25LINES|3)
+	require.Equal(t, background, pixelAt(vicii, 0x20, 50), "Stays open until the top of the next frame")
+	require.Equal(t, background, pixelAt(vicii, 0x3f, 50))
+
+	// The border is closed in the next frame
+	runFrame(vicii)
+	require.Equal(t, border, pixelAt(vicii, 0x100, 100))
+
+	// Switching after the bottom line has been reached doesn't open the border
+	vicii = initCollision([8]uint8{}, 1)
+	vicii.sprites[0].y = 0xfc
+	writeInCycle(vicii, 0xfb, 20, REG_CTRL1, CR1_ENABLE|3)
+	finishFrame(vicii)
+	require.Equal(t, border, pixelAt(vicii, 0x100, 100))
+}
+
+func TestIdleState(t *testing.T) {
+	for _, ecm := range []bool{false, true} {
+		vicii := initCollision([8]uint8{}, 1)
+		vicii.bus.WriteByte(0x3fff, 0xaa)
+		vicii.bus.WriteByte(0x39ff, 0x55)
+		vicii.extendedClr = ecm
+		vicii.scrollY = 7 // The first bad line is $37, so $33-$36 are idle
+		vicii.sprites[0].y = 0x32
+		vicii.sprites[0].x = 200
+		runFrame(vicii)
+		background := C64Colors[vicii.backgroundColors[0]]
+		black := C64Colors[0]
+		first, second := black, background // The pattern at $3fff
+		if ecm {
+			first, second = background, black // The pattern at $39ff
+		}
+		for x := 24; x < 40; x += 2 {
+			require.Equal(t, first, pixelAt(vicii, 0x34, x))
+			require.Equal(t, second, pixelAt(vicii, 0x34, x+1))
+		}
+		require.Equal(t, background, pixelAt(vicii, 0x38, 24), "Display state after the bad line")
+		require.Equal(t, uint8(0x01), vicii.ReadByte(REG_DATA_COLL), "Sprites collide with the idle pattern")
+	}
+}
+
+func TestInvalidModes(t *testing.T) {
+	solid := [8]uint8{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	for _, test := range []struct {
+		name   string
+		char   [8]uint8
+		multi  bool
+		bitmap bool
+	}{
+		{"ecm multicolor text", solid, true, false},
+		{"ecm bitmap", solid, false, true},
+		{"ecm multicolor bitmap", solid, true, true},
+	} {
+		vicii := initCollision(test.char, 0x0f)
+		if test.bitmap {
+			for i := uint16(0); i < 0x1000; i++ {
+				vicii.bus.WriteByte(0x2000+i, 0xff)
+			}
+		}
+		vicii.extendedClr = true
+		vicii.multiColor = test.multi
+		vicii.bitmapMode = test.bitmap
+		runFrame(vicii)
+		require.Equal(t, C64Colors[0], pixelAt(vicii, 0x40, 24), test.name)
+		require.Equal(t, C64Colors[0], pixelAt(vicii, 0xf0, 343), test.name)
+		require.Equal(t, uint8(0x01), vicii.ReadByte(REG_DATA_COLL), test.name)
+	}
+}
+
+// Set up a screen where every character is drawn with its own screen code as the
+// pattern on every row, so that cellAt can tell which video matrix position a cell
+// was fetched from.
+func initTiming(mainBus *core.Bus) *VicII {
+	vicii, _ := initVicII(mainBus, core.MakeRAM(1024))
+	vicii.charSetPtr = 0x2000
+	for i := uint16(0); i < 0x800; i++ {
+		vicii.bus.WriteByte(0x2000+i, uint8(i>>3))
+	}
+	for i := uint16(0); i < 1000; i++ {
+		vicii.bus.WriteByte(0x0400+i, uint8(i))
+		vicii.colorRam.WriteByte(i, 1)
+	}
+	return vicii
+}
+
+// Returns the pattern drawn in a character column of a raster line
+func cellAt(vicii *VicII, line uint16, column int) uint8 {
+	background := C64Colors[vicii.backgroundColors[0]]
+	pattern := uint8(0)
+	for b := 0; b < 8; b++ {
+		pattern <<= 1
+		if pixelAt(vicii, line, 24+column*8+b) != background {
+			pattern |= 1
+		}
+	}
+	return pattern
+}
+
+// Runs a program at $1000 on a CPU that sees the VIC-II at $D000 and has a zero page.
+// The CPU starts with the next half cycle.
+func startProgram(t *testing.T, vicii *VicII, program string) {
+	mainBus := vicii.cpuBus
+	mainBus.Connect(vicii, 0xd000, 0xd0ff)
+	mainBus.Connect(core.MakeRAM(0x100), 0x0000, 0x00ff)
+	code, err := assemble(program)
+	require.NoError(t, err)
+	mainBus.Connect(&core.RAM{Bytes: code}, 0x1000, 0x1000+uint16(len(code)))
+	cpu := &core.CPU{}
+	cpu.Init(mainBus)
+	cpu.SetPC(0x1000)
+	mainBus.ConnectClockablePh1(cpu)
+}
+
+func TestFLD(t *testing.T) {
+	vicii := initTiming(&core.Bus{})
+
+	// Keep YSCROLL two lines ahead of the raster for 18 lines after the first row
+	startProgram(t, vicii, `
+WAIT	LDA $D012
+		CMP #$39
+		BNE WAIT
+		LDX #$12
+LOOP	CMP $D012
+		BEQ LOOP
+		LDA $D012
+		STA $02
+		CLC
+		ADC #$02
+		AND #$07
+		ORA #$18
+		STA $D011
+		LDA $02
+		DEX
+		BNE LOOP
+NEXT	CMP $D012
+		BEQ NEXT
+		LDA #$1B
+		STA $D011
+DONE	JMP DONE
+`)
+	runFrame(vicii)
+	require.Equal(t, uint16(3), vicii.scrollY)
+	require.Equal(t, uint8(1), cellAt(vicii, 0x3a, 1), "Last line of the first row")
+	require.Equal(t, uint8(0), cellAt(vicii, 0x3b, 1), "Idle while the bad lines are suppressed")
+	require.Equal(t, uint8(0), cellAt(vicii, 0x52, 1))
+	require.Equal(t, uint8(41), cellAt(vicii, 0x53, 1), "The second row is pushed down 24 lines")
+	require.Equal(t, uint8(81), cellAt(vicii, 0x5b, 1))
+	require.Equal(t, uint8((21*40+1)&0xff), cellAt(vicii, 0xf3, 1), "The last rows fall off the bottom")
+}
+
+func TestFLI(t *testing.T) {
+	vicii := initTiming(nil)
+	for i := uint16(0); i < 1000; i++ {
+		vicii.bus.WriteByte(0x0400+i, 1)
+	}
+	for r := uint16(0); r < 8; r++ {
+		vicii.bus.WriteByte(0x2008+r, 0x80>>r)
+		vicii.bus.WriteByte(0x27f8+r, 0xff)
+	}
+
+	// Force a bad line after cycle 14 on every line and change the colors in between
+	for line := uint16(0x80); line < 0x88; line++ {
+		writeInCycle(vicii, line, 14, REG_CTRL1, CR1_ENABLE|CR1_25LINES|uint8(line&0x07))
+		for i := uint16(0); i < 1000; i++ {
+			vicii.colorRam.WriteByte(i, uint8(line&0x07)+8)
+		}
+	}
+	writeInCycle(vicii, 0x88, 5, REG_CTRL1, CR1_ENABLE|CR1_25LINES|3)
+	finishFrame(vicii)
+
+	grey := C64Colors[15]
+	background := C64Colors[vicii.backgroundColors[0]]
+	require.Equal(t, background, pixelAt(vicii, 0x7f, 24))
+	for line := uint16(0x80); line < 0x88; line++ {
+		rc := int(line+5) & 0x07 // The row started at $7b and RC isn't reset
+		require.Equal(t, grey, pixelAt(vicii, line, 24), "The first three columns read $ff")
+		require.Equal(t, grey, pixelAt(vicii, line, 24+2*8+7))
+		require.Equal(t, C64Colors[line&0x07+8], pixelAt(vicii, line, 24+3*8+rc), "New colors on every line")
+		require.Equal(t, background, pixelAt(vicii, line, 24+3*8+(rc+1)&0x07))
+	}
+}
+
+func TestFLIProgram(t *testing.T) {
+	vicii := initTiming(&core.Bus{})
+	for i := uint16(0); i < 0x400; i++ {
+		for screen := uint16(0); screen < 4; screen++ {
+			vicii.bus.WriteByte(0x2800+screen*0x400+i, uint8(0x41+screen))
+		}
+	}
+
+	// Switch to a new video matrix and force a bad line on each of four lines
+	startProgram(t, vicii, `
+		LDY #$80
+		LDX #$00
+LOOP	CPY $D012
+		BNE LOOP
+		LDA SCREENS,X
+		STA $D018
+		TYA
+		AND #$07
+		ORA #$18
+		STA $D011
+		INX
+		INY
+		CPY #$84
+		BNE LOOP
+		LDA #$1B
+		STA $D011
+		LDA #$18
+		STA $D018
+DONE	JMP DONE
+SCREENS	.BYTE $A8, $B8, $C8, $D8
+`)
+	runFrame(vicii)
+	require.Equal(t, uint8((9*40+39)&0xff), cellAt(vicii, 0x7f, 39), "Last line of the normal row")
+	for line := uint16(0x80); line < 0x84; line++ {
+		// Where the bad line starts depends on when the polling loop sees the line,
+		// but it's always over before the right half of the line.
+		for column := 20; column < 40; column++ {
+			require.Equalf(t, uint8(0x41+line-0x80), cellAt(vicii, line, column), "Line %X, column %d", line, column)
+		}
+	}
+}
+
+func TestVSP(t *testing.T) {
+	vicii := initTiming(nil)
+	vicii.scrollY = 4
+	finishFrame(vicii) // Leave RC at 7 the way the last row of a frame does
+
+	// Trigger the first bad line in cycle 21 instead of cycle 12
+	writeInCycle(vicii, 0x33, 20, REG_CTRL1, CR1_ENABLE|CR1_25LINES|3)
+	finishFrame(vicii)
+	grey := C64Colors[15]
+	for column := 6; column <= 8; column++ {
+		require.Equal(t, uint8(0xff), cellAt(vicii, 0x33, column), "The CPU still has the bus")
+		require.Equal(t, grey, pixelAt(vicii, 0x33, 24+column*8))
+	}
+	require.Equal(t, uint8(4), cellAt(vicii, 0x33, 9))
+
+	// The rows from the next bad line on are shifted five characters to the right
+	require.Equal(t, uint8(35), cellAt(vicii, 0x3b, 0))
+	require.Equal(t, uint8(40), cellAt(vicii, 0x3b, 5))
+	require.Equal(t, uint8(75), cellAt(vicii, 0x43, 0))
+	require.Equal(t, uint8(80), cellAt(vicii, 0x43, 5))
+}
+
+func TestVSPProgram(t *testing.T) {
+	vicii := initTiming(&core.Bus{})
+	vicii.scrollY = 4
+	finishFrame(vicii) // Leave RC at 7 the way the last row of a frame does
+
+	// Trigger the first bad line in the middle of the line
+	startProgram(t, vicii, `
+		LDY #$33
+		LDX #$1B
+WAIT	CPY $D012
+		BNE WAIT
+		NOP
+		NOP
+		NOP
+		NOP
+		NOP
+		NOP
+		STX $D011
+DONE	JMP DONE
+`)
+	runFrame(vicii)
+
+	// The shift depends on when the polling loop sees the line, but the rows move
+	// together and the characters fetched while the CPU has the bus read $ff
+	shift := 40 - int(cellAt(vicii, 0x3b, 0))
+	require.True(t, shift >= 3 && shift <= 9, "Shifted %d characters", shift)
+	require.Equal(t, uint8(40), cellAt(vicii, 0x3b, shift))
+	require.Equal(t, uint8(80), cellAt(vicii, 0x43, shift))
+	for column := shift + 1; column <= shift+3; column++ {
+		require.Equal(t, uint8(0xff), cellAt(vicii, 0x33, column), "The CPU still has the bus")
+	}
+}
+
+func TestLinecrunch(t *testing.T) {
+	vicii := initTiming(nil)
+
+	// Cancel three bad lines before cycle 14
+	for line := uint16(0x3b); line < 0x3e; line++ {
+		writeInCycle(vicii, line, 5, REG_CTRL1, CR1_ENABLE|CR1_25LINES|uint8(line+1)&0x07)
+	}
+	finishFrame(vicii)
+	require.Equal(t, uint8(1), cellAt(vicii, 0x3a, 1))
+	require.Equal(t, uint8(1), cellAt(vicii, 0x3b, 1), "Crunched lines repeat the last pixel row")
+	require.Equal(t, uint8(1), cellAt(vicii, 0x3d, 1))
+	require.Equal(t, uint8(161), cellAt(vicii, 0x3e, 1), "Three rows are skipped")
+	require.Equal(t, uint8(161), cellAt(vicii, 0x45, 1))
+	require.Equal(t, uint8(201), cellAt(vicii, 0x46, 1))
+}
+
+func TestLinecrunchProgram(t *testing.T) {
+	vicii := initTiming(&core.Bus{})
+
+	// Cancel three bad lines before cycle 14
+	startProgram(t, vicii, `
+		LDX #$1C
+		LDY #$3B
+LOOP	CPY $D012
+		BNE LOOP
+		STX $D011
+		INX
+		INY
+		CPY #$3E
+		BNE LOOP
+DONE	JMP DONE
+`)
+	runFrame(vicii)
+	require.Equal(t, uint8(1), cellAt(vicii, 0x3a, 1))
+	require.Equal(t, uint8(1), cellAt(vicii, 0x3b, 1), "Crunched lines repeat the last pixel row")
+	require.Equal(t, uint8(1), cellAt(vicii, 0x3d, 1))
+	require.Equal(t, uint8(161), cellAt(vicii, 0x3e, 1), "Three rows are skipped")
+	require.Equal(t, uint8(201), cellAt(vicii, 0x46, 1))
+}
+
+func TestBadLineStunsCPU(t *testing.T) {
+	cpuBus := &core.Bus{}
+	vicii, _ := initVicII(cpuBus, core.MakeRAM(1024))
+	stunned := false
+	for i := 0; i < 2*PalCycles; i++ {
+		vicii.Clock()
+		require.True(t, vicii.bus.RDY.Get(), "RDY pulled on the VIC-II's own bus")
+		stunned = stunned || !cpuBus.RDY.Get()
+	}
+	require.True(t, stunned, "RDY never pulled on the CPU bus")
+}
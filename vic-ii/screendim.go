@@ -21,21 +21,23 @@
 
 package vic_ii
 
-// PAL screen constants
+// PAL screen constants. The visible area is what a typical monitor shows, which
+// includes the parts of the border that can be opened. The display window starts at
+// X coordinate 24 in cycle 17 and at raster line 51.
 const (
 	PalFirstVisibleCycle = 11
 	PalFirstVisibleLine  = 16
-	PalLastVisibleLine   = 287
+	PalLastVisibleLine   = 299
 	PalCyclesPerLine     = 63
-	PalLastVisibleCycle  = 58
+	PalLastVisibleCycle  = 61
 
-	PalLeftBorderWidth40Cols  = 32
-	PalRightBorderWidth40Cols = 32
-	PalLeftBorderWidth38Cols  = 46
-	PalRightBorderWidth38Cols = 36
+	PalLeftBorderWidth40Cols  = 48
+	PalRightBorderWidth40Cols = 35
+	PalLeftBorderWidth38Cols  = PalLeftBorderWidth40Cols + 7
+	PalRightBorderWidth38Cols = PalRightBorderWidth40Cols + 9
 
-	PalTopBorderHeight40Cols = 36
-	PalTopBorderHeight38Cols = 40
+	PalTopBorderHeight40Cols = 35 // 25 rows
+	PalTopBorderHeight38Cols = 39 // 24 rows
 
 	PalContentTop25Lines    = PalFirstVisibleLine + PalTopBorderHeight40Cols
 	PalContentTop24Lines    = PalFirstVisibleLine + PalTopBorderHeight38Cols
@@ -45,8 +47,9 @@ const (
 	PalContentWidth40Cols = 320
 	PalContentWidth38Cols = PalContentWidth40Cols - 16
 
-	PalFirstContentCycle = 15
+	PalFirstContentCycle = 17
 	PalLastContentCycle  = 56
+	PalFirstSpriteCycle  = 58 // p-access of sprite 0
 	PalScreenWidth       = PalCyclesPerLine * 8
 	PalScreenHeight      = 312
 
@@ -56,7 +59,40 @@ const (
 	PalCycles = PalCyclesPerLine * 312
 
 	PalVisibleWidth  = PalContentWidth40Cols + PalRightBorderWidth40Cols + PalLeftBorderWidth40Cols
-	PalVisibleHeight = PalContentBottom25Lines + PalTopBorderHeight40Cols
+	PalVisibleHeight = PalLastVisibleLine - PalFirstVisibleLine + 1
+
+	PalClockFrequency = 985248 // 17.734472 MHz / 18
+	PalFrequency      = 50     // Mains frequency fed to the TOD clocks of the CIAs
+)
+
+// NTSC screen constants. The display window is at the same raster lines as on PAL,
+// but there are fewer lines, so the borders are smaller. Lines at the bottom of the
+// border that are shown after the raster counter wraps aren't drawn.
+const (
+	NtscFirstVisibleLine = 28
+	NtscLastVisibleLine  = 262
+	NtscCyclesPerLine    = 65
+	NtscScreenHeight     = 263
+	NtscFirstSpriteCycle = 60 // p-access of sprite 0
+
+	NtscClockFrequency = 1022727 // 14.31818 MHz / 14
+	NtscFrequency      = 60
+)
+
+// Old NTSC (6567R56A) screen constants. The first NTSC machines had one cycle less per
+// line and one line less per frame.
+const (
+	OldNtscLastVisibleLine  = 261
+	OldNtscCyclesPerLine    = 64
+	OldNtscScreenHeight     = 262
+	OldNtscFirstSpriteCycle = 59 // p-access of sprite 0
+)
+
+// PAL-N (6572) screen constants. The Drean Commodore 64 sold in Argentina has as many
+// lines as PAL, but as many cycles per line as NTSC and an NTSC-like clock.
+const (
+	PalNCyclesPerLine  = 65
+	PalNClockFrequency = 1023440 // 14.328225 MHz / 14
 )
 
 type ScreenDimensions struct {
@@ -76,6 +112,7 @@ type ScreenDimensions struct {
 	ContentWidth38Cols     uint16
 	FirstContentCycle      uint16
 	LastContentCycle       uint16
+	FirstSpriteCycle       uint16 // Cycle of the p-access of sprite 0. The others follow every other cycle.
 	OptimalYScroll25Lines  uint16
 	OptimalYScroll24Lines  uint16
 
@@ -84,8 +121,13 @@ type ScreenDimensions struct {
 
 	FirstVisibleLine uint16
 	LastVisibleLine  uint16
+	LastVisibleCycle uint16
 	CyclesPerLine    uint16
 	Cycles           uint16
+
+	Model          string // VIC-II revision
+	ClockFrequency uint32 // System clock in Hz, which the VIC-II derives from its crystal
+	PowerFrequency uint32 // Mains frequency in Hz, which drives the TOD clocks
 }
 
 var PALDimensions = ScreenDimensions{
@@ -100,13 +142,13 @@ var PALDimensions = ScreenDimensions{
 	LeftBorderWidth38Cols:  PalLeftBorderWidth38Cols,
 	LeftBorderWidth40Cols:  PalLeftBorderWidth40Cols,
 	RightBorderWidth38Cols: PalRightBorderWidth38Cols,
-	RightBorderWidth40Cols: PalLeftBorderWidth40Cols,
-
+	RightBorderWidth40Cols: PalRightBorderWidth40Cols,
 
 	ContentWidth40Cols:    PalContentWidth40Cols,
 	ContentWidth38Cols:    PalContentWidth38Cols,
 	FirstContentCycle:     PalFirstContentCycle,
 	LastContentCycle:      PalLastContentCycle,
+	FirstSpriteCycle:      PalFirstSpriteCycle,
 	OptimalYScroll25Lines: PalOptimalYScroll25Lines,
 	OptimalYScroll24Lines: PalOptimalYScroll24Lines,
 
@@ -116,6 +158,71 @@ var PALDimensions = ScreenDimensions{
 	FirstVisibleLine:  PalFirstVisibleLine,
 	LastVisibleLine:   PalLastVisibleLine,
 	FirstVisibleCycle: PalFirstVisibleCycle,
+	LastVisibleCycle:  PalLastVisibleCycle,
 	CyclesPerLine:     PalCyclesPerLine,
 	Cycles:            PalCycles,
+
+	Model:          "6569",
+	ClockFrequency: PalClockFrequency,
+	PowerFrequency: PalFrequency,
+}
+
+// NTSCDimensions is the timing of the 6567R8, which is used in most NTSC machines.
+// The horizontal layout is the one of PAL, with the extra cycles in the blanking
+// before the sprite fetches.
+var NTSCDimensions = ntscDimensions("6567R8", NtscScreenHeight, NtscCyclesPerLine, NtscLastVisibleLine,
+	NtscFirstSpriteCycle)
+
+// OldNTSCDimensions is the timing of the 6567R56A found in early NTSC machines.
+var OldNTSCDimensions = ntscDimensions("6567R56A", OldNtscScreenHeight, OldNtscCyclesPerLine, OldNtscLastVisibleLine,
+	OldNtscFirstSpriteCycle)
+
+// PALNDimensions is the timing of the 6572 found in the Drean Commodore 64. Like on
+// the 6567R8, the extra cycles are in the blanking before the sprite fetches.
+var PALNDimensions = palNDimensions()
+
+func ntscDimensions(model string, lines, cyclesPerLine, lastVisibleLine, firstSpriteCycle uint16) ScreenDimensions {
+	d := PALDimensions
+	d.Model = model
+	d.ScreenHeight = lines
+	d.ScreenWidth = cyclesPerLine * 8
+	d.CyclesPerLine = cyclesPerLine
+	d.Cycles = cyclesPerLine * lines
+	d.FirstSpriteCycle = firstSpriteCycle
+	d.FirstVisibleLine = NtscFirstVisibleLine
+	d.LastVisibleLine = lastVisibleLine
+	d.VisibleHeight = lastVisibleLine - NtscFirstVisibleLine + 1
+	d.ClockFrequency = NtscClockFrequency
+	d.PowerFrequency = NtscFrequency
+	return d
+}
+
+func palNDimensions() ScreenDimensions {
+	d := PALDimensions
+	d.Model = "6572"
+	d.ScreenWidth = PalNCyclesPerLine * 8
+	d.CyclesPerLine = PalNCyclesPerLine
+	d.Cycles = PalNCyclesPerLine * PalScreenHeight
+	d.FirstSpriteCycle = NtscFirstSpriteCycle
+	d.ClockFrequency = PalNClockFrequency
+	return d
+}
+
+// Models maps the names of the machine models to their timing. Each model can be
+// given by its VIC-II revision too.
+var Models = map[string]ScreenDimensions{
+	"pal":      PALDimensions,
+	"6569":     PALDimensions,
+	"ntsc":     NTSCDimensions,
+	"6567r8":   NTSCDimensions,
+	"ntsc-old": OldNTSCDimensions,
+	"6567r56a": OldNTSCDimensions,
+	"pal-n":    PALNDimensions,
+	"drean":    PALNDimensions,
+	"6572":     PALNDimensions,
+}
+
+// FrameRate returns the number of frames per second.
+func (d ScreenDimensions) FrameRate() float64 {
+	return float64(d.ClockFrequency) / float64(d.Cycles)
 }
@@ -27,5 +27,12 @@ import (
 )
 
 func TestScreenDim(t *testing.T) {
-	require.Equal(t, 403, PalRightBorderWidth38Cols+PalContentWidth40Cols+PalRightBorderWidth40Cols, "Visible width doesn't add up")
+	require.Equal(t, 403, PalLeftBorderWidth40Cols+PalContentWidth40Cols+PalRightBorderWidth40Cols, "Visible width doesn't add up")
+	require.Equal(t, 403, PalLeftBorderWidth38Cols+PalContentWidth38Cols+PalRightBorderWidth38Cols, "Visible width in 38 column mode doesn't add up")
+	require.Equal(t, PalLeftBorderWidth40Cols, (PalFirstContentCycle-PalFirstVisibleCycle)*8, "Display window must start with a cycle")
+	require.Equal(t, PalVisibleWidth, PalLeftBorderWidth40Cols+(PalLastContentCycle-PalFirstContentCycle+1)*8+PalRightBorderWidth40Cols)
+	require.Equal(t, uint16(0x33), PALDimensions.ContentTop25Lines)
+	require.Equal(t, uint16(0xfb), PALDimensions.ContentBottom25Lines)
+	require.Equal(t, uint16(0x37), PALDimensions.ContentTop24Lines)
+	require.Equal(t, uint16(0xf7), PALDimensions.ContentBottom24Lines)
 }
@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+// SpriteState is the part of the sprite state that is saved in snapshots.
+type SpriteState struct {
+	Enabled        bool
+	X              uint16
+	Y              uint8
+	Color          uint8
+	ExpandedX      bool
+	ExpandedY      bool
+	HasPriority    bool
+	MultiColor     bool
+	MC             uint8
+	MCBase         uint8
+	DMA            bool
+	ExpandYFF      bool
+	DisplayEnabled bool
+}
+
+// State is the part of the VIC-II state that is saved in snapshots. Snapshots are
+// taken between CPU instructions, which may be in the middle of a raster line, so
+// the beam position and the internal counters are saved along with the registers.
+type State struct {
+	Sprites                [8]SpriteState
+	BorderColor            uint8
+	BackgroundColors       [4]uint8
+	SpriteMultiColor0      uint8
+	SpriteMultiColor1      uint8
+	RasterLineTrigger      uint16
+	ScrollX                uint16
+	ScrollY                uint16
+	Line25                 bool
+	Col40                  bool
+	Enable                 bool
+	BitmapMode             bool
+	ExtendedColor          bool
+	MultiColor             bool
+	IRQRaster              bool
+	IRQSpriteBg            bool
+	IRQSpriteSprite        bool
+	IRQLp                  bool
+	IRQEnabled             bool
+	IRQRasterEnabled       bool
+	IRQSpriteBgEnabled     bool
+	IRQSpriteSpriteEnabled bool
+	IRQLpEnabled           bool
+	CharSetPtr             uint16
+	ScreenMemPtr           uint16
+	SpriteSpriteColl       uint8
+	SpriteDataColl         uint8
+
+	RasterLine   uint16
+	Cycle        uint16
+	ClockPhase2  bool
+	VC           uint16
+	RC           uint16
+	VCBase       uint16
+	VMLI         uint16
+	DisplayState bool
+	BadLine      bool
+	VBorderFF    bool
+	HBorderFF    bool
+
+	LightPenX         uint8
+	LightPenY         uint8
+	LightPenTriggered bool
+}
+
+func (v *VicII) State() State {
+	s := State{
+		BorderColor:            v.borderCol,
+		BackgroundColors:       v.backgroundColors,
+		SpriteMultiColor0:      v.spriteMultiClr0,
+		SpriteMultiColor1:      v.spriteMultiClr1,
+		RasterLineTrigger:      v.rasterLineTrigger,
+		ScrollX:                v.scrollX,
+		ScrollY:                v.scrollY,
+		Line25:                 v.line25,
+		Col40:                  v.col40,
+		Enable:                 v.enable,
+		BitmapMode:             v.bitmapMode,
+		ExtendedColor:          v.extendedClr,
+		MultiColor:             v.multiColor,
+		IRQRaster:              v.irqRaster,
+		IRQSpriteBg:            v.irqSpriteBg,
+		IRQSpriteSprite:        v.irqSpriteSprite,
+		IRQLp:                  v.irqLp,
+		IRQEnabled:             v.irqEnabled,
+		IRQRasterEnabled:       v.irqRasterEnabled,
+		IRQSpriteBgEnabled:     v.irqSpriteBgEnabled,
+		IRQSpriteSpriteEnabled: v.irqSpriteSpriteEnabled,
+		IRQLpEnabled:           v.irqLpEnabled,
+		CharSetPtr:             v.charSetPtr,
+		ScreenMemPtr:           v.screenMemPtr,
+		SpriteSpriteColl:       v.spriteSpriteColl,
+		SpriteDataColl:         v.spriteDataColl,
+		RasterLine:             v.rasterLine,
+		Cycle:                  v.cycle,
+		ClockPhase2:            v.clockPhase2,
+		VC:                     v.vc,
+		RC:                     v.rc,
+		VCBase:                 v.vcBase,
+		VMLI:                   v.vmli,
+		DisplayState:           v.displayState,
+		BadLine:                v.badLine,
+		VBorderFF:              v.vBorderFF,
+		HBorderFF:              v.hBorderFF,
+		LightPenX:              v.lpX,
+		LightPenY:              v.lpY,
+		LightPenTriggered:      v.lpTriggered,
+	}
+	for i, sp := range v.sprites {
+		s.Sprites[i] = SpriteState{
+			Enabled:        sp.enabled,
+			X:              sp.x,
+			Y:              sp.y,
+			Color:          sp.color,
+			ExpandedX:      sp.expandedX,
+			ExpandedY:      sp.expandedY,
+			HasPriority:    sp.hasPriority,
+			MultiColor:     sp.multicolor,
+			MC:             sp.mc,
+			MCBase:         sp.mcBase,
+			DMA:            sp.dma,
+			ExpandYFF:      sp.expandYFF,
+			DisplayEnabled: sp.displayEnabled,
+		}
+	}
+	return s
+}
+
+// SetState restores the VIC-II from a snapshot. The sequencers start over with empty
+// shift registers, so the first few pixels after the restore may be off.
+func (v *VicII) SetState(s State) {
+	v.borderCol = s.BorderColor
+	v.backgroundColors = s.BackgroundColors
+	v.spriteMultiClr0 = s.SpriteMultiColor0
+	v.spriteMultiClr1 = s.SpriteMultiColor1
+	v.rasterLineTrigger = s.RasterLineTrigger
+	v.scrollX = s.ScrollX
+	v.scrollY = s.ScrollY
+	v.line25 = s.Line25
+	v.col40 = s.Col40
+	v.enable = s.Enable
+	v.bitmapMode = s.BitmapMode
+	v.extendedClr = s.ExtendedColor
+	v.multiColor = s.MultiColor
+	v.irqRaster = s.IRQRaster
+	v.irqSpriteBg = s.IRQSpriteBg
+	v.irqSpriteSprite = s.IRQSpriteSprite
+	v.irqLp = s.IRQLp
+	v.irqEnabled = s.IRQEnabled
+	v.irqRasterEnabled = s.IRQRasterEnabled
+	v.irqSpriteBgEnabled = s.IRQSpriteBgEnabled
+	v.irqSpriteSpriteEnabled = s.IRQSpriteSpriteEnabled
+	v.irqLpEnabled = s.IRQLpEnabled
+	v.charSetPtr = s.CharSetPtr
+	v.screenMemPtr = s.ScreenMemPtr
+	v.spriteSpriteColl = s.SpriteSpriteColl
+	v.spriteDataColl = s.SpriteDataColl
+	v.rasterLine = s.RasterLine
+	v.cycle = s.Cycle
+	v.clockPhase2 = s.ClockPhase2
+	v.vc = s.VC
+	v.rc = s.RC
+	v.vcBase = s.VCBase
+	v.vmli = s.VMLI
+	v.displayState = s.DisplayState
+	v.badLine = s.BadLine
+	v.vBorderFF = s.VBorderFF
+	v.hBorderFF = s.HBorderFF
+	v.lpX = s.LightPenX
+	v.lpY = s.LightPenY
+	v.lpTriggered = s.LightPenTriggered
+	v.sequencer = 0
+	v.cData = 0
+	v.updateIRQ()
+	for i, sp := range s.Sprites {
+		v.sprites[i] = Sprite{
+			enabled:        sp.Enabled,
+			x:              sp.X,
+			y:              sp.Y,
+			color:          sp.Color,
+			expandedX:      sp.ExpandedX,
+			expandedY:      sp.ExpandedY,
+			hasPriority:    sp.HasPriority,
+			multicolor:     sp.MultiColor,
+			mc:             sp.MC,
+			mcBase:         sp.MCBase,
+			dma:            sp.DMA,
+			expandYFF:      sp.ExpandYFF,
+			displayEnabled: sp.DisplayEnabled,
+		}
+	}
+}
@@ -23,6 +23,7 @@ package vic_ii
 
 import (
 	"github.com/prydin/emu6502/core"
+	"image/color"
 )
 
 const (
@@ -169,24 +170,39 @@ type VicII struct {
 	skipFrame bool
 
 	// Bad line flags
-	badLine bool
+	badLine    bool
+	baLowCycle uint16 // Cycle the current bad line pulled BA low
 
-	// Internal color and character buffers
+	// Internal color and character buffers. The video matrix line in cBuf is indexed
+	// by VMLI, while gBuf and mBuf hold what the g-access of each cycle fetched and
+	// the video matrix data it used, for the sequencer to pick up.
 	cBuf [40]uint16
 	gBuf [40]uint8
+	mBuf [40]uint16
 
 	// Screen refresh state
 	displayState bool
 	cycle        uint16
 	screen       Raster
+	palette      []color.RGBA
 
 	// Border flip flops
 	vBorderFF bool
 	hBorderFF bool
 
 	// Sequencer internal registers
-	sequencer uint8  // Sequencer shift register
-	cData     uint16 // Current c-data
+	sequencer     uint8  // Sequencer shift register
+	cData         uint16 // Current c-data
+	secondHalf    bool   // Showing the second half of a multicolor pixel
+	graphicsColor uint8  // Color of the current graphics pixel
+	foreground    bool   // The current graphics pixel is foreground
+
+	irqLine bool // The IRQ line is pulled low
+
+	// Light pen latches
+	lpX         uint8
+	lpY         uint8
+	lpTriggered bool // Latched during this frame
 }
 
 func (v *VicII) Init(bus *core.Bus, cpuBus *core.Bus, colorRam core.AddressSpace, screen Raster, dimensions ScreenDimensions) {
@@ -202,6 +218,7 @@ func (v *VicII) Init(bus *core.Bus, cpuBus *core.Bus, colorRam core.AddressSpace
 	v.borderCol = 4
 	v.bus = bus
 	v.screen = screen
+	v.palette = C64Colors
 	v.dimensions = dimensions
 	v.colorRam = colorRam
 	v.cpuBus = cpuBus
@@ -212,6 +229,17 @@ func (v *VicII) Init(bus *core.Bus, cpuBus *core.Bus, colorRam core.AddressSpace
 	}
 }
 
+// SetPalette changes the RGB values of the 16 colors. It takes effect from the next
+// pixel drawn.
+func (v *VicII) SetPalette(palette []color.RGBA) {
+	v.palette = palette
+}
+
+// Palette returns the RGB values of the 16 colors.
+func (v *VicII) Palette() []color.RGBA {
+	return v.palette
+}
+
 func (v *VicII) ReadByte(addr uint16) uint8 {
 	addr &= 0x003f
 	if addr >= 0x002f {
@@ -254,9 +282,9 @@ func (v *VicII) ReadByte(addr uint16) uint8 {
 	case REG_RASTER_CNT:
 		return uint8(v.rasterLine & 0xff)
 	case REG_LPX:
-		return 0
+		return v.lpX
 	case REG_LPY:
-		return 0
+		return v.lpY
 	case REG_SPRITE_ENABLE:
 		r := uint8(0)
 		for i := range v.sprites {
@@ -302,7 +330,7 @@ func (v *VicII) ReadByte(addr uint16) uint8 {
 		if v.irqLp {
 			r |= IRQ_LP
 		}
-		if r != 0 {
+		if v.irqLine {
 			r |= IRQ_HAPPENED
 		}
 		return r | 0x70
@@ -369,6 +397,17 @@ func (v *VicII) ReadByte(addr uint16) uint8 {
 	return 0xff
 }
 
+// Peek returns what ReadByte would, without clearing the collision registers.
+func (v *VicII) Peek(addr uint16) uint8 {
+	switch addr & 0x003f {
+	case REG_SPRITE_COLL:
+		return v.spriteSpriteColl
+	case REG_DATA_COLL:
+		return v.spriteDataColl
+	}
+	return v.ReadByte(addr)
+}
+
 func (v *VicII) WriteByte(addr uint16, data uint8) {
 	addr &= 0x003f
 	if addr >= 0x002f {
@@ -426,32 +465,27 @@ func (v *VicII) WriteByte(addr uint16, data uint8) {
 		}
 		v.screenMemPtr = uint16(data&0xf0) << 6
 	case REG_IRQ:
-		newRaster := data&IRQ_RASTER != 0
-		if newRaster && v.irqRaster {
-			v.cpuBus.NotIRQ.Release()
+		// Writing a one acknowledges the interrupt
+		if data&IRQ_RASTER != 0 {
 			v.irqRaster = false
 		}
-		newSpritqBg := v.irqSpriteBg && data&IRQ_SPRITE_BG_COLL != 0
-		if newSpritqBg && v.irqSpriteBg {
-			v.cpuBus.NotIRQ.Release()
+		if data&IRQ_SPRITE_BG_COLL != 0 {
 			v.irqSpriteBg = false
 		}
-		newSpriteSprite := data&IRQ_SPRITE_SPRITE_COLL != 0
-		if newSpriteSprite && v.irqSpriteSprite {
-			v.cpuBus.NotIRQ.Release()
-			v.irqSpriteBg = false
+		if data&IRQ_SPRITE_SPRITE_COLL != 0 {
+			v.irqSpriteSprite = false
 		}
-		newLp := data&IRQ_LP != 0
-		if newLp && v.irqLp {
-			v.cpuBus.NotIRQ.Release()
+		if data&IRQ_LP != 0 {
 			v.irqLp = false
 		}
+		v.updateIRQ()
 	case REG_IRQ_ENABLE:
 		v.irqRasterEnabled = data&IRQ_RASTER != 0
 		v.irqSpriteBgEnabled = data&IRQ_SPRITE_BG_COLL != 0
 		v.irqSpriteSpriteEnabled = data&IRQ_SPRITE_SPRITE_COLL != 0
 		v.irqLpEnabled = data&IRQ_LP != 0
 		v.irqEnabled = data&IRQ_ENABLED != 0
+		v.updateIRQ()
 	case REG_BORDER:
 		v.borderCol = data
 	case REG_SPRITE_PRIO:
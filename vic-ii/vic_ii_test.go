@@ -128,3 +128,36 @@ func TestInterruptEnabledRegister(t *testing.T) {
 		}
 	}
 }
+
+func TestVicII_Peek(t *testing.T) {
+	v := VicII{}
+	v.Init(&core.Bus{}, nil, core.MakeRAM(1024), nil, PALDimensions)
+	v.spriteSpriteColl = 0x03
+	v.spriteDataColl = 0x81
+	require.Equal(t, uint8(0x03), v.Peek(REG_SPRITE_COLL))
+	require.Equal(t, uint8(0x81), v.Peek(REG_DATA_COLL))
+	require.Equal(t, uint8(0x03), v.ReadByte(REG_SPRITE_COLL), "Peeking doesn't clear the collisions")
+	require.Equal(t, uint8(0x81), v.ReadByte(REG_DATA_COLL))
+	require.Equal(t, uint8(0), v.Peek(REG_SPRITE_COLL))
+	require.Equal(t, v.ReadByte(REG_CTRL1), v.Peek(REG_CTRL1))
+}
+
+func TestVicII_State(t *testing.T) {
+	v := VicII{}
+	v.Init(&core.Bus{}, nil, core.MakeRAM(1024), nil, PALDimensions)
+	v.WriteByte(REG_M0X+4, 0x80)
+	v.WriteByte(REG_MX_HIGH, 0x04)
+	v.WriteByte(REG_BORDER, 0x02)
+	v.WriteByte(REG_MEMPTR, 0x18)
+	v.WriteByte(REG_CTRL1, 0x3b)
+	v.rasterLine = 0x123
+	state := v.State()
+
+	restored := VicII{}
+	restored.Init(&core.Bus{}, nil, core.MakeRAM(1024), nil, PALDimensions)
+	restored.SetState(state)
+	require.Equal(t, state, restored.State())
+	require.Equal(t, uint16(0x180), restored.sprites[2].x)
+	require.Equal(t, uint8(0x02), restored.ReadByte(REG_BORDER))
+	require.Equal(t, uint8(0x23), restored.ReadByte(REG_RASTER_CNT))
+}
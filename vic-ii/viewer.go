@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Sizes of the images drawn by the viewers
+const (
+	CharsetViewWidth  = 16 * 8
+	CharsetViewHeight = 16 * 8
+	SpriteViewWidth   = 16 * 24
+	SpriteViewHeight  = 16 * 21
+	BitmapViewWidth   = 320
+	BitmapViewHeight  = 200
+)
+
+// ViewNames are the views that View can draw
+var ViewNames = []string{"charset", "mccharset", "sprites", "mcsprites", "bitmap"}
+
+// View draws one of the views by name.
+func (v *VicII) View(name string) (*image.RGBA, error) {
+	switch name {
+	case "charset", "mccharset":
+		return v.CharsetImage(name == "mccharset"), nil
+	case "sprites", "mcsprites":
+		return v.SpritesImage(name == "mcsprites"), nil
+	case "bitmap":
+		return v.BitmapImage(), nil
+	}
+	return nil, fmt.Errorf("unknown view: %s", name)
+}
+
+// CharsetImage draws the 256 characters of the current character set as a 16x16 grid
+// of glyphs on background color 0. Set pixels are white. In multicolor, the pairs of
+// bits select background color 1, 2 or white instead.
+func (v *VicII) CharsetImage(multicolor bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, CharsetViewWidth, CharsetViewHeight))
+	colors := [4]uint8{v.backgroundColors[0], 1, 1, 1}
+	if multicolor {
+		colors = [4]uint8{v.backgroundColors[0], v.backgroundColors[1], v.backgroundColors[2], 1}
+	}
+	for ch := uint16(0); ch < 256; ch++ {
+		x, y := int(ch%16)*8, int(ch/16)*8
+		for row := uint16(0); row < 8; row++ {
+			v.drawByte(img, x, y+int(row), v.bus.ReadByte(v.charSetPtr+ch<<3|row), multicolor, colors)
+		}
+	}
+	return img
+}
+
+// SpritesImage draws the 256 blocks of 64 bytes that sprite pointers can select as a
+// 16x16 grid of 24x21 sprites on background color 0. Blocks that a sprite points to
+// are drawn in the color and mode of that sprite. The others are drawn in white,
+// using the two sprite multicolors if multicolor is set.
+func (v *VicII) SpritesImage(multicolor bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, SpriteViewWidth, SpriteViewHeight))
+	for block := uint16(0); block < 256; block++ {
+		mc := multicolor
+		colors := [4]uint8{v.backgroundColors[0], v.spriteMultiClr0, 1, v.spriteMultiClr1}
+		for i := len(v.sprites) - 1; i >= 0; i-- {
+			if s := &v.sprites[i]; s.pointer == block<<6 {
+				mc = s.multicolor
+				colors[2] = s.color
+			}
+		}
+		if !mc {
+			colors[1] = colors[2]
+			colors[3] = colors[2]
+		}
+		x, y := int(block%16)*24, int(block/16)*21
+		for i := uint16(0); i < 63; i++ {
+			v.drawByte(img, x+int(i%3)*8, y+int(i/3), v.bus.ReadByte(block<<6|i), mc, colors)
+		}
+	}
+	return img
+}
+
+// BitmapImage draws the current bitmap in hires or multicolor, depending on the mode
+// selected. The colors are taken from the video matrix and color RAM.
+func (v *VicII) BitmapImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, BitmapViewWidth, BitmapViewHeight))
+	base := v.charSetPtr & 0x2000
+	for cell := uint16(0); cell < 1000; cell++ {
+		matrix := v.bus.ReadByte(v.screenMemPtr | cell)
+		colors := [4]uint8{matrix & 0x0f, matrix >> 4, matrix >> 4, matrix >> 4}
+		if v.multiColor {
+			colors = [4]uint8{v.backgroundColors[0], matrix >> 4, matrix & 0x0f, v.colorRam.ReadByte(cell)}
+		}
+		x, y := int(cell%40)*8, int(cell/40)*8
+		for row := uint16(0); row < 8; row++ {
+			v.drawByte(img, x, y+int(row), v.bus.ReadByte(base+cell<<3|row), v.multiColor, colors)
+		}
+	}
+	return img
+}
+
+// Draws the 8 pixels of a byte of graphics data. In multicolor, each pair of bits
+// selects one of the colors for two pixels. Otherwise a bit selects color 0 or 3.
+func (v *VicII) drawByte(img *image.RGBA, x, y int, data uint8, multicolor bool, colors [4]uint8) {
+	for i := 0; i < 8; i++ {
+		var c uint8
+		if multicolor {
+			c = colors[data>>(6-i&^1)&0x03]
+		} else {
+			c = colors[data>>(7-i)&0x01*3]
+		}
+		img.SetRGBA(x+i, y, v.rgb(c))
+	}
+}
+
+func (v *VicII) rgb(c uint8) color.RGBA {
+	return v.palette[c&0x0f]
+}
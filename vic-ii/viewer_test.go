@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2021 Pontus Rydin
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify, merge,
+ * publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons
+ * to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+ * THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+ * OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ * ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+ * OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vic_ii
+
+import (
+	"github.com/prydin/emu6502/core"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCharsetImage(t *testing.T) {
+	vicii, _ := initVicII(nil, core.MakeRAM(1024))
+	vicii.charSetPtr = 0x2000
+	vicii.backgroundColors = [4]uint8{6, 2, 5, 0}
+	vicii.bus.WriteByte(0x2008, 0x81)        // Character 1, first row
+	vicii.bus.WriteByte(0x2000+17*8+7, 0x1b) // Character 17, last row
+
+	img := vicii.CharsetImage(false)
+	require.Equal(t, CharsetViewWidth, img.Rect.Dx())
+	require.Equal(t, C64Colors[1], img.RGBAAt(8, 0))
+	require.Equal(t, C64Colors[6], img.RGBAAt(9, 0))
+	require.Equal(t, C64Colors[1], img.RGBAAt(15, 0))
+	require.Equal(t, C64Colors[1], img.RGBAAt(11, 15))
+
+	img = vicii.CharsetImage(true)
+	for i, c := range []uint8{6, 2, 5, 1} {
+		require.Equal(t, C64Colors[c], img.RGBAAt(8+2*i, 15))
+		require.Equal(t, C64Colors[c], img.RGBAAt(9+2*i, 15))
+	}
+}
+
+func TestSpritesImage(t *testing.T) {
+	vicii, _ := initVicII(nil, core.MakeRAM(1024))
+	vicii.spriteMultiClr0 = 3
+	vicii.spriteMultiClr1 = 4
+	vicii.bus.WriteByte(0x3000, 0x80)
+	vicii.bus.WriteByte(0x3040+62, 0x1b)
+	vicii.sprites[2].pointer = 0x3000
+	vicii.sprites[2].color = 2
+
+	// Block $c0 is used by sprite 2, block $c1 isn't
+	img := vicii.SpritesImage(false)
+	require.Equal(t, SpriteViewHeight, img.Rect.Dy())
+	require.Equal(t, C64Colors[2], img.RGBAAt(0, 12*21))
+	require.Equal(t, C64Colors[6], img.RGBAAt(1, 12*21))
+	require.Equal(t, C64Colors[1], img.RGBAAt(24+16+3, 12*21+20))
+
+	img = vicii.SpritesImage(true)
+	require.Equal(t, C64Colors[2], img.RGBAAt(0, 12*21), "Sprite 2 is still single color")
+	for i, c := range []uint8{6, 3, 1, 4} {
+		require.Equal(t, C64Colors[c], img.RGBAAt(24+16+2*i, 12*21+20))
+	}
+}
+
+func TestBitmapImage(t *testing.T) {
+	colorRam := core.MakeRAM(1024)
+	vicii, _ := initVicII(nil, colorRam)
+	vicii.charSetPtr = 0x2800 // Only bit 13 counts
+	vicii.bus.WriteByte(0x0400+41, 0x25)
+	colorRam.WriteByte(41, 7)
+	vicii.bus.WriteByte(0x2000+41*8+1, 0x1b)
+
+	img := vicii.BitmapImage()
+	require.Equal(t, BitmapViewWidth, img.Rect.Dx())
+	for i, c := range []uint8{5, 5, 5, 2, 2, 5, 2, 2} {
+		require.Equal(t, C64Colors[c], img.RGBAAt(8+i, 9))
+	}
+
+	vicii.multiColor = true
+	img = vicii.BitmapImage()
+	for i, c := range []uint8{6, 2, 5, 7} {
+		require.Equal(t, C64Colors[c], img.RGBAAt(8+2*i, 9))
+	}
+}